@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxAliasDepth bounds how many aliases may expand into one another; a
+// longer chain is taken to be a loop.
+const maxAliasDepth = 10
+
+// setupAliases expands a user alias (aliases: in <root>/config.yaml) in
+// args[1], after the global flags have been stripped.  Built-in commands
+// are dispatched as they are without reading the config at all.
+func setupAliases(args []string) []string {
+	if len(args) < 2 || isCommand(args[1]) {
+		return args
+	}
+	cfg, err := loadUserConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: ignoring aliases: %v\n", err)
+		return args
+	}
+	if len(cfg.Aliases) == 0 {
+		return args
+	}
+	for _, name := range shadowedAliases(cfg.Aliases) {
+		fmt.Fprintf(os.Stderr, "grove: alias %q has the name of a built-in command and is ignored; rename it in %s\n", name, userConfigPath())
+	}
+	expanded, err := expandAlias(args, cfg.Aliases)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	return expanded
+}
+
+// isCommand reports whether name is a built-in grove command.
+func isCommand(name string) bool {
+	if name == "__complete" {
+		return true
+	}
+	for _, c := range commands {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// shadowedAliases returns, sorted, the aliases named after built-in
+// commands.  They can never run, since the built-in wins.
+func shadowedAliases(aliases map[string]string) []string {
+	var names []string
+	for name := range aliases {
+		if isCommand(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandAlias replaces args[1], while it names an alias, with the alias's
+// words, keeping the remaining arguments after them.  An alias may expand
+// to another alias; one that leads back to itself is an error.
+func expandAlias(args []string, aliases map[string]string) ([]string, error) {
+	var chain []string
+	for len(args) >= 2 && !isCommand(args[1]) {
+		expansion, ok := aliases[args[1]]
+		if !ok {
+			return args, nil
+		}
+		chain = append(chain, args[1])
+		for _, seen := range chain[:len(chain)-1] {
+			if seen == args[1] {
+				return nil, fmt.Errorf("alias loop: %s", strings.Join(chain, " -> "))
+			}
+		}
+		if len(chain) > maxAliasDepth {
+			return nil, fmt.Errorf("aliases nest more than %d deep: %s", maxAliasDepth, strings.Join(chain, " -> "))
+		}
+		words, err := splitAliasWords(expansion)
+		if err != nil {
+			return nil, fmt.Errorf("alias %q: %v", args[1], err)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("alias %q is empty", args[1])
+		}
+		args = append(append([]string{args[0]}, words...), args[2:]...)
+	}
+	return args, nil
+}
+
+// splitAliasWords splits an alias expansion into arguments at whitespace.
+// Single or double quotes keep spaces inside an argument, as in a shell;
+// nothing else is special.
+func splitAliasWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
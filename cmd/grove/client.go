@@ -1,17 +1,21 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/gandalfthegui/grove/internal/engine"
+	"github.com/gandalfthegui/grove/internal/envfile"
 	"github.com/gandalfthegui/grove/internal/proto"
 )
 
@@ -29,14 +33,58 @@ func rootDir() string {
 	return filepath.Join(home, ".grove")
 }
 
+// socketOverride is set by --socket, GROVE_ADDR or GROVE_SOCKET; see
+// setupSocket.
+var socketOverride string
+
+// socketPath returns the daemon socket grove talks to: socketOverride if
+// set, else <root>/groved.sock.
+func socketPath() string {
+	if socketOverride != "" {
+		return socketOverride
+	}
+	return filepath.Join(rootDir(), "groved.sock")
+}
+
 // daemonSocket returns the Unix socket path and ensures the daemon is running.
+// An explicit socket (--socket / GROVE_SOCKET) targets a specific daemon, so
+// none is started for it; grove exits if nothing answers there.
 func daemonSocket() string {
-	root := rootDir()
-	sock := filepath.Join(root, "groved.sock")
-	ensureDaemon(root, sock)
+	sock := socketPath()
+	if socketOverride != "" {
+		if !pingDaemon(sock) {
+			exitWith(exitNoDaemon, "no daemon answering on %s", sock)
+		}
+		return sock
+	}
+	ensureDaemon(rootDir(), sock)
 	return sock
 }
 
+// daemonNetwork splits a daemon address into the network and address to
+// dial: "tcp:<host>:<port>" is TCP, anything else a Unix socket path.
+func daemonNetwork(addr string) (network, address string) {
+	if rest, ok := strings.CutPrefix(addr, "tcp:"); ok {
+		return "tcp", rest
+	}
+	return "unix", addr
+}
+
+// dialAddr connects to the daemon at addr (see daemonNetwork) within t.
+func dialAddr(addr string, t time.Duration) (net.Conn, error) {
+	network, address := daemonNetwork(addr)
+	return net.DialTimeout(network, address, t)
+}
+
+// daemonToken returns the secret sent with requests over TCP:
+// GROVE_DAEMON_TOKEN from the environment, else from <root>/env.
+func daemonToken() string {
+	if token := os.Getenv(proto.DaemonTokenVar); token != "" {
+		return token
+	}
+	return envfile.Load(filepath.Join(rootDir(), "env"))[proto.DaemonTokenVar]
+}
+
 // ensureDaemon starts groved in the background if the socket doesn't exist
 // or is not responding to pings.  root is passed via --root so the daemon
 // uses the same data directory that grove is targeting.
@@ -46,9 +94,9 @@ func ensureDaemon(root, socketPath string) {
 	}
 
 	exe, _ := os.Executable()
-	daemonBin := filepath.Join(filepath.Dir(exe), "groved")
-	if _, err := os.Stat(daemonBin); err != nil {
-		daemonBin = "groved"
+	daemonBin, err := locateDaemon(exe)
+	if err != nil {
+		exitWith(exitNoDaemon, "could not start daemon: %v", err)
 	}
 
 	cmd := exec.Command(daemonBin, "--root", root)
@@ -56,8 +104,7 @@ func ensureDaemon(root, socketPath string) {
 	cmd.Stderr = nil
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
 	if err := cmd.Start(); err != nil {
-		fmt.Fprintf(os.Stderr, "grove: could not start daemon: %v\n", err)
-		os.Exit(1)
+		exitWith(exitNoDaemon, "could not start daemon: %v", err)
 	}
 
 	// Wait up to 3 seconds for it to become ready.
@@ -69,13 +116,28 @@ func ensureDaemon(root, socketPath string) {
 	}
 
 	fmt.Fprintln(os.Stderr, "grove: daemon did not start in time")
-	warnIfDockerUnavailable()
-	os.Exit(1)
+	if warnIfDockerUnavailable() {
+		os.Exit(exitNoDocker)
+	}
+	os.Exit(exitNoDaemon)
+}
+
+// locateDaemon returns the groved binary ensureDaemon runs: the one next to
+// exe (the grove binary) if there is one, else groved on PATH.
+func locateDaemon(exe string) (string, error) {
+	beside := filepath.Join(filepath.Dir(exe), "groved")
+	if _, err := os.Stat(beside); err == nil {
+		return beside, nil
+	}
+	if path, err := exec.LookPath("groved"); err == nil {
+		return path, nil
+	}
+	return "", fmt.Errorf("groved not found next to grove (%s) or on PATH — reinstall grove", filepath.Dir(exe))
 }
 
 // pingDaemon returns true if the daemon is alive and responding.
 func pingDaemon(socketPath string) bool {
-	conn, err := net.DialTimeout("unix", socketPath, 500*time.Millisecond)
+	conn, err := dialAddr(socketPath, 500*time.Millisecond)
 	if err != nil {
 		return false
 	}
@@ -86,25 +148,105 @@ func pingDaemon(socketPath string) bool {
 		return false
 	}
 	resp, err := readResponse(conn)
+	if errors.Is(err, errLegacyDaemon) {
+		// Alive, just old; let the real request report it rather than
+		// starting a second daemon on the same socket.
+		return true
+	}
 	return err == nil && resp.OK
 }
 
-// tryRequest sends a request to the daemon and returns the response.
-// Unlike mustRequest it returns an error instead of exiting, so callers
-// can tolerate a daemon that isn't running.
-func tryRequest(req proto.Request) (proto.Response, error) {
-	root := rootDir()
-	sock := filepath.Join(root, "groved.sock")
-	conn, err := net.Dial("unix", sock)
+// defaultTimeout bounds how long grove waits to connect to the daemon and,
+// for one-shot requests, for its reply, so a wedged daemon cannot hang every
+// command.  GROVE_TIMEOUT overrides it; see requestTimeout.
+const defaultTimeout = 5 * time.Second
+
+// slowRequestGrace is added to the reply timeout for requests the daemon
+// answers only after waiting on something else: an agent to exit or boot,
+// docker to remove a container, or a git remote.
+const slowRequestGrace = 30 * time.Second
+
+// requestTimeout returns GROVE_TIMEOUT, a duration ("10s", "500ms") or a
+// number of seconds, else defaultTimeout.  Zero disables the timeouts.
+func requestTimeout() time.Duration {
+	v := os.Getenv("GROVE_TIMEOUT")
+	if v == "" {
+		return defaultTimeout
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return d
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second
+	}
+	fmt.Fprintf(os.Stderr, "grove: ignoring invalid GROVE_TIMEOUT %q\n", v)
+	return defaultTimeout
+}
+
+// replyTimeout returns how long a one-shot request may take in all.
+func replyTimeout(req proto.Request) time.Duration {
+	t := requestTimeout()
+	if t == 0 {
+		return 0
+	}
+	if req.Type == proto.ReqRestart && req.Fresh {
+		// Builds a container and runs start commands: as long as a start.
+		return 0
+	}
+	switch {
+	case req.Type == proto.ReqStop && (req.Wait || req.Snapshot || req.Container),
+		req.Type == proto.ReqRestart && req.WaitReady,
+		req.Type == proto.ReqDrop,
+		req.Type == proto.ReqCheckRepo:
+		t += slowRequestGrace
+	}
+	return t
+}
+
+// dialDaemon connects to the daemon socket within requestTimeout.  Streaming
+// requests (start, attach, logs, check, finish, reload) use it directly and
+// set no deadline on the connection, since their output can run for as long
+// as it takes.
+func dialDaemon(socketPath string) (net.Conn, error) {
+	t := requestTimeout()
+	conn, err := dialAddr(socketPath, t)
+	return conn, notResponding(err, t)
+}
+
+// request sends a one-shot request and reads the response, giving up after
+// replyTimeout.  A response with OK unset is returned without error.
+func request(socketPath string, req proto.Request) (proto.Response, error) {
+	conn, err := dialDaemon(socketPath)
 	if err != nil {
 		return proto.Response{}, err
 	}
 	defer conn.Close()
 
+	t := replyTimeout(req)
+	if t > 0 {
+		conn.SetDeadline(time.Now().Add(t))
+	}
 	if err := writeRequest(conn, req); err != nil {
-		return proto.Response{}, err
+		return proto.Response{}, notResponding(err, t)
 	}
 	resp, err := readResponse(conn)
+	return resp, notResponding(err, t)
+}
+
+// notResponding replaces a timeout error with one saying the daemon did not
+// answer within t.  Other errors, and nil, are returned unchanged.
+func notResponding(err error, t time.Duration) error {
+	if timedOut(err) {
+		return fmt.Errorf("daemon not responding (no reply within %s; set GROVE_TIMEOUT to wait longer)", t)
+	}
+	return err
+}
+
+// tryRequest sends a request to the daemon and returns the response.
+// Unlike mustRequest it returns an error instead of exiting, so callers
+// can tolerate a daemon that isn't running.
+func tryRequest(req proto.Request) (proto.Response, error) {
+	resp, err := request(socketPath(), req)
 	if err != nil {
 		return proto.Response{}, err
 	}
@@ -117,105 +259,216 @@ func tryRequest(req proto.Request) (proto.Response, error) {
 // mustRequest sends a request to the daemon and returns the response, exiting
 // on any error.
 func mustRequest(req proto.Request) proto.Response {
-	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
+	resp, err := request(daemonSocket(), req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+		exitWith(exitNoDaemon, "%v", err)
+	}
+	if !resp.OK {
+		exitWith(exitCodeFor(resp), "%s", resp.Error)
 	}
+	return resp
+}
+
+// streamCommand sends a request to the daemon and streams its output to
+// stdout until the connection closes. Used by cmdFinish and cmdCheck.
+func streamCommand(req proto.Request) {
+	conn, resp := openStream(req)
 	defer conn.Close()
 
+	if !resp.Framed {
+		io.Copy(os.Stdout, conn)
+		return
+	}
+	if err := copyFramedOutput(os.Stdout, conn); err != nil {
+		exitWith(errorExitCode(err), "%v", err)
+	}
+}
+
+// openStream sends req and reads the daemon's first response, exiting on
+// any failure, and returns the connection with the output still to come.
+func openStream(req proto.Request) (net.Conn, proto.Response) {
+	conn, err := dialDaemon(daemonSocket())
+	if err != nil {
+		exitWith(exitNoDaemon, "%v", err)
+	}
+
 	if err := writeRequest(conn, req); err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+		exitWith(exitNoDaemon, "%v", err)
 	}
 
 	resp, err := readResponse(conn)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+		exitWith(exitNoDaemon, "%v", err)
 	}
 	if !resp.OK {
-		fmt.Fprintf(os.Stderr, "grove: %s\n", resp.Error)
-		os.Exit(1)
+		exitWith(exitCodeFor(resp), "%s", resp.Error)
 	}
-	return resp
+	return conn, resp
 }
 
-// streamCommand sends a request to the daemon and streams its output to
-// stdout until the connection closes. Used by cmdFinish and cmdCheck.
-func streamCommand(reqType string, instanceID string) {
-	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
+// copyFramedOutput copies proto.StreamFrameOutput frames from r to w until
+// the closing proto.StreamFrameResult frame, and returns an error if that
+// reports failure or the stream ends without one.
+func copyFramedOutput(w io.Writer, r io.Reader) error {
+	resp, err := readFramedResult(w, r)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+		return err
 	}
-	defer conn.Close()
-
-	if err := writeRequest(conn, proto.Request{Type: reqType, InstanceID: instanceID}); err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+	if !resp.OK {
+		return &responseError{resp}
 	}
+	return nil
+}
 
-	resp, err := readResponse(conn)
-	if err != nil || !resp.OK {
-		msg := resp.Error
-		if msg == "" && err != nil {
-			msg = err.Error()
+// readFramedResult is copyFramedOutput for callers that need the whole
+// result, such as grove exec for the exit status.  StreamFrameStderr frames,
+// which only grove exec gets, go to os.Stderr.  The error is only for a
+// broken stream.
+func readFramedResult(w io.Writer, r io.Reader) (proto.Response, error) {
+	for {
+		frameType, payload, err := proto.ReadFrame(r)
+		if err != nil {
+			return proto.Response{}, fmt.Errorf("connection closed before a result was reported")
+		}
+		switch frameType {
+		case proto.StreamFrameOutput:
+			w.Write(payload)
+		case proto.StreamFrameStderr:
+			os.Stderr.Write(payload)
+		case proto.StreamFrameResult:
+			var resp proto.Response
+			if err := json.Unmarshal(payload, &resp); err != nil {
+				return proto.Response{}, fmt.Errorf("bad result frame: %w", err)
+			}
+			return resp, nil
 		}
-		fmt.Fprintf(os.Stderr, "grove: %s\n", msg)
-		os.Exit(1)
 	}
-
-	io.Copy(os.Stdout, conn)
 }
 
-// findInstance looks up a single instance by ID from a live daemon list.
-// Returns nil and prints an error if the instance is not found.
+// findInstance looks up a single instance by ID or name from a live daemon
+// list.  Returns nil if the instance is not found.
 func findInstance(instanceID string) *proto.InstanceInfo {
 	resp := mustRequest(proto.Request{Type: proto.ReqList})
-	for i := range resp.Instances {
-		if resp.Instances[i].ID == instanceID {
-			return &resp.Instances[i]
+	return instanceIn(resp.Instances, instanceID)
+}
+
+// findBranchInstances resolves --branch for grove stop and drop: the
+// instances on branch, exiting if there are none, or several and all is
+// not set.  verb names the command in the error.
+func findBranchInstances(verb, branch string, all bool) []proto.InstanceInfo {
+	resp := mustRequest(proto.Request{Type: proto.ReqList})
+	matches, err := branchTargets(resp.Instances, branch, verb, all)
+	switch {
+	case err != nil && len(matches) == 0:
+		exitWith(exitNotFound, "%v", err)
+	case err != nil:
+		exitWith(exitFailure, "%v", err)
+	}
+	return matches
+}
+
+// branchTargets returns the instances whose branch is exactly branch.
+// Instances of different projects can share a branch name; unless all is
+// set that is an error listing their IDs (the matches are still returned),
+// so that one --branch never acts on several instances by accident.
+func branchTargets(instances []proto.InstanceInfo, branch, verb string, all bool) ([]proto.InstanceInfo, error) {
+	var matches []proto.InstanceInfo
+	var ids []string
+	for _, inst := range instances {
+		if inst.Branch == branch {
+			matches = append(matches, inst)
+			ids = append(ids, inst.ID)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return nil, fmt.Errorf("no instance is on branch %q", branch)
+	case len(matches) > 1 && !all:
+		return matches, fmt.Errorf("%d instances are on branch %q (%s); %s one by ID, or pass --all to %s them all",
+			len(matches), branch, strings.Join(ids, ", "), verb, verb)
+	}
+	return matches, nil
+}
+
+// instanceIn returns the instance with the given ID from instances, else
+// the one given that name with grove start --name, or nil.  The daemon
+// never hands out an ID that is already a name, so the two cannot clash.
+func instanceIn(instances []proto.InstanceInfo, instanceID string) *proto.InstanceInfo {
+	for i := range instances {
+		if instances[i].ID == instanceID {
+			return &instances[i]
+		}
+	}
+	for i := range instances {
+		if instances[i].Name != "" && instances[i].Name == instanceID {
+			return &instances[i]
 		}
 	}
 	return nil
 }
 
+// resolveInstanceID returns the ID of the instance arg names, by ID or by
+// name, for commands that send the ID on to the daemon.  An arg that
+// matches nothing comes back unchanged, for the daemon to report.
+func resolveInstanceID(arg string) string {
+	resp, err := request(daemonSocket(), proto.Request{Type: proto.ReqList})
+	if err != nil || !resp.OK {
+		return arg
+	}
+	if inst := instanceIn(resp.Instances, arg); inst != nil {
+		return inst.ID
+	}
+	return arg
+}
+
+// errLegacyDaemon is returned by readResponse when groved answers with the
+// newline-delimited framing used before length-prefixed messages.
+var errLegacyDaemon = errors.New("groved is running an older version of grove; stop it and run the command again")
+
+// writeRequest sends req, adding the daemon token on a TCP connection.
 func writeRequest(conn net.Conn, req proto.Request) error {
-	data, err := json.Marshal(req)
-	if err != nil {
-		return err
+	if _, ok := conn.(*net.TCPConn); ok && req.Token == "" {
+		req.Token = daemonToken()
 	}
-	data = append(data, '\n')
-	_, err = conn.Write(data)
-	return err
+	return proto.WriteMessage(conn, req)
 }
 
 func readResponse(conn net.Conn) (proto.Response, error) {
-	scanner := bufio.NewScanner(conn)
-	if !scanner.Scan() {
-		if err := scanner.Err(); err != nil {
+	var resp proto.Response
+	legacy, err := proto.ReadMessage(conn, &resp)
+	if legacy {
+		return proto.Response{}, errLegacyDaemon
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
 			return proto.Response{}, err
 		}
-		return proto.Response{}, io.EOF
-	}
-	var resp proto.Response
-	if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
 		return proto.Response{}, fmt.Errorf("bad response: %w", err)
 	}
 	return resp, nil
 }
 
 // warnIfDockerUnavailable prints a human-readable error to stderr when Docker
-// is not running or not installed.
-func warnIfDockerUnavailable() {
-	cmd := exec.Command("docker", "info")
+// (or GROVE_CONTAINER_RUNTIME) is not running or not installed, and reports
+// whether it did.
+func warnIfDockerUnavailable() bool {
+	cmd := engine.Command("info")
 	cmd.Stdout = io.Discard
 	cmd.Stderr = io.Discard
 	if cmd.Run() != nil {
+		if name := engine.Name(); name != engine.Default {
+			fmt.Fprintf(os.Stderr, "%sgrove requires %s%s (GROVE_CONTAINER_RUNTIME), which does not appear to be running.\n", colorRed+colorBold, name, colorReset)
+			return true
+		}
 		fmt.Fprintf(os.Stderr, "%sgrove requires Docker.%s Docker does not appear to be running.\n", colorRed+colorBold, colorReset)
 		fmt.Fprintf(os.Stderr, "  Start Docker Desktop or install it: https://docs.docker.com/get-docker/\n")
+		return true
 	}
+	return false
+}
+
+// timedOut reports whether err is a connection deadline expiring.
+func timedOut(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
 }
@@ -1,116 +1,312 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"flag"
 	"fmt"
 	"io"
-	"net"
 	"os"
+	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gandalfthegui/grove/internal/proto"
 	"golang.org/x/term"
 )
 
+// attachOptions controls optional attach behaviour.
+type attachOptions struct {
+	// cooked keeps the terminal in canonical mode so the local line
+	// discipline provides editing, and sends whole lines on Enter.
+	cooked bool
+	// once asks the daemon to end the session when the agent first goes
+	// idle after a line of input has been submitted.
+	once bool
+	// noTitle skips setting the terminal window title for the session.
+	noTitle bool
+	// maxRate, if positive, caps agent output in bytes per second; bursts
+	// above it are dropped and summarized.
+	maxRate int
+	// takeover detaches a client already attached to the instance, e.g. one
+	// left behind by a dropped SSH connection, instead of failing.
+	takeover bool
+	// pasteLimit, if positive, holds back a paste longer than this many
+	// bytes until the user confirms it.
+	pasteLimit int
+}
+
+// Terminal title sequences.  The current title is pushed onto the xterm
+// title stack before it is replaced and popped on detach; terminals without
+// a title stack ignore the push/pop.
+const (
+	pushTitle = "\033[22;2t"
+	popTitle  = "\033[23;2t"
+)
+
+// escapeActions maps the key pressed after Ctrl-] in a raw attach session to
+// the grove subcommand run against the attached instance.
+var escapeActions = map[byte]string{
+	'c': "check",
+	'f': "finish",
+	's': "stop",
+}
+
+// defaultPasteLimit is the paste size --confirm-paste asks about when
+// --paste-limit is not given.
+const defaultPasteLimit = 4096
+
+const escapeHelp = bannerPrefix + "c: check  f: finish  s: stop  Ctrl-] or Enter: detach"
+
+// attachTitle returns the OSC 2 sequence that names the window after inst.
+func attachTitle(inst proto.InstanceInfo) string {
+	return fmt.Sprintf("\033]2;grove %s %s/%s\007", inst.ID, inst.Project, inst.Branch)
+}
+
 func cmdAttach() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove attach <instance-id>")
+	var opts attachOptions
+	rawArgs, cooked := stripBoolFlag(os.Args[2:], "cooked", "cooked")
+	rawArgs, once := stripBoolFlag(rawArgs, "once", "once")
+	rawArgs, noTitle := stripBoolFlag(rawArgs, "no-title", "no-title")
+	rawArgs, takeover := stripBoolFlag(rawArgs, "takeover", "takeover")
+	rawArgs, confirmPaste := stripBoolFlag(rawArgs, "confirm-paste", "confirm-paste")
+	opts.cooked, opts.once, opts.noTitle, opts.takeover = cooked, once, noTitle, takeover
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	maxRate := fs.String("max-rate", "", "drop agent output above this many bytes per second (k/m suffixes allowed)")
+	pasteLimit := fs.String("paste-limit", "", "with --confirm-paste, ask before sending a paste larger than this (default 4k)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove attach <instance-id> [--cooked] [--once] [--no-title] [--max-rate <bytes/s>] [--takeover] [--confirm-paste [--paste-limit <bytes>]]")
+	}
+	args := parseInterspersed(fs, rawArgs)
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *maxRate != "" {
+		n, err := parseByteSize(*maxRate)
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "grove: --max-rate: invalid rate %q (e.g. 65536, 64k, 1m)\n", *maxRate)
+			os.Exit(1)
+		}
+		opts.maxRate = n
+	}
+	if *pasteLimit != "" {
+		n, err := parseByteSize(*pasteLimit)
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "grove: --paste-limit: invalid size %q (e.g. 4096, 4k, 1m)\n", *pasteLimit)
+			os.Exit(1)
+		}
+		opts.pasteLimit, confirmPaste = n, true
+	} else if confirmPaste {
+		opts.pasteLimit = defaultPasteLimit
+	}
+	if confirmPaste && cooked {
+		fmt.Fprintln(os.Stderr, "grove: --confirm-paste works in raw mode only; drop --cooked")
 		os.Exit(1)
 	}
-	doAttach(os.Args[2])
+	doAttach(resolveInstanceID(args[0]), opts)
+}
+
+// parseByteSize parses a byte count with an optional k or m suffix (powers
+// of 1024), e.g. "512", "64k", "1M".
+func parseByteSize(s string) (int, error) {
+	mult := 1
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		mult, s = 1024, s[:len(s)-1]
+	case "m":
+		mult, s = 1024*1024, s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	return n * mult, err
+}
+
+// throttleWriter passes writes through to w until more than limit bytes
+// have been written in the current one-second window.  Later writes in that
+// window are dropped whole, so escape sequences are not cut mid-chunk, and
+// the first write of the next window (or Flush) is preceded by a
+// "[grove] throttled" line saying how much was lost.
+type throttleWriter struct {
+	w     io.Writer
+	limit int
+	id    string // instance ID, for the grove logs hint
+	now   func() time.Time
+
+	mu           sync.Mutex
+	windowStart  time.Time
+	written      int
+	droppedLines int
+	droppedBytes int64
+}
+
+func newThrottleWriter(w io.Writer, limit int, instanceID string) *throttleWriter {
+	return &throttleWriter{w: w, limit: limit, id: instanceID, now: time.Now}
+}
+
+func (t *throttleWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now := t.now(); now.Sub(t.windowStart) >= time.Second {
+		t.windowStart = now
+		t.written = 0
+		t.report()
+	}
+	if t.written+len(p) > t.limit {
+		// Drop the rest of the window too, so output does not resume with
+		// whatever small chunks still fit.
+		t.written = t.limit
+		t.droppedLines += bytes.Count(p, []byte("\n"))
+		t.droppedBytes += int64(len(p))
+		return len(p), nil
+	}
+	t.written += len(p)
+	return t.w.Write(p)
+}
+
+// Flush reports output dropped since the last report, if any.
+func (t *throttleWriter) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report()
+}
+
+func (t *throttleWriter) report() {
+	if t.droppedBytes == 0 {
+		return
+	}
+	fmt.Fprintf(t.w, "\r\n"+bannerPrefix+"throttled %d lines (%s); full output: grove logs %s\r\n", t.droppedLines, formatBytes(t.droppedBytes), t.id)
+	t.droppedLines, t.droppedBytes = 0, 0
 }
 
 // doAttach connects the terminal to the instance PTY and blocks until the
-// user detaches (Ctrl-]) or the agent exits.
-func doAttach(instanceID string) {
+// user detaches (Ctrl-] twice, or Ctrl-] then Enter) or the agent exits.
+func doAttach(instanceID string, opts attachOptions) {
 	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
+	conn, err := dialDaemon(socketPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: cannot connect to daemon: %v\n", err)
-		os.Exit(1)
+		exitWith(exitNoDaemon, "cannot connect to daemon: %v", err)
 	}
 	// Note: conn is NOT deferred-closed here; the attach loop owns its lifetime.
 
 	if err := writeRequest(conn, proto.Request{
 		Type:       proto.ReqAttach,
 		InstanceID: instanceID,
+		Once:       opts.once,
+		Takeover:   opts.takeover,
 	}); err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
 
 	resp, err := readResponse(conn)
-	if err != nil || !resp.OK {
-		msg := "attach failed"
-		if err != nil {
-			msg = err.Error()
-		} else if resp.Error != "" {
-			msg = resp.Error
-		}
-		fmt.Fprintf(os.Stderr, "grove: %s\n", msg)
+	if err != nil {
 		conn.Close()
-		os.Exit(1)
+		exitWith(exitFailure, "%v", err)
+	}
+	if !resp.OK {
+		conn.Close()
+		exitWith(exitCodeFor(resp), "%s", resp.Error)
 	}
 
 	fd := int(os.Stdin.Fd())
-	oldState, err := term.MakeRaw(fd)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: cannot set raw mode: %v\n", err)
-		conn.Close()
-		os.Exit(1)
+
+	stdoutTTY := term.IsTerminal(int(os.Stdout.Fd()))
+	setTitle := !opts.noTitle && len(resp.Instances) == 1 && stdoutTTY
+	if setTitle {
+		fmt.Fprint(os.Stdout, pushTitle+attachTitle(resp.Instances[0]))
 	}
 
 	// sync.Once ensures the terminal is restored exactly once whether we
 	// exit via defer or via the explicit call below before cleanup output.
 	var restoreOnce sync.Once
-	restore := func() {
-		restoreOnce.Do(func() { term.Restore(fd, oldState) })
+	restore := func() {}
+	var oldState *term.State
+	if !opts.cooked {
+		oldState, err = term.MakeRaw(fd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: cannot set raw mode: %v\n", err)
+			conn.Close()
+			os.Exit(1)
+		}
+		restore = func() {
+			restoreOnce.Do(func() { term.Restore(fd, oldState) })
+		}
 	}
 	defer restore()
 
-	fmt.Fprintf(os.Stdout, "\r\n[grove] attached to %s  (detach: Ctrl-])\r\n", instanceID)
+	// runAction runs an escape-mode command as a separate grove process so
+	// that its exit paths cannot leave the terminal in raw mode.
+	runAction := func(action string) {
+		writeBanner(os.Stdout, "%s %s", action, instanceID)
+		term.Restore(fd, oldState)
+		exe, err := os.Executable()
+		if err != nil {
+			exe = "grove"
+		}
+		cmd := exec.Command(exe, append(globalArgs(), action, instanceID)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Run()
+		term.MakeRaw(fd)
+	}
 
-	done := make(chan struct{}, 1)
+	if opts.cooked {
+		writeBanner(os.Stdout, "attached to %s in cooked mode  (detach: Ctrl-] then Enter)", instanceID)
+	} else {
+		writeBanner(os.Stdout, "attached to %s  (detach: Ctrl-] Ctrl-]; Ctrl-] ? for commands)", instanceID)
+	}
 
-	// Goroutine 1: copy PTY output (server → client) to stdout.
-	go func() {
-		io.Copy(os.Stdout, conn)
+	done := make(chan struct{}, 1)
+	signalDone := func() {
 		select {
 		case done <- struct{}{}:
 		default:
 		}
+	}
+
+	// Goroutine 1: copy PTY output (server → client) to stdout.
+	var out io.Writer = os.Stdout
+	var throttle *throttleWriter
+	if opts.maxRate > 0 {
+		throttle = newThrottleWriter(os.Stdout, opts.maxRate, instanceID)
+		out = throttle
+	}
+	go func() {
+		io.Copy(out, conn)
+		signalDone()
 	}()
 
 	// Goroutine 2: read stdin, watch for Ctrl-], frame and send to server.
 	go func() {
-		buf := make([]byte, 256)
-		for {
-			n, err := os.Stdin.Read(buf)
-			if n > 0 {
-				for i := 0; i < n; i++ {
-					if buf[i] == 0x1D {
-						proto.WriteFrame(conn, proto.AttachFrameDetach, nil)
-						select {
-						case done <- struct{}{}:
-						default:
-						}
-						return
-					}
-				}
-				proto.WriteFrame(conn, proto.AttachFrameData, buf[:n])
-			}
-			if err != nil {
-				select {
-				case done <- struct{}{}:
-				default:
-				}
-				return
-			}
+		switch {
+		case opts.cooked:
+			sendCookedInput(conn, os.Stdin)
+		case opts.pasteLimit > 0:
+			sendRawInput(conn, newPasteGuard(os.Stdin, os.Stdout, opts.pasteLimit), os.Stdout, runAction)
+		default:
+			sendRawInput(conn, os.Stdin, os.Stdout, runAction)
 		}
+		signalDone()
 	}()
 
+	// In cooked mode the terminal still generates SIGINT for Ctrl-C; forward
+	// it to the agent as the byte a raw terminal would have sent.
+	if opts.cooked {
+		intCh := make(chan os.Signal, 1)
+		signal.Notify(intCh, os.Interrupt)
+		defer signal.Stop(intCh)
+		go func() {
+			for range intCh {
+				proto.WriteFrame(conn, proto.AttachFrameData, []byte{0x03})
+			}
+		}()
+	}
+
 	// Forward terminal resize events.
 	winchCh := make(chan os.Signal, 1)
 	signal.Notify(winchCh, syscall.SIGWINCH)
@@ -137,11 +333,240 @@ func doAttach(instanceID string) {
 	<-done
 	signal.Stop(winchCh)
 	conn.Close()
+	if throttle != nil {
+		throttle.Flush()
+	}
 
 	// Restore terminal before printing the detach message so the output
 	// is not in raw mode.
 	restore()
-	// Reset terminal modes the agent may have left on (focus reporting, bracketed paste, etc.).
-	fmt.Fprint(os.Stdout, "\033[?1004l\033[?2004l")
-	fmt.Fprintf(os.Stdout, "\n[grove] detached from %s\n", instanceID)
+	// Reset terminal modes the agent may have left on (focus reporting,
+	// bracketed paste, etc.).  Output going to a file has no modes to reset.
+	if stdoutTTY {
+		fmt.Fprint(os.Stdout, "\033[?1004l\033[?2004l")
+	}
+	if setTitle {
+		fmt.Fprint(os.Stdout, popTitle)
+	}
+	writeBanner(os.Stdout, "detached from %s", instanceID)
+}
+
+// sendRawInput forwards stdin bytes to the server as data frames until the
+// user detaches or r returns an error.
+//
+// Ctrl-] enters escape mode and the next key is not forwarded: a second
+// Ctrl-] or Enter sends a detach frame, a key in escapeActions calls run
+// with the action name, and anything else prints escapeHelp to status.
+//
+// A UTF-8 character cut off at the end of a read is held back until the
+// rest of it arrives, so no frame splits one.
+func sendRawInput(w io.Writer, r io.Reader, status io.Writer, run func(action string)) {
+	buf := make([]byte, 256)
+	pending := 0 // bytes of a partial character carried over to buf's start
+	escaped := false
+	for {
+		n, err := r.Read(buf[pending:])
+		n += pending
+		pending = 0
+		start := 0
+		for i := 0; i < n; i++ {
+			b := buf[i]
+			if !escaped && b != 0x1D {
+				continue
+			}
+			if i > start {
+				proto.WriteFrame(w, proto.AttachFrameData, buf[start:i])
+			}
+			start = i + 1
+			if !escaped {
+				escaped = true
+				continue
+			}
+			escaped = false
+			switch {
+			case b == 0x1D || b == '\r' || b == '\n':
+				proto.WriteFrame(w, proto.AttachFrameDetach, nil)
+				return
+			case escapeActions[b] != "":
+				run(escapeActions[b])
+			default:
+				fmt.Fprintf(status, "\r\n%s\r\n", escapeHelp)
+			}
+		}
+		end := n
+		if err == nil {
+			end -= partialRuneLen(buf[start:n])
+		}
+		if end > start {
+			proto.WriteFrame(w, proto.AttachFrameData, buf[start:end])
+		}
+		if err != nil {
+			return
+		}
+		pending = copy(buf, buf[end:n])
+	}
+}
+
+// Bracketed paste markers: a terminal in bracketed paste mode (turned on by
+// the agent with "\033[?2004h") wraps pasted text in them, which is how a
+// paste is told apart from fast typing.
+var (
+	pasteStart = []byte("\033[200~")
+	pasteEnd   = []byte("\033[201~")
+)
+
+// pasteGuard is an io.Reader over stdin that holds back each bracketed
+// paste longer than limit bytes and asks on status whether to send it.  The
+// paste is passed on whole, markers included, on "y" and dropped otherwise.
+// Without bracketed paste mode a paste reads like typing and goes straight
+// through.
+type pasteGuard struct {
+	r      io.Reader
+	status io.Writer
+	limit  int
+
+	pending []byte // read but not yet looked at, or a paste still arriving
+	inPaste bool   // pending starts with pasteStart
+	ready   []byte // cleared to be returned by Read
+	err     error  // from r, returned once ready is drained
+}
+
+func newPasteGuard(r io.Reader, status io.Writer, limit int) *pasteGuard {
+	return &pasteGuard{r: r, status: status, limit: limit}
+}
+
+func (g *pasteGuard) Read(p []byte) (int, error) {
+	for len(g.ready) == 0 {
+		if g.err != nil {
+			return 0, g.err
+		}
+		buf := make([]byte, 4096)
+		n, err := g.r.Read(buf)
+		g.pending = append(g.pending, buf[:n]...)
+		g.err = err
+		g.scan()
+	}
+	n := copy(p, g.ready)
+	g.ready = g.ready[n:]
+	return n, nil
+}
+
+// scan moves what pending holds to ready, up to a paste that has not ended
+// yet, confirming long pastes on the way.
+func (g *pasteGuard) scan() {
+	for len(g.pending) > 0 {
+		if !g.inPaste {
+			i := bytes.Index(g.pending, pasteStart)
+			if i < 0 {
+				// Hold back what may be a start marker split across
+				// reads, unless nothing more is coming.
+				keep := 0
+				if g.err == nil {
+					keep = partialPasteStart(g.pending)
+				}
+				g.ready = append(g.ready, g.pending[:len(g.pending)-keep]...)
+				g.pending = g.pending[len(g.pending)-keep:]
+				return
+			}
+			g.ready = append(g.ready, g.pending[:i]...)
+			g.pending = g.pending[i:]
+			g.inPaste = true
+		}
+		size := bytes.Index(g.pending[len(pasteStart):], pasteEnd)
+		if size < 0 {
+			if g.err != nil {
+				// Input ended mid-paste; there is no one left to ask.
+				g.ready = append(g.ready, g.pending...)
+				g.pending = nil
+			}
+			return
+		}
+		end := len(pasteStart) + size + len(pasteEnd)
+		paste := g.pending[:end]
+		g.pending = g.pending[end:]
+		g.inPaste = false
+		if size <= g.limit || g.confirm(size) {
+			g.ready = append(g.ready, paste...)
+		}
+	}
+}
+
+// partialPasteStart returns the length of the longest suffix of p, two
+// bytes or more, that pasteStart begins with: a marker whose rest has not
+// been read yet.  A lone trailing ESC is not held back; it is more likely
+// the Esc key, which the agent should get straight away.
+func partialPasteStart(p []byte) int {
+	for n := min(len(p), len(pasteStart)-1); n >= 2; n-- {
+		if bytes.HasPrefix(pasteStart, p[len(p)-n:]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// confirm asks whether to send a paste of size bytes and reads the answer,
+// a single key, from the input after it.
+func (g *pasteGuard) confirm(size int) bool {
+	fmt.Fprintf(g.status, "\r\n%ssend %d pasted bytes? [y/N] ", bannerPrefix, size)
+	var answer byte
+	if len(g.pending) > 0 {
+		answer, g.pending = g.pending[0], g.pending[1:]
+	} else {
+		b := make([]byte, 1)
+		n, err := g.r.Read(b)
+		if n == 0 {
+			if err != nil {
+				g.err = err
+			}
+			return false
+		}
+		answer = b[0]
+	}
+	if answer == 'y' || answer == 'Y' {
+		fmt.Fprint(g.status, "y\r\n")
+		return true
+	}
+	fmt.Fprintf(g.status, "\r\n%spaste dropped\r\n", bannerPrefix)
+	return false
+}
+
+// partialRuneLen returns the length of the incomplete UTF-8 sequence at the
+// end of p, or 0 if p ends on a character boundary.  Invalid bytes count as
+// complete; they are passed through as they are.
+func partialRuneLen(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-(utf8.UTFMax-1); i-- {
+		if utf8.RuneStart(p[i]) {
+			if utf8.FullRune(p[i:]) {
+				return 0
+			}
+			return len(p) - i
+		}
+	}
+	return 0
+}
+
+// sendCookedInput forwards stdin one line at a time. The terminal stays in
+// canonical mode, so editing happens locally and the agent only sees the
+// finished line; the trailing newline is sent as a carriage return, which is
+// what a raw terminal sends for Enter.  Ctrl-] anywhere in a line sends the
+// text before it and then detaches.
+func sendCookedInput(w io.Writer, r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if i := strings.IndexByte(line, 0x1D); i >= 0 {
+			if i > 0 {
+				proto.WriteFrame(w, proto.AttachFrameData, []byte(line[:i]))
+			}
+			proto.WriteFrame(w, proto.AttachFrameDetach, nil)
+			return
+		}
+		if line != "" {
+			line = strings.TrimSuffix(line, "\n") + "\r"
+			proto.WriteFrame(w, proto.AttachFrameData, []byte(line))
+		}
+		if err != nil {
+			return
+		}
+	}
 }
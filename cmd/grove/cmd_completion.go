@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/proto"
+)
+
+// commands lists grove's subcommands, in the order completion offers them.
+var commands = []string{
+	"init", "project", "start", "list", "attach", "watch", "logs", "stop",
+	"restart", "drop", "finish", "check", "prune", "dir", "open", "config",
+	"snapshot", "play", "daemon", "metrics", "token", "shell", "exec",
+	"annotate", "mv", "export", "completion", "doctor",
+}
+
+// instanceCommands take an instance ID as their first argument.
+var instanceCommands = map[string]bool{
+	"attach": true, "logs": true, "stop": true, "restart": true, "drop": true,
+	"finish": true, "check": true, "dir": true, "open": true, "config": true,
+	"snapshot": true, "shell": true, "exec": true, "annotate": true, "mv": true,
+	"export": true,
+}
+
+// valueFlags are flags whose value completion knows how to offer, mapped to
+// the kind of value: "instance" or "project".
+var valueFlags = map[string]string{
+	"--instance": "instance", "-instance": "instance",
+	"--project": "project", "-project": "project",
+}
+
+// cmdCompletion handles: grove completion bash|zsh|fish
+//
+// Prints a script that makes the shell complete grove's subcommands,
+// instance IDs and project names.  The script asks grove itself for the
+// candidates (see cmdComplete), so it never goes stale.
+func cmdCompletion() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: grove completion bash|zsh|fish")
+		os.Exit(1)
+	}
+	switch os.Args[2] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		fmt.Fprintf(os.Stderr, "grove: unsupported shell %q (bash, zsh or fish)\n", os.Args[2])
+		os.Exit(1)
+	}
+}
+
+const bashCompletion = `# grove bash completion; load with: source <(grove completion bash)
+_grove() {
+    local IFS=$'\n'
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    COMPREPLY=($(compgen -W "$(grove __complete "${COMP_WORDS[@]:1:COMP_CWORD-1}" 2>/dev/null)" -- "$cur"))
+}
+complete -o default -F _grove grove
+`
+
+const zshCompletion = `#compdef grove
+# grove zsh completion; load with: source <(grove completion zsh)
+_grove() {
+    local -a candidates
+    candidates=("${(@f)$(grove __complete "${(@)words[2,CURRENT-1]}" 2>/dev/null)}")
+    if [[ -n ${candidates[1]} ]]; then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+compdef _grove grove
+`
+
+const fishCompletion = `# grove fish completion; load with: grove completion fish | source
+function __grove_complete
+    set -l tokens (commandline -opc)
+    grove __complete $tokens[2..-1] 2>/dev/null
+end
+complete -c grove -a '(__grove_complete)'
+`
+
+// cmdComplete handles the hidden: grove __complete [word...]
+//
+// The words are the command line typed so far, without "grove" and without
+// the word being completed; the candidates for that word are printed one
+// per line.  The shell filters them by what has been typed.
+func cmdComplete() {
+	for _, c := range completions(os.Args[2:]) {
+		fmt.Println(c)
+	}
+}
+
+// completions returns the candidates for the word following args.
+func completions(args []string) []string {
+	// Drop global flags, which may come before the subcommand.
+	words := args[globalFlagsEnd(append([]string{"grove"}, args...))-1:]
+	if len(words) == 0 {
+		candidates := append([]string(nil), commands...)
+		if cfg, err := loadUserConfig(); err == nil {
+			for name := range cfg.Aliases {
+				if !isCommand(name) {
+					candidates = append(candidates, name)
+				}
+			}
+		}
+		return candidates
+	}
+
+	if kind := valueFlags[words[len(words)-1]]; kind != "" {
+		return completeKind(kind)
+	}
+	cmd := words[0]
+	var positional []string
+	for _, w := range words[1:] {
+		if !strings.HasPrefix(w, "-") {
+			positional = append(positional, w)
+		}
+	}
+
+	switch {
+	case instanceCommands[cmd]:
+		if len(positional) == 0 {
+			return completeKind("instance")
+		}
+	case cmd == "start":
+		if len(positional) == 0 {
+			return completeKind("project")
+		}
+	case cmd == "project":
+		switch {
+		case len(positional) == 0:
+			return []string{"create", "import-all", "list", "delete", "dir", "config-path", "default", "validate-repo", "warm"}
+		case len(positional) == 1 && positional[0] != "create" && positional[0] != "import-all" && positional[0] != "list":
+			return completeKind("project")
+		}
+	case cmd == "daemon":
+		switch {
+		case len(positional) == 0:
+			return []string{"install", "uninstall", "status", "logs", "reload"}
+		case len(positional) == 1 && positional[0] == "reload":
+			return completeKind("project")
+		}
+	case cmd == "completion":
+		if len(positional) == 0 {
+			return []string{"bash", "zsh", "fish"}
+		}
+	}
+	return nil
+}
+
+// completeKind returns the instance IDs or project names to offer.
+func completeKind(kind string) []string {
+	if kind == "project" {
+		var names []string
+		for _, e := range loadProjectEntries() {
+			names = append(names, e.name)
+		}
+		return names
+	}
+	return completionInstanceIDs()
+}
+
+// completionTimeout bounds how long completion waits on the daemon; a
+// tab press must not hang.
+const completionTimeout = 300 * time.Millisecond
+
+// completionInstanceIDs returns the IDs of all instances.  It asks the
+// daemon if one answers quickly, without ever starting one, and otherwise
+// reads the instance records the daemon persists.
+func completionInstanceIDs() []string {
+	if conn, err := dialAddr(socketPath(), completionTimeout); err == nil {
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(completionTimeout))
+		if writeRequest(conn, proto.Request{Type: proto.ReqList}) == nil {
+			if resp, err := readResponse(conn); err == nil && resp.OK {
+				ids := make([]string, 0, len(resp.Instances))
+				for _, inst := range resp.Instances {
+					ids = append(ids, inst.ID)
+				}
+				sort.Strings(ids)
+				return ids
+			}
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(rootDir(), "instances", "*.json"))
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		ids = append(ids, strings.TrimSuffix(filepath.Base(f), ".json"))
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -2,19 +2,27 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/gandalfthegui/grove/internal/proto"
 )
 
 func cmdDaemon() {
 	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove daemon <install|uninstall|status|logs>")
+		fmt.Fprintln(os.Stderr, "usage: grove daemon <install|uninstall|status|logs|reload>")
 		os.Exit(1)
 	}
 	switch os.Args[2] {
@@ -26,37 +34,131 @@ func cmdDaemon() {
 		cmdDaemonStatus()
 	case "logs":
 		cmdDaemonLogs()
+	case "reload":
+		cmdDaemonReload()
 	default:
 		fmt.Fprintf(os.Stderr, "grove: unknown daemon subcommand %q\n", os.Args[2])
 		os.Exit(1)
 	}
 }
 
+// cmdDaemonReload handles: grove daemon reload <project|#>
+//
+// Pulls the project's main checkout and reports which grove.yaml sections
+// changed and when running instances pick them up.
+func cmdDaemonReload() {
+	if len(os.Args) < 4 {
+		fmt.Fprintln(os.Stderr, "usage: grove daemon reload <project|#>")
+		os.Exit(1)
+	}
+	streamCommand(proto.Request{Type: proto.ReqReload, Project: resolveProject(os.Args[3])})
+}
+
+// cmdMetrics handles: grove metrics [--json]
+func cmdMetrics() {
+	fs := flag.NewFlagSet("metrics", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the raw metrics object")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove metrics [--json]")
+	}
+	fs.Parse(os.Args[2:])
+
+	resp := mustRequest(proto.Request{Type: proto.ReqMetrics})
+	if resp.Metrics == nil {
+		fmt.Fprintln(os.Stderr, "grove: daemon returned no metrics")
+		os.Exit(1)
+	}
+	m := *resp.Metrics
+
+	if *asJSON {
+		data, _ := json.MarshalIndent(m, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	docker := colorGreen + "reachable" + colorReset
+	if !m.DockerReachable {
+		docker = colorRed + "unreachable" + colorReset
+	}
+	fmt.Printf("%-16s %s\n", "uptime", formatUptime(m.UptimeSeconds))
+	fmt.Printf("%-16s %s\n", "docker", docker)
+	fmt.Printf("%-16s %d %s(%d started since daemon start)%s\n", "instances", m.Instances, colorDim, m.InstancesStarted, colorReset)
+	states := make([]string, 0, len(m.States))
+	for s := range m.States {
+		states = append(states, s)
+	}
+	sort.Strings(states)
+	for _, s := range states {
+		fmt.Printf("  %s%-14s%s %d\n", colorState(s), s, colorReset, m.States[s])
+	}
+	fmt.Printf("%-16s %d\n", "attached", m.AttachSessions)
+	fmt.Printf("%-16s %s\n", "log buffers", formatBytes(m.LogBytes))
+}
+
+// formatBytes renders n using binary units (B, KiB, MiB, GiB).
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 2; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMG"[exp])
+}
+
 func cmdDaemonLogs() {
 	fs := flag.NewFlagSet("daemon logs", flag.ExitOnError)
 	follow := fs.Bool("f", false, "follow log output")
 	fs.BoolVar(follow, "follow", false, "follow log output")
 	tailLines := fs.Int("n", 0, "print only the last N lines (0 = full file)")
 	fs.IntVar(tailLines, "tail", 0, "print only the last N lines (0 = full file)")
+	instanceID := fs.String("instance", "", "only show lines about this instance")
+	asJSON := fs.Bool("json", false, "print each line as a JSON object (NDJSON)")
+	asText := fs.Bool("text", false, "print each line as text, rendering JSON lines")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove daemon logs [-f] [-n N]")
+		fmt.Fprintln(os.Stderr, "usage: grove daemon logs [-f] [-n N] [--instance <id>] [--json | --text]")
 	}
 	fs.Parse(os.Args[3:])
 	if len(fs.Args()) != 0 {
-		fmt.Fprintln(os.Stderr, "usage: grove daemon logs [-f] [-n N]")
+		fs.Usage()
 		os.Exit(1)
 	}
 	if *tailLines < 0 {
 		fmt.Fprintln(os.Stderr, "grove: -n/--tail must be >= 0")
 		os.Exit(1)
 	}
+	if *asJSON && *asText {
+		fmt.Fprintln(os.Stderr, "grove: --json and --text cannot be combined")
+		os.Exit(1)
+	}
+
+	var keep func(string) bool
+	if *instanceID != "" {
+		// Match against the text form so attributes of JSON lines count.
+		match := instanceLogMatcher(*instanceID)
+		keep = func(line string) bool { return match(parseDaemonLogLine(line).text()) }
+	}
+	var render func(string) string
+	switch {
+	case *asJSON:
+		render = func(line string) string { return parseDaemonLogLine(line).json() }
+	case *asText:
+		render = func(line string) string { return parseDaemonLogLine(line).text() }
+	}
+	var out io.Writer = os.Stdout
+	if keep != nil || render != nil {
+		out = &lineFilterWriter{w: os.Stdout, keep: keep, render: render}
+	}
 
 	logPath := filepath.Join(rootDir(), "daemon.log")
 	var err error
 	if *tailLines > 0 {
-		err = printLastLines(logPath, *tailLines, os.Stdout)
+		err = printLastLines(logPath, *tailLines, out, keep)
 	} else {
-		err = copyFileToStdout(logPath)
+		err = copyFile(logPath, out)
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
@@ -64,14 +166,144 @@ func cmdDaemonLogs() {
 	}
 
 	if *follow {
-		if err := followFile(logPath); err != nil {
+		if err := followFile(logPath, out); err != nil {
 			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 			os.Exit(1)
 		}
 	}
 }
 
-func copyFileToStdout(path string) error {
+// daemonLogTimeLayout is the timestamp the standard log package puts at the
+// start of each daemon log line.
+const daemonLogTimeLayout = "2006/01/02 15:04:05"
+
+// daemonLogEntry is one line of the daemon log.  The log may hold text lines
+// from the standard log package ("2006/01/02 15:04:05 message") and JSON
+// objects with time, level and msg fields (log/slog's JSON format), even
+// mixed in one file; lines that are neither keep their content in msg.
+type daemonLogEntry struct {
+	raw   string
+	isObj bool      // raw is a JSON object
+	time  time.Time // zero if the line has none
+	level string    // JSON lines only
+	msg   string
+	attrs map[string]any // other JSON fields
+}
+
+func parseDaemonLogLine(line string) daemonLogEntry {
+	e := daemonLogEntry{raw: line, msg: line}
+	if strings.HasPrefix(line, "{") {
+		var obj map[string]any
+		if json.Unmarshal([]byte(line), &obj) == nil {
+			e.isObj = true
+			e.msg = ""
+			if v, ok := obj["time"].(string); ok {
+				e.time, _ = time.Parse(time.RFC3339Nano, v)
+			}
+			e.level, _ = obj["level"].(string)
+			e.msg, _ = obj["msg"].(string)
+			delete(obj, "time")
+			delete(obj, "level")
+			delete(obj, "msg")
+			e.attrs = obj
+			return e
+		}
+	}
+	if len(line) > len(daemonLogTimeLayout) {
+		if t, err := time.ParseInLocation(daemonLogTimeLayout, line[:len(daemonLogTimeLayout)], time.Local); err == nil {
+			e.time = t
+			e.msg = strings.TrimPrefix(line[len(daemonLogTimeLayout):], " ")
+		}
+	}
+	return e
+}
+
+// text renders e the way the daemon writes text lines, with a JSON line's
+// level before the message and its other fields after it as sorted key=value
+// pairs.  Text lines are returned unchanged.
+func (e daemonLogEntry) text() string {
+	if !e.isObj {
+		return e.raw
+	}
+	var b strings.Builder
+	if !e.time.IsZero() {
+		b.WriteString(e.time.Local().Format(daemonLogTimeLayout) + " ")
+	}
+	if e.level != "" {
+		b.WriteString(e.level + " ")
+	}
+	b.WriteString(e.msg)
+	keys := make([]string, 0, len(e.attrs))
+	for k := range e.attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fmt.Sprint(e.attrs[k])
+		if strings.ContainsAny(v, " \t\"=") || v == "" {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	return b.String()
+}
+
+// json renders e as one JSON object.  JSON lines are returned unchanged;
+// text lines become {"time": RFC 3339, "msg": ...}.
+func (e daemonLogEntry) json() string {
+	if e.isObj {
+		return e.raw
+	}
+	obj := struct {
+		Time string `json:"time,omitempty"`
+		Msg  string `json:"msg"`
+	}{Msg: e.msg}
+	if !e.time.IsZero() {
+		obj.Time = e.time.Format(time.RFC3339)
+	}
+	data, _ := json.Marshal(obj)
+	return string(data)
+}
+
+// instanceLogMatcher returns a predicate matching daemon log lines about
+// instance id, which the daemon writes as "instance=<id>" or "instance <id>:".
+func instanceLogMatcher(id string) func(string) bool {
+	re := regexp.MustCompile(`\binstance[= ]` + regexp.QuoteMeta(id) + `\b`)
+	return re.MatchString
+}
+
+// lineFilterWriter passes through only the complete lines for which keep
+// returns true (all lines if keep is nil), rewritten by render if set,
+// holding a trailing partial line until the rest arrives.
+type lineFilterWriter struct {
+	w      io.Writer
+	keep   func(string) bool
+	render func(string) string
+	buf    []byte
+}
+
+func (f *lineFilterWriter) Write(p []byte) (int, error) {
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := f.buf[:i+1]
+		if f.keep == nil || f.keep(string(line[:i])) {
+			if f.render != nil {
+				line = []byte(f.render(string(line[:i])) + "\n")
+			}
+			if _, err := f.w.Write(line); err != nil {
+				return 0, err
+			}
+		}
+		f.buf = f.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func copyFile(path string, w io.Writer) error {
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -81,13 +313,15 @@ func copyFileToStdout(path string) error {
 	}
 	defer f.Close()
 
-	if _, err := io.Copy(os.Stdout, f); err != nil {
+	if _, err := io.Copy(w, f); err != nil {
 		return fmt.Errorf("read daemon log: %w", err)
 	}
 	return nil
 }
 
-func printLastLines(path string, n int, w io.Writer) error {
+// printLastLines writes the last n lines of path to w, counting only lines
+// for which keep returns true (all lines if keep is nil).
+func printLastLines(path string, n int, w io.Writer, keep func(string) bool) error {
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -103,6 +337,9 @@ func printLastLines(path string, n int, w io.Writer) error {
 	ring := make([]string, n)
 	count := 0
 	for scanner.Scan() {
+		if keep != nil && !keep(scanner.Text()) {
+			continue
+		}
 		ring[count%n] = scanner.Text()
 		count++
 	}
@@ -122,7 +359,7 @@ func printLastLines(path string, n int, w io.Writer) error {
 	return nil
 }
 
-func followFile(path string) error {
+func followFile(path string, w io.Writer) error {
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -164,7 +401,7 @@ func followFile(path string) error {
 			if _, err := f.Seek(offset, io.SeekStart); err != nil {
 				return fmt.Errorf("seek daemon log: %w", err)
 			}
-			if _, err := io.CopyN(os.Stdout, f, size-offset); err != nil && err != io.EOF {
+			if _, err := io.CopyN(w, f, size-offset); err != nil && err != io.EOF {
 				return fmt.Errorf("read daemon log: %w", err)
 			}
 			offset = size
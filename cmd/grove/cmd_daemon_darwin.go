@@ -71,9 +71,12 @@ func cmdDaemonInstall() {
 		}
 	}
 	fmt.Fprintf(os.Stderr, "%s✗  daemon did not start%s\n\n", colorRed+colorBold, colorReset)
-	warnIfDockerUnavailable()
+	noDocker := warnIfDockerUnavailable()
 	fmt.Fprintf(os.Stderr, "  Check the log for details: %s%s%s\n\n", colorCyan, logFile, colorReset)
-	os.Exit(1)
+	if noDocker {
+		os.Exit(exitNoDocker)
+	}
+	os.Exit(exitNoDaemon)
 }
 
 func cmdDaemonUninstall() {
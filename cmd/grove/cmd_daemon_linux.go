@@ -0,0 +1,163 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const systemdUnitName = "groved.service"
+
+func systemdUnitPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user", systemdUnitName)
+}
+
+// systemctl runs systemctl --user with args and returns its combined output.
+func systemctl(args ...string) (string, error) {
+	out, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+func cmdDaemonInstall() {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: cannot resolve executable path: %v\n", err)
+		os.Exit(1)
+	}
+	daemonBin, err := locateDaemon(exe)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	root := rootDir()
+	logFile := filepath.Join(root, "daemon.log")
+	socketPath := filepath.Join(root, "groved.sock")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	unit := buildUnit(daemonBin, root, logFile, os.Getenv("PATH"), os.Getenv("GROVE_CONTAINER_RUNTIME"))
+
+	unitPath := systemdUnitPath()
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(unitPath, []byte(unit), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	// restart rather than start, so a reinstall picks up the new unit.
+	for _, args := range [][]string{
+		{"daemon-reload"},
+		{"enable", systemdUnitName},
+		{"restart", systemdUnitName},
+	} {
+		if out, err := systemctl(args...); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: systemctl --user %s failed: %v\n%s\n", strings.Join(args, " "), err, out)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("\n%s✓  groved systemd unit installed%s\n\n", colorGreen+colorBold, colorReset)
+	fmt.Printf("  %sUnit:%s %s%s%s\n", colorDim, colorReset, colorCyan, unitPath, colorReset)
+	fmt.Printf("  %sLog:%s  %s%s%s\n\n", colorDim, colorReset, colorCyan, logFile, colorReset)
+
+	// Verify the daemon actually started — the unit is enabled but the
+	// process may have exited immediately (e.g. Docker not running).
+	for i := 0; i < 20; i++ {
+		time.Sleep(150 * time.Millisecond)
+		if pingDaemon(socketPath) {
+			fmt.Printf("%s✓  daemon is running%s\n\n", colorGreen+colorBold, colorReset)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s✗  daemon did not start%s\n\n", colorRed+colorBold, colorReset)
+	noDocker := warnIfDockerUnavailable()
+	fmt.Fprintf(os.Stderr, "  Check the log for details: %s%s%s\n\n", colorCyan, logFile, colorReset)
+	if noDocker {
+		os.Exit(exitNoDocker)
+	}
+	os.Exit(exitNoDaemon)
+}
+
+func cmdDaemonUninstall() {
+	systemctl("disable", "--now", systemdUnitName)
+	os.Remove(systemdUnitPath())
+	systemctl("daemon-reload")
+
+	fmt.Printf("\n%s✓  groved systemd unit removed%s\n\n", colorGreen+colorBold, colorReset)
+}
+
+func cmdDaemonStatus() {
+	unitPath := systemdUnitPath()
+	if _, err := os.Stat(unitPath); os.IsNotExist(err) {
+		fmt.Printf("%snot installed%s\n", colorDim, colorReset)
+		return
+	}
+
+	// is-active exits non-zero unless the unit is active; its output is
+	// the state either way.
+	state, _ := systemctl("is-active", systemdUnitName)
+	if state == "" {
+		state = "unknown"
+	}
+	sock := filepath.Join(rootDir(), "groved.sock")
+	if pingDaemon(sock) {
+		fmt.Printf("%s✓  running%s\n\n", colorGreen+colorBold, colorReset)
+	} else {
+		fmt.Printf("%s⚠  installed but not running%s\n\n", colorYellow+colorBold, colorReset)
+	}
+	fmt.Printf("  %sunit:%s    %s%s%s\n", colorDim, colorReset, colorCyan, unitPath, colorReset)
+	fmt.Printf("  %ssystemd:%s %s\n", colorDim, colorReset, state)
+}
+
+// buildUnit generates the systemd user unit.  envPath is set as PATH so the
+// daemon finds docker and git as the user's shell does (the user manager's
+// PATH is minimal), and runtime, if set, carries GROVE_CONTAINER_RUNTIME.
+// Output goes to logFile, where grove daemon logs reads it.
+func buildUnit(daemonBin, rootDir, logFile, envPath, runtime string) string {
+	var env strings.Builder
+	fmt.Fprintf(&env, "Environment=%s\n", systemdQuote("PATH="+envPath))
+	if runtime != "" {
+		fmt.Fprintf(&env, "Environment=%s\n", systemdQuote("GROVE_CONTAINER_RUNTIME="+runtime))
+	}
+	return fmt.Sprintf(`[Unit]
+Description=Grove daemon
+
+[Service]
+ExecStart=%s --root %s
+%sRestart=on-failure
+StandardOutput=append:%s
+StandardError=append:%s
+
+[Install]
+WantedBy=default.target
+`, systemdQuote(daemonBin), systemdQuote(rootDir), env.String(),
+		systemdEscape(logFile), systemdEscape(logFile))
+}
+
+// systemdQuote double-quotes s as one word of a unit file setting.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + systemdEscape(s) + `"`
+}
+
+// systemdEscape escapes the % that would start a unit file specifier.
+func systemdEscape(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildUnit(t *testing.T) {
+	unit := buildUnit("/usr/local/bin/groved", "/home/user/.grove", "/home/user/.grove/daemon.log", "/usr/bin:/usr/local/bin", "")
+	assert.Contains(t, unit, `ExecStart="/usr/local/bin/groved" --root "/home/user/.grove"`)
+	assert.Contains(t, unit, `Environment="PATH=/usr/bin:/usr/local/bin"`)
+	assert.Contains(t, unit, "StandardOutput=append:/home/user/.grove/daemon.log")
+	assert.Contains(t, unit, "WantedBy=default.target")
+	assert.NotContains(t, unit, "GROVE_CONTAINER_RUNTIME")
+
+	unit = buildUnit("/opt/my tools/groved", `/data/100% "grove"`, "/data/log", "/usr/bin", "podman")
+	assert.Contains(t, unit, `ExecStart="/opt/my tools/groved" --root "/data/100%% \"grove\""`)
+	assert.Contains(t, unit, `Environment="GROVE_CONTAINER_RUNTIME=podman"`)
+}
@@ -1,4 +1,4 @@
-//go:build !darwin
+//go:build !darwin && !linux
 
 package main
 
@@ -8,19 +8,19 @@ import (
 )
 
 func cmdDaemonInstall() {
-	fmt.Fprintln(os.Stderr, "grove: daemon install is macOS-only (uses LaunchAgent)")
-	fmt.Fprintln(os.Stderr, "  On Linux, manage groved with systemd — see docs/TECHNICAL.md")
+	fmt.Fprintln(os.Stderr, "grove: daemon install needs launchd (macOS) or systemd (Linux)")
+	fmt.Fprintln(os.Stderr, "  Elsewhere, run groved under your init system — see docs/TECHNICAL.md")
 	os.Exit(1)
 }
 
 func cmdDaemonUninstall() {
-	fmt.Fprintln(os.Stderr, "grove: daemon uninstall is macOS-only (uses LaunchAgent)")
-	fmt.Fprintln(os.Stderr, "  On Linux, manage groved with systemd — see docs/TECHNICAL.md")
+	fmt.Fprintln(os.Stderr, "grove: daemon uninstall needs launchd (macOS) or systemd (Linux)")
+	fmt.Fprintln(os.Stderr, "  Elsewhere, run groved under your init system — see docs/TECHNICAL.md")
 	os.Exit(1)
 }
 
 func cmdDaemonStatus() {
-	fmt.Fprintln(os.Stderr, "grove: daemon status is macOS-only (uses LaunchAgent)")
-	fmt.Fprintln(os.Stderr, "  On Linux, manage groved with systemd — see docs/TECHNICAL.md")
+	fmt.Fprintln(os.Stderr, "grove: daemon status needs launchd (macOS) or systemd (Linux)")
+	fmt.Fprintln(os.Stderr, "  Elsewhere, run groved under your init system — see docs/TECHNICAL.md")
 	os.Exit(1)
 }
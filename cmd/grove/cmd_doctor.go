@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gandalfthegui/grove/internal/diskspace"
+	"github.com/gandalfthegui/grove/internal/engine"
+)
+
+// doctorCheck is one line of grove doctor's report.
+type doctorCheck struct {
+	name   string
+	ok     bool
+	warn   bool   // ok, but worth attention; does not fail grove doctor
+	detail string // resolved value when ok, else what is wrong and how to fix it
+}
+
+// cmdDoctor handles: grove doctor
+//
+// Checks the pieces grove needs outside its own binary — the groved binary
+// it starts the daemon from, Docker, the daemon itself, room on disk for its
+// data and the agent token — and says how to fix the ones that are missing.
+// Exits non-zero if any check fails; warnings do not count.
+func cmdDoctor() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: grove doctor")
+		os.Exit(1)
+	}
+	exe, _ := os.Executable()
+	if printDoctorChecks(os.Stdout, doctorChecks(exe)) > 0 {
+		os.Exit(1)
+	}
+}
+
+// doctorChecks runs grove doctor's checks.  Docker, disk space and the
+// token are the daemon host's; with a daemon reached over TCP (GROVE_ADDR)
+// that may be another machine, so they are skipped rather than reported for
+// this one.
+func doctorChecks(exe string) []doctorCheck {
+	onDaemonHost := func(name string, check func() doctorCheck) doctorCheck {
+		if network, _ := daemonNetwork(socketPath()); network == "tcp" {
+			return doctorCheck{name: name, ok: true, detail: "skipped: the daemon at " + socketPath() + " may be on another host; run grove doctor there"}
+		}
+		return check()
+	}
+	return []doctorCheck{
+		checkDaemonBinary(exe),
+		onDaemonHost(engine.Name(), checkDocker),
+		checkDaemonRunning(),
+		onDaemonHost("disk", func() doctorCheck { return checkDiskSpace(rootDir()) }),
+		onDaemonHost("token", checkToken),
+	}
+}
+
+// checkDaemonBinary looks for groved exactly where ensureDaemon does.
+func checkDaemonBinary(exe string) doctorCheck {
+	path, err := locateDaemon(exe)
+	if err != nil {
+		return doctorCheck{name: "groved", detail: err.Error()}
+	}
+	return doctorCheck{name: "groved", ok: true, detail: path}
+}
+
+func checkDocker() doctorCheck {
+	name := engine.Name()
+	if err := engine.Command("info").Run(); err != nil {
+		if name != engine.Default {
+			return doctorCheck{name: name, detail: "not running or not installed (" + name + " info: " + err.Error() + "); check GROVE_CONTAINER_RUNTIME"}
+		}
+		return doctorCheck{name: "docker", detail: "not running or not installed (docker info: " + err.Error() + "); start Docker Desktop or see https://docs.docker.com/get-docker/"}
+	}
+	return doctorCheck{name: name, ok: true, detail: "reachable"}
+}
+
+// checkDaemonRunning never starts the daemon; one that is simply not running
+// yet is fine, since grove starts it on demand.
+func checkDaemonRunning() doctorCheck {
+	sock := socketPath()
+	if pingDaemon(sock) {
+		return doctorCheck{name: "daemon", ok: true, detail: "answering on " + sock}
+	}
+	if socketOverride != "" {
+		return doctorCheck{name: "daemon", detail: "nothing answering on " + sock}
+	}
+	return doctorCheck{name: "daemon", ok: true, detail: "not running; grove starts it when needed"}
+}
+
+// checkDiskSpace warns when the filesystem holding the data root is nearly
+// out of space or inodes.
+func checkDiskSpace(root string) doctorCheck {
+	free, err := diskspace.Check(root)
+	if err != nil {
+		return doctorCheck{name: "disk", detail: err.Error()}
+	}
+	if free.LowBytes() || free.LowInodes() {
+		return doctorCheck{name: "disk", ok: true, warn: true, detail: fmt.Sprintf("only %s for %s; grove prune drops exited instances and their worktrees", free, root)}
+	}
+	return doctorCheck{name: "disk", ok: true, detail: fmt.Sprintf("%s for %s", free, root)}
+}
+
+// checkToken warns when an agent was refused the Claude token it was given
+// since its env file was last written (see tokenExpiredSince).  It is not a
+// failure: the refusal may have been transient.
+func checkToken() doctorCheck {
+	data, err := os.ReadFile(filepath.Join(rootDir(), "token-expired"))
+	if err != nil {
+		return doctorCheck{name: "token", ok: true, detail: "no rejected token recorded"}
+	}
+	project := strings.TrimSpace(string(data))
+	envPath := agentEnvPath(project)
+	since, expired := tokenExpiredSince(envPath)
+	if !expired {
+		return doctorCheck{name: "token", ok: true, detail: "no rejected token recorded"}
+	}
+	return doctorCheck{name: "token", ok: true, warn: true, detail: fmt.Sprintf("an agent of %s was refused the token in %s at %s; run claude setup-token and save the result with grove token",
+		project, envPath, since.Format("2006-01-02 15:04"))}
+}
+
+// printDoctorChecks writes one line per check and returns how many failed.
+func printDoctorChecks(w io.Writer, checks []doctorCheck) int {
+	failed := 0
+	for _, c := range checks {
+		mark := colorGreen + "✓" + colorReset
+		switch {
+		case !c.ok:
+			mark = colorRed + "✗" + colorReset
+			failed++
+		case c.warn:
+			mark = colorYellow + "!" + colorReset
+		}
+		fmt.Fprintf(w, "%s  %-8s %s\n", mark, c.name, c.detail)
+	}
+	return failed
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gandalfthegui/grove/internal/gitref"
+	"github.com/gandalfthegui/grove/internal/registration"
+)
+
+// cmdExport handles: grove export <instance-id> [--format patch|bundle] [-o file]
+//
+// It packages the commits on the instance's branch since it diverged from the
+// default branch, so the work can be reviewed or applied elsewhere without
+// pushing.  Runs entirely on the client against the worktree on disk.
+func cmdExport() {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "patch", "patch or bundle")
+	out := fs.String("o", "", "output file (default: stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove export <instance-id> [--format patch|bundle] [-o file]")
+	}
+	args := parseInterspersed(fs, os.Args[2:])
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	instanceID := args[0]
+	if *format != "patch" && *format != "bundle" {
+		fmt.Fprintf(os.Stderr, "grove: unknown export format %q (want patch or bundle)\n", *format)
+		os.Exit(1)
+	}
+
+	inst := findInstance(instanceID)
+	if inst == nil {
+		exitWith(exitNotFound, "instance not found: %s", instanceID)
+	}
+	dir := inst.WorktreeDir
+
+	base, baseRef, err := exportBase(dir, registration.ReadDefaultBranch(rootDir(), inst.Project))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	count, _ := gitOutput(dir, "rev-list", "--count", base+"..HEAD")
+	if count == "0" {
+		fmt.Fprintf(os.Stderr, "grove: %s has no commits since %s; nothing to export\n", inst.Branch, baseRef)
+		os.Exit(1)
+	}
+	if status, _ := gitOutput(dir, "status", "--porcelain"); status != "" {
+		fmt.Fprintf(os.Stderr, "%sgrove: warning: worktree has uncommitted changes; they are not included%s\n", colorYellow, colorReset)
+	}
+
+	var cmd *exec.Cmd
+	switch *format {
+	case "patch":
+		cmd = exec.Command("git", "-C", dir, "format-patch", "--stdout", base+"..HEAD")
+	case "bundle":
+		// A bundle needs a ref to be fetchable; name it after the branch.
+		target := *out
+		if target == "" {
+			target = "-"
+		}
+		cmd = exec.Command("git", "-C", dir, "bundle", "create", "--quiet", target, "^"+base, inst.Branch)
+	}
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	if *format == "patch" && *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		cmd.Stdout = f
+	}
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: git %s failed: %v\n", *format, err)
+		os.Exit(1)
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "\n%s✓  Exported%s %s commit(s) since %s to %s%s%s\n\n",
+			colorGreen+colorBold, colorReset, count, baseRef, colorCyan, *out, colorReset)
+	}
+}
+
+// exportBase returns the merge-base of HEAD with the repository's default
+// branch in dir, along with the name of the ref it was computed against.
+// The branch is found as the daemon finds it for grove finish (see
+// gitref.DefaultBranch), so defaultBranch is the project's default_branch.
+func exportBase(dir, defaultBranch string) (base, ref string, err error) {
+	name, ref := gitref.DefaultBranch(dir, defaultBranch)
+	if ref == "" {
+		if name != "" {
+			return "", "", fmt.Errorf("default_branch %q does not exist on origin or locally in %s", name, dir)
+		}
+		return "", "", fmt.Errorf("cannot find the default branch in %s", dir)
+	}
+	base, err = gitOutput(dir, "merge-base", "HEAD", ref)
+	if err != nil {
+		return "", "", fmt.Errorf("merge-base with %s: %w", ref, err)
+	}
+	return base, ref, nil
+}
+
+// gitOutput runs git in dir and returns its trimmed stdout.
+func gitOutput(dir string, args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -2,15 +2,24 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/gandalfthegui/grove/internal/engine"
+	"github.com/gandalfthegui/grove/internal/envfile"
 	"github.com/gandalfthegui/grove/internal/proto"
 )
 
@@ -31,39 +40,211 @@ func stripBoolFlag(args []string, short, long string) ([]string, bool) {
 	return out, found
 }
 
+// parseInterspersed parses fs over args, allowing flags before, between and
+// after positional arguments, and returns the positionals in order.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// agentEnvWithFiles returns the AgentEnv to send when starting an agent for
+// project: credentials from ensureAgentCredentials overlaid with the given
+// --env-file files.  Those take precedence over the global/project env file
+// on the daemon side, so a token in one of them needs no prompt.  Exits on
+// an unreadable file.
+func agentEnvWithFiles(project string, envFiles []string) map[string]string {
+	fileEnv, err := loadEnvFiles(envFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	var agentEnv map[string]string
+	if fileEnv["CLAUDE_CODE_OAUTH_TOKEN"] == "" && fileEnv["ANTHROPIC_API_KEY"] == "" {
+		agentEnv = ensureAgentCredentials(project)
+	}
+	if len(fileEnv) > 0 {
+		if agentEnv == nil {
+			agentEnv = map[string]string{}
+		}
+		for k, v := range fileEnv {
+			agentEnv[k] = v
+		}
+	}
+	return agentEnv
+}
+
+// loadEnvFiles loads each dotenv file in order and merges them, later files
+// overriding earlier ones.  Unlike the global env file, a file named on the
+// command line must exist.
+func loadEnvFiles(paths []string) (map[string]string, error) {
+	env := map[string]string{}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("env file: %w", err)
+		}
+		for k, v := range envfile.Load(path) {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+// stringList is a flag.Value that collects every occurrence of a
+// repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// parseKeyValues parses "key=value" arguments into a map.  A bare "key=" maps
+// to an empty value.
+func parseKeyValues(args []string) (map[string]string, error) {
+	kv := make(map[string]string, len(args))
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", a)
+		}
+		kv[k] = v
+	}
+	return kv, nil
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatAnnotations renders annotations as space-separated key=value pairs
+// sorted by key.
+func formatAnnotations(a map[string]string) string {
+	keys := sortedKeys(a)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + a[k]
+	}
+	return strings.Join(parts, " ")
+}
+
 func cmdStart() {
 	rawArgs, detach := stripBoolFlag(os.Args[2:], "d", "detach")
+	rawArgs, resume := stripBoolFlag(rawArgs, "resume", "resume")
+	rawArgs, openEditor := stripBoolFlag(rawArgs, "open", "open")
 	fs := flag.NewFlagSet("start", flag.ExitOnError)
+	var envFiles, agentArgs stringList
+	fs.Var(&envFiles, "env-file", "extra agent env file (repeatable; later files win)")
+	fs.Var(&agentArgs, "agent-arg", "extra argument appended to the agent command (repeatable)")
+	timeout := fs.Duration("timeout", 0, "stop waiting for setup after this long, e.g. 5m (default: wait until it is done)")
+	prompt := fs.String("prompt", "", "task to type into the agent once it is ready")
+	name := fs.String("name", "", "label that other commands accept in place of the instance ID")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove start <project|#> <branch> [-d]")
+		fmt.Fprintln(os.Stderr, "usage: grove start [<project|#>] <branch>... [-d] [--resume] [--open] [--timeout <duration>] [--prompt <text>] [--name <name>] [--env-file <path>]... [--agent-arg <arg>]...")
 	}
-	fs.Parse(rawArgs)
-	args := fs.Args()
-	if len(args) < 2 {
-		fmt.Fprintln(os.Stderr, "usage: grove start <project|#> <branch> [-d]")
+	args := parseInterspersed(fs, rawArgs)
+	var project string
+	var branches []string
+	switch len(args) {
+	case 1:
+		// Only a branch: start it in the default project.
+		p, err := defaultProjectFor(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			fs.Usage()
+			os.Exit(1)
+		}
+		project, branches = p, args[:1]
+	case 0:
+		fs.Usage()
+		os.Exit(1)
+	default:
+		project, branches = resolveProject(args[0]), args[1:]
+	}
+	if *name != "" && len(branches) > 1 {
+		fmt.Fprintln(os.Stderr, "grove: --name labels a single instance; start the branches one at a time to name them")
 		os.Exit(1)
 	}
-	project := resolveProject(args[0])
-	branch := args[1]
 
-	agentEnv := ensureAgentCredentials(project)
+	req := proto.Request{
+		Type:      proto.ReqStart,
+		Project:   project,
+		AgentEnv:  agentEnvWithFiles(project, envFiles),
+		AgentArgs: agentArgs,
+		Resume:    resume,
+		Prompt:    *prompt,
+		Name:      *name,
+	}
+	if len(branches) > 1 {
+		startMany(req, branches, *timeout, openEditor)
+		return
+	}
 
+	req.Branch = branches[0]
+	resp, code := startBranch(req, *timeout)
+	if code != 0 {
+		os.Exit(code)
+	}
+
+	// Without an attach to follow, this line is the only place the new
+	// instance's ID shows, so GROVE_NO_BANNERS does not drop it.
+	pipe := len(resp.Instances) == 1 && resp.Instances[0].Pipe
+	if banners || detach || pipe {
+		fmt.Printf("\n%s%s✓  Started instance%s %s%s%s\n\n", bannerPrefix, colorGreen+colorBold, colorReset, colorCyan, resp.InstanceID, colorReset)
+	}
+
+	// The editor runs alongside the attach session, so it is started in the
+	// background; a failure is only a warning since the instance is up.
+	if openEditor {
+		openStarted(resp)
+	}
+
+	if pipe {
+		// No terminal to attach to; point at the log instead.
+		if banners {
+			fmt.Printf("%s%sagent runs without a terminal; follow it with:%s grove logs -f %s\n\n", bannerPrefix, colorDim, colorReset, resp.InstanceID)
+		}
+		return
+	}
+	if !detach {
+		doAttach(resp.InstanceID, attachOptions{})
+	}
+}
+
+// startBranch sends req, a ReqStart for one branch, and waits for the
+// instance to be set up, showing a throbber and then the setup output.  On
+// failure it prints why and returns the exit code for it; on success the
+// code is 0.  A project without grove.yaml offers to create one and exits,
+// since no branch of it can start.
+func startBranch(req proto.Request, timeout time.Duration) (proto.Response, int) {
 	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
+	conn, err := dialDaemon(socketPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+		return proto.Response{}, exitNoDaemon
 	}
 
-	if err := writeRequest(conn, proto.Request{
-		Type:     proto.ReqStart,
-		Project:  project,
-		Branch:   branch,
-		AgentEnv: agentEnv,
-	}); err != nil {
+	if err := writeRequest(conn, req); err != nil {
 		conn.Close()
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+		return proto.Response{}, exitFailure
+	}
+
+	// The daemon finishes setting up whether or not anyone is still waiting
+	// for it, so giving up here leaves nothing half-built behind.
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
 	}
 
 	// Show a throbber while the daemon starts the container and shell (clone, container, start commands, agent install).
@@ -72,6 +253,7 @@ func cmdStart() {
 	go func() {
 		defer close(throbberDone)
 		frames := []rune(`|/-\`)
+		began := time.Now()
 		i := 0
 		for {
 			select {
@@ -80,7 +262,7 @@ func cmdStart() {
 				fmt.Fprint(os.Stderr, "\r  \033[K")
 				return
 			default:
-				fmt.Fprintf(os.Stderr, "\r  Starting instance %c  ", frames[i])
+				fmt.Fprint(os.Stderr, throbberLine(frames[i], time.Since(began)))
 				i = (i + 1) % len(frames)
 				time.Sleep(120 * time.Millisecond)
 			}
@@ -92,138 +274,597 @@ func cmdStart() {
 	<-throbberDone
 	if err != nil {
 		conn.Close()
+		if timedOut(err) {
+			fmt.Fprintf(os.Stderr, "grove: gave up waiting after %s; the daemon is still starting %s in %s\n", timeout, req.Branch, req.Project)
+			fmt.Fprintf(os.Stderr, "grove: it will appear in 'grove list --project %s' once ready (progress: grove daemon logs -f)\n", req.Project)
+			return resp, exitFailure
+		}
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+		return resp, exitFailure
 	}
+	conn.SetReadDeadline(time.Time{})
 	if !resp.OK {
-		conn.Close()
 		if resp.InitPath != "" {
+			conn.Close()
 			// Project exists but has no grove.yaml — prompt the user to create one.
-			promptCreateProjectConfig(resp.InitPath, project)
+			promptCreateProjectConfig(resp.InitPath, req.Project)
 			os.Exit(1)
 		}
+		// The daemon streams whatever setup output it captured before the
+		// failure; show it so the cause is visible even with -d.
+		n, _ := io.Copy(os.Stderr, conn)
+		conn.Close()
+		if n > 0 {
+			fmt.Fprintln(os.Stderr)
+		}
 		fmt.Fprintf(os.Stderr, "grove: %s\n", resp.Error)
-		fmt.Fprintf(os.Stderr, "grove: check daemon logs with: grove daemon logs -n 100\n")
-		os.Exit(1)
+		if n == 0 {
+			fmt.Fprintf(os.Stderr, "grove: check daemon logs with: grove daemon logs -n 100\n")
+		}
+		return resp, exitCodeFor(resp)
 	}
 
 	// Stream any setup output (clone, pull, bootstrap) the daemon buffered.
 	io.Copy(os.Stdout, conn)
 	conn.Close()
+	return resp, 0
+}
 
-	fmt.Printf("\n%s✓  Started instance%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, resp.InstanceID, colorReset)
+// openStarted opens a just-started instance's worktree in the editor, only
+// warning on failure since the instance is up.
+func openStarted(resp proto.Response) {
+	if len(resp.Instances) != 1 {
+		return
+	}
+	if err := openInEditor(resp.Instances[0].WorktreeDir, false); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: --open: %v\n", err)
+	}
+}
 
-	if !detach {
-		doAttach(resp.InstanceID)
+// startResult is the outcome of starting one branch in startMany.
+type startResult struct {
+	branch string
+	id     string // the new instance, if it started
+	failed bool
+}
+
+// startMany starts an instance on each branch in turn, carrying on past
+// failures, and prints a summary once all have been tried.  Nothing is
+// attached to; grove exits non-zero if any branch failed.
+func startMany(req proto.Request, branches []string, timeout time.Duration, openEditor bool) {
+	results := make([]startResult, len(branches))
+	for i, branch := range branches {
+		results[i].branch = branch
+		r := req
+		r.Branch = branch
+		resp, code := startBranch(r, timeout)
+		if code != 0 {
+			results[i].failed = true
+			continue
+		}
+		results[i].id = resp.InstanceID
+		fmt.Printf("%s%s✓  Started instance%s %s%s%s on %s\n", bannerPrefix, colorGreen+colorBold, colorReset, colorCyan, resp.InstanceID, colorReset, branch)
+		if openEditor {
+			openStarted(resp)
+		}
+	}
+	if failed := printStartResults(os.Stdout, results); failed > 0 {
+		fmt.Fprintf(os.Stderr, "\ngrove: %d of %d branch(es) did not start; see the errors above\n", failed, len(branches))
+		os.Exit(1)
+	}
+}
+
+// printStartResults writes one row per branch with the instance started on
+// it, or "failed".  It returns how many failed.
+func printStartResults(w io.Writer, results []startResult) int {
+	branchW := len("BRANCH")
+	for _, r := range results {
+		if l := len(r.branch); l > branchW {
+			branchW = l
+		}
 	}
+
+	fmt.Fprintf(w, "\n%s%-*s  %s%s\n", colorBold, branchW, "BRANCH", "INSTANCE", colorReset)
+	failed := 0
+	for _, r := range results {
+		if r.failed {
+			failed++
+			fmt.Fprintf(w, "%-*s  %sfailed%s\n", branchW, r.branch, colorRed, colorReset)
+			continue
+		}
+		fmt.Fprintf(w, "%-*s  %s\n", branchW, r.branch, r.id)
+	}
+	return failed
+}
+
+// throbberLine renders one frame of the start throbber.  Past the first few
+// seconds it shows how long setup has been running, so a slow clone or image
+// pull is visibly making progress rather than hung.
+func throbberLine(frame rune, elapsed time.Duration) string {
+	if elapsed < 3*time.Second {
+		return fmt.Sprintf("\r  Starting instance %c  ", frame)
+	}
+	return fmt.Sprintf("\r  Starting instance %c  %s  ", frame, elapsed.Truncate(time.Second))
+}
+
+// listFilter selects which instances cmdList shows.  Zero-valued fields
+// match everything.
+type listFilter struct {
+	activeOnly bool   // exclude FINISHED
+	state      string // exact state, case-insensitive
+	project    string // exact project name
+	branch     string // path.Match glob, e.g. "fix-*"; already validated
+	// annotations must all be present with equal values.
+	annotations map[string]string
+}
+
+func (f listFilter) match(inst proto.InstanceInfo) bool {
+	if f.activeOnly && inst.State == proto.StateFinished {
+		return false
+	}
+	if f.state != "" && !strings.EqualFold(inst.State, f.state) {
+		return false
+	}
+	if f.project != "" && inst.Project != f.project {
+		return false
+	}
+	if f.branch != "" {
+		if ok, _ := path.Match(f.branch, inst.Branch); !ok {
+			return false
+		}
+	}
+	for k, v := range f.annotations {
+		if got, ok := inst.Annotations[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
 }
 
 func cmdList() {
 	fs := flag.NewFlagSet("list", flag.ExitOnError)
-	activeOnly := fs.Bool("active", false, "show only active instances (exclude FINISHED)")
+	var filter listFilter
+	fs.BoolVar(&filter.activeOnly, "active", false, "show only active instances (exclude FINISHED)")
+	fs.StringVar(&filter.state, "state", "", "show only instances in this state (e.g. running)")
+	fs.StringVar(&filter.project, "project", "", "show only instances of this project")
+	fs.StringVar(&filter.branch, "branch", "", "show only instances whose branch matches this glob (e.g. 'fix-*')")
+	var annotationArgs stringList
+	fs.Var(&annotationArgs, "annotation", "show only instances with this key=value annotation (repeatable)")
+	count := fs.Bool("count", false, "print only the number of matching instances")
+	wide := fs.Bool("wide", false, "also show annotations and why crashed instances died")
+	asJSON := fs.Bool("json", false, "print the matching instances as a JSON array")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove list [--active]")
+		fmt.Fprintln(os.Stderr, "usage: grove list [--active] [--state <state>] [--project <name>] [--branch <glob>] [--annotation k=v] [--count] [--wide] [--json] [--watch [interval]]")
+	}
+	rawArgs, interval, watching, err := stripWatchFlag(os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: --watch: %v\n", err)
+		os.Exit(1)
+	}
+	fs.Parse(rawArgs)
+	if _, err := path.Match(filter.branch, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: --branch: bad pattern %q\n", filter.branch)
+		os.Exit(1)
+	}
+	if len(annotationArgs) > 0 {
+		kv, err := parseKeyValues(annotationArgs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: --annotation: %v\n", err)
+			os.Exit(1)
+		}
+		filter.annotations = kv
 	}
-	fs.Parse(os.Args[2:])
 
+	format := listFormat{count: *count, wide: *wide, json: *asJSON}
+	if watching {
+		watchList(filter, format, interval)
+		return
+	}
 	resp := mustRequest(proto.Request{Type: proto.ReqList})
+	printList(os.Stdout, filter.apply(resp.Instances), format)
+}
 
+// apply returns the instances f matches, in order.
+func (f listFilter) apply(all []proto.InstanceInfo) []proto.InstanceInfo {
 	var instances []proto.InstanceInfo
-	for _, inst := range resp.Instances {
-		if *activeOnly && inst.State == proto.StateFinished {
-			continue
+	for _, inst := range all {
+		if f.match(inst) {
+			instances = append(instances, inst)
 		}
-		instances = append(instances, inst)
+	}
+	return instances
+}
+
+// listFormat is how cmdList prints the instances it shows.
+type listFormat struct {
+	count bool // just the number
+	wide  bool // annotations and exit reasons, with the branch column padded to fit
+	json  bool // a JSON array of the records
+}
+
+// printList writes instances to w in format.
+func printList(w io.Writer, instances []proto.InstanceInfo, format listFormat) {
+	if format.count {
+		fmt.Fprintln(w, len(instances))
+		return
+	}
+
+	if format.json {
+		if instances == nil {
+			instances = []proto.InstanceInfo{} // [] rather than null
+		}
+		data, _ := json.MarshalIndent(instances, "", "  ")
+		fmt.Fprintln(w, string(data))
+		return
 	}
 
 	if len(instances) == 0 {
-		fmt.Printf("%sno instances%s\n", colorDim, colorReset)
+		fmt.Fprintf(w, "%sno instances%s\n", colorDim, colorReset)
 		return
 	}
 
-	fmt.Printf("%s%-10s  %-12s  %-10s  %s%s\n", colorBold, "ID", "PROJECT", "STATE", "BRANCH", colorReset)
-	fmt.Printf("%s%-10s  %-12s  %-10s  %s%s\n", colorDim, "----------", "------------", "----------", "------", colorReset)
+	// The branch is the last column unless --wide adds more after it.
+	branchW := 0
+	if format.wide {
+		branchW = len("BRANCH")
+		for _, inst := range instances {
+			branchW = max(branchW, len(inst.Branch))
+		}
+	}
+
+	fmt.Fprintf(w, "%s%-10s  %-12s  %-12s  %-10s  %-*s", colorBold, "ID", "NAME", "PROJECT", "STATE", branchW, "BRANCH")
+	if format.wide {
+		fmt.Fprint(w, "  ANNOTATIONS")
+	}
+	fmt.Fprintln(w, colorReset)
+	fmt.Fprintf(w, "%s%-10s  %-12s  %-12s  %-10s  %s", colorDim, "----------", "------------", "------------", "----------", strings.Repeat("-", max(branchW, len("BRANCH"))))
+	if format.wide {
+		fmt.Fprint(w, "  -----------")
+	}
+	fmt.Fprintln(w, colorReset)
 	for _, inst := range instances {
 		color := colorState(inst.State)
 		reset := ""
 		if color != "" {
-			reset = "\033[0m"
+			reset = colorReset
 		}
-		fmt.Printf("%-10s  %-12s  %s%-10s%s  %s\n", inst.ID, inst.Project, color, inst.State, reset, inst.Branch)
+		fmt.Fprintf(w, "%-10s  %-12s  %-12s  %s%-10s%s  %-*s", inst.ID, inst.Name, inst.Project, color, inst.State, reset, branchW, inst.Branch)
+		if format.wide {
+			fmt.Fprintf(w, "  %s%s", formatAnnotations(inst.Annotations), exitReasonNote(inst))
+		}
+		fmt.Fprintln(w, worktreeMissingNote(inst))
 	}
 }
 
-func cmdStop() {
+// defaultListWatchInterval is how often grove list --watch redraws when no
+// interval is given, as with watch(1).
+const defaultListWatchInterval = 2 * time.Second
+
+// stripWatchFlag removes --watch from args, along with the interval after
+// it if the next argument is one ("--watch=5s" works too), and returns the
+// rest, the interval and whether the flag was there.
+func stripWatchFlag(args []string) ([]string, time.Duration, bool, error) {
+	out := make([]string, 0, len(args))
+	interval, found := defaultListWatchInterval, false
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		name, value, hasValue := strings.Cut(a, "=")
+		if name != "-watch" && name != "--watch" {
+			out = append(out, a)
+			continue
+		}
+		found = true
+		if !hasValue && i+1 < len(args) && isNumberOrDuration(args[i+1]) {
+			value, hasValue = args[i+1], true
+			i++
+		}
+		if hasValue {
+			d, err := parseWatchInterval(value)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			interval = d
+		}
+	}
+	return out, interval, found, nil
+}
+
+// isNumberOrDuration reports whether s reads as a --watch interval, valid
+// or not, rather than as the next flag.
+func isNumberOrDuration(s string) bool {
+	if _, err := time.ParseDuration(s); err == nil {
+		return true
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// parseWatchInterval parses a --watch interval: a duration such as "500ms"
+// or "1m", or a bare number of seconds.
+func parseWatchInterval(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		secs, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return 0, fmt.Errorf("invalid interval %q (e.g. 5, 5s, 500ms)", s)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("interval must be positive, got %q", s)
+	}
+	return d, nil
+}
+
+// watchList redraws the list every interval until Ctrl-C.  Unlike grove
+// watch it stays on the normal screen, so the last table is left in the
+// scrollback, and a daemon that goes away is reported in place of the table
+// rather than ending the loop.
+func watchList(filter listFilter, format listFormat, interval time.Duration) {
+	socketPath := daemonSocket()
+	fmt.Print("\033[H\033[2J")
+	for {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "%severy %s · %s · Ctrl-C to stop%s\n\n", colorDim, interval, time.Now().Format("15:04:05"), colorReset)
+		resp, err := request(socketPath, proto.Request{Type: proto.ReqList})
+		switch {
+		case err != nil:
+			fmt.Fprintf(&buf, "daemon not reachable: %v\n", err)
+		case !resp.OK:
+			fmt.Fprintf(&buf, "%s\n", resp.Error)
+		default:
+			printList(&buf, filter.apply(resp.Instances), format)
+		}
+		// As in drawWatch, clear what is left of each line and of the
+		// screen so nothing of a longer previous frame remains.
+		fmt.Print("\033[H" + strings.ReplaceAll(buf.String(), "\n", "\033[K\n") + "\033[J")
+		time.Sleep(interval)
+	}
+}
+
+// worktreeMissingNote flags an instance whose worktree was deleted outside
+// grove in the list's branch column.
+func worktreeMissingNote(inst proto.InstanceInfo) string {
+	if !inst.WorktreeMissing {
+		return ""
+	}
+	return "  " + colorRed + "(worktree missing)" + colorReset
+}
+
+// exitReasonNote shows why a crashed instance died, when the daemon could
+// tell, in the wide list.
+func exitReasonNote(inst proto.InstanceInfo) string {
+	if inst.ExitReason == "" {
+		return ""
+	}
+	return "  " + colorRed + "(" + inst.ExitReason + ")" + colorReset
+}
+
+// cmdAnnotate handles: grove annotate <instance-id> [key=value ...]
+//
+// With no pairs it prints the instance's annotations, one per line.  "key="
+// removes a key.
+func cmdAnnotate() {
 	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove stop <instance-id>")
+		fmt.Fprintln(os.Stderr, "usage: grove annotate <instance-id> [key=value ...]")
 		os.Exit(1)
 	}
-	instanceID := os.Args[2]
+	instanceID := resolveInstanceID(os.Args[2])
+
+	kv, err := parseKeyValues(os.Args[3:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(kv) == 0 {
+		inst := findInstance(instanceID)
+		if inst == nil {
+			exitWith(exitNotFound, "instance not found: %s", instanceID)
+		}
+		for _, k := range sortedKeys(inst.Annotations) {
+			fmt.Printf("%s=%s\n", k, inst.Annotations[k])
+		}
+		return
+	}
 
 	mustRequest(proto.Request{
-		Type:       proto.ReqStop,
+		Type:        proto.ReqAnnotate,
+		InstanceID:  instanceID,
+		Annotations: kv,
+	})
+	fmt.Printf("\n%s✓  Annotated%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, instanceID, colorReset)
+}
+
+// cmdMv handles: grove mv <instance-id> <new-branch>
+func cmdMv() {
+	if len(os.Args) != 4 {
+		fmt.Fprintln(os.Stderr, "usage: grove mv <instance-id> <new-branch>")
+		os.Exit(1)
+	}
+	instanceID, branch := resolveInstanceID(os.Args[2]), os.Args[3]
+
+	resp := mustRequest(proto.Request{
+		Type:       proto.ReqMove,
 		InstanceID: instanceID,
+		Branch:     branch,
 	})
+	worktree := ""
+	if len(resp.Instances) == 1 {
+		worktree = resp.Instances[0].WorktreeDir
+	}
+	fmt.Printf("\n%s✓  Renamed%s %s%s%s to %s%s%s\n", colorGreen+colorBold, colorReset, colorCyan, instanceID, colorReset, colorCyan, branch, colorReset)
+	if worktree != "" {
+		fmt.Printf("   %sworktree unchanged: %s%s\n", colorDim, worktree, colorReset)
+	}
+	fmt.Println()
+}
+
+func cmdStop() {
+	rawArgs, wait := stripBoolFlag(os.Args[2:], "wait", "wait")
+	rawArgs, snapshot := stripBoolFlag(rawArgs, "snapshot", "snapshot")
+	rawArgs, container := stripBoolFlag(rawArgs, "container", "container")
+	rawArgs, all := stripBoolFlag(rawArgs, "all", "all")
+	fs := flag.NewFlagSet("stop", flag.ExitOnError)
+	branch := fs.String("branch", "", "stop the instance on this branch instead of naming its ID")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove stop <instance-id> | --branch <branch> [--all] [--wait] [--snapshot] [--container]")
+	}
+	args := parseInterspersed(fs, rawArgs)
+	instanceIDs := targetIDs(fs, args, "stop", *branch, all)
+
+	for _, instanceID := range instanceIDs {
+		resp := mustRequest(proto.Request{
+			Type:       proto.ReqStop,
+			InstanceID: instanceID,
+			Wait:       wait,
+			Snapshot:   snapshot,
+			Container:  container,
+		})
+
+		fmt.Printf("\n%s✓  Stopped%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, instanceID, colorReset)
+		if container {
+			fmt.Printf("  %scontainer removed, worktree kept; bring it back with:%s grove restart --fresh %s\n\n", colorDim, colorReset, instanceID)
+		}
+		if snapshot {
+			if resp.Error != "" {
+				fmt.Fprintf(os.Stderr, "grove: no snapshot of %s: %s\n", instanceID, resp.Error)
+				continue
+			}
+			printScreen(resp.Screen)
+		}
+	}
+}
+
+// targetIDs returns the instances grove stop or drop acts on: the one ID in
+// args, or with --branch those found by findBranchInstances.  It exits on
+// anything else.
+func targetIDs(fs *flag.FlagSet, args []string, verb, branch string, all bool) []string {
+	switch {
+	case branch != "" && len(args) > 0:
+		fmt.Fprintln(os.Stderr, "grove: give an instance ID or --branch, not both")
+		os.Exit(1)
+	case all && branch == "":
+		fmt.Fprintln(os.Stderr, "grove: --all goes with --branch")
+		os.Exit(1)
+	case branch != "":
+		var ids []string
+		for _, inst := range findBranchInstances(verb, branch, all) {
+			ids = append(ids, inst.ID)
+		}
+		return ids
+	case len(args) != 1:
+		fs.Usage()
+		os.Exit(1)
+	}
+	return []string{resolveInstanceID(args[0])}
+}
 
-	fmt.Printf("\n%s✓  Stopped%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, instanceID, colorReset)
+// cmdSnapshot handles: grove snapshot <instance-id>
+//
+// Prints the agent's terminal screen as text: what it shows now, or what it
+// showed when it exited.
+func cmdSnapshot() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: grove snapshot <instance-id>")
+		os.Exit(1)
+	}
+	resp := mustRequest(proto.Request{Type: proto.ReqSnapshot, InstanceID: resolveInstanceID(os.Args[2])})
+	fmt.Print(resp.Screen)
+}
+
+// printScreen prints a screen snapshot between two rules so it stands apart
+// from grove's own output.
+func printScreen(screen string) {
+	rule := colorDim + strings.Repeat("─", 40) + colorReset
+	fmt.Println(rule)
+	fmt.Print(screen)
+	fmt.Println(rule)
+	fmt.Println()
 }
 
 func cmdRestart() {
 	rawArgs, detach := stripBoolFlag(os.Args[2:], "d", "detach")
+	rawArgs, waitReady := stripBoolFlag(rawArgs, "wait-ready", "wait-ready")
+	rawArgs, fresh := stripBoolFlag(rawArgs, "fresh", "fresh")
+	rawArgs, resendPrompt := stripBoolFlag(rawArgs, "resend-prompt", "resend-prompt")
 	fs := flag.NewFlagSet("restart", flag.ExitOnError)
+	var envFiles, agentArgs stringList
+	fs.Var(&envFiles, "env-file", "extra agent env file (repeatable; later files win)")
+	fs.Var(&agentArgs, "agent-arg", "extra argument appended to the agent command (repeatable)")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove restart <instance-id> [-d]")
+		fmt.Fprintln(os.Stderr, "usage: grove restart <instance-id> [-d] [--wait-ready] [--fresh] [--resend-prompt] [--env-file <path>]... [--agent-arg <arg>]...")
 	}
-	fs.Parse(rawArgs)
-	args := fs.Args()
+	args := parseInterspersed(fs, rawArgs)
 	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: grove restart <instance-id> [-d]")
+		fs.Usage()
 		os.Exit(1)
 	}
 	instanceID := args[0]
 
 	var agentEnv map[string]string
 	if inst := findInstance(instanceID); inst != nil {
-		agentEnv = ensureAgentCredentials(inst.Project)
+		instanceID = inst.ID
+		agentEnv = agentEnvWithFiles(inst.Project, envFiles)
 	}
 
-	mustRequest(proto.Request{
-		Type:       proto.ReqRestart,
-		InstanceID: instanceID,
-		AgentEnv:   agentEnv,
+	if fresh {
+		fmt.Fprintf(os.Stderr, "%sRecreating the container for %s …%s\n", colorDim, instanceID, colorReset)
+	}
+	resp := mustRequest(proto.Request{
+		Type:         proto.ReqRestart,
+		InstanceID:   instanceID,
+		AgentEnv:     agentEnv,
+		AgentArgs:    agentArgs,
+		WaitReady:    waitReady,
+		Fresh:        fresh,
+		ResendPrompt: resendPrompt,
 	})
 
 	fmt.Printf("\n%s✓  Restarted%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, instanceID, colorReset)
 
+	if len(resp.Instances) == 1 && resp.Instances[0].Pipe {
+		fmt.Printf("  %sagent runs without a terminal; follow it with:%s grove logs -f %s\n\n", colorDim, colorReset, instanceID)
+		return
+	}
 	if !detach {
-		doAttach(instanceID)
+		doAttach(instanceID, attachOptions{})
 	}
 }
 
 func cmdDrop() {
 	rawArgs, force := stripBoolFlag(os.Args[2:], "f", "force")
+	rawArgs, all := stripBoolFlag(rawArgs, "all", "all")
 	fs := flag.NewFlagSet("drop", flag.ExitOnError)
-	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: grove drop <instance-id> [-f]") }
-	fs.Parse(rawArgs)
-	args := fs.Args()
-	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: grove drop <instance-id> [-f]")
-		os.Exit(1)
-	}
-	instanceID := args[0]
+	branch := fs.String("branch", "", "drop the instance on this branch instead of naming its ID")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: grove drop <instance-id> | --branch <branch> [--all] [-f]") }
+	args := parseInterspersed(fs, rawArgs)
+	instanceIDs := targetIDs(fs, args, "drop", *branch, all)
 
-	found := findInstance(instanceID)
-	if found == nil {
-		fmt.Fprintf(os.Stderr, "grove: instance not found: %s\n", instanceID)
-		os.Exit(1)
+	resp := mustRequest(proto.Request{Type: proto.ReqList})
+	var targets []proto.InstanceInfo
+	for _, instanceID := range instanceIDs {
+		found := instanceIn(resp.Instances, instanceID)
+		if found == nil {
+			exitWith(exitNotFound, "instance not found: %s", instanceID)
+		}
+		targets = append(targets, *found)
 	}
 
 	if !force {
-		fmt.Printf("\n%sInstance%s %s%s%s\n\n", colorBold, colorReset, colorCyan, instanceID, colorReset)
-		fmt.Printf("  %sProject:%s  %s%s%s\n", colorDim, colorReset, colorCyan, found.Project, colorReset)
-		fmt.Printf("  %sWorktree:%s %s%s%s\n", colorDim, colorReset, colorCyan, found.WorktreeDir, colorReset)
-		fmt.Printf("  %sBranch:%s   %s%s%s\n\n", colorDim, colorReset, colorCyan, found.Branch, colorReset)
-		fmt.Printf("%sDelete instance %q and worktree?%s [y/N] ", colorBold, found.Project, colorReset)
+		refuseIfBatch("drop needs confirmation", "pass -f to drop without asking")
+		for _, found := range targets {
+			fmt.Printf("\n%sInstance%s %s%s%s\n\n", colorBold, colorReset, colorCyan, found.ID, colorReset)
+			fmt.Printf("  %sProject:%s  %s%s%s\n", colorDim, colorReset, colorCyan, found.Project, colorReset)
+			fmt.Printf("  %sWorktree:%s %s%s%s\n", colorDim, colorReset, colorCyan, found.WorktreeDir, colorReset)
+			fmt.Printf("  %sBranch:%s   %s%s%s\n", colorDim, colorReset, colorCyan, found.Branch, colorReset)
+		}
+		fmt.Println()
+		if len(targets) == 1 {
+			fmt.Printf("%sDelete instance %q and worktree?%s [y/N] ", colorBold, targets[0].Project, colorReset)
+		} else {
+			fmt.Printf("%sDelete these %d instances and their worktrees?%s [y/N] ", colorBold, len(targets), colorReset)
+		}
 
 		reader := bufio.NewReader(os.Stdin)
 		answer, _ := reader.ReadString('\n')
@@ -234,27 +875,176 @@ func cmdDrop() {
 		}
 	}
 
-	mustRequest(proto.Request{
-		Type:       proto.ReqDrop,
-		InstanceID: instanceID,
-	})
-	fmt.Printf("\n%s✓  Dropped%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, instanceID, colorReset)
+	for _, found := range targets {
+		mustRequest(proto.Request{
+			Type:       proto.ReqDrop,
+			InstanceID: found.ID,
+		})
+		fmt.Printf("\n%s✓  Dropped%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, found.ID, colorReset)
+	}
 }
 
 func cmdFinish() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove finish <instance-id>")
+	fs := flag.NewFlagSet("finish", flag.ExitOnError)
+	allowEmpty := fs.Bool("allow-empty", false, "finish even when the branch has no new commits")
+	target := fs.String("target", "", "branch substituted for {{target}} in finish commands (default: the default branch)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove finish <instance-id> [--allow-empty] [--target <branch>]")
+	}
+	args := parseInterspersed(fs, os.Args[2:])
+	if len(args) != 1 {
+		fs.Usage()
 		os.Exit(1)
 	}
-	streamCommand(proto.ReqFinish, os.Args[2])
+	streamCommand(proto.Request{Type: proto.ReqFinish, InstanceID: resolveInstanceID(args[0]), AllowEmpty: *allowEmpty, Target: *target})
 }
 
 func cmdCheck() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove check <instance-id>")
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	var only, skip stringList
+	fs.Var(&only, "only", "run only these check groups (repeatable or comma-separated)")
+	fs.Var(&skip, "skip", "skip these check groups (repeatable or comma-separated)")
+	all := fs.Bool("all", false, "check every live instance")
+	project := fs.String("project", "", "check every live instance of this project")
+	jobs := fs.Int("jobs", 4, "with --all or --project, how many instances to check at once")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove check <instance-id> [--only <group>[,<group>...]] [--skip <group>[,<group>...]]")
+		fmt.Fprintln(os.Stderr, "       grove check --all | --project <name|#> [--jobs N] [--only ...] [--skip ...]")
+	}
+	args := parseInterspersed(fs, os.Args[2:])
+	batch := *all || *project != ""
+	if (batch && len(args) != 0) || (!batch && len(args) != 1) || *jobs < 1 {
+		fs.Usage()
 		os.Exit(1)
 	}
-	streamCommand(proto.ReqCheck, os.Args[2])
+	req := proto.Request{
+		Type:      proto.ReqCheck,
+		CheckOnly: splitCommas(only),
+		CheckSkip: splitCommas(skip),
+		Framed:    true,
+	}
+	if batch {
+		name := ""
+		if *project != "" {
+			name = resolveProject(*project)
+		}
+		checkMany(name, *jobs, req)
+		return
+	}
+	req.InstanceID = resolveInstanceID(args[0])
+	streamCommand(req)
+}
+
+// checkResult is the outcome of checking one instance in checkMany.
+type checkResult struct {
+	ran bool  // the checks ran; err then reports which failed
+	err error // nil if every check passed
+}
+
+// checkMany runs req's checks on every live instance, or every live
+// instance of project, at most jobs at a time.  Their output goes to the
+// instance logs rather than the terminal; a table of results is printed
+// once all are done, and grove exits non-zero unless all passed.
+func checkMany(project string, jobs int, req proto.Request) {
+	var targets []proto.InstanceInfo
+	for _, inst := range mustRequest(proto.Request{Type: proto.ReqList}).Instances {
+		if proto.IsTerminal(inst.State) || (project != "" && inst.Project != project) {
+			continue
+		}
+		targets = append(targets, inst)
+	}
+	if len(targets) == 0 {
+		fmt.Printf("%sno live instances to check%s\n", colorDim, colorReset)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%sChecking %d instance(s), %d at a time …%s\n", colorDim, len(targets), min(jobs, len(targets)), colorReset)
+	sock := daemonSocket()
+	results := make([]checkResult, len(targets))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, inst := range targets {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			r := req
+			r.InstanceID = id
+			results[i] = runCheck(sock, r)
+		}(i, inst.ID)
+	}
+	wg.Wait()
+
+	if bad := printCheckResults(os.Stdout, targets, results); bad > 0 {
+		fmt.Fprintf(os.Stderr, "\ngrove: %d of %d instance(s) did not pass; their check output is in: grove logs <instance-id> --merge-setup\n", bad, len(targets))
+		os.Exit(1)
+	}
+}
+
+// runCheck sends a framed ReqCheck and waits for its result, discarding the
+// output the daemon streams (it is also written to the instance log).
+func runCheck(sock string, req proto.Request) checkResult {
+	conn, err := dialDaemon(sock)
+	if err != nil {
+		return checkResult{err: err}
+	}
+	defer conn.Close()
+	if err := writeRequest(conn, req); err != nil {
+		return checkResult{err: err}
+	}
+	resp, err := readResponse(conn)
+	if err != nil {
+		return checkResult{err: err}
+	}
+	if !resp.OK {
+		return checkResult{err: errors.New(resp.Error)}
+	}
+	return checkResult{ran: true, err: copyFramedOutput(io.Discard, conn)}
+}
+
+// printCheckResults writes one row per instance: passed, FAILED (checks ran
+// and some failed) or skipped (they could not run).  It returns how many
+// did not pass.
+func printCheckResults(w io.Writer, targets []proto.InstanceInfo, results []checkResult) int {
+	branchW := len("BRANCH")
+	for _, inst := range targets {
+		if l := len(inst.Branch); l > branchW {
+			branchW = l
+		}
+	}
+
+	fmt.Fprintf(w, "\n%s%-10s  %-12s  %-*s  %-7s  %s%s\n", colorBold, "ID", "PROJECT", branchW, "BRANCH", "RESULT", "DETAIL", colorReset)
+	bad := 0
+	for i, inst := range targets {
+		result, color, detail := "passed", colorGreen, ""
+		switch r := results[i]; {
+		case r.err != nil && r.ran:
+			result, color, detail = "FAILED", colorRed, r.err.Error()
+		case r.err != nil:
+			result, color, detail = "skipped", colorYellow, r.err.Error()
+		}
+		if result == "passed" {
+			fmt.Fprintf(w, "%-10s  %-12s  %-*s  %s%s%s\n", inst.ID, inst.Project, branchW, inst.Branch, color, result, colorReset)
+			continue
+		}
+		bad++
+		fmt.Fprintf(w, "%-10s  %-12s  %-*s  %s%-7s%s  %s\n", inst.ID, inst.Project, branchW, inst.Branch, color, result, colorReset, detail)
+	}
+	return bad
+}
+
+// splitCommas flattens comma-separated flag values into a single list.
+func splitCommas(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
 }
 
 func cmdDir() {
@@ -266,34 +1056,228 @@ func cmdDir() {
 
 	inst := findInstance(id)
 	if inst == nil {
-		fmt.Fprintf(os.Stderr, "grove: instance not found: %s\n", id)
-		os.Exit(1)
+		exitWith(exitNotFound, "instance not found: %s", id)
 	}
 	fmt.Println(inst.WorktreeDir)
 }
 
+// cmdConfig handles: grove config <instance-id>
+//
+// Prints the configuration the daemon recorded when the instance started,
+// which can lag behind grove.yaml if it has changed since.
+func cmdConfig() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: grove config <instance-id>")
+		os.Exit(1)
+	}
+	resp := mustRequest(proto.Request{Type: proto.ReqInstanceConfig, InstanceID: resolveInstanceID(os.Args[2])})
+	if resp.InstanceConfig == nil {
+		fmt.Fprintln(os.Stderr, "grove: daemon returned no config (is groved up to date?)")
+		os.Exit(1)
+	}
+	printInstanceConfig(os.Stdout, resp.InstanceConfig)
+}
+
+// printInstanceConfig writes c as aligned "field  value" lines.
+func printInstanceConfig(w io.Writer, c *proto.InstanceConfig) {
+	field := func(name, value string) {
+		fmt.Fprintf(w, "%s%-10s%s %s\n", colorDim, name, colorReset, value)
+	}
+	if c.Compose != "" {
+		field("compose", c.Compose)
+		field("service", c.Service)
+	} else {
+		field("image", c.Image)
+	}
+	field("workdir", c.Workdir)
+	if c.Subdir != "" {
+		field("subdir", c.Subdir)
+	}
+	for i, m := range c.Mounts {
+		name := ""
+		if i == 0 {
+			name = "mounts"
+		}
+		field(name, m)
+	}
+	if len(c.Hidden) > 0 {
+		field("hide", strings.Join(c.Hidden, ", "))
+	}
+	field("agent", strings.TrimSpace(c.AgentCommand+" "+strings.Join(c.AgentArgs, " ")))
+	if c.Pipe {
+		field("pty", "false")
+	}
+	env := "(none)"
+	if len(c.AgentEnvKeys) > 0 {
+		env = strings.Join(c.AgentEnvKeys, ", ") + " " + colorDim + "(values hidden)" + colorReset
+	}
+	field("env", env)
+	if c.Prompt != "" {
+		field("prompt", c.Prompt)
+	}
+}
+
+// cmdOpen handles: grove open <instance-id>
+//
+// Opens the instance's worktree on the host in $GROVE_EDITOR, or VS Code.
+func cmdOpen() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: grove open <instance-id>")
+		os.Exit(1)
+	}
+	id := os.Args[2]
+
+	inst := findInstance(id)
+	if inst == nil {
+		exitWith(exitNotFound, "instance not found: %s", id)
+	}
+	if err := openInEditor(inst.WorktreeDir, true); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// editorCommand returns the command that opens a directory in the user's
+// editor: $GROVE_EDITOR split into words (e.g. "code -n", "idea"), else
+// "code" if it is on PATH.
+func editorCommand() ([]string, error) {
+	if words := strings.Fields(os.Getenv("GROVE_EDITOR")); len(words) > 0 {
+		return words, nil
+	}
+	if _, err := exec.LookPath("code"); err == nil {
+		return []string{"code"}, nil
+	}
+	return nil, fmt.Errorf("no editor configured; set GROVE_EDITOR (e.g. export GROVE_EDITOR=\"code -n\") or put VS Code's code on your PATH")
+}
+
+// openInEditor runs the editor command on dir.  With wait it runs in the
+// foreground on this terminal, so terminal editors work too; otherwise it
+// is started in the background and left running.
+func openInEditor(dir string, wait bool) error {
+	words, err := editorCommand()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(words[0], append(words[1:], dir)...)
+	if wait {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// shellRCPath is where grove shell installs its rc file inside the container
+// for root shells; other users get shellRCName in their own home.
+const (
+	shellRCName = ".grove_shellrc"
+	shellRCPath = "/root/" + shellRCName
+)
+
+// shellCwdPath is where grove shell sessions record their last working
+// directory, so the next grove shell on the same instance starts there.  The
+// container belongs to one instance, so this is per instance and goes away
+// with it.  Like the rc file it lives in the shell user's home.
+const (
+	shellCwdName = ".grove_shell_cwd"
+	shellCwdPath = "/root/" + shellCwdName
+)
+
+// shellCwdTrap returns the line appended to every rc file, including
+// --rcfile ones, that records the working directory in cwdPath on exit.
+func shellCwdTrap(cwdPath string) string {
+	return "\ntrap 'pwd > " + cwdPath + "' EXIT\n"
+}
+
+// defaultShellRC gives grove shell sessions history and a few conveniences.
+// It must stay POSIX sh compatible: sh reads it via $ENV, bash via --rcfile.
+const defaultShellRC = `# Installed by grove shell; replace with: grove shell <id> --rcfile <file>
+HISTFILE=$HOME/.grove_history
+HISTSIZE=5000
+export HISTFILE HISTSIZE
+alias ll='ls -alF'
+alias la='ls -A'
+alias ..='cd ..'
+if [ -n "$BASH_VERSION" ]; then
+	shopt -s histappend checkwinsize
+	PROMPT_COMMAND='history -a'
+	bind '"\e[A": history-search-backward' 2>/dev/null
+	bind '"\e[B": history-search-forward' 2>/dev/null
+fi
+`
+
 func cmdShell() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove shell <instance-id> [shell]")
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	rcfile := fs.String("rcfile", "", "host rc file to use instead of grove's defaults")
+	user := fs.String("user", "", "open the shell as this container user instead of root")
+	noRoot := fs.Bool("no-root", false, "open the shell as the image's default user")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove shell <instance-id> [shell] [--rcfile <path>] [--user <user> | --no-root]")
+	}
+	args := parseInterspersed(fs, os.Args[2:])
+	if len(args) < 1 || len(args) > 2 || (*user != "" && *noRoot) {
+		fs.Usage()
 		os.Exit(1)
 	}
-	instanceID := os.Args[2]
+	// There is no container.user setting yet, so without a flag the shell
+	// stays root, matching the agent.  "" means the image's default user.
+	shellUser := "root"
+	if *user != "" {
+		shellUser = *user
+	} else if *noRoot {
+		shellUser = ""
+	}
+	instanceID := args[0]
 	shell := "sh"
-	if len(os.Args) >= 4 {
-		shell = os.Args[3]
+	if len(args) == 2 {
+		shell = args[1]
+	}
+
+	rc := []byte(defaultShellRC)
+	if *rcfile != "" {
+		data, err := os.ReadFile(*rcfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
+		}
+		rc = data
 	}
 
 	inst := findInstance(instanceID)
 	if inst == nil {
-		fmt.Fprintf(os.Stderr, "grove: instance not found: %s\n", instanceID)
-		os.Exit(1)
+		exitWith(exitNotFound, "instance not found: %s", instanceID)
 	}
 	if inst.ContainerID == "" {
-		fmt.Fprintf(os.Stderr, "grove: instance not found: %s\n", instanceID)
+		exitWith(exitNotFound, "instance not found: %s", instanceID)
+	}
+	if inst.WorktreeMissing {
+		fmt.Fprintf(os.Stderr, "grove: worktree %s is missing (deleted outside grove?); drop this instance with: grove drop %s\n", inst.WorktreeDir, inst.ID)
+		os.Exit(1)
+	}
+	if inst.ContainerStopped {
+		fmt.Fprintf(os.Stderr, "grove: instance %s has no container (grove stop --container); grove restart --fresh %s first\n", inst.ID, inst.ID)
 		os.Exit(1)
 	}
 
-	cmd := exec.Command("docker", "exec", "-it", "-u", "root", "-e", "HOME=/root", inst.ContainerID, shell)
+	rcPath, cwdPath := shellRCPath, shellCwdPath
+	if shellUser != "root" {
+		home := containerHome(inst.ContainerID, shellUser)
+		rcPath, cwdPath = home+"/"+shellRCName, home+"/"+shellCwdName
+	}
+	rc = append(rc, shellCwdTrap(cwdPath)...)
+
+	// Copy the rc file in on every shell so edits to --rcfile take effect.
+	// It is written as the shell's user so that user can read it.  Failure
+	// is not fatal: the shell still works, just without the extras.
+	install := engine.Command(append(execUserArgs("-i", shellUser), inst.ContainerID, "sh", "-c", "cat > "+rcPath)...)
+	install.Stdin = bytes.NewReader(rc)
+	if out, err := install.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "%sgrove: could not install shell rc file: %v %s%s\n", colorDim, err, strings.TrimSpace(string(out)), colorReset)
+	}
+
+	cmd := engine.Command(shellExecArgs(inst.ContainerID, shellUser, shell, rcPath, lastShellDir(inst.ContainerID, shellUser, cwdPath))...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -306,57 +1290,285 @@ func cmdShell() {
 	}
 }
 
+// lastShellDir returns the directory the previous grove shell as user in
+// container exited in, as recorded in cwdPath, or "" if there was none or it
+// no longer exists.
+func lastShellDir(container, user, cwdPath string) string {
+	out, err := engine.Command(append(execUserArgs("", user), container, "sh", "-c",
+		`d=$(cat `+cwdPath+` 2>/dev/null) && [ -d "$d" ] && printf %s "$d"`)...).Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+// containerHome returns user's home directory in container ("" for the
+// image's default user), falling back to /tmp if it cannot be found.
+func containerHome(container, user string) string {
+	out, err := engine.Command(append(execUserArgs("", user), container, "sh", "-c", `printf %s "$HOME"`)...).Output()
+	if err != nil || len(out) == 0 || string(out) == "/" {
+		return "/tmp"
+	}
+	return string(out)
+}
+
+// execUserArgs returns "docker exec" arguments, with flags if non-empty,
+// that run as user.  root also gets HOME=/root, since images with a non-root
+// default user may set HOME to that user's home; "" keeps the image's
+// default user.
+func execUserArgs(flags, user string) []string {
+	args := []string{"exec"}
+	if flags != "" {
+		args = append(args, flags)
+	}
+	switch user {
+	case "":
+	case "root":
+		args = append(args, "-u", "root", "-e", "HOME=/root")
+	default:
+		args = append(args, "-u", user)
+	}
+	return args
+}
+
+// shellExecArgs returns the docker arguments that start shell interactively
+// in container with grove's rc file loaded, in workdir if it is set.  POSIX
+// shells pick the rc file up from $ENV; bash ignores $ENV for interactive
+// shells, so it gets --rcfile.
+func shellExecArgs(container, user, shell, rcPath, workdir string) []string {
+	args := append(execUserArgs("-it", user), "-e", "ENV="+rcPath)
+	if workdir != "" {
+		args = append(args, "-w", workdir)
+	}
+	args = append(args, container, shell)
+	if path.Base(shell) == "bash" {
+		args = append(args, "--rcfile", rcPath, "-i")
+	}
+	return args
+}
+
+// cmdExec handles: grove exec <instance-id> <command>...
+//
+// Runs one command in the instance's container for scripts: its output goes
+// to stdout and grove exits with its exit status.  Several words are joined
+// with spaces into one shell command line, as with ssh.
+func cmdExec() {
+	if len(os.Args) < 4 {
+		fmt.Fprintln(os.Stderr, "usage: grove exec <instance-id> <command>...")
+		os.Exit(1)
+	}
+	conn, _ := openStream(proto.Request{
+		Type:       proto.ReqExec,
+		InstanceID: resolveInstanceID(os.Args[2]),
+		Command:    strings.Join(os.Args[3:], " "),
+		Framed:     true,
+	})
+	defer conn.Close()
+
+	result, err := readFramedResult(os.Stdout, conn)
+	switch {
+	case err != nil:
+		exitWith(exitFailure, "%v", err)
+	case result.OK:
+	case result.ExitCode > 0:
+		// The command's own output says what went wrong.
+		conn.Close()
+		os.Exit(result.ExitCode)
+	default:
+		exitWith(exitCodeFor(result), "%s", result.Error)
+	}
+}
+
 func cmdLogs() {
+	rawArgs, retry := stripBoolFlag(os.Args[2:], "retry", "retry")
 	fs := flag.NewFlagSet("logs", flag.ExitOnError)
 	follow := fs.Bool("f", false, "follow log output")
 	fs.BoolVar(follow, "follow", false, "follow log output")
+	all := fs.Bool("all", false, "save every instance's log")
+	saveDir := fs.String("save-dir", "", "directory to write <id>.log files into (with --all)")
+	withNames := fs.Bool("with-names", false, "name saved files <id>-<project>-<branch>.log")
+	mergeSetup := fs.Bool("merge-setup", false, "interleave setup, agent, check and finish output by time")
+	tailBytes := fs.Int("tail-bytes", 0, "print only about the last N bytes of buffered output")
+	allRuns := fs.Bool("all-runs", false, "print output from every run of the agent, not just the latest")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove logs <instance-id> [-f]")
+		fmt.Fprintln(os.Stderr, "usage: grove logs <instance-id> [-f [--retry] | --merge-setup | --all-runs] [--tail-bytes N]")
+		fmt.Fprintln(os.Stderr, "       grove logs --all --save-dir <dir> [--with-names]")
+	}
+	remaining := parseInterspersed(fs, rawArgs)
+	if *all {
+		if *saveDir == "" || *follow || len(remaining) > 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		saveAllLogs(*saveDir, *withNames)
+		return
 	}
-	fs.Parse(os.Args[2:])
-	remaining := fs.Args()
 	if len(remaining) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: grove logs <instance-id> [-f]")
+		fs.Usage()
 		os.Exit(1)
 	}
 	instanceID := remaining[0]
+	if *mergeSetup && *follow {
+		fmt.Fprintln(os.Stderr, "grove: --merge-setup cannot be combined with -f")
+		os.Exit(1)
+	}
+	if *tailBytes < 0 {
+		fmt.Fprintln(os.Stderr, "grove: --tail-bytes must be >= 0")
+		os.Exit(1)
+	}
+	if *mergeSetup && *tailBytes > 0 {
+		fmt.Fprintln(os.Stderr, "grove: --tail-bytes cannot be combined with --merge-setup")
+		os.Exit(1)
+	}
+	if *allRuns && (*follow || *mergeSetup) {
+		fmt.Fprintln(os.Stderr, "grove: --all-runs cannot be combined with -f or --merge-setup")
+		os.Exit(1)
+	}
 
-	reqType := proto.ReqLogs
+	instanceID = resolveInstanceID(instanceID)
+	req := proto.Request{Type: proto.ReqLogs, InstanceID: instanceID, MergeSetup: *mergeSetup, TailBytes: *tailBytes, AllRuns: *allRuns}
 	if *follow {
-		reqType = proto.ReqLogsFollow
+		req.Type = proto.ReqLogsFollow
 	}
 
 	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
+	if err := copyLogs(socketPath, req, os.Stdout); err != nil {
+		exitWith(errorExitCode(err), "%v", err)
+	}
+	if !*follow || !retry {
+		return
+	}
+
+	// The stream ends either because the instance finished or because the
+	// daemon went away.  In the latter case wait for it to come back and
+	// follow again; a restarted daemon starts with an empty log buffer, so
+	// nothing already printed is repeated.
+	for {
+		if pingDaemon(socketPath) {
+			resp, err := tryRequest(proto.Request{Type: proto.ReqList})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+				os.Exit(1)
+			}
+			inst := instanceIn(resp.Instances, instanceID)
+			if inst == nil {
+				fmt.Fprintf(os.Stderr, "grove: instance %s no longer exists\n", instanceID)
+				os.Exit(1)
+			}
+			if proto.IsTerminal(inst.State) {
+				return
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%sgrove: lost connection to daemon; waiting for it to come back…%s\n", colorDim, colorReset)
+			for !pingDaemon(socketPath) {
+				time.Sleep(time.Second)
+			}
+		}
+		if err := copyLogs(socketPath, req, os.Stdout); err != nil {
+			exitWith(errorExitCode(err), "%v", err)
+		}
+	}
+}
+
+// copyLogs issues a logs request and copies the streamed output to w until
+// the daemon closes the connection.
+func copyLogs(socketPath string, req proto.Request, w io.Writer) error {
+	conn, err := dialDaemon(socketPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: cannot connect to daemon: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("%w: %v", errNoDaemon, err)
 	}
 	defer conn.Close()
 
-	if err := writeRequest(conn, proto.Request{Type: reqType, InstanceID: instanceID}); err != nil {
+	if err := writeRequest(conn, req); err != nil {
+		return err
+	}
+	resp, err := readResponse(conn)
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return &responseError{resp}
+	}
+	io.Copy(w, conn)
+	return nil
+}
+
+// saveAllLogs writes the buffered log of every instance into dir, one file
+// per instance.  Existing files are never overwritten: a numeric suffix is
+// added instead, so repeated runs into the same directory keep every copy.
+func saveAllLogs(dir string, withNames bool) {
+	resp := mustRequest(proto.Request{Type: proto.ReqList})
+	if len(resp.Instances) == 0 {
+		fmt.Printf("%sno instances%s\n", colorDim, colorReset)
+		return
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
-	resp, err := readResponse(conn)
-	if err != nil || !resp.OK {
-		msg := "logs failed"
-		if resp.Error != "" {
-			msg = resp.Error
+
+	socketPath := daemonSocket()
+	saved := 0
+	for _, inst := range resp.Instances {
+		path := uniquePath(filepath.Join(dir, logFileName(inst, withNames)))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %s: %v\n", inst.ID, err)
+			continue
 		}
-		fmt.Fprintf(os.Stderr, "grove: %s\n", msg)
+		err = copyLogs(socketPath, proto.Request{Type: proto.ReqLogs, InstanceID: inst.ID}, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+			fmt.Fprintf(os.Stderr, "grove: %s: %v\n", inst.ID, err)
+			continue
+		}
+		saved++
+	}
+
+	fmt.Printf("\n%s✓  Saved %d of %d log(s)%s to %s%s%s\n\n",
+		colorGreen+colorBold, saved, len(resp.Instances), colorReset, colorCyan, dir, colorReset)
+	if saved < len(resp.Instances) {
 		os.Exit(1)
 	}
-	io.Copy(os.Stdout, conn)
+}
+
+// logFileName returns the file name for inst's saved log.  Path separators
+// in the branch are replaced so the file lands directly in the save dir.
+func logFileName(inst proto.InstanceInfo, withNames bool) string {
+	if !withNames {
+		return inst.ID + ".log"
+	}
+	branch := strings.NewReplacer("/", "-", string(os.PathSeparator), "-").Replace(inst.Branch)
+	return inst.ID + "-" + inst.Project + "-" + branch + ".log"
+}
+
+// uniquePath returns path if nothing exists there, otherwise the first of
+// "<base>.1<ext>", "<base>.2<ext>", … that is free.
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s.%d%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
 }
 
 func cmdPrune() {
+	rawArgs, force := stripBoolFlag(os.Args[2:], "f", "force")
 	fs := flag.NewFlagSet("prune", flag.ExitOnError)
 	includeFinished := fs.Bool("finished", false, "also drop FINISHED instances")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove prune [--finished]")
+		fmt.Fprintln(os.Stderr, "usage: grove prune [--finished] [-f]")
 	}
-	fs.Parse(os.Args[2:])
+	fs.Parse(rawArgs)
 
 	resp := mustRequest(proto.Request{Type: proto.ReqList})
 
@@ -386,14 +1598,17 @@ func cmdPrune() {
 		fmt.Printf("    %sState:%s     %s\n\n", colorDim, colorReset, inst.State)
 	}
 	fmt.Printf("  This will drop %d instance(s) and their worktrees.\n\n", len(dead))
-	fmt.Printf("%sContinue?%s [y/N] ", colorBold, colorReset)
+	if !force {
+		refuseIfBatch("prune needs confirmation", "pass -f to prune without asking")
+		fmt.Printf("%sContinue?%s [y/N] ", colorBold, colorReset)
 
-	reader := bufio.NewReader(os.Stdin)
-	answer, _ := reader.ReadString('\n')
-	answer = strings.TrimSpace(answer)
-	if answer != "y" && answer != "Y" {
-		fmt.Printf("%saborted%s\n", colorDim, colorReset)
-		return
+		reader := bufio.NewReader(os.Stdin)
+		answer, _ := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer != "y" && answer != "Y" {
+			fmt.Printf("%saborted%s\n", colorDim, colorReset)
+			return
+		}
 	}
 
 	for _, inst := range dead {
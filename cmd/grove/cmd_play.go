@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// cmdPlay handles: grove play <file> [--speed N] [--no-timing]
+//
+// It replays a terminal session recorded in asciicast v2 format (the format
+// asciinema writes) to stdout, with the original timing, so a session can be
+// reviewed without asciinema installed.
+func cmdPlay() {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "playback speed multiplier (2 = twice as fast)")
+	noTiming := fs.Bool("no-timing", false, "write all output at once, without pauses")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove play <file.cast> [--speed N] [--no-timing]")
+	}
+	args := parseInterspersed(fs, os.Args[2:])
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *speed <= 0 {
+		fmt.Fprintln(os.Stderr, "grove: --speed must be greater than 0")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	sleep := time.Sleep
+	if *noTiming {
+		sleep = func(time.Duration) {}
+	}
+	if err := playCast(f, os.Stdout, *speed, sleep); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version int `json:"version"`
+	Width   int `json:"width"`
+	Height  int `json:"height"`
+}
+
+// playCast writes the output events of the asciicast v2 recording in r to w,
+// calling sleep for the gap before each one divided by speed.  Input, marker
+// and resize events are skipped.
+func playCast(r io.Reader, w io.Writer, speed float64, sleep func(time.Duration)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("empty recording")
+	}
+	var hdr castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &hdr); err != nil {
+		return fmt.Errorf("not an asciicast file: %w", err)
+	}
+	if hdr.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d (only version 2 can be played)", hdr.Version)
+	}
+
+	line := 1
+	last := 0.0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event []json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("line %d: not an asciicast event", line)
+		}
+		var at float64
+		var kind, data string
+		if json.Unmarshal(event[0], &at) != nil || json.Unmarshal(event[1], &kind) != nil || json.Unmarshal(event[2], &data) != nil {
+			return fmt.Errorf("line %d: not an asciicast event", line)
+		}
+		if kind != "o" {
+			continue
+		}
+		if at > last {
+			sleep(time.Duration((at - last) / speed * float64(time.Second)))
+			last = at
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
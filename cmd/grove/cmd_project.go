@@ -10,23 +10,34 @@ import (
 	"strings"
 
 	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/internal/registration"
 	"gopkg.in/yaml.v3"
 )
 
 func cmdProject() {
 	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove project <create|list|delete|dir>")
+		fmt.Fprintln(os.Stderr, "usage: grove project <create|import-all|list|delete|dir|config-path|default|validate-repo|warm>")
 		os.Exit(1)
 	}
 	switch os.Args[2] {
 	case "create":
 		cmdProjectCreate()
+	case "import-all":
+		cmdProjectImportAll()
 	case "list":
 		cmdProjectList()
 	case "delete":
 		cmdProjectDelete()
 	case "dir":
 		cmdProjectDir()
+	case "config-path":
+		cmdProjectConfigPath()
+	case "default":
+		cmdProjectDefault()
+	case "validate-repo":
+		cmdProjectValidateRepo()
+	case "warm":
+		cmdProjectWarm()
 	default:
 		fmt.Fprintf(os.Stderr, "grove: unknown project subcommand %q\n", os.Args[2])
 		os.Exit(1)
@@ -40,35 +51,37 @@ func cmdProject() {
 // start, finish, check) belongs in grove.yaml in the project repo.
 func cmdProjectCreate() {
 	if len(os.Args) < 4 || os.Args[3] == "" || os.Args[3][0] == '-' {
-		fmt.Fprintln(os.Stderr, "usage: grove project create <name> [--repo <url>]")
+		fmt.Fprintln(os.Stderr, "usage: grove project create <name> [--repo <url>] [--subdir <dir>]")
 		os.Exit(1)
 	}
 	name := os.Args[3]
 
 	fs := flag.NewFlagSet("project create", flag.ExitOnError)
 	repo := fs.String("repo", "", "git remote URL (can be added later)")
+	subdir := fs.String("subdir", "", "directory of a monorepo this project works in (grove.yaml, agent and commands)")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove project create <name> [--repo <url>]")
+		fmt.Fprintln(os.Stderr, "usage: grove project create <name> [--repo <url>] [--subdir <dir>]")
 		fs.PrintDefaults()
 	}
 	fs.Parse(os.Args[4:])
-
-	projectDir := filepath.Join(rootDir(), "projects", name)
-	if _, err := os.Stat(filepath.Join(projectDir, "project.yaml")); err == nil {
-		fmt.Fprintf(os.Stderr, "grove: project %q already exists at %s\n", name, projectDir)
-		os.Exit(1)
-	}
-	if err := os.MkdirAll(projectDir, 0o755); err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+	clean, err := registration.CleanSubdir(*subdir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: --%v\n", err)
 		os.Exit(1)
 	}
+	*subdir = clean
 
-	yamlPath := filepath.Join(projectDir, "project.yaml")
-	content := fmt.Sprintf("name: %s\nrepo: %s\n", name, *repo)
-	if err := os.WriteFile(yamlPath, []byte(content), 0o644); err != nil {
+	yamlPath, err := writeProjectRegistration(name, *repo)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
+	if *subdir != "" {
+		if err := appendProjectSetting(yamlPath, "subdir", *subdir); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	fmt.Printf("\n%s✓  Created project%s %s%q%s\n\n", colorGreen+colorBold, colorReset, colorCyan, name, colorReset)
 	fmt.Printf("%sConfig:%s %s%s%s\n\n", colorBold, colorReset, colorCyan, yamlPath, colorReset)
@@ -82,12 +95,129 @@ func cmdProjectCreate() {
 	fmt.Printf("     %sgrove start %s <branch>%s\n\n", colorDim, name, colorReset)
 }
 
+// cmdProjectImportAll handles: grove project import-all <dir>
+//
+// Registers a project for every git repository directly under dir, named
+// after its directory and cloned from its origin remote.
+func cmdProjectImportAll() {
+	if len(os.Args) != 4 {
+		fmt.Fprintln(os.Stderr, "usage: grove project import-all <dir>")
+		os.Exit(1)
+	}
+	results, err := importProjects(os.Args[3])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	created := 0
+	fmt.Println()
+	for _, r := range results {
+		if r.skipped != "" {
+			fmt.Printf("  %s-  %-24s skipped: %s%s\n", colorDim, r.name, r.skipped, colorReset)
+			continue
+		}
+		created++
+		fmt.Printf("  %s✓%s  %-24s %s%s%s\n", colorGreen, colorReset, r.name, colorDim, r.repo, colorReset)
+	}
+	fmt.Printf("\n%s✓  Imported%s %d project(s), skipped %d\n\n", colorGreen+colorBold, colorReset, created, len(results)-created)
+}
+
+// importResult is the outcome for one repository seen by importProjects.
+type importResult struct {
+	name    string
+	repo    string
+	skipped string // reason it was not registered; empty if it was
+}
+
+// importProjects registers each git repository directly under dir that has
+// an origin remote and is not registered yet, by name or by repo URL.
+// Subdirectories that are not repositories are ignored.
+func importProjects(dir string) ([]importResult, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	registered := map[string]string{} // repo URL → project name
+	names := map[string]bool{}
+	for _, e := range loadProjectEntries() {
+		registered[e.repo] = e.name
+		names[e.name] = true
+	}
+
+	var results []importResult
+	for _, e := range dirEntries {
+		if !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+			continue
+		}
+		r := importResult{name: e.Name()}
+		r.repo, _ = gitOutput(path, "remote", "get-url", "origin")
+		switch {
+		case names[r.name]:
+			r.skipped = "already registered"
+		case r.repo == "":
+			r.skipped = "no origin remote"
+		case registered[r.repo] != "":
+			r.skipped = "repo already registered as " + registered[r.repo]
+		default:
+			if _, err := writeProjectRegistration(r.name, r.repo); err != nil {
+				r.skipped = err.Error()
+			} else {
+				names[r.name] = true
+				registered[r.repo] = r.name
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
 // projectEntry holds the parsed fields grove cares about from a registration.
 type projectEntry struct {
 	name string
 	repo string
 }
 
+// writeProjectRegistration writes ~/.grove/projects/<name>/project.yaml and
+// returns its path.  It refuses to overwrite an existing registration.
+func writeProjectRegistration(name, repo string) (string, error) {
+	projectDir := filepath.Join(rootDir(), "projects", name)
+	yamlPath := filepath.Join(projectDir, "project.yaml")
+	if _, err := os.Stat(yamlPath); err == nil {
+		return "", fmt.Errorf("project %q already exists at %s", name, projectDir)
+	}
+	if err := os.MkdirAll(projectDir, 0o755); err != nil {
+		return "", err
+	}
+	content := fmt.Sprintf("name: %s\nrepo: %s\n", name, repo)
+	if err := os.WriteFile(yamlPath, []byte(content), 0o644); err != nil {
+		return "", err
+	}
+	return yamlPath, nil
+}
+
+// appendProjectSetting adds "key: value" to a project.yaml written by
+// writeProjectRegistration, quoting value as YAML needs.
+func appendProjectSetting(yamlPath, key, value string) error {
+	line, err := yaml.Marshal(map[string]string{key: value})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(yamlPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 // loadProjectEntries scans ~/.grove/projects/ and returns all registered
 // projects in directory order (alphabetical by folder name).
 func loadProjectEntries() []projectEntry {
@@ -136,8 +266,7 @@ func resolveProject(arg string) string {
 	}
 	entries := loadProjectEntries()
 	if n < 1 || n > len(entries) {
-		fmt.Fprintf(os.Stderr, "grove: project index %d out of range (have %d project(s))\n", n, len(entries))
-		os.Exit(1)
+		exitWith(exitNotFound, "project index %d out of range (have %d project(s))", n, len(entries))
 	}
 	return entries[n-1].name
 }
@@ -165,21 +294,20 @@ func cmdProjectList() {
 // Prompts for confirmation (project and all worktrees are removed), then
 // deletes the entire project directory under ~/.grove/projects/<name>/.
 func cmdProjectDelete() {
-	if len(os.Args) < 4 || os.Args[3] == "" {
-		fmt.Fprintln(os.Stderr, "usage: grove project delete <name|#>")
+	args, force := stripBoolFlag(os.Args[3:], "f", "force")
+	if len(args) < 1 || args[0] == "" {
+		fmt.Fprintln(os.Stderr, "usage: grove project delete <name|#> [-f]")
 		os.Exit(1)
 	}
-	name := resolveProject(os.Args[3])
+	name := resolveProject(args[0])
 
 	projectDir := filepath.Join(rootDir(), "projects", name)
 	yamlPath := filepath.Join(projectDir, "project.yaml")
 	if _, err := os.Stat(yamlPath); err != nil {
 		if os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "grove: project %q not found\n", name)
-		} else {
-			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			exitWith(exitNotFound, "project %q not found", name)
 		}
-		os.Exit(1)
+		exitWith(exitFailure, "%v", err)
 	}
 
 	// Count live instances so the warning can be specific.
@@ -199,22 +327,35 @@ func cmdProjectDelete() {
 	} else {
 		fmt.Printf("  This will delete the project and %sall its worktrees%s.\n\n", colorBold, colorReset)
 	}
-	fmt.Printf("%sContinue?%s [y/N] ", colorBold, colorReset)
+	if !force {
+		refuseIfBatch("project delete needs confirmation", "pass -f to delete without asking")
+		fmt.Printf("%sContinue?%s [y/N] ", colorBold, colorReset)
 
-	reader := bufio.NewReader(os.Stdin)
-	answer, _ := reader.ReadString('\n')
-	answer = strings.TrimSpace(answer)
-	if answer != "y" && answer != "Y" {
-		fmt.Printf("%saborted%s\n", colorDim, colorReset)
-		return
+		reader := bufio.NewReader(os.Stdin)
+		answer, _ := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer != "y" && answer != "Y" {
+			fmt.Printf("%saborted%s\n", colorDim, colorReset)
+			return
+		}
 	}
 
-	// Drop all instances belonging to this project before removing the
-	// project directory, so they don't linger in watch/list.
+	// Stop and drop all instances belonging to this project before removing
+	// the project directory, so they don't linger in watch/list.  Each reply
+	// is waited for: the daemon detaches attached clients and lets the agent
+	// exit first, so nothing writes into the directory once it is removed.
 	if resp, err := tryRequest(proto.Request{Type: proto.ReqList}); err == nil {
 		for _, inst := range resp.Instances {
-			if inst.Project == name {
-				tryRequest(proto.Request{Type: proto.ReqDrop, InstanceID: inst.ID})
+			if inst.Project != name {
+				continue
+			}
+			if _, err := tryRequest(proto.Request{Type: proto.ReqStop, InstanceID: inst.ID, Wait: true}); err != nil {
+				fmt.Fprintf(os.Stderr, "grove: stopping %s: %v; project not deleted\n", inst.ID, err)
+				os.Exit(1)
+			}
+			if _, err := tryRequest(proto.Request{Type: proto.ReqDrop, InstanceID: inst.ID}); err != nil {
+				fmt.Fprintf(os.Stderr, "grove: dropping %s: %v; project not deleted\n", inst.ID, err)
+				os.Exit(1)
 			}
 		}
 	}
@@ -223,6 +364,12 @@ func cmdProjectDelete() {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
+	if cfg, err := loadUserConfig(); err == nil && cfg.DefaultProject == name {
+		cfg.DefaultProject = ""
+		if err := saveUserConfig(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: could not clear the default project: %v\n", err)
+		}
+	}
 	fmt.Printf("\n%s✓  Deleted project%s %s%q%s\n\n", colorGreen+colorBold, colorReset, colorCyan, name, colorReset)
 }
 
@@ -234,3 +381,310 @@ func cmdProjectDir() {
 	project := resolveProject(os.Args[3])
 	fmt.Println(filepath.Join(rootDir(), "projects", project, "main"))
 }
+
+// cmdProjectConfigPath handles: grove project config-path <name|#>
+//
+// Prints the path of the grove.yaml the daemon loads for the project, for
+// scripts that read or edit it.
+func cmdProjectConfigPath() {
+	if len(os.Args) != 4 {
+		fmt.Fprintln(os.Stderr, "usage: grove project config-path <project|#>")
+		os.Exit(1)
+	}
+	project := resolveProject(os.Args[3])
+	if !projectExists(project) {
+		exitWith(exitNotFound, "project %q not found", project)
+	}
+	path, err := projectConfigPath(filepath.Join(rootDir(), "projects", project))
+	if err != nil {
+		exitWith(exitFailure, "%v", err)
+	}
+	fmt.Println(path)
+}
+
+// projectConfigPath returns the grove.yaml in the main checkout of the
+// project registered in projectDir, chosen as the daemon does (see
+// registration.InRepoConfigPath).
+func projectConfigPath(projectDir string) (string, error) {
+	var reg struct {
+		Subdir string `yaml:"subdir"`
+	}
+	data, err := os.ReadFile(filepath.Join(projectDir, "project.yaml"))
+	if err != nil {
+		return "", err
+	}
+	if err := yaml.Unmarshal(data, &reg); err != nil {
+		return "", fmt.Errorf("%s: %w", filepath.Join(projectDir, "project.yaml"), err)
+	}
+	subdir, err := registration.CleanSubdir(reg.Subdir)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", filepath.Join(projectDir, "project.yaml"), err)
+	}
+	mainDir := filepath.Join(projectDir, "main")
+	path := registration.InRepoConfigPath(mainDir, subdir)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if _, err := os.Stat(mainDir); err != nil {
+		return "", fmt.Errorf("no config found: %s has not been cloned yet (it is, on the first grove start)", mainDir)
+	}
+	if subdir != "" {
+		return "", fmt.Errorf("no config found (looked for %s and %s)", filepath.Join(mainDir, filepath.FromSlash(subdir), "grove.yaml"), path)
+	}
+	return "", fmt.Errorf("no config found (looked for %s)", path)
+}
+
+// userConfig is the client's own settings file, <root>/config.yaml.
+type userConfig struct {
+	// DefaultProject is the project "grove start <branch>" uses.
+	DefaultProject string `yaml:"default_project,omitempty"`
+
+	// Aliases maps a name to the grove arguments it stands for, e.g.
+	// "qf: start myproj -d"; see setupAliases.
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+}
+
+func userConfigPath() string {
+	return filepath.Join(rootDir(), "config.yaml")
+}
+
+// loadUserConfig reads config.yaml.  A missing file is an empty config.
+func loadUserConfig() (userConfig, error) {
+	var cfg userConfig
+	data, err := os.ReadFile(userConfigPath())
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", userConfigPath(), err)
+	}
+	return cfg, nil
+}
+
+func saveUserConfig(cfg userConfig) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(rootDir(), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(userConfigPath(), data, 0o644)
+}
+
+// projectExists reports whether name is a registered project.
+func projectExists(name string) bool {
+	_, err := os.Stat(filepath.Join(rootDir(), "projects", name, "project.yaml"))
+	return err == nil
+}
+
+// cmdProjectDefault handles: grove project default [<name|#> | --clear]
+//
+// With a project, records it as the one "grove start <branch>" uses; with
+// none, prints the current default.
+func cmdProjectDefault() {
+	args, clearDefault := stripBoolFlag(os.Args[3:], "clear", "clear")
+	if len(args) > 1 || (clearDefault && len(args) > 0) {
+		fmt.Fprintln(os.Stderr, "usage: grove project default [<name|#> | --clear]")
+		os.Exit(1)
+	}
+	cfg, err := loadUserConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case clearDefault:
+		cfg.DefaultProject = ""
+	case len(args) == 1:
+		name := resolveProject(args[0])
+		if !projectExists(name) {
+			exitWith(exitNotFound, "project %q not found", name)
+		}
+		cfg.DefaultProject = name
+	default:
+		if cfg.DefaultProject == "" {
+			fmt.Printf("%sno default project%s\n", colorDim, colorReset)
+			return
+		}
+		fmt.Println(cfg.DefaultProject)
+		return
+	}
+
+	if err := saveUserConfig(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.DefaultProject == "" {
+		fmt.Printf("\n%s✓  Cleared default project%s\n\n", colorGreen+colorBold, colorReset)
+		return
+	}
+	fmt.Printf("\n%s✓  Default project%s %s%q%s\n\n", colorGreen+colorBold, colorReset, colorCyan, cfg.DefaultProject, colorReset)
+	fmt.Printf("     %sgrove start <branch>%s now starts in it\n\n", colorDim, colorReset)
+}
+
+// defaultProjectFor returns the project "grove start <arg>" means when arg
+// is the only argument: the default project, with arg as the branch.  A lone
+// argument that names a project (or is a project's number) is a start with
+// no branch, not a branch called after the project, so it is an error.  Any
+// other number, such as an issue number, is a branch.
+func defaultProjectFor(arg string) (string, error) {
+	n, err := strconv.Atoi(arg)
+	if (err == nil && n >= 1 && n <= len(loadProjectEntries())) || projectExists(arg) {
+		return "", fmt.Errorf("no branch given for project %s", arg)
+	}
+	cfg, err := loadUserConfig()
+	if err != nil {
+		return "", err
+	}
+	if cfg.DefaultProject == "" {
+		return "", fmt.Errorf("no project given and no default project set (grove project default <name>)")
+	}
+	if !projectExists(cfg.DefaultProject) {
+		return "", fmt.Errorf("default project %q no longer exists (grove project default <name>)", cfg.DefaultProject)
+	}
+	return cfg.DefaultProject, nil
+}
+
+// cmdProjectValidateRepo handles: grove project validate-repo <name|#>
+//
+// Asks the daemon to run "git ls-remote" against the project's repo URL, so
+// the check uses the same environment and credentials a real clone would.
+func cmdProjectValidateRepo() {
+	if len(os.Args) < 4 {
+		fmt.Fprintln(os.Stderr, "usage: grove project validate-repo <project|#>")
+		os.Exit(1)
+	}
+	project := resolveProject(os.Args[3])
+
+	fmt.Fprintf(os.Stderr, "%sChecking repo access for %s …%s\n", colorDim, project, colorReset)
+	mustRequest(proto.Request{Type: proto.ReqCheckRepo, Project: project})
+	fmt.Printf("\n%s✓  Repo reachable%s %s%q%s\n\n", colorGreen+colorBold, colorReset, colorCyan, project, colorReset)
+}
+
+// cmdProjectWarm handles: grove project warm <name|#>
+//
+// Has the daemon pull the project's container image(s) ahead of time, so the
+// first grove start with a new image does not sit in docker pull.
+func cmdProjectWarm() {
+	if len(os.Args) != 4 {
+		fmt.Fprintln(os.Stderr, "usage: grove project warm <project|#>")
+		os.Exit(1)
+	}
+	project := resolveProject(os.Args[3])
+	streamCommand(proto.Request{Type: proto.ReqWarm, Project: project, Framed: true})
+	fmt.Printf("\n%s✓  Images ready%s for %s%q%s\n\n", colorGreen+colorBold, colorReset, colorCyan, project, colorReset)
+}
+
+// cmdInit handles: grove init [name]
+//
+// Registers the git repository containing the current directory as a
+// project, using its remote URL, and offers to write a grove.yaml if the
+// repo has none.  With no remote the local checkout itself is used as the
+// repo; with several the user picks one (origin is the default).
+func cmdInit() {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove init [name]")
+	}
+	fs.Parse(os.Args[2:])
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	top, err := gitOutput(".", "rev-parse", "--show-toplevel")
+	if err != nil || top == "" {
+		fmt.Fprintln(os.Stderr, "grove: not inside a git repository")
+		os.Exit(1)
+	}
+	name := filepath.Base(top)
+	if fs.NArg() == 1 {
+		name = fs.Arg(0)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	repo := chooseRemoteURL(top, reader)
+
+	yamlPath, err := writeProjectRegistration(name, repo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n%s✓  Created project%s %s%q%s %s(%s)%s\n", colorGreen+colorBold, colorReset, colorCyan, name, colorReset, colorDim, repo, colorReset)
+	fmt.Printf("%sConfig:%s %s%s%s\n", colorBold, colorReset, colorCyan, yamlPath, colorReset)
+
+	if _, err := os.Stat(filepath.Join(top, "grove.yaml")); os.IsNotExist(err) {
+		promptCreateProjectConfig(top, name)
+		return
+	}
+	fmt.Printf("\n%sNext step:%s\n\n", colorBold, colorReset)
+	fmt.Printf("  %sgrove start %s <branch>%s\n\n", colorDim, name, colorReset)
+}
+
+// chooseRemoteURL returns the URL grove should clone for the repo at top.
+// One remote is used as-is; several prompt for a choice (default origin, or
+// the first); none prompts for a URL, defaulting to the local path.
+func chooseRemoteURL(top string, reader *bufio.Reader) string {
+	out, _ := gitOutput(top, "remote")
+	remotes := strings.Fields(out)
+
+	switch len(remotes) {
+	case 0:
+		if nonInteractive {
+			fmt.Fprintf(os.Stderr, "grove: no git remote found; cloning the local path %s\n", top)
+			return top
+		}
+		fmt.Printf("%sNo git remote found.%s Repo URL to clone %s[%s]%s: ", colorYellow, colorReset, colorDim, top, colorReset)
+		answer, _ := reader.ReadString('\n')
+		if answer = strings.TrimSpace(answer); answer != "" {
+			return answer
+		}
+		return top
+	case 1:
+		url, _ := gitOutput(top, "remote", "get-url", remotes[0])
+		return url
+	}
+
+	def := 0
+	for i, r := range remotes {
+		if r == "origin" {
+			def = i
+		}
+	}
+	urls := make([]string, len(remotes))
+	if nonInteractive {
+		url, _ := gitOutput(top, "remote", "get-url", remotes[def])
+		fmt.Fprintf(os.Stderr, "grove: several git remotes; using %s (%s)\n", remotes[def], url)
+		return url
+	}
+	fmt.Printf("%sThis repo has several remotes:%s\n\n", colorBold, colorReset)
+	for i, r := range remotes {
+		urls[i], _ = gitOutput(top, "remote", "get-url", r)
+		fmt.Printf("  %s%d.%s %-10s %s%s%s\n", colorBold, i+1, colorReset, r, colorDim, urls[i], colorReset)
+	}
+	for {
+		fmt.Printf("\n%sWhich one should grove clone?%s [%d] ", colorBold, colorReset, def+1)
+		answer, err := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return urls[def]
+		}
+		if n, convErr := strconv.Atoi(answer); convErr == nil && n >= 1 && n <= len(urls) {
+			return urls[n-1]
+		}
+		for i, r := range remotes {
+			if r == answer {
+				return urls[i]
+			}
+		}
+		if err != nil {
+			return urls[def]
+		}
+		fmt.Printf("%senter a number from 1 to %d or a remote name%s\n", colorDim, len(urls), colorReset)
+	}
+}
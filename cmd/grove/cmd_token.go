@@ -6,15 +6,29 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gandalfthegui/grove/internal/envfile"
+	"github.com/gandalfthegui/grove/internal/registration"
 	"gopkg.in/yaml.v3"
 )
 
 // cmdToken sets or replaces the CLAUDE_CODE_OAUTH_TOKEN in ~/.grove/env.
 // It replaces any existing entry rather than appending, so repeated calls
-// don't accumulate stale tokens.
+// don't accumulate stale tokens.  With --show it prints the stored token
+// masked instead.
 func cmdToken() {
+	args, show := stripBoolFlag(os.Args[2:], "show", "show")
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: grove token [--show]")
+		os.Exit(1)
+	}
+	if show {
+		showToken()
+		return
+	}
+
+	refuseIfBatch("grove token reads the token from a prompt", "write CLAUDE_CODE_OAUTH_TOKEN=<token> to ~/.grove/env instead")
 	root := rootDir()
 	envPath := filepath.Join(root, "env")
 
@@ -39,8 +53,18 @@ func cmdToken() {
 		return
 	}
 
-	// Re-write the env file, stripping any existing CLAUDE_CODE_OAUTH_TOKEN
-	// lines so we don't accumulate duplicates.
+	if err := saveToken(envPath, token); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n%s✓  Token saved%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorDim, envPath, colorReset)
+}
+
+// saveToken re-writes the env file at envPath with token as its only
+// CLAUDE_CODE_OAUTH_TOKEN, stripping existing entries so repeated saves
+// don't accumulate duplicates.
+func saveToken(envPath, token string) error {
 	existing, _ := os.ReadFile(envPath)
 	var kept []string
 	for _, line := range strings.Split(string(existing), "\n") {
@@ -56,16 +80,89 @@ func cmdToken() {
 	kept = append(kept, "CLAUDE_CODE_OAUTH_TOKEN="+token)
 	content := strings.Join(kept, "\n") + "\n"
 
-	if err := os.MkdirAll(root, 0o755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(envPath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(envPath, []byte(content), 0o600)
+}
+
+// tokenExpiredSince reports when a claude agent last showed an
+// authentication failure using the token in envPath, if that happened after
+// the file was last written.  The daemon records the failure in
+// <root>/token-expired (see markTokenExpired in the daemon) naming the
+// project; it applies here when that project's env file is envPath.
+func tokenExpiredSince(envPath string) (time.Time, bool) {
+	markerPath := filepath.Join(rootDir(), "token-expired")
+	marker, err := os.Stat(markerPath)
+	if err != nil {
+		return time.Time{}, false
+	}
+	data, err := os.ReadFile(markerPath)
+	if err != nil || agentEnvPath(strings.TrimSpace(string(data))) != envPath {
+		return time.Time{}, false
+	}
+	if env, err := os.Stat(envPath); err == nil && !env.ModTime().Before(marker.ModTime()) {
+		return time.Time{}, false
+	}
+	return marker.ModTime(), true
+}
+
+// promptExpiredToken warns that the stored token was rejected at since and
+// offers to replace it.  It returns true if a new token was saved.  With
+// prompts disabled it only warns: the failure may have been transient.
+func promptExpiredToken(envPath string, since time.Time) bool {
+	fmt.Fprintf(os.Stderr, "\n%s⚠  The Claude token in %s appeared expired%s (an agent asked to log in at %s).\n",
+		colorYellow+colorBold, envPath, colorReset, since.Format("2006-01-02 15:04"))
+	if nonInteractive {
+		fmt.Fprintf(os.Stderr, "   Run %sclaude setup-token%s and save the result with %sgrove token%s.\n\n", colorCyan, colorReset, colorCyan, colorReset)
+		return false
+	}
+	fmt.Printf("\nGenerate a new token by running:\n\n")
+	fmt.Printf("    %sclaude setup-token%s\n\n", colorCyan, colorReset)
+	fmt.Printf("%sNew token%s (or Enter to start with the current one): ", colorBold, colorReset)
+
+	s := bufio.NewScanner(os.Stdin)
+	if !s.Scan() {
+		return false
+	}
+	token := strings.TrimSpace(s.Text())
+	if token == "" {
+		return false
+	}
+	if err := saveToken(envPath, token); err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
-	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+	fmt.Printf("\n%s✓  Saved to %s%s\n\n", colorGreen, envPath, colorReset)
+	return true
+}
+
+// showToken prints a masked copy of the stored CLAUDE_CODE_OAUTH_TOKEN and
+// its length, so a bad paste can be spotted without exposing the token.
+func showToken() {
+	envPath := filepath.Join(rootDir(), "env")
+	token := envfile.Load(envPath)["CLAUDE_CODE_OAUTH_TOKEN"]
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "grove: no CLAUDE_CODE_OAUTH_TOKEN in %s\n", envPath)
 		os.Exit(1)
 	}
+	fmt.Printf("CLAUDE_CODE_OAUTH_TOKEN=%s  %s(%d chars, %s)%s\n", maskToken(token), colorDim, len(token), envPath, colorReset)
+	if since, expired := tokenExpiredSince(envPath); expired {
+		fmt.Printf("%sappeared expired at %s; replace it with grove token%s\n", colorYellow, since.Format("2006-01-02 15:04"), colorReset)
+	}
+}
 
-	fmt.Printf("\n%s✓  Token saved%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorDim, envPath, colorReset)
+// maskToken keeps at most the first 8 and last 4 characters of s, and never
+// more than a quarter of it in total, replacing the rest with "…".
+func maskToken(s string) string {
+	head, tail := len(s)/8, len(s)/8
+	if head > 8 {
+		head = 8
+	}
+	if tail > 4 {
+		tail = 4
+	}
+	return s[:head] + "…" + s[len(s)-tail:]
 }
 
 // ensureAgentCredentials checks whether the required credentials for the
@@ -86,12 +183,19 @@ func ensureAgentCredentials(project string) map[string]string {
 		return nil
 	}
 
-	root := rootDir()
-	envFile := envfile.Load(filepath.Join(root, "env"))
+	envPath := agentEnvPath(project)
+	envFile := envfile.Load(envPath)
 
-	// If a token is already persisted in ~/.grove/env, the daemon will inject
-	// it directly — no need to echo it back through the request.
-	if envFile["CLAUDE_CODE_OAUTH_TOKEN"] != "" || envFile["ANTHROPIC_API_KEY"] != "" {
+	// If a token is already persisted in the env file, the daemon will inject
+	// it directly — no need to echo it back through the request.  A token
+	// an agent has already been refused with is worth replacing first.
+	if envFile["CLAUDE_CODE_OAUTH_TOKEN"] != "" {
+		if since, expired := tokenExpiredSince(envPath); expired {
+			promptExpiredToken(envPath, since)
+		}
+		return nil
+	}
+	if envFile["ANTHROPIC_API_KEY"] != "" {
 		return nil
 	}
 
@@ -110,6 +214,7 @@ func ensureAgentCredentials(project string) map[string]string {
 	}
 
 	// No token found anywhere — prompt the user.
+	refuseIfBatch("no Claude credentials found", "set CLAUDE_CODE_OAUTH_TOKEN or ANTHROPIC_API_KEY, or save a token with grove token")
 	fmt.Printf("\n%sClaude authentication required.%s\n\n", colorYellow+colorBold, colorReset)
 	fmt.Printf("Generate a long-lived token by running:\n\n")
 	fmt.Printf("    %sclaude setup-token%s\n\n", colorCyan, colorReset)
@@ -125,8 +230,7 @@ func ensureAgentCredentials(project string) map[string]string {
 		return nil
 	}
 
-	// Save to ~/.grove/env so the user never has to do this again.
-	envPath := filepath.Join(root, "env")
+	// Save to the env file so the user never has to do this again.
 	f, err := os.OpenFile(envPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
 	if err == nil {
 		fmt.Fprintf(f, "CLAUDE_CODE_OAUTH_TOKEN=%s\n", token)
@@ -137,6 +241,12 @@ func ensureAgentCredentials(project string) map[string]string {
 	return map[string]string{"CLAUDE_CODE_OAUTH_TOKEN": token}
 }
 
+// agentEnvPath returns the env file the daemon injects into the project's
+// agent: the registration's credentials.env_file if set, else ~/.grove/env.
+func agentEnvPath(project string) string {
+	return registration.ReadAgentEnvFile(rootDir(), project)
+}
+
 // detectAgentCommand reads the project's grove.yaml to determine the agent
 // command. Returns "" if the file doesn't exist or has no agent configured.
 func detectAgentCommand(project string) string {
@@ -164,6 +274,7 @@ func detectAgentCommand(project string) string {
 func promptCreateProjectConfig(mainDir, projectName string) {
 	configPath := filepath.Join(mainDir, "grove.yaml")
 
+	refuseIfBatch("no grove.yaml found in "+projectName, "commit a grove.yaml to the repository root and re-run")
 	fmt.Printf("\n%s⚠  No grove.yaml found in %s%s\n\n", colorYellow+colorBold, projectName, colorReset)
 	fmt.Printf("  This file tells grove how to set up the container, run the agent,\n")
 	fmt.Printf("  and finish the work. Commit it once and every grove user gets the\n")
@@ -212,7 +323,7 @@ const projectConfigBoilerplate = `# grove.yaml
 # Option A – single image (no external services):
 #   container:
 #     image: ruby:3.3      # any Docker image
-#     workdir: /app        # working directory inside the container (default /app)
+#     workdir: /app        # working directory inside the container (default: the image's WORKDIR, else /app)
 #
 # Option B – docker-compose.yml (databases, caches, etc.):
 #   container:
@@ -220,6 +331,12 @@ const projectConfigBoilerplate = `# grove.yaml
 #     service: app                  # service to exec into (default: app)
 #     workdir: /app
 #
+# Your ~/.gitconfig is mounted read-only so the agent's commits carry your
+# identity; set 'gitconfig: false' under container: to disable.
+#
+# Builds that run out of memory get the agent killed; cap or raise the
+# container's memory with e.g. 'memory: 4g' (and 'memory_swap: 6g').
+#
 container:
   image: ubuntu:24.04
 
@@ -262,12 +379,20 @@ agent:
 #   - npm test
 #   - go test ./...
 #   - make lint
+#
+# Or name groups so output is labelled and 'grove check <id> --only test'
+# runs a subset. Groups run concurrently; commands in a group run in order:
+#   lint: make lint
+#   test:
+#     - go test ./...
 check:
 
 # ── Finish ────────────────────────────────────────────────────────────────────
 # Commands run by 'grove finish <id>' inside the worktree directory.
 # The daemon executes these — they complete even if you close your terminal.
-# Use {{branch}} as a placeholder for the instance's branch name.
+# Use {{branch}} as a placeholder for the instance's branch name, and
+# {{target}} for the branch it is headed for ('grove finish <id> --target
+# <branch>', default: the default branch).
 #
 # The instance is marked FINISHED before these run, so a disconnection mid-way
 # does not leave it in a broken state; output is preserved in the instance log.
@@ -282,7 +407,7 @@ finish:
   - git push -u origin {{branch}}
 
   # Open a pull request (requires GitHub CLI: https://cli.github.com).
-  # - gh pr create --title "{{branch}}" --fill
+  # - gh pr create --base {{target}} --title "{{branch}}" --fill
 
   # Or push, open a PR, squash-merge, and delete the branch in one step.
   # - git push -u origin {{branch}} && gh pr create --title "{{branch}}" --fill && gh pr merge --squash --delete-branch
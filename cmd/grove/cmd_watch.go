@@ -1,13 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"net"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gandalfthegui/grove/internal/proto"
 	"golang.org/x/term"
@@ -53,23 +54,269 @@ var watchBanner = []string{
 	" `--`------' `--`-`--`--'    `--`--''      `--`--'  `--`-----`` ",
 }
 
+// Escape sequences that switch to the alternate screen buffer with the
+// cursor hidden, and back to the normal screen (restoring scrollback).
+const (
+	enterWatchScreen = "\033[?1049h\033[?25l"
+	leaveWatchScreen = "\033[?25h\033[?1049l"
+)
+
+// watchColumn is one column of the watch table.  A zero width means the
+// column is sized from the terminal width or its content; see layoutColumns.
+type watchColumn struct {
+	name  string
+	title string
+	width int
+	value func(inst proto.InstanceInfo, now int64) string
+	// stateColor paints the cell in the instance's state color.
+	stateColor bool
+}
+
+// watchColumns lists the columns --columns accepts, in default order.
+var watchColumns = []watchColumn{
+	{name: "id", title: "ID", width: 10, value: func(inst proto.InstanceInfo, _ int64) string { return inst.ID }},
+	{name: "project", title: "PROJECT", value: func(inst proto.InstanceInfo, _ int64) string { return inst.Project }},
+	{name: "state", title: "STATE", width: 10, stateColor: true, value: func(inst proto.InstanceInfo, _ int64) string { return inst.State }},
+	{name: "age", title: "AGE", width: 10, value: func(inst proto.InstanceInfo, now int64) string {
+		end := now
+		if inst.EndedAt > 0 {
+			end = inst.EndedAt
+		}
+		return formatUptime(end - inst.CreatedAt)
+	}},
+	{name: "branch", title: "BRANCH", value: func(inst proto.InstanceInfo, _ int64) string { return inst.Branch }},
+}
+
+// compactColumns is the --compact view: the ID and a state dot.
+var compactColumns = []watchColumn{
+	watchColumns[0],
+	{name: "dot", title: "", width: 1, stateColor: true, value: func(inst proto.InstanceInfo, _ int64) string { return stateDot(inst.State) }},
+}
+
+// stateDot is the --compact stand-in for the state column: a colored dot, or
+// the state's first letter when color is off.
+func stateDot(state string) string {
+	if colorState(state) == "" && state != "" {
+		return state[:1]
+	}
+	return "●"
+}
+
+// parseWatchColumns resolves a comma-separated --columns value against
+// watchColumns, keeping the order given.
+func parseWatchColumns(spec string) ([]watchColumn, error) {
+	var cols []watchColumn
+	seen := map[string]bool{}
+	for _, name := range splitCommas([]string{spec}) {
+		name = strings.ToLower(name)
+		if seen[name] {
+			return nil, fmt.Errorf("column %q listed twice", name)
+		}
+		var col *watchColumn
+		for i := range watchColumns {
+			if watchColumns[i].name == name {
+				col = &watchColumns[i]
+			}
+		}
+		if col == nil {
+			return nil, fmt.Errorf("unknown column %q (want %s)", name, watchColumnNames())
+		}
+		seen[name] = true
+		cols = append(cols, *col)
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no columns given (want %s)", watchColumnNames())
+	}
+	return cols, nil
+}
+
+func watchColumnNames() string {
+	names := make([]string, len(watchColumns))
+	for i, c := range watchColumns {
+		names[i] = c.name
+	}
+	return strings.Join(names, ",")
+}
+
+// layoutColumns returns the width of each column for a terminal width wide.
+// Project is sized to its longest value (14–30); branch takes what is left,
+// at least 15.
+func layoutColumns(cols []watchColumn, instances []proto.InstanceInfo, width int) []int {
+	widths := make([]int, len(cols))
+	used := 2 * (len(cols) - 1) // column gaps
+	flex := -1
+	for i, c := range cols {
+		switch {
+		case c.width > 0:
+			widths[i] = c.width
+		case c.name == "project":
+			widths[i] = 14
+			for _, inst := range instances {
+				if l := utf8.RuneCountInString(inst.Project); l > widths[i] {
+					widths[i] = l
+				}
+			}
+			if widths[i] > 30 {
+				widths[i] = 30
+			}
+		default:
+			flex = i
+			continue
+		}
+		used += widths[i]
+	}
+	if flex >= 0 {
+		widths[flex] = width - used
+		if widths[flex] < 15 {
+			widths[flex] = 15
+		}
+	}
+	return widths
+}
+
+// watchHighlightFor is how long a row stays highlighted after its state
+// changes.
+const watchHighlightFor = 3 * time.Second
+
+// watchTracker remembers each instance's state from the previous refresh so
+// grove watch can highlight rows whose state just changed.
+type watchTracker struct {
+	prev    map[string]string    // instance ID → state at the last refresh
+	changed map[string]time.Time // instance ID → when its state last changed
+}
+
+// observe records the states in instances as of now.  It returns the number
+// of instances that moved into a terminal state since the previous call;
+// the first call only records and reports nothing.
+func (t *watchTracker) observe(instances []proto.InstanceInfo, now time.Time) int {
+	first := t.prev == nil
+	cur := make(map[string]string, len(instances))
+	if t.changed == nil {
+		t.changed = map[string]time.Time{}
+	}
+	ended := 0
+	for _, inst := range instances {
+		cur[inst.ID] = inst.State
+		old, seen := t.prev[inst.ID]
+		if first || !seen || old == inst.State {
+			continue
+		}
+		t.changed[inst.ID] = now
+		if proto.IsTerminal(inst.State) && !proto.IsTerminal(old) {
+			ended++
+		}
+	}
+	for id := range t.changed {
+		if _, ok := cur[id]; !ok {
+			delete(t.changed, id)
+		}
+	}
+	t.prev = cur
+	return ended
+}
+
+// highlighted reports whether id's state changed within watchHighlightFor
+// of now.
+func (t *watchTracker) highlighted(id string, now time.Time) bool {
+	at, ok := t.changed[id]
+	return ok && now.Sub(at) < watchHighlightFor
+}
+
+// renderWatchTable writes the header, rule and one row per instance.  The
+// last column is not padded, so rows carry no trailing blanks.  Rows for
+// which highlight (if non-nil) returns true are drawn in reverse video, or
+// marked with a trailing " *" when color is off.
+func renderWatchTable(buf *strings.Builder, cols []watchColumn, instances []proto.InstanceInfo, width int, now int64, highlight func(id string) bool) {
+	widths := layoutColumns(cols, instances, width)
+	cell := func(i int, s string) string {
+		if i == len(cols)-1 {
+			return s
+		}
+		return fmt.Sprintf("%-*s", widths[i], s)
+	}
+
+	titles := make([]string, len(cols))
+	rules := make([]string, len(cols))
+	for i, c := range cols {
+		titles[i] = cell(i, c.title)
+		rules[i] = strings.Repeat("─", widths[i])
+	}
+	fmt.Fprintf(buf, "%s\n", strings.Join(titles, "  "))
+	fmt.Fprintf(buf, "%s%s%s\n", colorDim, strings.Join(rules, "  "), colorReset)
+
+	cells := make([]string, len(cols))
+	for _, inst := range instances {
+		hl := highlight != nil && highlight(inst.ID)
+		mark := ""
+		if hl {
+			mark = colorReverse
+		}
+		for i, c := range cols {
+			v := cell(i, truncate(c.value(inst, now), widths[i]))
+			if color := colorState(inst.State); c.stateColor && color != "" {
+				v = color + mark + v + colorReset
+			} else if mark != "" {
+				v = mark + v + colorReset
+			}
+			cells[i] = v
+		}
+		row := strings.Join(cells, "  ")
+		if hl && colorReverse == "" {
+			row += " *"
+		}
+		fmt.Fprintf(buf, "%s\n", row)
+	}
+}
+
+// cmdWatch handles: grove watch [--compact | --columns <list>] [--bell]
 func cmdWatch() {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	compact := fs.Bool("compact", false, "show only the ID and a state dot, without the banner")
+	columnSpec := fs.String("columns", "", "comma-separated columns to show, in order ("+watchColumnNames()+")")
+	bell := fs.Bool("bell", false, "ring the terminal bell when an instance exits, crashes or finishes")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove watch [--compact | --columns "+watchColumnNames()+"] [--bell]")
+	}
+	fs.Parse(os.Args[2:])
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cols := watchColumns
+	switch {
+	case *compact && *columnSpec != "":
+		fmt.Fprintln(os.Stderr, "grove: --compact and --columns cannot be used together")
+		os.Exit(1)
+	case *compact:
+		cols = compactColumns
+	case *columnSpec != "":
+		var err error
+		if cols, err = parseWatchColumns(*columnSpec); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: --columns: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	socketPath := daemonSocket()
 
 	fd := int(os.Stdout.Fd())
 
 	// Enter alternate screen buffer; restore on exit.
-	fmt.Print("\033[?1049h\033[?25l")
-	defer fmt.Print("\033[?25h\033[?1049l")
+	fmt.Print(enterWatchScreen)
+	defer fmt.Print(leaveWatchScreen)
 
+	// os.Exit skips deferred calls, so every signal path restores the
+	// screen explicitly before exiting.
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	winchCh := make(chan os.Signal, 1)
 	signal.Notify(winchCh, syscall.SIGWINCH)
 	defer signal.Stop(sigCh)
 	defer signal.Stop(winchCh)
 
-	drawWatch(fd, socketPath)
+	tracker := &watchTracker{}
+	drawWatch(fd, socketPath, cols, *compact, tracker, *bell)
 
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -77,61 +324,73 @@ func cmdWatch() {
 	for {
 		select {
 		case <-sigCh:
-			fmt.Print("\033[?25h\033[?1049l")
+			fmt.Print(leaveWatchScreen)
 			os.Exit(0)
 		case <-winchCh:
-			drawWatch(fd, socketPath)
+			drawWatch(fd, socketPath, cols, *compact, tracker, *bell)
 		case <-ticker.C:
-			drawWatch(fd, socketPath)
+			drawWatch(fd, socketPath, cols, *compact, tracker, *bell)
 		}
 	}
 }
 
-func drawWatch(fd int, socketPath string) {
+func drawWatch(fd int, socketPath string, cols []watchColumn, compact bool, tracker *watchTracker, bell bool) {
+	// Read the width on every draw, so a resize (SIGWINCH redraws at once)
+	// re-lays out the banner and columns.
 	width, _, err := term.GetSize(fd)
-	if err != nil || width < 40 {
-		width = 120
+	if err != nil || width <= 0 {
+		width = 80
 	}
 
-	conn, err := net.Dial("unix", socketPath)
-	if err != nil {
+	resp, err := request(socketPath, proto.Request{Type: proto.ReqList})
+	if err != nil || !resp.OK {
 		fmt.Printf("\033[Hdaemon not reachable: %v\n\033[J", err)
 		return
 	}
-	defer conn.Close()
 
-	if err := writeRequest(conn, proto.Request{Type: proto.ReqList}); err != nil {
-		fmt.Printf("\033[Hdaemon not reachable: %v\n\033[J", err)
-		return
+	var buf strings.Builder
+	buf.WriteString("\033[H")
+
+	if !compact {
+		writeWatchBanner(&buf, width)
 	}
-	resp, err := readResponse(conn)
-	if err != nil || !resp.OK {
-		fmt.Printf("\033[Hdaemon not reachable: %v\n\033[J", err)
-		return
+
+	now := time.Now()
+	if ended := tracker.observe(resp.Instances, now); ended > 0 && bell {
+		buf.WriteString("\a")
 	}
+	renderWatchTable(&buf, cols, resp.Instances, width, now.Unix(), func(id string) bool {
+		return tracker.highlighted(id, now)
+	})
 
-	// Compute dynamic column widths based on actual content.
-	const idW, stateW, uptimeW = 10, 10, 10
-	projW := 14 // minimum width
+	var running int
 	for _, inst := range resp.Instances {
-		if l := len(inst.Project); l > projW {
-			projW = l
+		if inst.State == "RUNNING" || inst.State == "ATTACHED" {
+			running++
 		}
 	}
-	if projW > 30 {
-		projW = 30
+
+	if len(resp.Instances) == 0 && !compact {
+		buf.WriteString("\n  no instances running\n")
 	}
 
-	const separators = 4 * 2 // 4 column gaps of 2 spaces
-	branchW := width - (idW + projW + stateW + uptimeW + separators)
-	if branchW < 15 {
-		branchW = 15
+	// Status footer.
+	if compact {
+		fmt.Fprintf(&buf, "\n%s%d/%d running%s\n", colorDim, running, len(resp.Instances), colorReset)
+	} else {
+		fmt.Fprintf(&buf, "\n%s  %d instance(s)  ·  %d running  ·  %s%s\n",
+			colorDim, len(resp.Instances), running, time.Now().Format("15:04:05"), colorReset)
 	}
 
-	var buf strings.Builder
-	buf.WriteString("\033[H")
+	// Clear the rest of each line and of the screen, so nothing of a wider
+	// or longer previous frame is left behind.
+	fmt.Print(strings.ReplaceAll(buf.String(), "\n", "\033[K\n") + "\033[J")
+}
 
-	// ASCII art grove header — banner with tree on either side.
+// writeWatchBanner writes the ASCII art header — the banner with a tree on
+// either side — centered in width.  The trees are left out when they do not
+// fit, and the whole header when the banner alone does not.
+func writeWatchBanner(buf *strings.Builder, width int) {
 	const treeGap = 2
 	maxTreeW := 0
 	for _, l := range watchTreeLeft {
@@ -158,11 +417,18 @@ func drawWatch(fd int, socketPath string) {
 		bannerPadded[1+i] = watchBanner[i]
 	}
 	rowWidth := maxTreeW + treeGap + maxBannerW + treeGap + maxTreeW
+	trees := rowWidth <= width
+	if !trees {
+		if maxBannerW > width {
+			return
+		}
+		rowWidth = maxBannerW
+	}
 	leftRowPad := (width - rowWidth) / 2
 	if leftRowPad < 0 {
 		leftRowPad = 0
 	}
-	buf.WriteString("\033[32m") // green for the trees
+	buf.WriteString(colorGreen) // green for the trees
 	for i := 0; i < 11; i++ {
 		leftLine := watchTreeLeft[i]
 		if len(leftLine) < maxTreeW {
@@ -176,54 +442,14 @@ func drawWatch(fd int, socketPath string) {
 		if len(bannerLine) < maxBannerW {
 			bannerLine = bannerLine + strings.Repeat(" ", maxBannerW-len(bannerLine))
 		}
-		row := leftLine + strings.Repeat(" ", treeGap) + bannerLine + strings.Repeat(" ", treeGap) + rightLine
+		row := bannerLine
+		if trees {
+			row = leftLine + strings.Repeat(" ", treeGap) + bannerLine + strings.Repeat(" ", treeGap) + rightLine
+		}
 		if leftRowPad > 0 {
 			buf.WriteString(strings.Repeat(" ", leftRowPad))
 		}
 		buf.WriteString(row + "\n")
 	}
-	buf.WriteString("\033[0m\n")
-
-	// Column headers.
-	fmt.Fprintf(&buf, "%-*s  %-*s  %-*s  %-*s  %s\n",
-		idW, "ID", projW, "PROJECT", stateW, "STATE", uptimeW, "UPTIME", "BRANCH")
-	fmt.Fprintf(&buf, "\033[2m%s  %s  %s  %s  %s\033[0m\n",
-		strings.Repeat("─", idW),
-		strings.Repeat("─", projW),
-		strings.Repeat("─", stateW),
-		strings.Repeat("─", uptimeW),
-		strings.Repeat("─", branchW))
-
-	now := time.Now().Unix()
-	var running int
-	for _, inst := range resp.Instances {
-		project := truncate(inst.Project, projW)
-		branch := truncate(inst.Branch, branchW)
-		uptimeEnd := now
-		if inst.EndedAt > 0 {
-			uptimeEnd = inst.EndedAt
-		}
-		uptime := formatUptime(uptimeEnd - inst.CreatedAt)
-		stateColored := colorState(inst.State)
-		fmt.Fprintf(&buf, "%-*s  %-*s  %s%-*s\033[0m  %-*s  %s\n",
-			idW, inst.ID,
-			projW, project,
-			stateColored, stateW, inst.State,
-			uptimeW, uptime,
-			branch)
-		if inst.State == "RUNNING" || inst.State == "ATTACHED" {
-			running++
-		}
-	}
-
-	if len(resp.Instances) == 0 {
-		buf.WriteString("\n  no instances running\n")
-	}
-
-	// Status footer.
-	fmt.Fprintf(&buf, "\n\033[2m  %d instance(s)  ·  %d running  ·  %s\033[0m\n",
-		len(resp.Instances), running, time.Now().Format("15:04:05"))
-
-	buf.WriteString("\033[J")
-	fmt.Print(buf.String())
+	buf.WriteString(colorReset + "\n")
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/gandalfthegui/grove/internal/proto"
+)
+
+// Exit codes, so scripts can tell the common failures apart.  Any other
+// failure exits with exitFailure.  2 is left out: the flag package exits
+// with it on a bad flag.
+const (
+	exitFailure  = 1
+	exitNoDaemon = 3 // the daemon could not be started or reached
+	exitNotFound = 4 // no such instance or project
+	exitNoDocker = 5 // docker is not running or not installed
+)
+
+// exitCodeFor returns the exit code for resp, a failed response, from the
+// Code the daemon gave it.
+func exitCodeFor(resp proto.Response) int {
+	switch resp.Code {
+	case proto.CodeInstanceNotFound, proto.CodeProjectNotFound:
+		return exitNotFound
+	case proto.CodeNoEngine:
+		return exitNoDocker
+	}
+	return exitFailure
+}
+
+// responseError is a failed response as an error, for helpers that return
+// one, keeping the response for errorExitCode.
+type responseError struct {
+	resp proto.Response
+}
+
+func (e *responseError) Error() string {
+	return e.resp.Error
+}
+
+// errNoDaemon is wrapped by errors for a daemon that could not be reached.
+var errNoDaemon = errors.New("cannot connect to daemon")
+
+// errorExitCode is exitCodeFor for an error: the code for a responseError,
+// exitNoDaemon for errNoDaemon, and exitFailure for anything else.
+func errorExitCode(err error) int {
+	var failed *responseError
+	switch {
+	case errors.As(err, &failed):
+		return exitCodeFor(failed.resp)
+	case errors.Is(err, errNoDaemon):
+		return exitNoDaemon
+	}
+	return exitFailure
+}
+
+// exitWith prints "grove: " and the formatted message to stderr and exits
+// with code.
+func exitWith(code int, format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "grove: "+format+"\n", args...)
+	os.Exit(code)
+}
@@ -11,7 +11,7 @@
 //	grove destroy <instance-id>      – stop and remove an instance
 //
 // grove will start the daemon automatically if it is not already running.
-// Detach from an attached session with Ctrl-] (0x1D).
+// Detach from an attached session with Ctrl-] (0x1D) pressed twice.
 package main
 
 import (
@@ -20,12 +20,19 @@ import (
 )
 
 func main() {
+	os.Args = setupColor(os.Args)
+	os.Args = setupBatch(os.Args)
+	setupBanners()
+	os.Args = setupSocket(os.Args)
+	os.Args = setupAliases(os.Args)
 	if len(os.Args) < 2 {
 		usage()
 		os.Exit(1)
 	}
 
 	switch os.Args[1] {
+	case "init":
+		cmdInit()
 	case "project":
 		cmdProject()
 	case "start":
@@ -52,12 +59,36 @@ func main() {
 		cmdPrune()
 	case "dir":
 		cmdDir()
+	case "open":
+		cmdOpen()
+	case "config":
+		cmdConfig()
+	case "snapshot":
+		cmdSnapshot()
+	case "play":
+		cmdPlay()
 	case "daemon":
 		cmdDaemon()
+	case "metrics":
+		cmdMetrics()
+	case "doctor":
+		cmdDoctor()
 	case "token":
 		cmdToken()
 	case "shell":
 		cmdShell()
+	case "exec":
+		cmdExec()
+	case "annotate":
+		cmdAnnotate()
+	case "mv":
+		cmdMv()
+	case "export":
+		cmdExport()
+	case "completion":
+		cmdCompletion()
+	case "__complete":
+		cmdComplete()
 	default:
 		fmt.Fprintf(os.Stderr, "grove: unknown command %q\n", os.Args[1])
 		usage()
@@ -69,35 +100,158 @@ func usage() {
 	fmt.Fprintln(os.Stderr, `grove – supervise AI coding agent instances
 
 Project commands:
-  project create <name> [--repo <url>]
+  init [name]              Register the git repo you are in as a project (uses its remote)
+  project create <name> [--repo <url>] [--subdir <dir>]
                            Register a new project (name + repo URL)
+                           --subdir: work in this directory of a monorepo (grove.yaml, agent, commands)
+  project import-all <dir> Register every git repo under <dir> (named after its directory, cloned from
+                           origin); skips repos without origin or already registered
   project list             List registered projects (numbered)
-  project delete <name|#>  Remove a project and all its worktrees
+  project delete <name|#> [-f]
+                           Remove a project and all its worktrees (-f: don't ask)
   project dir <name|#>     Print the main checkout path for a project
+  project config-path <name|#>
+                           Print the path of the grove.yaml the daemon loads for a project
+  project default [<name|#> | --clear]
+                           Set the project 'grove start <branch>' uses; with no argument, print it
+  project validate-repo <name|#>
+                           Check the repo URL is reachable with your credentials (git ls-remote)
+  project warm <name|#>    Pull the project's container image(s) now, so the next start is fast
 
 Instance commands:
-  start <project|#> <branch> [-d]
+  start [<project|#>] <branch>... [-d] [--resume] [--open] [--timeout <duration>] [--prompt <text>] [--name <name>] [--env-file <path>]... [--agent-arg <arg>]...
                                  Start a new agent instance on <branch> (attaches immediately; -d to skip)
                                  <project> may be a name or the number from 'project list'
-  attach <instance-id>           Attach terminal to an instance (detach: Ctrl-])
-  stop <instance-id>             Kill the agent; instance stays in list as KILLED
-  restart <instance-id> [-d]     Restart agent in existing worktree (attaches immediately; -d to skip)
-  check <instance-id>            Run check commands concurrently; instance returns to WAITING
-  finish <instance-id>           Run finish steps; instance stays as FINISHED
-  shell <instance-id> [shell]    Open an interactive shell in the instance container (default: sh)
-  drop <instance-id>             Delete the worktree and branch permanently
-  list [--active]                List all instances (--active: exclude FINISHED)
-  logs <instance-id> [-f]        Print buffered output for an instance
-  watch                          Live dashboard (refreshes every second, Ctrl-C to exit)
-  prune [--finished]             Drop all exited/crashed instances (--finished: also FINISHED)
+                                 Without <project>, the default project is used (see 'project default')
+                                 Several branches start one instance each, in turn, without attaching
+                                 --resume: check out an existing branch (local or origin) instead of a new one
+                                 --open: also open the worktree in $GROVE_EDITOR (default: code)
+                                 --timeout: stop waiting after this long (e.g. 5m); setup carries on in
+                                 the daemon and the instance shows up in 'grove list' when ready
+                                 --prompt: type this task into the agent once it is ready (gives up
+                                 after 30s of boot output, with a [grove] note in the agent output)
+                                 --name: a label other commands accept in place of the instance ID
+                                 --env-file: extra agent env (repeatable; later files win over earlier
+                                 ones and over ~/.grove/env)
+                                 --agent-arg: append an argument to the agent command (repeatable)
+  attach <instance-id> [--cooked] [--once] [--no-title] [--max-rate <bytes/s>] [--takeover]
+         [--confirm-paste [--paste-limit <bytes>]]
+                                 Attach terminal to an instance (detach: Ctrl-] Ctrl-])
+                                 Ctrl-] then c/f/s runs check/finish/stop on the instance
+                                 --cooked: local line editing, sends whole lines on Enter
+                                 --once: detach when the agent next goes idle after working
+                                 --no-title: leave the terminal window title alone
+                                 --max-rate: drop output bursts above this rate (e.g. 64k) and summarize them
+                                 --takeover: detach whoever is attached already (e.g. a dropped SSH session)
+                                 --confirm-paste: ask before sending a paste over --paste-limit (default 4k)
+  stop <instance-id> | --branch <branch> [--all] [--wait] [--snapshot] [--container]
+                                 Kill the agent; instance stays in list as KILLED (container keeps running)
+                                 --branch: the instance on that branch (--all if several projects have it)
+                                 --wait: return only once the agent process has exited
+                                 --snapshot: wait, then print the agent's final screen
+                                 --container: also remove the container, keeping the worktree
+  restart <instance-id> [-d] [--wait-ready] [--fresh] [--resend-prompt] [--env-file <path>]... [--agent-arg <arg>]...
+                                 Restart agent in existing worktree (attaches immediately; -d to skip)
+                                 --wait-ready: return only once the agent has booted and settled
+                                 --fresh: build a new container first (needed after stop --container)
+                                 --resend-prompt: type the start --prompt task in again once ready
+                                 --env-file / --agent-arg: as for start, for this run only
+  check <instance-id> [--only <group>] [--skip <group>]
+                                 Run check commands concurrently; instance returns to WAITING
+                                 --only/--skip: select named check groups (comma-separated)
+                                 Exits non-zero if any check fails: grove check 3 && grove finish 3
+  check --all | --project <name|#> [--jobs N] [--only <group>] [--skip <group>]
+                                 Check every live instance (of the project), N at a time (default 4),
+                                 and print a pass/fail table; output goes to each instance's log
+  finish <instance-id> [--allow-empty] [--target <branch>]
+                                 Run finish steps; instance stays as FINISHED (refuses a branch with no
+                                 commits ahead of the default branch unless --allow-empty)
+                                 --target: branch for {{target}} in finish steps (default: default branch)
+  shell <instance-id> [shell] [--rcfile <path>] [--user <user> | --no-root]
+                                 Open an interactive shell in the instance container (default: sh)
+                                 with history and aliases; --rcfile uses your own rc file instead
+                                 (starts where the previous shell on the instance exited)
+                                 Runs as root unless --user names another user or --no-root
+                                 keeps the image's default user
+  exec <instance-id> <command>...
+                                 Run one command in the instance container and exit with its status
+                                 (stdout and stderr are kept apart; env: from grove.yaml is set;
+                                 interrupting grove ends the docker exec; the instance state is untouched)
+  drop <instance-id> | --branch <branch> [--all] [-f]
+                                 Delete the worktree and branch permanently
+                                 --branch: the instance on that branch (--all if several projects have it)
+  list [--active] [--state <s>] [--project <p>] [--branch <glob>] [--annotation k=v]
+       [--count] [--wide] [--json] [--watch [interval]]
+                                 List instances (--active: exclude FINISHED; --count: print only the number;
+                                 --wide: also show annotations and why a crashed agent died;
+                                 --json: print the instances' records as a JSON array, for scripts)
+                                 --branch: shell-style glob on the branch name, e.g. 'fix-*' ('*' stops at '/')
+                                 --watch: redraw the list in place every interval (default 2s) until Ctrl-C
+  annotate <instance-id> [key=value ...]
+                                 Set key/value notes on an instance (key= removes; no args prints them)
+  mv <instance-id> <new-branch>  Rename an instance's branch (worktree and container are unaffected)
+  logs <instance-id> [-f [--retry] | --merge-setup | --all-runs] [--tail-bytes N]
+                                 Print buffered output for an instance
+                                 (--retry: keep following across daemon restarts;
+                                 --merge-setup: setup, agent, check and finish output by time;
+                                 --all-runs: the whole on-disk log, every run of the agent marked;
+                                 --tail-bytes: only the last N bytes, for very long lines)
+  logs --all --save-dir <dir> [--with-names]
+                                 Save every instance's buffered output to <dir>/<id>.log
+  watch [--compact | --columns <list>] [--bell]
+                                 Live dashboard (refreshes every second, Ctrl-C to exit; --compact: ID and
+                                 state dot only; --columns: pick from id,project,state,age,branch in order)
+                                 Rows whose state just changed are highlighted; --bell rings when one ends
+  prune [--finished] [-f]        Drop all exited/crashed instances (--finished: also FINISHED; -f: don't ask)
   dir <instance-id>              Print the worktree path for an instance
+  open <instance-id>             Open the worktree in $GROVE_EDITOR (default: VS Code's code)
+  config <instance-id>           Show the config the instance was started with (env values hidden)
+  snapshot <instance-id>         Print the agent's screen as text (the last one, if it has exited)
+  export <instance-id> [--format patch|bundle] [-o file]
+                                 Write the branch's commits since the default branch as a patch or bundle
+  play <file.cast> [--speed N] [--no-timing]
+                                 Replay an asciicast v2 terminal recording with its original timing
 
 Daemon commands:
-  daemon install           Register groved as a login LaunchAgent
-  daemon uninstall         Remove the LaunchAgent
-  daemon status            Show whether the LaunchAgent is installed and running
-  daemon logs [-f] [-n N]  Print daemon log (-f follow, -n tail lines)
+  daemon install           Register groved as a login LaunchAgent (macOS) or systemd user unit (Linux)
+  daemon uninstall         Remove the LaunchAgent or systemd unit
+  daemon status            Show whether the LaunchAgent or systemd unit is installed and running
+  daemon logs [-f] [-n N] [--instance <id>] [--json | --text]
+                           Print daemon log (-f follow, -n tail lines,
+                           --instance: only lines about one instance, e.g. a failed start;
+                           --json: one JSON object per line, for jq; --text: render JSON lines as text)
+  daemon reload <project|#>
+                           Pull grove.yaml and report what changed for running instances
+  metrics [--json]         Show daemon uptime, instance counts by state, attach sessions, docker status
+  doctor                   Check the install: groved binary (next to grove or on PATH), Docker, daemon,
+                           free disk space and inodes for ~/.grove, a rejected Claude token (low space
+                           and the token are warnings; Docker, disk and token are skipped with GROVE_ADDR)
 
 Credential commands:
-  token                    Set or replace the CLAUDE_CODE_OAUTH_TOKEN in ~/.grove/env`)
+  token                    Set or replace the CLAUDE_CODE_OAUTH_TOKEN in ~/.grove/env
+  token --show             Print the stored token masked, with its length
+
+Shell completion:
+  completion bash|zsh|fish Print a completion script for subcommands, instance IDs and projects
+                           (bash/zsh: source <(grove completion bash); fish: grove completion fish | source)
+
+Aliases:
+  Define shortcuts in ~/.grove/config.yaml, e.g.  aliases: {qf: start myproj -d}
+  then 'grove qf feat-x' runs 'grove start myproj -d feat-x'
+
+Global flags (before the command, e.g. grove --batch drop 3):
+  --no-color               Disable colored output (also NO_COLOR; off when stdout is not a TTY)
+  --batch                  Never prompt: fail or use the default instead (also GROVE_NONINTERACTIVE=1)
+  --socket <path>          Talk to the daemon on this socket; never auto-start one (also GROVE_SOCKET)
+
+Environment:
+  GROVE_TIMEOUT            How long to wait for the daemon to answer (default 5s; 0 waits forever)
+  GROVE_NO_BANNERS         Leave out grove's [grove] attach/detach lines, and the start line before an attach
+  GROVE_CONTAINER_RUNTIME  Container engine to run instead of docker, e.g. podman (the daemon reads it too)
+  GROVE_ADDR               Daemon to talk to instead, e.g. tcp:127.0.0.1:7433 (groved --listen);
+                           requests carry GROVE_DAEMON_TOKEN (environment or ~/.grove/env)
+
+Exit codes:
+  1 failure, 2 bad flag, 3 daemon not reachable, 4 no such instance or project, 5 Docker unavailable
+  (grove exec exits with the command's own status once it ran, so any of these may be the command's)`)
 }
@@ -1,12 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
 
+	"github.com/gandalfthegui/grove/internal/engine"
+	"github.com/gandalfthegui/grove/internal/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestFormatUptime(t *testing.T) {
@@ -43,6 +59,8 @@ func TestTruncate(t *testing.T) {
 		{"hello world", 3, "hel"}, // n<=3: no ellipsis
 		{"hello world", 8, "hello..."},
 		{"", 5, ""},
+		{"●", 1, "●"},
+		{"ünïcödé-branch", 8, "ünïcö..."},
 	}
 	for _, tc := range cases {
 		assert.Equal(t, tc.want, truncate(tc.s, tc.n), "truncate(%q, %d)", tc.s, tc.n)
@@ -58,6 +76,174 @@ func TestColorState(t *testing.T) {
 	assert.Empty(t, colorState("UNKNOWN"))
 }
 
+func TestColorEnabled(t *testing.T) {
+	assert.True(t, colorEnabled(false, false, false, true))
+	assert.False(t, colorEnabled(false, false, false, false), "non-TTY stdout disables color")
+	assert.True(t, colorEnabled(false, false, true, false), "FORCE_COLOR overrides non-TTY")
+	assert.False(t, colorEnabled(true, false, true, true), "--no-color wins")
+	assert.False(t, colorEnabled(false, true, true, true), "NO_COLOR wins")
+}
+
+func TestGlobalFlagsEnd(t *testing.T) {
+	assert.Equal(t, 1, globalFlagsEnd([]string{"grove", "list"}))
+	assert.Equal(t, 2, globalFlagsEnd([]string{"grove", "--no-color", "exec", "1", "ls", "--no-color"}))
+	assert.Equal(t, 3, globalFlagsEnd([]string{"grove", "--batch", "--no-color", "drop", "1"}))
+	assert.Equal(t, 4, globalFlagsEnd([]string{"grove", "--no-color", "--socket", "/tmp/a.sock", "exec", "1", "ls", "--no-color"}))
+	assert.Equal(t, 2, globalFlagsEnd([]string{"grove", "--socket=/tmp/a.sock", "list"}))
+	assert.Equal(t, 2, globalFlagsEnd([]string{"grove", "--socket"}))
+	assert.Equal(t, 1, globalFlagsEnd([]string{"grove", "--", "--no-color"}))
+	assert.Equal(t, 2, globalFlagsEnd([]string{"grove", "--no-color"}))
+
+	args, found := stripGlobalBoolFlag([]string{"grove", "--no-color", "exec", "1", "ls", "--no-color"}, "no-color")
+	assert.True(t, found)
+	assert.Equal(t, []string{"grove", "exec", "1", "ls", "--no-color"}, args, "the command's own flag is kept")
+	args, found = stripGlobalBoolFlag([]string{"grove", "start", "app", "b", "--agent-arg", "--no-color"}, "no-color")
+	assert.False(t, found)
+	assert.Equal(t, []string{"grove", "start", "app", "b", "--agent-arg", "--no-color"}, args)
+}
+
+// restoreColorsAfter puts the color escapes back when t ends, for tests
+// that call disableColor.
+func restoreColorsAfter(t *testing.T) {
+	saved := []string{colorBold, colorDim, colorReverse, colorRed, colorGreen, colorYellow, colorCyan, colorReset}
+	t.Cleanup(func() {
+		colorBold, colorDim, colorReverse, colorRed, colorGreen, colorYellow, colorCyan, colorReset =
+			saved[0], saved[1], saved[2], saved[3], saved[4], saved[5], saved[6], saved[7]
+	})
+}
+
+func TestGlobalArgs(t *testing.T) {
+	restoreColorsAfter(t)
+	defer func() { nonInteractive, socketOverride = false, "" }()
+
+	assert.Empty(t, globalArgs())
+
+	disableColor()
+	nonInteractive = true
+	socketOverride = "tcp:127.0.0.1:7433"
+	assert.Equal(t, []string{"--no-color", "--batch", "--socket", "tcp:127.0.0.1:7433"}, globalArgs())
+}
+
+func TestSetupBatch(t *testing.T) {
+	defer func() { nonInteractive = false }()
+
+	t.Setenv("GROVE_NONINTERACTIVE", "")
+	args := setupBatch([]string{"grove", "--batch", "drop", "1"})
+	assert.Equal(t, []string{"grove", "drop", "1"}, args)
+	assert.True(t, nonInteractive)
+
+	args = setupBatch([]string{"grove", "exec", "1", "deploy", "--batch"})
+	assert.Equal(t, []string{"grove", "exec", "1", "deploy", "--batch"}, args, "after the command it is the command's")
+	assert.False(t, nonInteractive)
+
+	setupBatch([]string{"grove", "list"})
+	assert.False(t, nonInteractive)
+
+	t.Setenv("GROVE_NONINTERACTIVE", "1")
+	setupBatch([]string{"grove", "list"})
+	assert.True(t, nonInteractive)
+
+	t.Setenv("GROVE_NONINTERACTIVE", "0")
+	setupBatch([]string{"grove", "list"})
+	assert.False(t, nonInteractive)
+}
+
+func TestWriteBanner(t *testing.T) {
+	defer func() { banners = true }()
+
+	var buf strings.Builder
+	t.Setenv("GROVE_NO_BANNERS", "")
+	setupBanners()
+	writeBanner(&buf, "attached to %s", "3")
+	assert.Equal(t, "\r\n[grove] attached to 3\r\n", buf.String())
+
+	buf.Reset()
+	t.Setenv("GROVE_NO_BANNERS", "1")
+	setupBanners()
+	writeBanner(&buf, "detached from %s", "3")
+	assert.Empty(t, buf.String())
+
+	t.Setenv("GROVE_NO_BANNERS", "0")
+	setupBanners()
+	assert.True(t, banners)
+}
+
+func TestSetupSocket(t *testing.T) {
+	defer func() { socketOverride = "" }()
+
+	t.Setenv("GROVE_SOCKET", "")
+	t.Setenv("GROVE_ROOT", "/tmp/groot")
+	args := setupSocket([]string{"grove", "--socket", "/tmp/a.sock", "list"})
+	assert.Equal(t, []string{"grove", "list"}, args)
+	assert.Equal(t, "/tmp/a.sock", socketPath())
+
+	args = setupSocket([]string{"grove", "--socket=/tmp/b.sock", "list"})
+	assert.Equal(t, []string{"grove", "list"}, args)
+	assert.Equal(t, "/tmp/b.sock", socketPath())
+
+	args = setupSocket([]string{"grove", "exec", "1", "--socket", "/tmp/c.sock"})
+	assert.Equal(t, []string{"grove", "exec", "1", "--socket", "/tmp/c.sock"}, args, "after the subcommand it belongs to the command")
+	assert.Equal(t, "/tmp/groot/groved.sock", socketPath())
+
+	t.Setenv("GROVE_SOCKET", "/tmp/env.sock")
+	setupSocket([]string{"grove", "list"})
+	assert.Equal(t, "/tmp/env.sock", socketPath())
+
+	t.Setenv("GROVE_ADDR", "tcp:127.0.0.1:7433")
+	setupSocket([]string{"grove", "list"})
+	assert.Equal(t, "tcp:127.0.0.1:7433", socketPath())
+	network, address := daemonNetwork(socketPath())
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "127.0.0.1:7433", address)
+
+	t.Setenv("GROVE_ADDR", "")
+	t.Setenv("GROVE_SOCKET", "")
+	setupSocket([]string{"grove", "list"})
+	assert.Equal(t, "/tmp/groot/groved.sock", socketPath())
+}
+
+func TestRequestTimeout(t *testing.T) {
+	t.Setenv("GROVE_TIMEOUT", "")
+	assert.Equal(t, defaultTimeout, requestTimeout())
+	t.Setenv("GROVE_TIMEOUT", "250ms")
+	assert.Equal(t, 250*time.Millisecond, requestTimeout())
+	t.Setenv("GROVE_TIMEOUT", "12")
+	assert.Equal(t, 12*time.Second, requestTimeout())
+	t.Setenv("GROVE_TIMEOUT", "soon")
+	assert.Equal(t, defaultTimeout, requestTimeout())
+
+	t.Setenv("GROVE_TIMEOUT", "2s")
+	assert.Equal(t, 2*time.Second, replyTimeout(proto.Request{Type: proto.ReqStop}))
+	assert.Equal(t, 2*time.Second+slowRequestGrace, replyTimeout(proto.Request{Type: proto.ReqStop, Wait: true}))
+	t.Setenv("GROVE_TIMEOUT", "0")
+	assert.Zero(t, replyTimeout(proto.Request{Type: proto.ReqDrop}), "0 disables timeouts")
+}
+
+func TestRequestToWedgedDaemon(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "groved.sock")
+	ln, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		// Accept and read, but never answer.
+		conn, err := ln.Accept()
+		if err == nil {
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	t.Setenv("GROVE_TIMEOUT", "100ms")
+	start := time.Now()
+	_, err = request(sock, proto.Request{Type: proto.ReqList})
+	assert.ErrorContains(t, err, "daemon not responding (no reply within 100ms")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestThrobberLine(t *testing.T) {
+	assert.Equal(t, "\r  Starting instance |  ", throbberLine('|', time.Second))
+	assert.Equal(t, "\r  Starting instance /  1m5s  ", throbberLine('/', 65*time.Second+400*time.Millisecond))
+}
+
 func TestLoadProjectEntries(t *testing.T) {
 	dir := t.TempDir()
 	t.Setenv("GROVE_ROOT", dir)
@@ -76,6 +262,54 @@ func TestLoadProjectEntries(t *testing.T) {
 	assert.Equal(t, "gamma", entries[2].name)
 }
 
+func TestAppendProjectSettingQuotes(t *testing.T) {
+	t.Setenv("GROVE_ROOT", t.TempDir())
+	yamlPath, err := writeProjectRegistration("web", "git@github.com:org/web.git")
+	require.NoError(t, err)
+	require.NoError(t, appendProjectSetting(yamlPath, "subdir", "svc: a #1"))
+
+	data, err := os.ReadFile(yamlPath)
+	require.NoError(t, err)
+	var reg struct {
+		Repo   string `yaml:"repo"`
+		Subdir string `yaml:"subdir"`
+	}
+	require.NoError(t, yaml.Unmarshal(data, &reg))
+	assert.Equal(t, "git@github.com:org/web.git", reg.Repo)
+	assert.Equal(t, "svc: a #1", reg.Subdir)
+}
+
+func TestDefaultProjectFor(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GROVE_ROOT", dir)
+	_, err := writeProjectRegistration("web", "git@github.com:org/web.git")
+	require.NoError(t, err)
+
+	_, err = defaultProjectFor("fix-login")
+	assert.ErrorContains(t, err, "no default project set")
+
+	require.NoError(t, saveUserConfig(userConfig{DefaultProject: "web"}))
+	cfg, err := loadUserConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "web", cfg.DefaultProject)
+
+	project, err := defaultProjectFor("fix-login")
+	require.NoError(t, err)
+	assert.Equal(t, "web", project)
+
+	_, err = defaultProjectFor("web")
+	assert.ErrorContains(t, err, "no branch given", "a lone project name is not a branch")
+	_, err = defaultProjectFor("1")
+	assert.ErrorContains(t, err, "no branch given", "a lone project number is not a branch")
+	project, err = defaultProjectFor("1234")
+	require.NoError(t, err, "a number no project has is a branch")
+	assert.Equal(t, "web", project)
+
+	require.NoError(t, saveUserConfig(userConfig{DefaultProject: "gone"}))
+	_, err = defaultProjectFor("fix-login")
+	assert.ErrorContains(t, err, `default project "gone" no longer exists`)
+}
+
 func TestLoadProjectEntriesEmpty(t *testing.T) {
 	t.Setenv("GROVE_ROOT", t.TempDir())
 	assert.Empty(t, loadProjectEntries())
@@ -122,3 +356,870 @@ func TestResolveProjectByNumber(t *testing.T) {
 	assert.Equal(t, "alpha", resolveProject("1"))
 	assert.Equal(t, "beta", resolveProject("2"))
 }
+
+func TestProjectConfigPath(t *testing.T) {
+	projectDir := t.TempDir()
+	yamlPath := filepath.Join(projectDir, "project.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte("name: api\n"), 0o644))
+
+	_, err := projectConfigPath(projectDir)
+	assert.ErrorContains(t, err, "has not been cloned yet")
+
+	main := filepath.Join(projectDir, "main")
+	require.NoError(t, os.MkdirAll(filepath.Join(main, "services", "api"), 0o755))
+	_, err = projectConfigPath(projectDir)
+	assert.ErrorContains(t, err, "no config found")
+
+	rootConfig := filepath.Join(main, "grove.yaml")
+	require.NoError(t, os.WriteFile(rootConfig, nil, 0o644))
+	path, err := projectConfigPath(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, rootConfig, path)
+
+	require.NoError(t, os.WriteFile(yamlPath, []byte("name: api\nsubdir: services/api\n"), 0o644))
+	path, err = projectConfigPath(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, rootConfig, path, "falls back to the repo root")
+
+	subConfig := filepath.Join(main, "services", "api", "grove.yaml")
+	require.NoError(t, os.WriteFile(subConfig, nil, 0o644))
+	path, err = projectConfigPath(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, subConfig, path)
+
+	require.NoError(t, os.WriteFile(yamlPath, []byte("name: api\nsubdir: ./services//api/\n"), 0o644))
+	path, err = projectConfigPath(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, subConfig, path, "subdir is normalised as the daemon does")
+
+	require.NoError(t, os.WriteFile(yamlPath, []byte("name: api\nsubdir: ../elsewhere\n"), 0o644))
+	_, err = projectConfigPath(projectDir)
+	assert.ErrorContains(t, err, "must be a path inside the repository")
+}
+
+func TestSendCookedInput(t *testing.T) {
+	var out bytes.Buffer
+	sendCookedInput(&out, strings.NewReader("ls -la\necho hi\x1d\nignored\n"))
+
+	ft, payload, err := proto.ReadFrame(&out)
+	require.NoError(t, err)
+	assert.Equal(t, proto.AttachFrameData, ft)
+	assert.Equal(t, "ls -la\r", string(payload))
+
+	ft, payload, err = proto.ReadFrame(&out)
+	require.NoError(t, err)
+	assert.Equal(t, proto.AttachFrameData, ft)
+	assert.Equal(t, "echo hi", string(payload))
+
+	ft, _, err = proto.ReadFrame(&out)
+	require.NoError(t, err)
+	assert.Equal(t, proto.AttachFrameDetach, ft)
+	assert.Zero(t, out.Len(), "nothing after the detach key should be sent")
+}
+
+func TestSendRawInputEscape(t *testing.T) {
+	var out, status bytes.Buffer
+	var ran []string
+	run := func(action string) { ran = append(ran, action) }
+	sendRawInput(&out, strings.NewReader("ab\x1dcxy\x1d?z\x1d\x1dignored"), &status, run)
+
+	var data string
+	for {
+		ft, payload, err := proto.ReadFrame(&out)
+		require.NoError(t, err)
+		if ft == proto.AttachFrameDetach {
+			break
+		}
+		assert.Equal(t, proto.AttachFrameData, ft)
+		data += string(payload)
+	}
+	assert.Equal(t, "abxyz", data, "escape keys are not forwarded")
+	assert.Equal(t, []string{"check"}, ran)
+	assert.Contains(t, status.String(), escapeHelp)
+	assert.Zero(t, out.Len(), "nothing after the detach should be sent")
+}
+
+func TestSendRawInputKeepsCharactersWhole(t *testing.T) {
+	input := "héllo 世界 🌳!"
+	var out bytes.Buffer
+	// One byte per read: every multibyte character arrives split.
+	sendRawInput(&out, iotest.OneByteReader(strings.NewReader(input)), io.Discard, func(string) {})
+
+	var data string
+	for out.Len() > 0 {
+		ft, payload, err := proto.ReadFrame(&out)
+		require.NoError(t, err)
+		assert.Equal(t, proto.AttachFrameData, ft)
+		assert.True(t, utf8.Valid(payload), "frame %q splits a character", payload)
+		data += string(payload)
+	}
+	assert.Equal(t, input, data)
+
+	assert.Equal(t, 0, partialRuneLen([]byte("ab")))
+	assert.Equal(t, 2, partialRuneLen([]byte("a\xe4\xb8")), "2 of 3 bytes of 世")
+	assert.Equal(t, 0, partialRuneLen([]byte("a\xe4\xb8\x96")))
+	assert.Equal(t, 0, partialRuneLen([]byte("\x80\x80\x80\x80")), "stray continuation bytes are passed on")
+}
+
+func TestPasteGuard(t *testing.T) {
+	paste := func(text string) string { return string(pasteStart) + text + string(pasteEnd) }
+	long := strings.Repeat("x", 20)
+	cases := []struct {
+		name, want, status string
+		in                 io.Reader
+	}{
+		{"short paste", "ab" + paste("short") + "cd", "", strings.NewReader("ab" + paste("short") + "cd")},
+		{"confirmed", paste(long) + "z", "send 20 pasted bytes? [y/N] y", strings.NewReader(paste(long) + "yz")},
+		{"declined", "az", "paste dropped", strings.NewReader("a" + paste(long) + "nz")},
+		{"split across reads", paste(long), "send 20 pasted bytes?", io.MultiReader(
+			strings.NewReader(string(pasteStart)+long[:10]),
+			strings.NewReader(long[10:]+string(pasteEnd)),
+			strings.NewReader("y"),
+		)},
+		{"start marker split across reads", "ab" + paste(long), "send 20 pasted bytes?", io.MultiReader(
+			strings.NewReader("ab"+string(pasteStart[:3])),
+			strings.NewReader(string(pasteStart[3:])+long+string(pasteEnd)+"y"),
+		)},
+		{"escape sequence split across reads", "\033[A\033", "", io.MultiReader(
+			strings.NewReader("\033["),
+			strings.NewReader("A\033"),
+		)},
+	}
+	for _, c := range cases {
+		var status bytes.Buffer
+		got, err := io.ReadAll(newPasteGuard(c.in, &status, 10))
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.want, string(got), c.name)
+		if c.status == "" {
+			assert.Empty(t, status.String(), c.name)
+		} else {
+			assert.Contains(t, status.String(), c.status, c.name)
+		}
+	}
+}
+
+func TestListFilterMatch(t *testing.T) {
+	running := proto.InstanceInfo{ID: "1", Project: "app", State: proto.StateRunning}
+	finished := proto.InstanceInfo{ID: "2", Project: "api", State: proto.StateFinished}
+
+	assert.True(t, listFilter{}.match(running))
+	assert.True(t, listFilter{}.match(finished))
+
+	assert.True(t, listFilter{activeOnly: true}.match(running))
+	assert.False(t, listFilter{activeOnly: true}.match(finished))
+
+	assert.True(t, listFilter{state: "running"}.match(running), "state match is case-insensitive")
+	assert.False(t, listFilter{state: "running"}.match(finished))
+
+	assert.True(t, listFilter{project: "api"}.match(finished))
+	assert.False(t, listFilter{project: "api"}.match(running))
+}
+
+func TestListFilterBranch(t *testing.T) {
+	fix := proto.InstanceInfo{ID: "1", Branch: "fix-login", State: proto.StateRunning}
+	feat := proto.InstanceInfo{ID: "2", Branch: "feature/fix-ui", State: proto.StateFinished}
+
+	assert.True(t, listFilter{branch: "fix-*"}.match(fix))
+	assert.False(t, listFilter{branch: "fix-*"}.match(feat))
+	assert.True(t, listFilter{branch: "feature/*"}.match(feat))
+	assert.False(t, listFilter{branch: "*fix*"}.match(feat), "* does not cross /")
+	assert.False(t, listFilter{branch: "fix-*", activeOnly: true}.match(feat))
+}
+
+func TestStripWatchFlag(t *testing.T) {
+	cases := []struct {
+		args     []string
+		rest     []string
+		interval time.Duration
+		found    bool
+	}{
+		{[]string{"--wide"}, []string{"--wide"}, defaultListWatchInterval, false},
+		{[]string{"--watch"}, []string{}, defaultListWatchInterval, true},
+		{[]string{"--watch", "5"}, []string{}, 5 * time.Second, true},
+		{[]string{"--watch", "500ms", "--wide"}, []string{"--wide"}, 500 * time.Millisecond, true},
+		{[]string{"--watch", "--project", "api"}, []string{"--project", "api"}, defaultListWatchInterval, true},
+		{[]string{"--active", "-watch=1.5"}, []string{"--active"}, 1500 * time.Millisecond, true},
+	}
+	for _, c := range cases {
+		rest, interval, found, err := stripWatchFlag(c.args)
+		require.NoError(t, err, c.args)
+		assert.Equal(t, c.rest, rest, c.args)
+		assert.Equal(t, c.interval, interval, c.args)
+		assert.Equal(t, c.found, found, c.args)
+	}
+
+	for _, bad := range [][]string{{"--watch=soon"}, {"--watch", "0"}, {"--watch=-1s"}} {
+		_, _, _, err := stripWatchFlag(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPrintList(t *testing.T) {
+	insts := []proto.InstanceInfo{{ID: "1", Name: "auth", Project: "app", State: proto.StateRunning, Branch: "fix-login"}}
+
+	var out bytes.Buffer
+	printList(&out, insts, listFormat{})
+	assert.Contains(t, out.String(), "NAME")
+	assert.Contains(t, out.String(), "auth")
+	assert.NotContains(t, out.String(), "ANNOTATIONS")
+
+	out.Reset()
+	insts[0].Annotations = map[string]string{"ticket": "JIRA-1"}
+	printList(&out, insts, listFormat{wide: true})
+	assert.Contains(t, out.String(), "BRANCH     ANNOTATIONS")
+	assert.Contains(t, out.String(), "fix-login  ticket=JIRA-1\n", "the branch column is padded to fit")
+
+	out.Reset()
+	printList(&out, insts, listFormat{count: true})
+	assert.Equal(t, "1\n", out.String())
+
+	out.Reset()
+	printList(&out, nil, listFormat{json: true})
+	assert.Equal(t, "[]\n", out.String())
+}
+
+func TestBranchTargets(t *testing.T) {
+	insts := []proto.InstanceInfo{
+		{ID: "1", Project: "api", Branch: "fix-login"},
+		{ID: "2", Project: "web", Branch: "fix-login"},
+		{ID: "3", Project: "api", Branch: "fix-login-2"},
+	}
+	got, err := branchTargets(insts, "fix-login-2", "stop", false)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "3", got[0].ID)
+
+	_, err = branchTargets(insts, "fix-login", "drop", false)
+	assert.EqualError(t, err, `2 instances are on branch "fix-login" (1, 2); drop one by ID, or pass --all to drop them all`)
+	got, err = branchTargets(insts, "fix-login", "drop", true)
+	require.NoError(t, err)
+	assert.Len(t, got, 2)
+
+	_, err = branchTargets(insts, "fix-*", "stop", true)
+	assert.EqualError(t, err, `no instance is on branch "fix-*"`, "not a glob")
+}
+
+func TestInstanceIn(t *testing.T) {
+	insts := []proto.InstanceInfo{
+		{ID: "1", Name: "auth"},
+		{ID: "2"},
+		{ID: "3", Name: "billing"},
+	}
+	assert.Equal(t, "1", instanceIn(insts, "1").ID)
+	assert.Equal(t, "1", instanceIn(insts, "auth").ID)
+	assert.Equal(t, "3", instanceIn(insts, "billing").ID)
+	assert.Nil(t, instanceIn(insts, "4"))
+	assert.Nil(t, instanceIn(insts, ""), "an unnamed instance does not match the empty name")
+}
+
+func TestExitCodeFor(t *testing.T) {
+	assert.Equal(t, exitNotFound, exitCodeFor(proto.Response{Code: proto.CodeInstanceNotFound, Error: "instance not found: 7"}))
+	assert.Equal(t, exitNotFound, exitCodeFor(proto.Response{Code: proto.CodeProjectNotFound}))
+	assert.Equal(t, exitNoDocker, exitCodeFor(proto.Response{Code: proto.CodeNoEngine}))
+	assert.Equal(t, exitFailure, exitCodeFor(proto.Response{Error: "instance not found: 7"}), "only the code counts")
+	assert.Equal(t, exitFailure, exitCodeFor(proto.Response{Error: "2 of 3 checks failed"}))
+
+	assert.Equal(t, exitNotFound, errorExitCode(&responseError{proto.Response{Code: proto.CodeInstanceNotFound}}))
+	assert.Equal(t, exitNoDaemon, errorExitCode(fmt.Errorf("%w: dial unix /tmp/groved.sock: connect: no such file or directory", errNoDaemon)))
+	assert.Equal(t, exitFailure, errorExitCode(errors.New("connection closed before a result was reported")))
+}
+
+func TestParseKeyValues(t *testing.T) {
+	kv, err := parseKeyValues([]string{"ticket=JIRA-1", "note=a=b", "gone="})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"ticket": "JIRA-1", "note": "a=b", "gone": ""}, kv)
+
+	_, err = parseKeyValues([]string{"novalue"})
+	assert.Error(t, err)
+	_, err = parseKeyValues([]string{"=x"})
+	assert.Error(t, err)
+}
+
+func TestListFilterAnnotations(t *testing.T) {
+	inst := proto.InstanceInfo{ID: "1", Annotations: map[string]string{"ticket": "JIRA-1"}}
+
+	assert.True(t, listFilter{annotations: map[string]string{"ticket": "JIRA-1"}}.match(inst))
+	assert.False(t, listFilter{annotations: map[string]string{"ticket": "JIRA-2"}}.match(inst))
+	assert.False(t, listFilter{annotations: map[string]string{"owner": ""}}.match(inst))
+	assert.Equal(t, "a=1 b=2", formatAnnotations(map[string]string{"b": "2", "a": "1"}))
+}
+
+func TestExportBase(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.email=t@t", "-c", "user.name=t"}, args...)...).CombinedOutput()
+		require.NoError(t, err, "git %v: %s", args, out)
+	}
+	git("init", "-q")
+	git("symbolic-ref", "HEAD", "refs/heads/main")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	mainHead, err := gitOutput(dir, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	git("checkout", "-q", "-b", "feat/x")
+	git("commit", "-q", "--allow-empty", "-m", "work")
+
+	base, ref, err := exportBase(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, mainHead, base)
+	assert.Equal(t, "main", ref)
+
+	git("branch", "release", mainHead)
+	git("commit", "-q", "--allow-empty", "-m", "more work")
+	git("checkout", "-q", "main")
+	git("merge", "-q", "--ff-only", "feat/x")
+	git("checkout", "-q", "feat/x")
+	base, ref, err = exportBase(dir, "release")
+	require.NoError(t, err)
+	assert.Equal(t, mainHead, base, "the project's default_branch wins")
+	assert.Equal(t, "release", ref)
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "1.0 MiB", formatBytes(1<<20))
+	assert.Equal(t, "2.0 GiB", formatBytes(2<<30))
+}
+
+func TestLoadEnvFilesLaterWins(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.env")
+	b := filepath.Join(dir, "b.env")
+	require.NoError(t, os.WriteFile(a, []byte("FOO=a\nBAR=a\n"), 0o644))
+	require.NoError(t, os.WriteFile(b, []byte("FOO=b\n"), 0o644))
+
+	env, err := loadEnvFiles([]string{a, b})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"FOO": "b", "BAR": "a"}, env)
+
+	_, err = loadEnvFiles([]string{filepath.Join(dir, "missing.env")})
+	assert.Error(t, err)
+}
+
+func TestParseInterspersed(t *testing.T) {
+	fs := flag.NewFlagSet("t", flag.ContinueOnError)
+	var files stringList
+	fs.Var(&files, "env-file", "")
+	d := fs.Bool("d", false, "")
+
+	args := parseInterspersed(fs, []string{"proj", "--env-file", "a.env", "branch", "-d", "--env-file=b.env"})
+	assert.Equal(t, []string{"proj", "branch"}, args)
+	assert.Equal(t, stringList{"a.env", "b.env"}, files)
+	assert.True(t, *d)
+}
+
+func TestShellExecArgs(t *testing.T) {
+	assert.Equal(t,
+		[]string{"exec", "-it", "-u", "root", "-e", "HOME=/root", "-e", "ENV=" + shellRCPath, "grove-1", "sh"},
+		shellExecArgs("grove-1", "root", "sh", shellRCPath, ""))
+	assert.Equal(t,
+		[]string{"exec", "-it", "-u", "root", "-e", "HOME=/root", "-e", "ENV=" + shellRCPath, "grove-1", "/bin/bash", "--rcfile", shellRCPath, "-i"},
+		shellExecArgs("grove-1", "root", "/bin/bash", shellRCPath, ""))
+	assert.Equal(t,
+		[]string{"exec", "-it", "-u", "root", "-e", "HOME=/root", "-e", "ENV=" + shellRCPath, "-w", "/app/lib", "grove-1", "sh"},
+		shellExecArgs("grove-1", "root", "sh", shellRCPath, "/app/lib"))
+	assert.Equal(t,
+		[]string{"exec", "-it", "-u", "node", "-e", "ENV=/home/node/.grove_shellrc", "grove-1", "sh"},
+		shellExecArgs("grove-1", "node", "sh", "/home/node/.grove_shellrc", ""))
+	assert.Equal(t,
+		[]string{"exec", "-it", "-e", "ENV=/tmp/.grove_shellrc", "grove-1", "sh"},
+		shellExecArgs("grove-1", "", "sh", "/tmp/.grove_shellrc", ""),
+		"--no-root leaves the image's default user")
+}
+
+func TestAttachTitle(t *testing.T) {
+	inst := proto.InstanceInfo{ID: "3", Project: "my-app", Branch: "feat/login"}
+	assert.Equal(t, "\033]2;grove 3 my-app/feat/login\007", attachTitle(inst))
+}
+
+func TestLogFileName(t *testing.T) {
+	inst := proto.InstanceInfo{ID: "4", Project: "my-app", Branch: "feat/login"}
+	assert.Equal(t, "4.log", logFileName(inst, false))
+	assert.Equal(t, "4-my-app-feat-login.log", logFileName(inst, true))
+}
+
+func TestUniquePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "1.log")
+	assert.Equal(t, path, uniquePath(path))
+
+	require.NoError(t, os.WriteFile(path, nil, 0o644))
+	assert.Equal(t, filepath.Join(dir, "1.1.log"), uniquePath(path))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1.1.log"), nil, 0o644))
+	assert.Equal(t, filepath.Join(dir, "1.2.log"), uniquePath(path))
+}
+
+func TestChooseRemoteURL(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		require.NoError(t, err, "git %v: %s", args, out)
+	}
+	git("init", "-q")
+
+	assert.Equal(t, dir, chooseRemoteURL(dir, bufio.NewReader(strings.NewReader("\n"))), "no remote defaults to the local path")
+
+	git("remote", "add", "upstream", "git@example.com:up/repo.git")
+	assert.Equal(t, "git@example.com:up/repo.git", chooseRemoteURL(dir, bufio.NewReader(strings.NewReader(""))))
+
+	git("remote", "add", "origin", "git@example.com:me/repo.git")
+	assert.Equal(t, "git@example.com:me/repo.git", chooseRemoteURL(dir, bufio.NewReader(strings.NewReader("\n"))), "origin is the default")
+	assert.Equal(t, "git@example.com:up/repo.git", chooseRemoteURL(dir, bufio.NewReader(strings.NewReader("nope\nupstream\n"))))
+}
+
+func TestInstanceLogMatcher(t *testing.T) {
+	match := instanceLogMatcher("1")
+	assert.True(t, match("start failed: stage=clone project=app branch=x instance=1 repo=\"r\""))
+	assert.True(t, match("instance 1: check command \"make\" failed"))
+	assert.False(t, match("start requested: project=app branch=x instance=12 repo=\"r\""))
+	assert.False(t, match("instance 21: exited"))
+
+	var buf bytes.Buffer
+	fw := &lineFilterWriter{w: &buf, keep: match}
+	fw.Write([]byte("instance=2 a\ninstance="))
+	fw.Write([]byte("1 b\npartial instance=1"))
+	assert.Equal(t, "instance=1 b\n", buf.String(), "partial lines wait for their newline")
+}
+
+func TestDaemonLogMixedFormats(t *testing.T) {
+	text := "2026/03/01 12:00:05 instance 1: agent exited (<nil>)"
+	obj := `{"time":"2026-03-01T12:00:06Z","level":"WARN","msg":"start failed","instance":"1","err":"no such image"}`
+	junk := "goroutine 1 [running]:"
+
+	e := parseDaemonLogLine(text)
+	assert.Equal(t, "instance 1: agent exited (<nil>)", e.msg)
+	assert.Equal(t, text, e.text(), "text lines pass through")
+	local := time.Date(2026, 3, 1, 12, 0, 5, 0, time.Local).Format(time.RFC3339)
+	assert.JSONEq(t, `{"time":"`+local+`","msg":"instance 1: agent exited (<nil>)"}`, e.json())
+
+	e = parseDaemonLogLine(obj)
+	assert.Equal(t, obj, e.json(), "JSON lines pass through")
+	assert.Equal(t, time.Date(2026, 3, 1, 12, 0, 6, 0, time.UTC).Local().Format(daemonLogTimeLayout)+
+		` WARN start failed err="no such image" instance=1`, e.text())
+	assert.True(t, instanceLogMatcher("1")(e.text()), "--instance sees JSON attributes")
+
+	assert.Equal(t, junk, parseDaemonLogLine(junk).text())
+	assert.JSONEq(t, `{"msg":"goroutine 1 [running]:"}`, parseDaemonLogLine(junk).json())
+
+	var buf bytes.Buffer
+	w := &lineFilterWriter{w: &buf, render: func(l string) string { return parseDaemonLogLine(l).json() }}
+	w.Write([]byte(obj + "\n" + junk + "\n"))
+	assert.Equal(t, obj+"\n"+`{"msg":"goroutine 1 [running]:"}`+"\n", buf.String())
+}
+
+func TestParseWatchColumns(t *testing.T) {
+	cols, err := parseWatchColumns("id, State,branch")
+	require.NoError(t, err)
+	var names []string
+	for _, c := range cols {
+		names = append(names, c.name)
+	}
+	assert.Equal(t, []string{"id", "state", "branch"}, names)
+
+	_, err = parseWatchColumns("id,uptime")
+	assert.ErrorContains(t, err, `unknown column "uptime"`)
+	_, err = parseWatchColumns("id,id")
+	assert.ErrorContains(t, err, "listed twice")
+	_, err = parseWatchColumns(",")
+	assert.Error(t, err)
+}
+
+func TestRenderWatchTable(t *testing.T) {
+	cols, err := parseWatchColumns("branch,id")
+	require.NoError(t, err)
+	insts := []proto.InstanceInfo{{ID: "3", Branch: "feat/x", State: "EXITED"}}
+	assert.Equal(t, []int{26, 10}, layoutColumns(cols, insts, 38))
+
+	var buf strings.Builder
+	renderWatchTable(&buf, cols, insts, 38, 0, nil)
+	lines := strings.Split(buf.String(), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "BRANCH                      ID", lines[0])
+	assert.Equal(t, "feat/x                      3", lines[2], "last column is not padded")
+}
+
+func TestWriteWatchBannerFitsWidth(t *testing.T) {
+	restoreColorsAfter(t)
+	disableColor()
+	banner := func(width int) []string {
+		var buf strings.Builder
+		writeWatchBanner(&buf, width)
+		return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	}
+
+	wide := banner(200)
+	assert.Contains(t, wide[2], "GandalftheGUI", "trees either side on a wide terminal")
+	for _, width := range []int{200, 80} {
+		for _, l := range banner(width) {
+			assert.LessOrEqual(t, len(l), width)
+		}
+	}
+	assert.NotContains(t, strings.Join(banner(80), "\n"), "GandalftheGUI", "trees dropped when they do not fit")
+	assert.Equal(t, []string{""}, banner(40), "no header at all when the banner alone does not fit")
+}
+
+func TestWatchTracker(t *testing.T) {
+	var tr watchTracker
+	now := time.Unix(1000, 0)
+	insts := []proto.InstanceInfo{{ID: "1", State: "RUNNING"}, {ID: "2", State: "RUNNING"}}
+	assert.Equal(t, 0, tr.observe(insts, now), "the first refresh only records")
+	assert.False(t, tr.highlighted("1", now))
+
+	now = now.Add(time.Second)
+	insts = []proto.InstanceInfo{{ID: "1", State: "WAITING"}, {ID: "2", State: "CRASHED"}, {ID: "3", State: "RUNNING"}}
+	assert.Equal(t, 1, tr.observe(insts, now), "one instance ended")
+	assert.True(t, tr.highlighted("1", now))
+	assert.True(t, tr.highlighted("2", now))
+	assert.False(t, tr.highlighted("3", now), "new instances are not changes")
+
+	now = now.Add(watchHighlightFor)
+	assert.Equal(t, 0, tr.observe(insts, now))
+	assert.False(t, tr.highlighted("1", now), "the highlight fades")
+}
+
+func TestMaskToken(t *testing.T) {
+	token := "sk-ant-oat01-" + strings.Repeat("x", 90) + "Zq9w"
+	masked := maskToken(token)
+	assert.Equal(t, "sk-ant-o…Zq9w", masked)
+	assert.Equal(t, "ab…op", maskToken("abcdefghijklmnop"), "short tokens reveal at most a quarter")
+	assert.Equal(t, "…", maskToken("short"))
+}
+
+func TestThrottleWriter(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Unix(1000, 0)
+	tw := newThrottleWriter(&out, 10, "7")
+	tw.now = func() time.Time { return now }
+
+	tw.Write([]byte("hello\n"))
+	tw.Write([]byte("a\nb\nc\n")) // would exceed 10 bytes this second
+	tw.Write([]byte("d\n"))
+	assert.Equal(t, "hello\n", out.String())
+
+	now = now.Add(time.Second)
+	tw.Write([]byte("next\n"))
+	assert.Equal(t, "hello\n\r\n[grove] throttled 4 lines (8 B); full output: grove logs 7\r\nnext\n", out.String())
+
+	out.Reset()
+	tw.Flush()
+	assert.Empty(t, out.String(), "nothing dropped since the last report")
+}
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]int{"512": 512, "64k": 64 << 10, "1M": 1 << 20} {
+		n, err := parseByteSize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, n, in)
+	}
+	_, err := parseByteSize("fast")
+	assert.Error(t, err)
+}
+
+func TestImportProjects(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("GROVE_ROOT", root)
+	_, err := writeProjectRegistration("taken", "git@example.com:org/other.git")
+	require.NoError(t, err)
+
+	src := t.TempDir()
+	repos := map[string]string{
+		"api":    "git@example.com:org/api.git",
+		"taken":  "git@example.com:org/taken.git",
+		"local":  "",
+		"mirror": "git@example.com:org/other.git",
+	}
+	for name, url := range repos {
+		dir := filepath.Join(src, name)
+		out, err := exec.Command("git", "init", "-q", dir).CombinedOutput()
+		require.NoError(t, err, string(out))
+		if url != "" {
+			out, err := exec.Command("git", "-C", dir, "remote", "add", "origin", url).CombinedOutput()
+			require.NoError(t, err, string(out))
+		}
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(src, "notes"), 0o755))
+
+	results, err := importProjects(src)
+	require.NoError(t, err)
+	got := map[string]string{}
+	for _, r := range results {
+		got[r.name] = r.skipped
+	}
+	assert.Equal(t, map[string]string{
+		"api":    "",
+		"taken":  "already registered",
+		"local":  "no origin remote",
+		"mirror": "repo already registered as taken",
+	}, got)
+
+	entries := loadProjectEntries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, projectEntry{"api", "git@example.com:org/api.git"}, entries[0])
+}
+
+func TestCopyFramedOutput(t *testing.T) {
+	stream := func(result proto.Response, withResult bool) *bytes.Buffer {
+		var b bytes.Buffer
+		proto.WriteFrame(&b, proto.StreamFrameOutput, []byte("$ make test\n"))
+		proto.WriteFrame(&b, proto.StreamFrameOutput, []byte("ok\n"))
+		if withResult {
+			data, _ := json.Marshal(result)
+			proto.WriteFrame(&b, proto.StreamFrameResult, data)
+		}
+		return &b
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, copyFramedOutput(&out, stream(proto.Response{OK: true}, true)))
+	assert.Equal(t, "$ make test\nok\n", out.String())
+
+	err := copyFramedOutput(io.Discard, stream(proto.Response{Error: "1 of 2 checks failed"}, true))
+	assert.EqualError(t, err, "1 of 2 checks failed")
+
+	err = copyFramedOutput(io.Discard, stream(proto.Response{}, false))
+	assert.ErrorContains(t, err, "before a result")
+
+	result, err := readFramedResult(io.Discard, stream(proto.Response{Error: "command exited with status 2", ExitCode: 2}, true))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.ExitCode)
+}
+
+func TestTokenExpiredSince(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("GROVE_ROOT", root)
+	envPath := filepath.Join(root, "env")
+	require.NoError(t, os.WriteFile(envPath, []byte("CLAUDE_CODE_OAUTH_TOKEN=old\n"), 0o600))
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(envPath, past, past))
+
+	_, expired := tokenExpiredSince(envPath)
+	assert.False(t, expired, "no failure recorded")
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "token-expired"), []byte("app\n"), 0o644))
+	_, expired = tokenExpiredSince(envPath)
+	assert.True(t, expired)
+
+	// A token saved after the failure is assumed to be the fix.
+	require.NoError(t, saveToken(envPath, "new"))
+	_, expired = tokenExpiredSince(envPath)
+	assert.False(t, expired)
+	data, err := os.ReadFile(envPath)
+	require.NoError(t, err)
+	assert.Equal(t, "CLAUDE_CODE_OAUTH_TOKEN=new\n", string(data))
+}
+
+func TestEditorCommand(t *testing.T) {
+	t.Setenv("GROVE_EDITOR", "code -n")
+	words, err := editorCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code", "-n"}, words)
+
+	t.Setenv("GROVE_EDITOR", "")
+	t.Setenv("PATH", t.TempDir())
+	_, err = editorCommand()
+	assert.ErrorContains(t, err, "GROVE_EDITOR")
+}
+
+func TestPlayCast(t *testing.T) {
+	cast := `{"version": 2, "width": 80, "height": 24}
+[0.5, "o", "hello "]
+[0.7, "i", "typed"]
+[2.5, "o", "world\r\n"]
+[2.5, "m", ""]
+[3.0, "o", "\u001b[1mbye\u001b[0m"]
+`
+	var out bytes.Buffer
+	var slept []time.Duration
+	require.NoError(t, playCast(strings.NewReader(cast), &out, 2, func(d time.Duration) { slept = append(slept, d) }))
+	assert.Equal(t, "hello world\r\n\x1b[1mbye\x1b[0m", out.String(), "only output events")
+	assert.Equal(t, []time.Duration{250 * time.Millisecond, time.Second, 250 * time.Millisecond}, slept, "gaps divided by speed")
+
+	err := playCast(strings.NewReader(`{"version": 1, "stdout": []}`), io.Discard, 1, func(time.Duration) {})
+	assert.ErrorContains(t, err, "unsupported asciicast version 1")
+	err = playCast(strings.NewReader("{\"version\": 2}\n[1, \"o\"]\n"), io.Discard, 1, func(time.Duration) {})
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestCompletions(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("GROVE_ROOT", root)
+	socketOverride = filepath.Join(root, "no-daemon.sock")
+	t.Cleanup(func() { socketOverride = "" })
+	for _, name := range []string{"web", "api"} {
+		_, err := writeProjectRegistration(name, "")
+		require.NoError(t, err)
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "instances"), 0o755))
+	for _, id := range []string{"2", "1"} {
+		require.NoError(t, os.WriteFile(filepath.Join(root, "instances", id+".json"), []byte("{}"), 0o644))
+	}
+
+	assert.Equal(t, commands, completions(nil))
+	assert.Equal(t, commands, completions([]string{"--no-color"}))
+	assert.Equal(t, []string{"1", "2"}, completions([]string{"--socket", "/tmp/s", "attach"}))
+	assert.Equal(t, []string{"1", "2"}, completions([]string{"attach"}), "read from disk with no daemon")
+	assert.Equal(t, []string{"1", "2"}, completions([]string{"logs", "-f"}))
+	assert.Nil(t, completions([]string{"mv", "1"}))
+	assert.Equal(t, []string{"api", "web"}, completions([]string{"start"}))
+	assert.Nil(t, completions([]string{"start", "web"}))
+	assert.Equal(t, []string{"api", "web"}, completions([]string{"project", "delete"}))
+	assert.Nil(t, completions([]string{"project", "create"}))
+	assert.Equal(t, []string{"api", "web"}, completions([]string{"list", "--project"}))
+	assert.Equal(t, []string{"1", "2"}, completions([]string{"daemon", "logs", "--instance"}))
+	assert.Equal(t, []string{"bash", "zsh", "fish"}, completions([]string{"completion"}))
+}
+
+func TestPrintCheckResults(t *testing.T) {
+	restoreColorsAfter(t)
+	disableColor()
+	targets := []proto.InstanceInfo{
+		{ID: "1", Project: "web", Branch: "fix-login"},
+		{ID: "2", Project: "web", Branch: "main"},
+		{ID: "3", Project: "api", Branch: "feat"},
+	}
+	results := []checkResult{
+		{ran: true},
+		{ran: true, err: errors.New("1 of 2 checks failed")},
+		{err: errors.New("cannot check: instance is CHECKING")},
+	}
+	var buf bytes.Buffer
+	assert.Equal(t, 2, printCheckResults(&buf, targets, results))
+	assert.Equal(t, `
+ID          PROJECT       BRANCH     RESULT   DETAIL
+1           web           fix-login  passed
+2           web           main       FAILED   1 of 2 checks failed
+3           api           feat       skipped  cannot check: instance is CHECKING
+`, buf.String())
+}
+
+func TestPrintStartResults(t *testing.T) {
+	restoreColorsAfter(t)
+	disableColor()
+	results := []startResult{
+		{branch: "feat-a", id: "4"},
+		{branch: "feat-long-name", failed: true},
+		{branch: "b", id: "5"},
+	}
+	var buf bytes.Buffer
+	assert.Equal(t, 1, printStartResults(&buf, results))
+	assert.Equal(t, `
+BRANCH          INSTANCE
+feat-a          4
+feat-long-name  failed
+b               5
+`, buf.String())
+}
+
+func TestExpandAlias(t *testing.T) {
+	aliases := map[string]string{
+		"qf":    "start myproj -d",
+		"fix":   `qf --prompt "fix the flaky test"`,
+		"loopa": "loopb",
+		"loopb": "loopa --x",
+		"list":  "list --wide",
+		"bad":   `start 'unterminated`,
+	}
+
+	args, err := expandAlias([]string{"grove", "qf", "feat-x"}, aliases)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grove", "start", "myproj", "-d", "feat-x"}, args)
+
+	args, err = expandAlias([]string{"grove", "fix", "bug-1"}, aliases)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grove", "start", "myproj", "-d", "--prompt", "fix the flaky test", "bug-1"}, args)
+
+	// Built-in commands win over an alias of the same name.
+	args, err = expandAlias([]string{"grove", "list"}, aliases)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grove", "list"}, args)
+	assert.Equal(t, []string{"list"}, shadowedAliases(aliases))
+
+	args, err = expandAlias([]string{"grove", "nope"}, aliases)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grove", "nope"}, args)
+
+	_, err = expandAlias([]string{"grove", "loopa"}, aliases)
+	assert.EqualError(t, err, "alias loop: loopa -> loopb -> loopa")
+	_, err = expandAlias([]string{"grove", "bad"}, aliases)
+	assert.EqualError(t, err, `alias "bad": unterminated ' quote`)
+}
+
+func TestLocateDaemon(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "grove")
+
+	_, err := locateDaemon(exe)
+	assert.ErrorContains(t, err, "groved not found next to grove ("+dir+") or on PATH")
+	assert.False(t, checkDaemonBinary(exe).ok)
+
+	onPath := filepath.Join(os.Getenv("PATH"), "groved")
+	require.NoError(t, os.WriteFile(onPath, []byte("#!/bin/sh\n"), 0o755))
+	path, err := locateDaemon(exe)
+	require.NoError(t, err)
+	assert.Equal(t, onPath, path)
+
+	// The binary next to grove wins, as ensureDaemon runs that one.
+	beside := filepath.Join(dir, "groved")
+	require.NoError(t, os.WriteFile(beside, []byte("#!/bin/sh\n"), 0o755))
+	assert.Equal(t, doctorCheck{name: "groved", ok: true, detail: beside}, checkDaemonBinary(exe))
+}
+
+func TestCheckToken(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("GROVE_ROOT", root)
+	t.Setenv("HOME", t.TempDir())
+	envPath := filepath.Join(root, "env")
+	require.NoError(t, os.WriteFile(envPath, []byte("CLAUDE_CODE_OAUTH_TOKEN=old\n"), 0o600))
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(envPath, past, past))
+	assert.Equal(t, doctorCheck{name: "token", ok: true, detail: "no rejected token recorded"}, checkToken())
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "token-expired"), []byte("app\n"), 0o644))
+	check := checkToken()
+	assert.True(t, check.ok && check.warn, "a rejected token is a warning")
+	assert.Contains(t, check.detail, "an agent of app was refused the token in "+envPath)
+}
+
+func TestDoctorChecksSkipRemoteHost(t *testing.T) {
+	defer func() { socketOverride = "" }()
+	t.Setenv("GROVE_ROOT", t.TempDir())
+	socketOverride = "tcp:127.0.0.1:1"
+
+	names := map[string]string{}
+	for _, c := range doctorChecks("/nonexistent/grove") {
+		names[c.name] = c.detail
+	}
+	for _, name := range []string{engine.Name(), "disk", "token"} {
+		assert.Contains(t, names[name], "skipped: the daemon at tcp:127.0.0.1:1", name)
+	}
+	assert.NotContains(t, names["daemon"], "skipped")
+}
+
+func TestPrintDoctorChecksWarningsPass(t *testing.T) {
+	var out bytes.Buffer
+	failed := printDoctorChecks(&out, []doctorCheck{
+		{name: "docker", ok: true, detail: "reachable"},
+		{name: "disk", ok: true, warn: true, detail: "only 1.0 GiB free"},
+		{name: "groved", detail: "not found"},
+	})
+	assert.Equal(t, 1, failed, "a low-disk warning is not a failure")
+	assert.Contains(t, out.String(), "!"+colorReset+"  disk     only 1.0 GiB free\n")
+}
+
+func TestRenderWatchTableCompact(t *testing.T) {
+	saved := colorGreen
+	colorGreen = "\033[32m"
+	t.Cleanup(func() { colorGreen = saved })
+
+	insts := []proto.InstanceInfo{{ID: "3", State: "RUNNING"}}
+	var buf strings.Builder
+	renderWatchTable(&buf, compactColumns, insts, 20, 0, nil)
+	lines := strings.Split(buf.String(), "\n")
+	require.Len(t, lines, 4)
+	assert.True(t, utf8.ValidString(lines[2]), "row %q splits a character", lines[2])
+	assert.Equal(t, "3           "+colorGreen+"●"+colorReset, lines[2])
+}
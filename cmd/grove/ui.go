@@ -1,35 +1,201 @@
 package main
 
-import "fmt"
-
-const (
-	colorBold   = "\033[1m"
-	colorDim    = "\033[2m"
-	colorRed    = "\033[31m"
-	colorGreen  = "\033[32m"
-	colorYellow = "\033[33m"
-	colorCyan   = "\033[36m"
-	colorReset  = "\033[0m"
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Color escapes.  These are variables rather than constants so setupColor
+// can blank them when output should be plain.
+var (
+	colorBold    = "\033[1m"
+	colorDim     = "\033[2m"
+	colorReverse = "\033[7m"
+	colorRed     = "\033[31m"
+	colorGreen   = "\033[32m"
+	colorYellow  = "\033[33m"
+	colorCyan    = "\033[36m"
+	colorReset   = "\033[0m"
 )
 
+// globalFlagsEnd returns the index of the first argument after grove's
+// global flags: the subcommand, or len(args).  Global flags only count
+// before the subcommand, so the same words meant for the subcommand or for a
+// command it runs (grove exec 1 ls --no-color) are left alone.
+func globalFlagsEnd(args []string) int {
+	i := 1
+	for i < len(args) && strings.HasPrefix(args[i], "-") {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		switch {
+		case name == "no-color" || name == "batch":
+			i++
+		case name == "socket" && hasValue:
+			i++
+		case name == "socket":
+			i += 2
+		default:
+			// Including "--", which ends the global flags.
+			return i
+		}
+	}
+	return min(i, len(args))
+}
+
+// stripGlobalBoolFlag removes the global flag --name (or -name) from the
+// global flags at the front of args and reports whether it was there.
+func stripGlobalBoolFlag(args []string, name string) ([]string, bool) {
+	end := globalFlagsEnd(args)
+	rest, found := stripBoolFlag(args[:end], name, name)
+	return append(rest, args[end:]...), found
+}
+
+// setupColor strips the global --no-color flag from args and disables colors
+// when it is present, when NO_COLOR is set, or when stdout is not a terminal.
+// FORCE_COLOR keeps colors on for non-terminal output; --no-color and
+// NO_COLOR still take precedence over it.
+func setupColor(args []string) []string {
+	args, noColor := stripGlobalBoolFlag(args, "no-color")
+	if !colorEnabled(noColor, os.Getenv("NO_COLOR") != "", os.Getenv("FORCE_COLOR") != "", term.IsTerminal(int(os.Stdout.Fd()))) {
+		disableColor()
+	}
+	return args
+}
+
+// globalArgs returns the global flags that reproduce this process's daemon
+// address, batch mode and color setting in a child grove process.
+func globalArgs() []string {
+	var args []string
+	if colorReset == "" {
+		args = append(args, "--no-color")
+	}
+	if nonInteractive {
+		args = append(args, "--batch")
+	}
+	if socketOverride != "" {
+		args = append(args, "--socket", socketOverride)
+	}
+	return args
+}
+
+// nonInteractive is set by --batch or GROVE_NONINTERACTIVE.  Prompt sites
+// check it (via refuseIfBatch) and fail or fall back to a default instead of
+// waiting for an answer on stdin.
+var nonInteractive bool
+
+// setupBatch strips the global --batch flag from args and turns on
+// non-interactive mode when it is present or GROVE_NONINTERACTIVE is set to
+// anything other than "" or "0".
+func setupBatch(args []string) []string {
+	args, batch := stripGlobalBoolFlag(args, "batch")
+	env := os.Getenv("GROVE_NONINTERACTIVE")
+	nonInteractive = batch || (env != "" && env != "0")
+	return args
+}
+
+// setupSocket strips the global --socket <path> (or --socket=<path>) flag,
+// given before the subcommand, from args and points grove at that daemon
+// socket instead of the one under the data root.  GROVE_ADDR, then
+// GROVE_SOCKET, is used when the flag is absent.  A "tcp:<host>:<port>"
+// address reaches a daemon started with groved --listen.
+func setupSocket(args []string) []string {
+	socketOverride = os.Getenv("GROVE_SOCKET")
+	if addr := os.Getenv("GROVE_ADDR"); addr != "" {
+		socketOverride = addr
+	}
+	end := globalFlagsEnd(args)
+	out := make([]string, 0, len(args))
+	for i := 0; i < end; i++ {
+		a := args[i]
+		switch {
+		case (a == "--socket" || a == "-socket") && i+1 < len(args):
+			socketOverride = args[i+1]
+			i++
+		case strings.HasPrefix(a, "--socket=") || strings.HasPrefix(a, "-socket="):
+			_, socketOverride, _ = strings.Cut(a, "=")
+		default:
+			out = append(out, a)
+		}
+	}
+	out = append(out, args[end:]...)
+	if network, _ := daemonNetwork(socketOverride); network == "unix" && socketOverride != "" {
+		if abs, err := filepath.Abs(socketOverride); err == nil {
+			socketOverride = abs
+		}
+	}
+	return out
+}
+
+// bannerPrefix starts every line grove itself writes into an attach session
+// or after a start, so that a saved session can be split into grove's
+// messages and the agent's output with grep.
+const bannerPrefix = "[grove] "
+
+// banners is turned off by GROVE_NO_BANNERS; see writeBanner.
+var banners = true
+
+// setupBanners turns banners off when GROVE_NO_BANNERS is set to anything
+// other than "" or "0".
+func setupBanners() {
+	env := os.Getenv("GROVE_NO_BANNERS")
+	banners = env == "" || env == "0"
+}
+
+// writeBanner writes a message as a line of its own starting with
+// bannerPrefix, ended with "\r\n" since a raw terminal does not turn "\n"
+// into one.  Nothing is written when banners are off.
+func writeBanner(w io.Writer, format string, args ...any) {
+	if !banners {
+		return
+	}
+	fmt.Fprintf(w, "\r\n"+bannerPrefix+format+"\r\n", args...)
+}
+
+// refuseIfBatch exits with an error in non-interactive mode, for a prompt
+// that has no safe default.  problem says what grove would have asked about
+// and hint how to supply the answer up front.
+func refuseIfBatch(problem, hint string) {
+	if !nonInteractive {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "grove: %s and prompts are disabled (--batch / GROVE_NONINTERACTIVE); %s\n", problem, hint)
+	os.Exit(1)
+}
+
+// colorEnabled decides whether to emit color escapes.
+func colorEnabled(noColorFlag, noColorEnv, forceColor, stdoutTTY bool) bool {
+	if noColorFlag || noColorEnv {
+		return false
+	}
+	return forceColor || stdoutTTY
+}
+
+func disableColor() {
+	colorBold, colorDim, colorReverse, colorRed, colorGreen, colorYellow, colorCyan, colorReset = "", "", "", "", "", "", "", ""
+}
+
 func colorState(state string) string {
 	switch state {
 	case "RUNNING":
-		return "\033[32m"
+		return colorGreen
 	case "WAITING":
-		return "\033[33m"
+		return colorYellow
 	case "ATTACHED":
-		return "\033[36m"
+		return colorCyan
 	case "CHECKING":
-		return "\033[36m"
+		return colorCyan
 	case "EXITED":
-		return "\033[2m"
+		return colorDim
 	case "CRASHED":
-		return "\033[31m"
+		return colorRed
 	case "KILLED":
-		return "\033[33m"
+		return colorYellow
 	case "FINISHED":
-		return "\033[2m"
+		return colorDim
 	default:
 		return ""
 	}
@@ -48,15 +214,19 @@ func formatUptime(secs int64) string {
 	return fmt.Sprintf("%dh%02dm", secs/3600, (secs%3600)/60)
 }
 
+// truncate shortens s to n characters, ending in "..." when there is room.
+// It counts runes, so a cell never ends in half a character such as the
+// compact view's "●".
 func truncate(s string, n int) string {
 	if n <= 0 {
 		return ""
 	}
-	if len(s) <= n {
+	r := []rune(s)
+	if len(r) <= n {
 		return s
 	}
 	if n <= 3 {
-		return s[:n]
+		return string(r[:n])
 	}
-	return s[:n-3] + "..."
+	return string(r[:n-3]) + "..."
 }
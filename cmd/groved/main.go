@@ -2,11 +2,15 @@
 //
 // Usage:
 //
-//	groved [--root <dir>]
+//	groved [--root <dir>] [--socket <path>] [--listen tcp:<host>:<port>]
 //
-// The daemon listens on a Unix domain socket at <root>/groved.sock and
-// handles commands from the grove CLI.  It is normally started automatically
-// by grove; you do not need to run it by hand.
+// The daemon listens on a Unix domain socket at <root>/groved.sock (or
+// --socket) and handles commands from the grove CLI.  It is normally started
+// automatically by grove; you do not need to run it by hand.
+//
+// --listen also accepts connections on TCP, for driving a daemon in a
+// container or VM from the host (client side: GROVE_ADDR).  Requests on it
+// must carry GROVE_DAEMON_TOKEN, taken from the environment or <root>/env.
 package main
 
 import (
@@ -15,9 +19,12 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/gandalfthegui/grove/internal/daemon"
+	"github.com/gandalfthegui/grove/internal/envfile"
+	"github.com/gandalfthegui/grove/internal/proto"
 )
 
 func main() {
@@ -31,8 +38,19 @@ func main() {
 	}
 
 	rootDir := flag.String("root", defaultRoot, "groved data directory (env: GROVE_ROOT)")
+	socketFlag := flag.String("socket", "", "Unix socket to listen on (default: <root>/groved.sock)")
+	listenFlag := flag.String("listen", "", "also listen on TCP, as tcp:<host>:<port> (requests need GROVE_DAEMON_TOKEN)")
 	flag.Parse()
 
+	var tcpAddr string
+	if *listenFlag != "" {
+		addr, ok := strings.CutPrefix(*listenFlag, "tcp:")
+		if !ok {
+			log.Fatalf("--listen %q: only tcp:<host>:<port> is supported", *listenFlag)
+		}
+		tcpAddr = addr
+	}
+
 	d, err := daemon.New(*rootDir)
 	if err != nil {
 		log.Printf("daemon init: %v", err)
@@ -44,6 +62,9 @@ func main() {
 	}
 
 	socketPath := filepath.Join(*rootDir, "groved.sock")
+	if *socketFlag != "" {
+		socketPath = *socketFlag
+	}
 
 	// Graceful shutdown on SIGINT / SIGTERM.
 	sigCh := make(chan os.Signal, 1)
@@ -55,6 +76,18 @@ func main() {
 		os.Exit(0)
 	}()
 
+	if tcpAddr != "" {
+		token := os.Getenv(proto.DaemonTokenVar)
+		if token == "" {
+			token = envfile.Load(filepath.Join(*rootDir, "env"))[proto.DaemonTokenVar]
+		}
+		go func() {
+			if err := d.RunTCP(tcpAddr, token); err != nil {
+				log.Fatalf("daemon run: %v", err)
+			}
+		}()
+	}
+
 	if err := d.Run(socketPath); err != nil {
 		log.Fatalf("daemon run: %v", err)
 	}
@@ -1,22 +1,37 @@
 package daemon
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/engine"
+	"github.com/gandalfthegui/grove/internal/registration"
+	"gopkg.in/yaml.v3"
 )
 
-// validateDocker checks that Docker is available by running "docker info".
+// validateDocker checks that Docker, or the engine GROVE_CONTAINER_RUNTIME
+// names, is available by running "docker info".
 func validateDocker() error {
-	cmd := exec.Command("docker", "info")
+	cmd := engine.Command("info")
 	cmd.Stdout = io.Discard
 	cmd.Stderr = io.Discard
 	if err := cmd.Run(); err != nil {
+		if name := engine.Name(); name != engine.Default {
+			return fmt.Errorf("%s is not available (%w)", name, err)
+		}
 		return fmt.Errorf("docker is not available (%w)\nInstall Docker: https://docs.docker.com/get-docker/", err)
 	}
 	return nil
@@ -29,15 +44,123 @@ func startContainer(p *Project, instanceID, worktreeDir string, w io.Writer) (st
 		return startComposeContainer(p, instanceID, worktreeDir, w)
 	}
 	if p.Container.Image == "" {
-		groveYAML := filepath.Join(p.MainDir(), "grove.yaml")
-		return "", fmt.Errorf("no container configured in %s\nadd a 'container:' section, e.g.:\n\n  container:\n    image: ubuntu:24.04\n", groveYAML)
+		return "", noContainerError(p)
 	}
 	return startSingleContainer(p, instanceID, worktreeDir, w)
 }
 
+// dockerUnavailableMarkers are fragments of what docker or podman prints,
+// and of the error exec gives, when there is no engine to talk to.
+var dockerUnavailableMarkers = []string{
+	"cannot connect to the docker daemon",
+	"is the docker daemon running",
+	`"docker": executable file not found`,
+	"cannot connect to podman",
+	`"podman": executable file not found`,
+}
+
+// dockerUnavailable reports whether out, the error or output of a failed
+// docker command, says there was no engine to run it.
+func dockerUnavailable(out string) bool {
+	lower := strings.ToLower(out)
+	for _, m := range dockerUnavailableMarkers {
+		if strings.Contains(lower, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// noContainerError is the error for a grove.yaml without a container.
+func noContainerError(p *Project) error {
+	return fmt.Errorf("no container configured in %s\nadd a 'container:' section, e.g.:\n\n  container:\n    image: ubuntu:24.04\n", p.inRepoConfigPath())
+}
+
+// warmImages pulls the image grove.yaml names, or with compose every image
+// of the compose file (relative to the main checkout), writing docker's
+// progress to w.
+func warmImages(p *Project, w io.Writer) error {
+	var cmd *exec.Cmd
+	switch {
+	case p.Container.Compose != "":
+		fmt.Fprintf(w, "Pulling the images of %s …\n", p.Container.Compose)
+		cmd = engine.Command("compose", "-f", p.Container.Compose, "pull")
+		cmd.Dir = p.MainDir()
+	case p.Container.Image != "":
+		fmt.Fprintf(w, "Pulling %s …\n", p.Container.Image)
+		cmd = engine.Command("pull", p.Container.Image)
+	default:
+		return noContainerError(p)
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", strings.Join(cmd.Args, " "), err)
+	}
+	if p.Container.Compose != "" {
+		fmt.Fprintf(w, "images of %s are ready\n", p.Container.Compose)
+	} else {
+		fmt.Fprintf(w, "%s is ready\n", p.Container.Image)
+	}
+	return nil
+}
+
+// imageWorkdirs caches the working directory of each image inspected by
+// detectWorkdir for the life of the daemon.
+var (
+	imageWorkdirsMu sync.Mutex
+	imageWorkdirs   = map[string]string{}
+)
+
+// detectWorkdir sets p's workdir to the image's configured WorkingDir when
+// grove.yaml names none, so the worktree is mounted where the image expects
+// its code (e.g. /usr/src/app for node).  Images with none, or with "/",
+// keep the /app default.  An image not present locally is pulled first, as
+// docker run would; if that fails the default is kept and docker run reports
+// the problem.  Compose projects are left alone.
+func detectWorkdir(p *Project, w io.Writer) {
+	if p.Container.Workdir != "" || p.Container.Compose != "" || p.Container.Image == "" {
+		return
+	}
+	image := p.Container.Image
+
+	imageWorkdirsMu.Lock()
+	dir, ok := imageWorkdirs[image]
+	imageWorkdirsMu.Unlock()
+	if !ok {
+		var err error
+		dir, err = inspectImageWorkdir(image)
+		if err != nil {
+			fmt.Fprintf(w, "Pulling %s to read its working directory …\n", image)
+			pull := engine.Command("pull", "-q", image)
+			pull.Stdout = w
+			pull.Stderr = w
+			if pull.Run() == nil {
+				dir, err = inspectImageWorkdir(image)
+			}
+		}
+		if err != nil {
+			return
+		}
+		imageWorkdirsMu.Lock()
+		imageWorkdirs[image] = dir
+		imageWorkdirsMu.Unlock()
+	}
+	if dir != "" && dir != "/" {
+		p.imageWorkdir = dir
+		fmt.Fprintf(w, "Using the image's working directory %s (set container.workdir to override)\n", dir)
+	}
+}
+
+// inspectImageWorkdir returns the WorkingDir configured in a local image.
+func inspectImageWorkdir(image string) (string, error) {
+	out, err := engine.Command("image", "inspect", "--format", "{{.Config.WorkingDir}}", image).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
 // startSingleContainer runs:
 //
-//	docker run -d --name grove-<id> -v <worktreeDir>:<workdir> -w <workdir> [mounts...] <image> sleep infinity
+//	docker run -d --name grove-<id> -v <worktreeDir>:<workdir> -w <workdir>[/<subdir>] [mounts...] <image> sleep infinity
 func startSingleContainer(p *Project, instanceID, worktreeDir string, w io.Writer) (string, error) {
 	name := "grove-" + instanceID
 	workdir := p.containerWorkdir()
@@ -46,25 +169,57 @@ func startSingleContainer(p *Project, instanceID, worktreeDir string, w io.Write
 	args := []string{"run", "-d",
 		"--name", name,
 		"-v", worktreeDir + ":" + workdir,
-		"-w", workdir,
+		"-w", p.execDir(),
 	}
 	for _, m := range buildMounts(p, w) {
-		args = append(args, "-v", m[0]+":"+m[1])
+		args = append(args, "-v", m.volumeArg())
+	}
+	// Anonymous volumes layered after the worktree mount shadow hidden
+	// subpaths, giving the container its own copy (e.g. node_modules).
+	for _, h := range hiddenPaths(p, w) {
+		args = append(args, "-v", h)
+	}
+	if p.Container.MemorySwap != "" && p.Container.Memory == "" {
+		return "", fmt.Errorf("container.memory_swap needs container.memory to be set too")
+	}
+	if p.Container.Memory != "" {
+		args = append(args, "--memory", p.Container.Memory)
+	}
+	if p.Container.MemorySwap != "" {
+		args = append(args, "--memory-swap", p.Container.MemorySwap)
 	}
 	args = append(args, image, "sleep", "infinity")
 
 	fmt.Fprintf(w, "Starting container %s (image: %s) …\n", name, image)
-	cmd := exec.Command("docker", args...)
+	cmd := engine.Command(args...)
 	out, err := cmd.CombinedOutput()
 	if len(out) > 0 {
 		w.Write(out)
 	}
 	if err != nil {
-		return "", fmt.Errorf("docker run: %w", err)
+		return "", withContainerLogs(fmt.Errorf("docker run: %w", err), "docker logs "+name,
+			"logs", "--tail", containerLogTail, name)
 	}
 	return name, nil
 }
 
+// containerLogTail is how many lines of a failed container's own output
+// are added to the start error.
+const containerLogTail = "50"
+
+// withContainerLogs adds to err what the container printed before it died,
+// read with "docker <logsArgs...>".  The docker CLI error alone rarely
+// says why (an image entrypoint that crashed, say).  Best-effort: if the
+// container was never created there are no logs and err is returned as is.
+func withContainerLogs(err error, label string, logsArgs ...string) error {
+	out, lerr := engine.Command(logsArgs...).CombinedOutput()
+	logs := strings.TrimSpace(string(out))
+	if lerr != nil || logs == "" {
+		return err
+	}
+	return fmt.Errorf("%w\ncontainer output (%s):\n%s", err, label, logs)
+}
+
 // startComposeContainer writes a temporary override YAML that bind-mounts the
 // worktree (and any extra mounts) into the app service, then runs:
 //
@@ -74,22 +229,19 @@ func startSingleContainer(p *Project, instanceID, worktreeDir string, w io.Write
 func startComposeContainer(p *Project, instanceID, worktreeDir string, w io.Writer) (string, error) {
 	project := "grove-" + instanceID
 	service := p.containerService()
-	workdir := p.containerWorkdir()
 	composeFile := p.Container.Compose
 
-	// Build the volumes block: worktree first, then any extra mounts.
-	volumes := fmt.Sprintf("      - type: bind\n        source: %s\n        target: %s\n", worktreeDir, workdir)
-	for _, m := range buildMounts(p, w) {
-		volumes += fmt.Sprintf("      - type: bind\n        source: %s\n        target: %s\n", m[0], m[1])
+	overrideContent, err := composeOverride(p, worktreeDir, w)
+	if err != nil {
+		return "", fmt.Errorf("build compose override: %w", err)
 	}
-	overrideContent := fmt.Sprintf("services:\n  %s:\n    volumes:\n%s", service, volumes)
 
 	overrideFile, err := os.CreateTemp("", "grove-compose-override-*.yml")
 	if err != nil {
 		return "", fmt.Errorf("create compose override: %w", err)
 	}
 	overridePath := overrideFile.Name()
-	if _, err := overrideFile.WriteString(overrideContent); err != nil {
+	if _, err := overrideFile.Write(overrideContent); err != nil {
 		overrideFile.Close()
 		os.Remove(overridePath)
 		return "", fmt.Errorf("write compose override: %w", err)
@@ -98,7 +250,7 @@ func startComposeContainer(p *Project, instanceID, worktreeDir string, w io.Writ
 	defer os.Remove(overridePath)
 
 	fmt.Fprintf(w, "Starting compose stack %s (compose: %s, service: %s) …\n", project, composeFile, service)
-	cmd := exec.Command("docker", "compose",
+	cmd := engine.Command("compose",
 		"-p", project,
 		"-f", composeFile,
 		"-f", overridePath,
@@ -107,43 +259,157 @@ func startComposeContainer(p *Project, instanceID, worktreeDir string, w io.Writ
 	cmd.Stdout = w
 	cmd.Stderr = w
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("docker compose up: %w", err)
+		return "", withContainerLogs(fmt.Errorf("docker compose up: %w", err), "docker compose -p "+project+" logs",
+			"compose", "-p", project, "-f", composeFile, "-f", overridePath,
+			"logs", "--no-color", "--tail", containerLogTail)
 	}
 
 	// Exec target: "grove-<id>-<service>-1"
 	return project + "-" + service + "-1", nil
 }
 
+// composeOverride renders the override file layered on top of the project's
+// compose file.  It starts from the user's container.compose_override fragment
+// and merges in grove's volumes for the exec service: the worktree first, then
+// extra mounts, then hidden paths.
+func composeOverride(p *Project, worktreeDir string, w io.Writer) ([]byte, error) {
+	volumes := []any{
+		map[string]any{"type": "bind", "source": worktreeDir, "target": p.containerWorkdir()},
+	}
+	for _, m := range buildMounts(p, w) {
+		v := map[string]any{"type": "bind", "source": m.source, "target": m.target}
+		if m.readOnly {
+			v["read_only"] = true
+		}
+		volumes = append(volumes, v)
+	}
+	for _, h := range hiddenPaths(p, w) {
+		volumes = append(volumes, map[string]any{"type": "volume", "target": h})
+	}
+
+	service := map[string]any{"volumes": volumes}
+	if p.Subdir != "" {
+		service["working_dir"] = p.execDir()
+	}
+	if p.Container.Memory != "" {
+		service["mem_limit"] = p.Container.Memory
+	}
+	if p.Container.MemorySwap != "" {
+		service["memswap_limit"] = p.Container.MemorySwap
+	}
+
+	doc := map[string]any{}
+	mergeCompose(doc, p.Container.ComposeOverride)
+	mergeCompose(doc, map[string]any{
+		"services": map[string]any{
+			p.containerService(): service,
+		},
+	})
+	return yaml.Marshal(doc)
+}
+
+// mergeCompose merges src into dst.  Nested mappings merge key by key, lists
+// are concatenated (dst's entries first), and any other src value replaces
+// dst's.  src is never aliased by dst.
+func mergeCompose(dst, src map[string]any) {
+	for k, v := range src {
+		switch sv := v.(type) {
+		case map[string]any:
+			dv, ok := dst[k].(map[string]any)
+			if !ok {
+				dv = map[string]any{}
+				dst[k] = dv
+			}
+			mergeCompose(dv, sv)
+		case []any:
+			dv, _ := dst[k].([]any)
+			dst[k] = append(append([]any(nil), dv...), sv...)
+		default:
+			dst[k] = v
+		}
+	}
+}
+
 // stopContainer tears down the container or compose stack for an instance.
 // If composeProject is non-empty, tears down the compose stack; otherwise
 // stops and removes the single container.
 func stopContainer(containerName, composeProject string) {
 	if composeProject != "" {
-		exec.Command("docker", "compose", "-p", composeProject, "down", "-v").Run()
+		engine.Command("compose", "-p", composeProject, "down", "-v").Run()
 		return
 	}
-	exec.Command("docker", "stop", containerName).Run()
-	exec.Command("docker", "rm", containerName).Run()
+	engine.Command("stop", containerName).Run()
+	// -v also removes anonymous volumes created for container.hide paths.
+	engine.Command("rm", "-v", containerName).Run()
 }
 
-// execInContainer runs cmd inside the named container using "docker exec".
-func execInContainer(containerName, cmd string, w io.Writer) error {
-	c := exec.Command("docker", "exec", containerName, "sh", "-c", cmd)
-	c.Stdout = w
-	c.Stderr = w
+// oomExitCode is the status of a process killed with SIGKILL, which is how
+// the kernel's OOM killer ends it.
+const oomExitCode = 137
+
+// oomKilled reports whether docker recorded an out-of-memory kill in the
+// container.  The flag stays set for the container's life, so callers
+// only trust it for a process that itself died of SIGKILL.
+func oomKilled(containerName string) bool {
+	out, err := engine.Command("inspect", "--format", "{{.State.OOMKilled}}", containerName).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// execInContainer runs cmd inside the named container using "docker exec",
+// with env (grove.yaml's env: block) set for it.
+func execInContainer(ctx context.Context, containerName, cmd string, env map[string]string, w io.Writer) error {
+	return execInContainerSplit(ctx, containerName, cmd, env, w, w)
+}
+
+// execInContainerSplit is execInContainer with the command's stdout and
+// stderr sent to separate writers.
+func execInContainerSplit(ctx context.Context, containerName, cmd string, env map[string]string, stdout, stderr io.Writer) error {
+	args := append([]string{"exec"}, envArgs(env)...)
+	args = append(args, containerName, "sh", "-c", cmd)
+	if deadline, ok := ctx.Deadline(); ok {
+		// Killing docker exec leaves the command running in the container,
+		// so it is also run under the container's timeout, where there is one.
+		secs := max(1, int(math.Ceil(time.Until(deadline).Seconds())))
+		args = append(append([]string{"exec"}, envArgs(env)...), containerName, "sh", "-c",
+			`if command -v timeout >/dev/null 2>&1; then exec timeout -s KILL "$0" sh -c "$1"; fi; exec sh -c "$1"`,
+			strconv.Itoa(secs), cmd)
+	}
+	c := engine.CommandContext(ctx, args...)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	// Don't wait on output from anything the killed command left behind.
+	c.WaitDelay = time.Second
 	if err := c.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("exec in container %s: %w", containerName, ctx.Err())
+		}
 		return fmt.Errorf("exec in container %s: %w", containerName, err)
 	}
 	return nil
 }
 
+// envArgs returns "-e KEY=value" docker exec arguments for env, sorted by
+// key so the command line is the same from run to run.
+func envArgs(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		args = append(args, "-e", k+"="+env[k])
+	}
+	return args
+}
+
 // ensureAgentInstalled checks whether agentCmd is present in the container and,
 // if not, attempts to install it automatically for known agents.
 // All output (install progress, errors) is written to w so it appears in the
 // instance log and in the user's terminal during "grove start".
 func ensureAgentInstalled(agentCmd, containerName string, w io.Writer) error {
 	// Fast path: agent already installed.
-	check := exec.Command("docker", "exec", containerName,
+	check := engine.Command("exec", containerName,
 		"sh", "-c", "command -v "+agentCmd+" >/dev/null 2>&1")
 	if check.Run() == nil {
 		return nil
@@ -201,7 +467,7 @@ pip install aider-chat 2>/dev/null || pip3 install aider-chat`
 	}
 
 	fmt.Fprintf(w, "Agent %q not found — auto-installing (this runs once per container)…\n", agentCmd)
-	c := exec.Command("docker", "exec", containerName, "sh", "-c", installScript)
+	c := engine.Command("exec", containerName, "sh", "-c", installScript)
 	c.Stdout = w
 	c.Stderr = w
 	if err := c.Run(); err != nil {
@@ -211,7 +477,7 @@ pip install aider-chat 2>/dev/null || pip3 install aider-chat`
 	}
 
 	// Verify the install actually made the binary available.
-	verify := exec.Command("docker", "exec", containerName,
+	verify := engine.Command("exec", containerName,
 		"sh", "-c", "command -v "+agentCmd+" >/dev/null 2>&1")
 	if err := verify.Run(); err != nil {
 		return fmt.Errorf("auto-install of %q appeared to succeed but the command is still not in PATH\n"+
@@ -223,55 +489,116 @@ pip install aider-chat 2>/dev/null || pip3 install aider-chat`
 	return nil
 }
 
-// buildMounts returns all (source, target) mount pairs for the container:
-// auto-detected agent credentials followed by user-configured mounts.
+// mount is a host path bind-mounted into the container.
+type mount struct {
+	source   string
+	target   string
+	readOnly bool
+}
+
+// volumeArg renders m as a "docker run -v" value.
+func (m mount) volumeArg() string {
+	if m.readOnly {
+		return m.source + ":" + m.target + ":ro"
+	}
+	return m.source + ":" + m.target
+}
+
+// buildMounts returns all mounts for the container: auto-detected agent
+// credentials, the host's ~/.gitconfig, then user-configured mounts.
 // Each applied mount is logged to w. User-configured paths that don't exist
-// on the host produce a warning; missing credential dirs are silently skipped
-// (the agent may not be installed yet).
-func buildMounts(p *Project, w io.Writer) [][2]string {
+// on the host produce a warning; missing credential dirs and a missing
+// ~/.gitconfig are silently skipped (the agent may not be installed yet).
+func buildMounts(p *Project, w io.Writer) []mount {
 	home, _ := os.UserHomeDir()
-	var mounts [][2]string
+	var mounts []mount
 
 	// Auto-mount credentials for known agents.
-	for _, pair := range agentCredentialMounts(p.Agent.Command, home) {
+	for _, pair := range agentCredentialMounts(p.Agent.Command, home, p.Credentials.Dir) {
 		if _, err := os.Stat(pair[0]); err == nil {
 			fmt.Fprintf(w, "Mounting credentials: %s → %s\n", pair[0], pair[1])
-			mounts = append(mounts, pair)
+			mounts = append(mounts, mount{source: pair[0], target: pair[1]})
 		}
 	}
 
 	// User-configured extra mounts from grove.yaml.
+	var userMounts []mount
 	for _, m := range p.Container.Mounts {
 		src, tgt := resolveMountPath(m, home)
 		if _, err := os.Stat(src); err == nil {
-			fmt.Fprintf(w, "Mounting: %s → %s\n", src, tgt)
-			mounts = append(mounts, [2]string{src, tgt})
+			userMounts = append(userMounts, mount{source: src, target: tgt})
 		} else {
 			fmt.Fprintf(w, "Warning: skipping mount %q — path not found on host\n", m)
 		}
 	}
 
+	// Mount ~/.gitconfig read-only so commits made inside the container carry
+	// the user's identity, unless disabled or already listed under mounts:.
+	if p.mountGitconfig() {
+		src, tgt := filepath.Join(home, ".gitconfig"), "/root/.gitconfig"
+		if _, err := os.Stat(src); err == nil && !hasMountTarget(userMounts, tgt) {
+			fmt.Fprintf(w, "Mounting git identity: %s → %s (read-only)\n", src, tgt)
+			mounts = append(mounts, mount{source: src, target: tgt, readOnly: true})
+		}
+	}
+
+	for _, m := range userMounts {
+		fmt.Fprintf(w, "Mounting: %s → %s\n", m.source, m.target)
+		mounts = append(mounts, m)
+	}
+
 	return mounts
 }
 
+// hiddenPaths returns the absolute in-container paths listed under
+// container.hide, resolved against the directory commands run in (see
+// execDir).  Entries that are absolute or escape it are skipped with a
+// warning to w.
+func hiddenPaths(p *Project, w io.Writer) []string {
+	var out []string
+	for _, h := range p.Container.Hide {
+		rel := path.Clean(h)
+		if path.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			fmt.Fprintf(w, "Warning: skipping hide %q — must be a path inside the worktree\n", h)
+			continue
+		}
+		out = append(out, path.Join(p.execDir(), rel))
+	}
+	return out
+}
+
+// hasMountTarget reports whether any mount in mounts targets path.
+func hasMountTarget(mounts []mount, path string) bool {
+	for _, m := range mounts {
+		if m.target == path {
+			return true
+		}
+	}
+	return false
+}
+
 // agentCredentialMounts returns (source, target) pairs for known agent CLIs.
+// credDir, when non-empty, replaces the default host directory (a project's
+// credentials.dir override); the container-side target is unchanged.
 //
 // Note: ~/.claude.json is deliberately NOT bind-mounted for Claude because the
 // host's Claude Code and the container's Claude Code both write to it
 // frequently, causing file corruption. Instead, seedClaudeConfig copies a
 // snapshot into the container after creation.
-func agentCredentialMounts(agentCmd, home string) [][2]string {
+func agentCredentialMounts(agentCmd, home, credDir string) [][2]string {
+	var pair [2]string
 	switch agentCmd {
 	case "claude":
-		return [][2]string{
-			{filepath.Join(home, ".claude"), "/root/.claude"},
-		}
+		pair = [2]string{filepath.Join(home, ".claude"), "/root/.claude"}
 	case "aider":
-		return [][2]string{
-			{filepath.Join(home, ".aider"), "/root/.aider"},
-		}
+		pair = [2]string{filepath.Join(home, ".aider"), "/root/.aider"}
+	default:
+		return nil
 	}
-	return nil
+	if credDir != "" {
+		pair[0] = registration.ExpandHome(credDir, home)
+	}
+	return [][2]string{pair}
 }
 
 // seedClaudeConfig copies the host's ~/.claude.json into the container so
@@ -294,7 +621,7 @@ func seedClaudeConfig(containerName string) {
 		return
 	}
 
-	cmd := exec.Command("docker", "cp", src, containerName+":/root/.claude.json")
+	cmd := engine.Command("cp", src, containerName+":/root/.claude.json")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		log.Printf("seedClaudeConfig: docker cp failed: %v: %s", err, out)
 	}
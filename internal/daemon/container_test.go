@@ -0,0 +1,241 @@
+package daemon
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestResolveMountPath(t *testing.T) {
+	cases := []struct {
+		in, src, tgt string
+	}{
+		{"~", "/home/u", "/root"},
+		{"~/.ssh", "/home/u/.ssh", "/root/.ssh"},
+		{"/opt/data", "/opt/data", "/opt/data"},
+	}
+	for _, tc := range cases {
+		src, tgt := resolveMountPath(tc.in, "/home/u")
+		assert.Equal(t, tc.src, src, "source for %q", tc.in)
+		assert.Equal(t, tc.tgt, tgt, "target for %q", tc.in)
+	}
+}
+
+func TestBuildMountsGitconfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[user]\n"), 0o644))
+
+	p := &Project{}
+	mounts := buildMounts(p, io.Discard)
+	require.Len(t, mounts, 1)
+	assert.Equal(t, mount{source: filepath.Join(home, ".gitconfig"), target: "/root/.gitconfig", readOnly: true}, mounts[0])
+	assert.Equal(t, filepath.Join(home, ".gitconfig")+":/root/.gitconfig:ro", mounts[0].volumeArg())
+
+	off := false
+	p.Container.Gitconfig = &off
+	assert.Empty(t, buildMounts(p, io.Discard), "gitconfig: false disables the mount")
+}
+
+func TestBuildMountsGitconfigUserMountWins(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[user]\n"), 0o644))
+
+	p := &Project{}
+	p.Container.Mounts = []string{"~/.gitconfig"}
+	mounts := buildMounts(p, io.Discard)
+	require.Len(t, mounts, 1, "explicit mount must not be duplicated")
+	assert.False(t, mounts[0].readOnly)
+}
+
+func TestBuildMountsNoGitconfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	assert.Empty(t, buildMounts(&Project{}, io.Discard))
+}
+
+func TestHiddenPaths(t *testing.T) {
+	p := &Project{}
+	p.Container.Workdir = "/app"
+	p.Container.Hide = []string{"node_modules", "./.venv/", "web/dist", "/etc", "../up", "."}
+
+	assert.Equal(t, []string{"/app/node_modules", "/app/.venv", "/app/web/dist"}, hiddenPaths(p, io.Discard))
+}
+
+func TestComposeOverrideMergesUserFragment(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var fragment map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(`
+services:
+  app:
+    depends_on: [migrate]
+    volumes:
+      - cache:/cache
+  migrate:
+    command: rake db:migrate
+volumes:
+  cache: {}
+`), &fragment))
+	p := &Project{Container: ContainerConfig{ComposeOverride: fragment}}
+
+	out, err := composeOverride(p, "/wt/1", io.Discard)
+	require.NoError(t, err)
+
+	var doc struct {
+		Services map[string]struct {
+			DependsOn []string `yaml:"depends_on"`
+			Command   string   `yaml:"command"`
+			Volumes   []any    `yaml:"volumes"`
+		} `yaml:"services"`
+		Volumes map[string]any `yaml:"volumes"`
+	}
+	require.NoError(t, yaml.Unmarshal(out, &doc))
+	app := doc.Services["app"]
+	assert.Equal(t, []string{"migrate"}, app.DependsOn)
+	require.Len(t, app.Volumes, 2, "user volumes are kept and grove's are appended")
+	assert.Equal(t, "cache:/cache", app.Volumes[0])
+	assert.Equal(t, map[string]any{"type": "bind", "source": "/wt/1", "target": "/app"}, app.Volumes[1])
+	assert.Equal(t, "rake db:migrate", doc.Services["migrate"].Command)
+	assert.Contains(t, doc.Volumes, "cache")
+
+	assert.Len(t, fragment["services"].(map[string]any)["app"].(map[string]any)["volumes"], 1, "config fragment must not be mutated")
+}
+
+func TestDockerUnavailable(t *testing.T) {
+	assert.True(t, dockerUnavailable("docker run: exit status 125\nCannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?"))
+	assert.True(t, dockerUnavailable("Cannot connect to Podman. Please verify your connection to the Linux system"))
+	assert.False(t, dockerUnavailable("Unable to find image 'nope:latest' locally\ndocker: Error response from daemon: pull access denied for nope"))
+}
+
+func TestDetectWorkdir(t *testing.T) {
+	// A fake docker that reports a WorkingDir and counts its invocations.
+	calls := fakeDocker(t, "echo \"$@\" >> \"$CALLS\"\necho /usr/src/app\n")
+
+	imageWorkdirsMu.Lock()
+	imageWorkdirs = map[string]string{}
+	imageWorkdirsMu.Unlock()
+
+	p := &Project{}
+	p.Container.Image = "node:20"
+	detectWorkdir(p, io.Discard)
+	assert.Equal(t, "/usr/src/app", p.containerWorkdir())
+
+	// A second project on the same image uses the cached answer.
+	p2 := &Project{}
+	p2.Container.Image = "node:20"
+	detectWorkdir(p2, io.Discard)
+	assert.Equal(t, "/usr/src/app", p2.containerWorkdir())
+	out, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "image inspect --format {{.Config.WorkingDir}} node:20\n", string(out))
+
+	// An explicit workdir is never overridden.
+	p3 := &Project{}
+	p3.Container.Image = "node:20"
+	p3.Container.Workdir = "/src"
+	detectWorkdir(p3, io.Discard)
+	assert.Equal(t, "/src", p3.containerWorkdir())
+}
+
+func TestDetectWorkdirRootFallsBackToApp(t *testing.T) {
+	imageWorkdirsMu.Lock()
+	imageWorkdirs = map[string]string{"busybox": "/"}
+	imageWorkdirsMu.Unlock()
+
+	p := &Project{}
+	p.Container.Image = "busybox"
+	detectWorkdir(p, io.Discard)
+	assert.Equal(t, "/app", p.containerWorkdir())
+}
+
+func TestComposeOverrideMemoryLimits(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	p := &Project{Container: ContainerConfig{Memory: "4g", MemorySwap: "6g"}}
+	out, err := composeOverride(p, "/wt/1", io.Discard)
+	require.NoError(t, err)
+
+	var doc struct {
+		Services map[string]map[string]any `yaml:"services"`
+	}
+	require.NoError(t, yaml.Unmarshal(out, &doc))
+	assert.Equal(t, "4g", doc.Services["app"]["mem_limit"])
+	assert.Equal(t, "6g", doc.Services["app"]["memswap_limit"])
+}
+
+func TestStartFailureIncludesContainerLogs(t *testing.T) {
+	// A fake docker whose run fails and whose container left output behind.
+	fakeDocker(t, `case "$1" in
+run) echo "docker: container exited" >&2; exit 125 ;;
+logs) echo "entrypoint.sh: line 3: exec: foo: not found" ;;
+esac
+`)
+	t.Setenv("HOME", t.TempDir())
+
+	p := &Project{Container: ContainerConfig{Image: "broken"}}
+	_, err := startSingleContainer(p, "1", t.TempDir(), io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker run: exit status 125")
+	assert.Contains(t, err.Error(), "container output (docker logs grove-1):\nentrypoint.sh: line 3: exec: foo: not found")
+
+	// A container that was never created has no logs to add.
+	fakeDocker(t, "exit 1\n")
+	_, err = startSingleContainer(p, "1", t.TempDir(), io.Discard)
+	require.Error(t, err)
+	assert.Equal(t, "docker run: exit status 1", err.Error())
+}
+
+func TestWarmImages(t *testing.T) {
+	// A fake docker that records what it is asked to do, and where.
+	calls := fakeDocker(t, "echo \"$(pwd) $@\" >> \"$CALLS\"\n[ \"$2\" != missing ]\n")
+
+	dataDir := t.TempDir()
+	main := filepath.Join(dataDir, "main")
+	require.NoError(t, os.MkdirAll(main, 0o755))
+
+	var out strings.Builder
+	p := &Project{Name: "app", DataDir: dataDir, Container: ContainerConfig{Image: "node:20"}}
+	require.NoError(t, warmImages(p, &out))
+	assert.Contains(t, out.String(), "node:20 is ready")
+
+	p.Container = ContainerConfig{Compose: "docker-compose.yml"}
+	require.NoError(t, warmImages(p, &out))
+
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], " pull node:20"))
+	assert.Equal(t, main+" compose -f docker-compose.yml pull", lines[1], "compose file is relative to the main checkout")
+
+	p.Container = ContainerConfig{Image: "missing"}
+	assert.EqualError(t, warmImages(p, io.Discard), "docker pull missing: exit status 1")
+
+	p.Container = ContainerConfig{}
+	assert.ErrorContains(t, warmImages(p, io.Discard), "no container configured")
+}
+
+func TestExecInContainerTimeout(t *testing.T) {
+	// A fake docker that records its arguments and then hangs, with a child
+	// holding its output open the way a real command's would.
+	calls := fakeDocker(t, "echo \"$@\" > \"$CALLS\"\nsleep 30\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := execInContainer(ctx, "box", "make test", map[string]string{"RAILS_ENV": "test"}, io.Discard)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second, "a hung command must not hold up the caller")
+
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "exec -e RAILS_ENV=test box sh -c", "runs under the container's timeout too")
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(string(data)), " 1 make test"))
+}
@@ -1,34 +1,44 @@
 // Package daemon implements the groved background daemon.
 //
-// The daemon listens on a Unix domain socket and handles requests from grove
-// clients.  Each request is a single newline-terminated JSON object; the daemon
-// writes a single newline-terminated JSON response and then closes the
-// connection — except for attach requests, which enter a bidirectional
-// streaming mode (see instance.go and proto/messages.go for the wire format).
+// The daemon listens on a Unix domain socket, and optionally TCP, and
+// handles requests from grove clients.  Each request is a single
+// length-prefixed JSON message; the daemon writes a single length-prefixed
+// JSON response and then closes the connection — except for attach
+// requests, which enter a bidirectional streaming mode (see instance.go and
+// proto/messages.go for the wire format).
 package daemon
 
 import (
-	"bufio"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
 
+	"github.com/gandalfthegui/grove/internal/diskspace"
 	"github.com/gandalfthegui/grove/internal/proto"
 )
 
 // Daemon is the central supervisor.  It owns a map of live instances and
 // handles all IPC requests from grove.
 type Daemon struct {
-	rootDir string // ~/.grove  (data root: projects, instances, logs)
+	rootDir   string    // ~/.grove  (data root: projects, instances, logs)
+	startedAt time.Time // when New was called; reported by ReqMetrics
 
 	mu        sync.Mutex
 	instances map[string]*Instance // keyed by instance ID
+	starting  map[string]bool      // project+branch pairs with a start still in setup
+	reserved  map[string]bool      // IDs and names held by starts still in setup
+	started   int                  // successful starts since the daemon started
 }
 
 // New creates a Daemon that uses rootDir (~/.grove) as its data directory.
@@ -48,10 +58,14 @@ func New(rootDir string) (*Daemon, error) {
 			return nil, err
 		}
 	}
+	warnIfLowOnDisk(rootDir)
 
 	d := &Daemon{
 		rootDir:   rootDir,
+		startedAt: time.Now(),
 		instances: make(map[string]*Instance),
+		starting:  make(map[string]bool),
+		reserved:  make(map[string]bool),
 	}
 
 	if err := d.loadPersistedInstances(); err != nil {
@@ -61,6 +75,20 @@ func New(rootDir string) (*Daemon, error) {
 	return d, nil
 }
 
+// warnIfLowOnDisk logs a warning when the filesystem holding rootDir is
+// nearly full; running out fails clones and container starts with errors
+// that do not say so.
+func warnIfLowOnDisk(rootDir string) {
+	free, err := diskspace.Check(rootDir)
+	if err != nil {
+		log.Printf("warning: could not check free space: %v", err)
+		return
+	}
+	if free.LowBytes() || free.LowInodes() {
+		log.Printf("warning: the filesystem holding %s is nearly full (%s); grove prune drops exited instances and their worktrees", rootDir, free)
+	}
+}
+
 // Run starts the Unix socket listener and blocks until it is closed.
 func (d *Daemon) Run(socketPath string) error {
 	// Remove stale socket.
@@ -80,13 +108,40 @@ func (d *Daemon) Run(socketPath string) error {
 			// Listener was closed (shutdown).
 			return nil
 		}
-		go d.handleConn(conn)
+		go d.handleConn(conn, "")
+	}
+}
+
+// RunTCP listens on the TCP address addr as well, for clients that cannot
+// reach the Unix socket (groved in a container or VM).  Every request on it
+// must carry token, since a TCP port has no file permissions to keep other
+// users out.  It blocks until the listener is closed.
+func (d *Daemon) RunTCP(addr, token string) error {
+	if token == "" {
+		return fmt.Errorf("listen on %s: no %s set", addr, proto.DaemonTokenVar)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	defer l.Close()
+
+	log.Printf("groved listening on tcp:%s", l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return nil
+		}
+		go d.handleConn(conn, token)
 	}
 }
 
 // ─── Connection handling ──────────────────────────────────────────────────────
 
-func (d *Daemon) handleConn(conn net.Conn) {
+// handleConn serves one request.  A non-empty token is the secret the
+// request must carry (see RunTCP).
+func (d *Daemon) handleConn(conn net.Conn, token string) {
 	// Non-attach requests are handled quickly; attach blocks for its duration.
 	defer func() {
 		// conn may already be closed by Attach(); that's fine.
@@ -94,12 +149,22 @@ func (d *Daemon) handleConn(conn net.Conn) {
 	}()
 
 	var req proto.Request
-	scanner := bufio.NewScanner(conn)
-	if !scanner.Scan() {
+	legacy, err := proto.ReadMessage(conn, &req)
+	if legacy {
+		// Reply to an older grove in the format it expects.
+		conn = legacyConn{conn}
+	}
+	if err != nil {
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			respond(conn, proto.Response{OK: false, Error: "bad request: " + err.Error()})
+		}
 		return
 	}
-	if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
-		respond(conn, proto.Response{OK: false, Error: "bad request: " + err.Error()})
+	// Ping is let through so a client can tell a wrong token from a daemon
+	// that is not there.
+	if token != "" && req.Type != proto.ReqPing && subtle.ConstantTimeCompare([]byte(req.Token), []byte(token)) != 1 {
+		log.Printf("rejected %s request from %s: bad or missing token", req.Type, conn.RemoteAddr())
+		respond(conn, proto.Response{OK: false, Error: "unauthorized: set " + proto.DaemonTokenVar + " to the daemon's token"})
 		return
 	}
 
@@ -137,15 +202,51 @@ func (d *Daemon) handleConn(conn net.Conn) {
 	case proto.ReqRestart:
 		d.handleRestart(conn, req)
 
+	case proto.ReqAnnotate:
+		d.handleAnnotate(conn, req)
+
+	case proto.ReqMove:
+		d.handleMove(conn, req)
+
+	case proto.ReqMetrics:
+		d.handleMetrics(conn)
+
+	case proto.ReqCheckRepo:
+		d.handleCheckRepo(conn, req)
+
+	case proto.ReqReload:
+		d.handleReload(conn, req)
+
+	case proto.ReqWarm:
+		d.handleWarm(conn, req)
+
+	case proto.ReqInstanceConfig:
+		d.handleInstanceConfig(conn, req)
+
+	case proto.ReqSnapshot:
+		d.handleSnapshot(conn, req)
+
+	case proto.ReqExec:
+		d.handleExec(conn, req)
+
 	default:
 		respond(conn, proto.Response{OK: false, Error: "unknown request type: " + req.Type})
 	}
 }
 
-func respond(conn net.Conn, r proto.Response) {
-	data, _ := json.Marshal(r)
-	data = append(data, '\n')
-	conn.Write(data)
+// respond writes r to conn.  Most handlers ignore the error: a client that
+// has gone away has nobody left to tell.
+func respond(conn net.Conn, r proto.Response) error {
+	if _, ok := conn.(legacyConn); ok {
+		return proto.WriteLegacyMessage(conn, r)
+	}
+	return proto.WriteMessage(conn, r)
+}
+
+// legacyConn marks a connection from a client that sent a newline-terminated
+// request, so respond answers it the same way.
+type legacyConn struct {
+	net.Conn
 }
 
 // ─── Helpers ──────────────────────────────────────────────────────────────────
@@ -156,6 +257,58 @@ func (d *Daemon) getInstance(id string) *Instance {
 	return d.instances[id]
 }
 
+// instanceOnBranch returns the ID of an instance whose worktree has branch of
+// project checked out, or "" if there is none.
+func (d *Daemon) instanceOnBranch(project, branch string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, inst := range d.instances {
+		if inst.Project == project && inst.Branch == branch {
+			return id
+		}
+	}
+	return ""
+}
+
+// beginStart records an in-flight start for project/branch.  It returns false
+// if a start for the same pair is already setting up, so an accidental
+// double-start fails fast instead of cloning and creating a second container.
+func (d *Daemon) beginStart(project, branch string) bool {
+	key := project + "\x00" + branch
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.starting[key] {
+		return false
+	}
+	d.starting[key] = true
+	return true
+}
+
+// endStart clears the in-flight entry recorded by beginStart.
+func (d *Daemon) endStart(project, branch string) {
+	d.mu.Lock()
+	delete(d.starting, project+"\x00"+branch)
+	d.mu.Unlock()
+}
+
+// reserve holds an instance ID and name ("" for none) for a start that has
+// not registered its instance yet, so a concurrent start picks neither.
+// Must be called with d.mu held.
+func (d *Daemon) reserve(id, name string) {
+	d.reserved[id] = true
+	if name != "" {
+		d.reserved[name] = true
+	}
+}
+
+// release drops what reserve held.
+func (d *Daemon) release(id, name string) {
+	d.mu.Lock()
+	delete(d.reserved, id)
+	delete(d.reserved, name)
+	d.mu.Unlock()
+}
+
 // idAlphabet is the ordered set of characters used to build instance IDs.
 // Single-character IDs are assigned first (digits 1-9, then a-z), giving 35
 // slots before falling back to two-character combinations.
@@ -169,14 +322,14 @@ var idAlphabet = []string{
 // Must be called with d.mu held.
 func (d *Daemon) nextInstanceID() string {
 	for _, id := range idAlphabet {
-		if _, taken := d.instances[id]; !taken {
+		if !d.idTaken(id) {
 			return id
 		}
 	}
 	for _, a := range idAlphabet {
 		for _, b := range idAlphabet {
 			id := a + b
-			if _, taken := d.instances[id]; !taken {
+			if !d.idTaken(id) {
 				return id
 			}
 		}
@@ -186,3 +339,43 @@ func (d *Daemon) nextInstanceID() string {
 	rand.Read(b)
 	return hex.EncodeToString(b)
 }
+
+// idTaken reports whether id is an instance's ID or name, or reserved by a
+// start in setup, so a new ID never shadows a name given with grove start
+// --name.  Must be called with d.mu held.
+func (d *Daemon) idTaken(id string) bool {
+	if _, taken := d.instances[id]; taken || d.reserved[id] {
+		return true
+	}
+	for _, inst := range d.instances {
+		if inst.Name == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkInstanceName returns an error if name, from grove start --name,
+// cannot label a new instance: it must look like an argument rather than a
+// flag, and must not already be an instance's ID or name.  "" is fine.
+// Must be called with d.mu held.
+func (d *Daemon) checkInstanceName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if strings.HasPrefix(name, "-") || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid instance name %q: it must not start with - or contain spaces", name)
+	}
+	if d.reserved[name] {
+		return fmt.Errorf("name %q is already taken by an instance still starting", name)
+	}
+	for id, inst := range d.instances {
+		switch {
+		case id == name:
+			return fmt.Errorf("name %q is already the ID of instance %s", name, id)
+		case inst.Name == name:
+			return fmt.Errorf("name %q is already used by instance %s", name, id)
+		}
+	}
+	return nil
+}
@@ -1,11 +1,52 @@
 package daemon
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/creack/pty"
+	"github.com/gandalfthegui/grove/internal/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// fakeDocker puts a docker on PATH for the rest of the test that runs
+// script, a sh script body.  It returns the file the script sees as $CALLS,
+// for scripts that record how they were called.
+func fakeDocker(t *testing.T, script string) (calls string) {
+	t.Helper()
+	bin := t.TempDir()
+	calls = filepath.Join(bin, "calls")
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker"), []byte("#!/bin/sh\nCALLS="+calls+"\n"+script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+// callHandler runs handler for req on one end of a pipe, as handleConn
+// would, and returns its response.  Anything the handler writes after it is
+// read and dropped until the handler returns.
+func callHandler(t *testing.T, handler func(net.Conn, proto.Request), req proto.Request) proto.Response {
+	t.Helper()
+	server, client := net.Pipe()
+	go func() {
+		handler(server, req)
+		server.Close()
+	}()
+	var resp proto.Response
+	_, err := proto.ReadMessage(client, &resp)
+	require.NoError(t, err)
+	io.Copy(io.Discard, client)
+	return resp
+}
+
 func TestNextInstanceID(t *testing.T) {
 	d := &Daemon{instances: make(map[string]*Instance)}
 
@@ -33,6 +74,68 @@ func TestNextInstanceID(t *testing.T) {
 	d.mu.Unlock()
 }
 
+func TestNextInstanceIDSkipsNames(t *testing.T) {
+	d := &Daemon{instances: map[string]*Instance{"1": {ID: "1", Name: "2"}}}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	assert.Equal(t, "3", d.nextInstanceID(), "2 is taken as a name")
+}
+
+func TestReservedIDsAndNames(t *testing.T) {
+	d := &Daemon{instances: map[string]*Instance{}, reserved: map[string]bool{}}
+	d.mu.Lock()
+	d.reserve(d.nextInstanceID(), "billing")
+	assert.Equal(t, "2", d.nextInstanceID(), "1 is held by a start in setup")
+	err := d.checkInstanceName("billing")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "still starting")
+	d.mu.Unlock()
+
+	d.release("1", "billing")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	assert.Equal(t, "1", d.nextInstanceID())
+	assert.NoError(t, d.checkInstanceName("billing"))
+}
+
+func TestCheckInstanceName(t *testing.T) {
+	d := &Daemon{instances: map[string]*Instance{
+		"1": {ID: "1", Name: "auth"},
+		"2": {ID: "2"},
+	}}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	assert.NoError(t, d.checkInstanceName(""))
+	assert.NoError(t, d.checkInstanceName("billing"))
+	assert.NoError(t, d.checkInstanceName("3"), "not an ID yet")
+
+	err := d.checkInstanceName("auth")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already used by instance 1")
+
+	err = d.checkInstanceName("2")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already the ID of instance 2")
+
+	for _, bad := range []string{"-x", "two words", "tab\there"} {
+		assert.Error(t, d.checkInstanceName(bad), bad)
+	}
+}
+
+func TestStartRejectsTakenName(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "projects", "app"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "projects", "app", "project.yaml"), []byte("name: app\n"), 0o644))
+	d := &Daemon{rootDir: root, starting: map[string]bool{},
+		instances: map[string]*Instance{"1": {ID: "1", Name: "auth", Project: "app", Branch: "a"}}}
+
+	resp := callHandler(t, d.handleStart, proto.Request{Type: proto.ReqStart, Project: "app", Branch: "b", Name: "auth"})
+	assert.False(t, resp.OK)
+	assert.Equal(t, `name "auth" is already used by instance 1`, resp.Error)
+	assert.Len(t, d.instances, 1)
+}
+
 func TestRepoURLHintSuffix(t *testing.T) {
 	cases := []struct {
 		repo string
@@ -55,3 +158,477 @@ func TestRepoURLHintSuffix(t *testing.T) {
 		}
 	}
 }
+
+func TestBeginStartRejectsDuplicateInFlight(t *testing.T) {
+	d := &Daemon{starting: make(map[string]bool)}
+
+	assert.True(t, d.beginStart("my-app", "feat/a"))
+	assert.False(t, d.beginStart("my-app", "feat/a"), "second start for the same pair must be rejected")
+	assert.True(t, d.beginStart("my-app", "feat/b"), "different branch is independent")
+	assert.True(t, d.beginStart("other", "feat/a"), "different project is independent")
+
+	d.endStart("my-app", "feat/a")
+	assert.True(t, d.beginStart("my-app", "feat/a"), "entry is cleared once the first start completes")
+}
+
+func TestRestartBackoff(t *testing.T) {
+	assert.Equal(t, 2*time.Second, restartBackoff(1))
+	assert.Equal(t, 4*time.Second, restartBackoff(2))
+	assert.Equal(t, 16*time.Second, restartBackoff(4))
+	assert.Equal(t, 30*time.Second, restartBackoff(5))
+	assert.Equal(t, 30*time.Second, restartBackoff(20))
+}
+
+func TestMetricsAggregatesInstances(t *testing.T) {
+	d := &Daemon{instances: make(map[string]*Instance), startedAt: time.Now(), started: 3}
+	d.instances["1"] = &Instance{ID: "1", state: proto.StateRunning, logBuf: make([]byte, 10)}
+	d.instances["2"] = &Instance{ID: "2", state: proto.StateCrashed, logBuf: make([]byte, 5)}
+	d.instances["3"] = &Instance{ID: "3", state: proto.StateCrashed}
+
+	m := d.metrics()
+	assert.Equal(t, 3, m.Instances)
+	assert.Equal(t, 3, m.InstancesStarted)
+	assert.Equal(t, map[string]int{proto.StateRunning: 1, proto.StateCrashed: 2}, m.States)
+	assert.Equal(t, int64(15), m.LogBytes)
+	assert.Equal(t, 0, m.AttachSessions)
+}
+
+func TestAgentEnvPrecedence(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "env"), []byte("HTTPS_PROXY=from-file\nTOKEN=file\nRAILS_ENV=file\nSHARED=file\n"), 0o600))
+	t.Setenv("HTTP_PROXY", "http://proxy:3128")
+	t.Setenv("HTTPS_PROXY", "https://proxy:3128")
+	t.Setenv("NOT_LISTED", "x")
+
+	d := &Daemon{rootDir: root}
+	p := &Project{Env: map[string]string{"RAILS_ENV": "development", "SHARED": "grove.yaml", "TOKEN": "grove.yaml"}}
+	p.Container.EnvPassthrough = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_SUCH_VAR"}
+
+	repoEnv := map[string]string{"FEATURE_X": "on", "HTTP_PROXY": "repo", "RAILS_ENV": "repo"}
+	env := d.agentEnv(p, repoEnv, map[string]string{"TOKEN": "request"})
+	assert.Equal(t, map[string]string{
+		"FEATURE_X":   "on", // the repo's env_file only fills gaps
+		"HTTP_PROXY":  "http://proxy:3128",
+		"HTTPS_PROXY": "from-file",
+		"RAILS_ENV":   "development", // grove.yaml env: beats the env file
+		"SHARED":      "grove.yaml",
+		"TOKEN":       "request", // the request beats grove.yaml
+	}, env)
+}
+
+func TestPrefixWriterLabelsLines(t *testing.T) {
+	var buf bytes.Buffer
+	pw := &prefixWriter{w: &buf, prefix: "[lint] "}
+	pw.Write([]byte("one\ntw"))
+	pw.Write([]byte("o\nthree"))
+	pw.Flush()
+	assert.Equal(t, "[lint] one\n[lint] two\n[lint] three\n", buf.String())
+}
+
+func TestRespondStartFailureStreamsSetupOutput(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		respondStartFailure(server, "start command failed: exit status 1", []byte("bundle install\nGem::MissingSpecError\n"))
+		server.Close()
+	}()
+
+	var resp proto.Response
+	legacy, err := proto.ReadMessage(client, &resp)
+	require.NoError(t, err)
+	assert.False(t, legacy)
+	assert.False(t, resp.OK)
+	assert.Equal(t, "start command failed: exit status 1", resp.Error)
+	rest, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "bundle install\nGem::MissingSpecError\n", string(rest))
+	assert.Empty(t, resp.Code)
+}
+
+func TestErrorCodes(t *testing.T) {
+	d := &Daemon{rootDir: t.TempDir(), instances: map[string]*Instance{}}
+	resp := callHandler(t, d.handleCheckRepo, proto.Request{Project: "api"})
+	assert.Equal(t, proto.CodeProjectNotFound, resp.Code, resp.Error)
+	resp = callHandler(t, d.handleCheck, proto.Request{InstanceID: "7"})
+	assert.Equal(t, proto.CodeInstanceNotFound, resp.Code, resp.Error)
+
+	assert.Equal(t, proto.CodeNoEngine, errorResponse(errors.New(`exec: "docker": executable file not found in $PATH`)).Code)
+	assert.Empty(t, errorResponse(errors.New("2 of 3 checks failed")).Code)
+
+	// A start that docker failed for want of an engine says so in its
+	// output rather than its error.
+	server, client := net.Pipe()
+	go func() {
+		respondStartFailure(server, "start container: exit status 125", []byte("Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?\n"))
+		server.Close()
+	}()
+	_, err := proto.ReadMessage(client, &resp)
+	require.NoError(t, err)
+	assert.Equal(t, proto.CodeNoEngine, resp.Code)
+	io.Copy(io.Discard, client)
+}
+
+func TestResilientWriterFramed(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		rw := newResilientWriter(server, nil)
+		rw.framed = true
+		rw.Write([]byte("boom\n"))
+		rw.writeResult(proto.Response{Error: "1 of 1 checks failed"})
+		server.Close()
+	}()
+
+	typ, payload, err := proto.ReadFrame(client)
+	require.NoError(t, err)
+	assert.Equal(t, proto.StreamFrameOutput, typ)
+	assert.Equal(t, "boom\n", string(payload))
+
+	typ, payload, err = proto.ReadFrame(client)
+	require.NoError(t, err)
+	assert.Equal(t, proto.StreamFrameResult, typ)
+	assert.JSONEq(t, `{"ok":false,"error":"1 of 1 checks failed"}`, string(payload))
+}
+
+func TestTailBytes(t *testing.T) {
+	b := []byte("hello world")
+	assert.Equal(t, "world", string(tailBytes(b, 5)))
+	assert.Equal(t, "hello world", string(tailBytes(b, 0)), "zero means everything")
+	assert.Equal(t, "hello world", string(tailBytes(b, 100)), "clamped to the buffer")
+
+	// A cut inside "é" (two bytes) moves past the partial character.
+	assert.Equal(t, "x", string(tailBytes([]byte("éx"), 2)))
+
+	// A cut inside a colour sequence moves past it.
+	colored := []byte("ab\x1b[31mred\x1b[0m")
+	assert.Equal(t, "red\x1b[0m", string(tailBytes(colored, 9)))
+	assert.Equal(t, "\x1b[31mred\x1b[0m", string(tailBytes(colored, 12)), "a cut at the ESC keeps the sequence")
+}
+
+func TestInstanceConfigSurvivesDaemonRestart(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	require.NoError(t, os.Mkdir(filepath.Join(home, "cache"), 0o755))
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+
+	p := &Project{Name: "app"}
+	p.Container.Image = "ubuntu:24.04"
+	p.Container.Mounts = []string{"~/cache", "/no/such/dir"}
+	p.Container.Hide = []string{"node_modules"}
+	env := map[string]string{"TOKEN": "secret", "HTTP_PROXY": "http://proxy:3128"}
+
+	inst := &Instance{ID: "1", Project: "app", Branch: "feat", state: proto.StateExited, InstancesDir: instancesDir}
+	inst.config = instanceConfig(p, "claude", []string{"--model", "opus"}, env)
+	inst.persistMeta(instancesDir)
+
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, d.loadPersistedInstances())
+
+	resp := callHandler(t, d.handleInstanceConfig, proto.Request{InstanceID: "1"})
+	require.True(t, resp.OK, resp.Error)
+	assert.Equal(t, &proto.InstanceConfig{
+		Image:        "ubuntu:24.04",
+		Workdir:      "/app",
+		Mounts:       []string{filepath.Join(home, "cache") + ":/root/cache"},
+		Hidden:       []string{"/app/node_modules"},
+		AgentCommand: "claude",
+		AgentArgs:    []string{"--model", "opus"},
+		AgentEnvKeys: []string{"HTTP_PROXY", "TOKEN"},
+	}, resp.InstanceConfig)
+
+	data, err := os.ReadFile(filepath.Join(instancesDir, "1.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+}
+
+func TestInstanceConfigMissingForOldInstances(t *testing.T) {
+	d := &Daemon{instances: map[string]*Instance{"1": {ID: "1", state: proto.StateExited}}}
+	resp := callHandler(t, d.handleInstanceConfig, proto.Request{InstanceID: "1"})
+	assert.False(t, resp.OK)
+	assert.Contains(t, resp.Error, "no recorded config")
+}
+
+func TestStopContainerKeepsInstance(t *testing.T) {
+	// A fake docker that records what it is asked to do.
+	calls := fakeDocker(t, "echo \"$@\" >> \"$CALLS\"\n")
+
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+	worktree := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: x\n"), 0o644))
+
+	inst := &Instance{ID: "1", Project: "app", Branch: "feat", WorktreeDir: worktree, ContainerID: "grove-1",
+		state: proto.StateExited, InstancesDir: instancesDir, timeline: &timeline{}}
+	d := &Daemon{rootDir: root, instances: map[string]*Instance{"1": inst}}
+
+	resp := callHandler(t, d.handleStop, proto.Request{InstanceID: "1", Container: true})
+	require.True(t, resp.OK, resp.Error)
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "stop grove-1\nrm -v grove-1\n", string(data))
+	assert.True(t, inst.Info().ContainerStopped)
+
+	// A second stop --container has nothing left to remove.
+	resp = callHandler(t, d.handleStop, proto.Request{InstanceID: "1", Container: true})
+	require.True(t, resp.OK, resp.Error)
+	data, err = os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "stop grove-1\nrm -v grove-1\n", string(data))
+
+	resp = callHandler(t, d.handleCheck, proto.Request{InstanceID: "1"})
+	assert.False(t, resp.OK)
+	assert.Contains(t, resp.Error, "grove restart --fresh 1")
+
+	// The worktree and record survive a daemon restart, still without a container.
+	reloaded := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, reloaded.loadPersistedInstances())
+	require.Contains(t, reloaded.instances, "1")
+	assert.True(t, reloaded.instances["1"].Info().ContainerStopped)
+	assert.DirExists(t, worktree)
+}
+
+func TestHandleConnRequiresToken(t *testing.T) {
+	d := &Daemon{instances: make(map[string]*Instance)}
+	send := func(req proto.Request) proto.Response {
+		server, client := net.Pipe()
+		defer client.Close()
+		go d.handleConn(server, "s3cret")
+		require.NoError(t, proto.WriteMessage(client, req))
+		var resp proto.Response
+		_, err := proto.ReadMessage(client, &resp)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := send(proto.Request{Type: proto.ReqList})
+	assert.False(t, resp.OK)
+	assert.Contains(t, resp.Error, "unauthorized")
+	assert.False(t, send(proto.Request{Type: proto.ReqList, Token: "guess"}).OK)
+	assert.True(t, send(proto.Request{Type: proto.ReqList, Token: "s3cret"}).OK)
+	assert.True(t, send(proto.Request{Type: proto.ReqPing}).OK, "ping needs no token")
+}
+
+func TestHandleExec(t *testing.T) {
+	// A fake docker that echoes its arguments, says where it is on stderr,
+	// and exits with the status given as the last one, the command line.
+	fakeDocker(t, "echo \"$@\"\necho in-container >&2\nfor a; do last=$a; done\nexit ${last##* }\n")
+
+	root := t.TempDir()
+	projectDir := filepath.Join(root, "projects", "app")
+	require.NoError(t, os.MkdirAll(filepath.Join(projectDir, "main"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "project.yaml"), []byte("name: app\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "main", "grove.yaml"), []byte("env:\n  RAILS_ENV: test\n"), 0o644))
+
+	running := &Instance{ID: "1", Project: "app", ContainerID: "grove-1", state: proto.StateWaiting}
+	exited := &Instance{ID: "2", Project: "app", ContainerID: "grove-2", state: proto.StateExited}
+	d := &Daemon{rootDir: root, instances: map[string]*Instance{"1": running, "2": exited}}
+
+	var stderr bytes.Buffer
+	run := func(id, command string) (proto.Response, string, proto.Response) {
+		server, client := net.Pipe()
+		go func() {
+			d.handleExec(server, proto.Request{Type: proto.ReqExec, InstanceID: id, Command: command})
+			server.Close()
+		}()
+		var resp proto.Response
+		_, err := proto.ReadMessage(client, &resp)
+		require.NoError(t, err)
+		if !resp.OK {
+			return resp, "", proto.Response{}
+		}
+		var out bytes.Buffer
+		stderr.Reset()
+		for {
+			frameType, payload, err := proto.ReadFrame(client)
+			require.NoError(t, err)
+			switch frameType {
+			case proto.StreamFrameOutput:
+				out.Write(payload)
+				continue
+			case proto.StreamFrameStderr:
+				stderr.Write(payload)
+				continue
+			}
+			var result proto.Response
+			require.NoError(t, json.Unmarshal(payload, &result))
+			return resp, out.String(), result
+		}
+	}
+
+	resp, out, result := run("1", "exit 0")
+	assert.True(t, resp.Framed)
+	assert.Equal(t, "exec -e RAILS_ENV=test grove-1 sh -c exit 0\n", out, "grove.yaml env: is passed")
+	assert.Equal(t, "in-container\n", stderr.String(), "stderr comes in frames of its own")
+	assert.True(t, result.OK, result.Error)
+
+	_, _, result = run("1", "exit 3")
+	assert.False(t, result.OK)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, proto.StateWaiting, running.Info().State, "exec leaves the state alone")
+
+	resp, _, _ = run("2", "exit 0")
+	assert.False(t, resp.OK)
+	assert.Equal(t, "cannot exec: instance 2 is EXITED; grove restart 2 first", resp.Error)
+
+	resp, _, _ = run("9", "true")
+	assert.Equal(t, proto.ErrInstanceNotFound+": 9", resp.Error)
+}
+
+func TestHandleExecStopsWhenClientLeaves(t *testing.T) {
+	fakeDocker(t, "exec sleep 30\n")
+
+	d := &Daemon{rootDir: t.TempDir(), instances: map[string]*Instance{
+		"1": {ID: "1", Project: "app", ContainerID: "grove-1", state: proto.StateWaiting}}}
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		d.handleExec(server, proto.Request{Type: proto.ReqExec, InstanceID: "1", Command: "make watch"})
+		close(done)
+	}()
+	var resp proto.Response
+	_, err := proto.ReadMessage(client, &resp)
+	require.NoError(t, err)
+	require.True(t, resp.OK)
+
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exec kept running after the client went away")
+	}
+}
+
+func TestLoadPersistedInstancesSetsAsideCorruptRecords(t *testing.T) {
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+
+	good := &Instance{ID: "1", Name: "auth", Project: "app", Branch: "feat", state: proto.StateExited}
+	good.persistMeta(instancesDir)
+	truncated := filepath.Join(instancesDir, "2.json")
+	require.NoError(t, os.WriteFile(truncated, []byte(`{"id":"2","project":"ap`), 0o644))
+	leftover := filepath.Join(instancesDir, "1.json.123.tmp")
+	require.NoError(t, os.WriteFile(leftover, []byte(`{"id":"1"`), 0o644))
+
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, d.loadPersistedInstances())
+
+	assert.Len(t, d.instances, 1)
+	require.NotNil(t, d.instances["1"])
+	assert.Equal(t, "auth", d.instances["1"].Name)
+	assert.NoFileExists(t, truncated)
+	assert.FileExists(t, truncated+".corrupt")
+	assert.NoFileExists(t, leftover)
+
+	// A reload does not pick the set-aside record up again.
+	d = &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, d.loadPersistedInstances())
+	assert.Len(t, d.instances, 1)
+}
+
+func TestRestartKeepsMetadata(t *testing.T) {
+	// A fake docker whose "exec" is an agent that keeps running.
+	fakeDocker(t, "exec sleep 60\n")
+
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "projects", "app"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "projects", "app", "project.yaml"), []byte("name: app\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "projects", "app", "main"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "projects", "app", "main", "grove.yaml"), []byte("env_file: missing.env\n"), 0o644))
+	worktree := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: x\n"), 0o644))
+
+	inst := &Instance{ID: "1", Project: "app", Branch: "feat/renamed", WorktreeDir: worktree, ContainerID: "grove-1",
+		state: proto.StateCrashed, exitReason: exitReasonOOM, InstancesDir: instancesDir, timeline: &timeline{},
+		annotations: map[string]string{"ticket": "ABC-1"}, config: &proto.InstanceConfig{AgentCommand: "sh", Prompt: "fix it"}}
+	inst.persistMeta(instancesDir)
+	d := &Daemon{rootDir: root, instances: map[string]*Instance{"1": inst}}
+
+	resp := callHandler(t, d.handleRestart, proto.Request{InstanceID: "1"})
+	require.True(t, resp.OK, resp.Error)
+	t.Cleanup(func() {
+		inst.destroy()
+		<-inst.processDone
+	})
+
+	info := inst.Info()
+	assert.Equal(t, proto.StateRunning, info.State)
+	assert.Equal(t, "feat/renamed", info.Branch)
+	assert.Equal(t, map[string]string{"ticket": "ABC-1"}, info.Annotations)
+	assert.Equal(t, "fix it", inst.recordedConfig().Prompt)
+	assert.Empty(t, info.ExitReason, "the crash reason belongs to the previous run")
+	inst.mu.Lock()
+	assert.Contains(t, string(inst.logBuf), "warning: env_file missing.env not found in the worktree; starting without it")
+	inst.mu.Unlock()
+
+	reloaded := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, reloaded.loadPersistedInstances())
+	require.Contains(t, reloaded.instances, "1")
+	assert.Equal(t, map[string]string{"ticket": "ABC-1"}, reloaded.instances["1"].Info().Annotations, "and it is persisted")
+}
+
+func TestDropEvictsClientAndWaitsForAgent(t *testing.T) {
+	fakeDocker(t, "")
+
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+	cmd := exec.Command("sleep", "60")
+	ptm, err := pty.Start(cmd)
+	require.NoError(t, err)
+	inst := &Instance{ID: "1", Project: "app", Branch: "feat", ContainerID: "grove-1", state: proto.StateRunning,
+		pid: cmd.Process.Pid, ptm: ptm, InstancesDir: instancesDir, processDone: make(chan struct{}), timeline: &timeline{}}
+	go inst.ptyReader(cmd)
+	inst.persistMeta(instancesDir)
+	d := &Daemon{rootDir: root, instances: map[string]*Instance{"1": inst}}
+
+	attached, attachedClient := net.Pipe()
+	attachedOut := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(attachedClient)
+		attachedOut <- string(data)
+	}()
+	go inst.Attach(attached, AttachOptions{})
+	require.Eventually(t, func() bool { return inst.Info().State == proto.StateAttached }, time.Second, 10*time.Millisecond)
+
+	resp := callHandler(t, d.handleDrop, proto.Request{InstanceID: "1"})
+	require.True(t, resp.OK, resp.Error)
+
+	assert.Equal(t, dropNotice, <-attachedOut, "the attached client is told why, then disconnected")
+	select {
+	case <-inst.processDone:
+	default:
+		t.Fatal("drop replied before the agent's exit was recorded")
+	}
+	assert.NoFileExists(t, filepath.Join(instancesDir, "1.json"), "no late write brings the record back")
+	assert.Nil(t, d.getInstance("1"))
+}
+
+func TestSetupContainerStopsContainerOnFailure(t *testing.T) {
+	// A fake docker that records what it is asked to do and fails any
+	// command mentioning "broken".
+	calls := fakeDocker(t, "echo \"$1 $2\" >> \"$CALLS\"\ncase \"$*\" in *broken*) exit 1 ;; esac\n")
+	t.Setenv("HOME", t.TempDir())
+
+	p := &Project{Start: []string{"make broken"}}
+	p.Container.Image = "img"
+	p.Container.Workdir = "/app"
+	p.Agent.Command = "sh"
+	_, _, stage, err := setupContainer(p, "1", t.TempDir(), "sh", io.Discard)
+	require.Error(t, err)
+	assert.Equal(t, "start", stage)
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "run -d\nexec grove-1\nstop grove-1\nrm -v\n", string(data))
+
+	p.Start = []string{"make"}
+	require.NoError(t, os.Remove(calls))
+	name, composeProject, stage, err := setupContainer(p, "1", t.TempDir(), "sh", io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, "grove-1", name)
+	assert.Empty(t, composeProject)
+	assert.Empty(t, stage)
+}
@@ -2,6 +2,8 @@ package daemon
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,15 +11,22 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gandalfthegui/grove/internal/envfile"
+	"github.com/gandalfthegui/grove/internal/gitref"
 	"github.com/gandalfthegui/grove/internal/proto"
 )
 
+// stopWaitTimeout bounds how long a ReqStop with Wait blocks for the agent
+// process to exit after it has been sent SIGKILL.
+const stopWaitTimeout = 15 * time.Second
+
 func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 	if req.Project == "" {
 		respond(conn, proto.Response{OK: false, Error: "project name required"})
@@ -28,16 +37,29 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 		return
 	}
 
+	if !d.beginStart(req.Project, req.Branch) {
+		respond(conn, proto.Response{OK: false, Error: "already starting " + req.Branch + " in " + req.Project + " (an earlier grove start may still be setting it up; see grove daemon logs)"})
+		return
+	}
+	defer d.endStart(req.Project, req.Branch)
+
 	p, err := loadProject(d.rootDir, req.Project)
 	if err != nil {
-		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		respond(conn, errorResponse(err))
 		return
 	}
 
 	// Allocate instance ID early so the log file can be named after it.
 	d.mu.Lock()
+	if err := d.checkInstanceName(req.Name); err != nil {
+		d.mu.Unlock()
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
 	instanceID := d.nextInstanceID()
+	d.reserve(instanceID, req.Name)
 	d.mu.Unlock()
+	defer d.release(instanceID, req.Name)
 	startedAt := time.Now()
 
 	logFile := filepath.Join(d.rootDir, "logs", instanceID+".log")
@@ -49,9 +71,10 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 	// setupW captures all clone/pull/bootstrap output in memory and also
 	// writes it to the log file so it's preserved after the connection closes.
 	var outputBuf bytes.Buffer
-	var setupW io.Writer = &outputBuf
+	tl := &timeline{}
+	setupW := io.MultiWriter(&outputBuf, tl.writer(sourceSetup))
 	if logFd != nil {
-		setupW = io.MultiWriter(&outputBuf, logFd)
+		setupW = io.MultiWriter(setupW, logFd)
 	}
 	log.Printf("start requested: project=%s branch=%s instance=%s repo=%q main_dir=%s", req.Project, req.Branch, instanceID, p.Repo, p.MainDir())
 
@@ -72,7 +95,7 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 		setupErr = err
 		log.Printf("start failed: stage=clone project=%s branch=%s instance=%s repo=%q elapsed=%s err=%v%s",
 			req.Project, req.Branch, instanceID, p.Repo, time.Since(startedAt).Round(time.Millisecond), err, repoURLHintSuffix(p.Repo))
-		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		respondStartFailure(conn, err.Error(), outputBuf.Bytes())
 		return
 	}
 
@@ -80,6 +103,7 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 	// Non-fatal: log the warning and continue so offline use still works.
 	if err := pullMain(p, setupW); err != nil {
 		log.Printf("warning: git pull failed for %s: %v", req.Project, err)
+		fmt.Fprintf(setupW, "warning: %v\n", err)
 	}
 
 	// Overlay grove.yaml from the repo root if it exists.
@@ -95,68 +119,76 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 		respond(conn, proto.Response{
 			OK:       false,
 			Error:    "no grove.yaml found in " + req.Project,
-			InitPath: p.MainDir(),
+			InitPath: filepath.Join(p.MainDir(), filepath.FromSlash(p.Subdir)),
 		})
 		return
 	}
 
-	// Create the git worktree on the user-specified branch.
-	worktreeDir, err := createWorktree(p, instanceID, req.Branch, setupW)
-	if err != nil {
-		setupErr = err
-		log.Printf("start failed: stage=worktree project=%s branch=%s instance=%s main_dir=%s elapsed=%s err=%v",
-			req.Project, req.Branch, instanceID, p.MainDir(), time.Since(startedAt).Round(time.Millisecond), err)
-		respond(conn, proto.Response{OK: false, Error: err.Error()})
+	// A prompt is typed into the agent's terminal; a piped agent has none.
+	if req.Prompt != "" && p.agentPipe() {
+		setupErr = fmt.Errorf("prompt without pty")
+		respond(conn, proto.Response{OK: false, Error: "--prompt needs the agent to run in a terminal (agent.pty is false in grove.yaml)"})
 		return
 	}
-	rollbacks = append(rollbacks, func() { removeWorktree(p, instanceID, req.Branch) })
 
-	// Start the container with the worktree bind-mounted inside it.
-	containerName, err := startContainer(p, instanceID, worktreeDir, setupW)
+	// Create the git worktree on the user-specified branch.  On resume the
+	// branch must already exist and survives a rollback.
+	var worktreeDir string
+	if req.Resume {
+		if owner := d.instanceOnBranch(req.Project, req.Branch); owner != "" {
+			setupErr = fmt.Errorf("branch in use")
+			respond(conn, proto.Response{OK: false, Error: "cannot resume: " + req.Branch + " is still checked out by instance " + owner + " (restart or drop it instead)"})
+			return
+		}
+		worktreeDir, err = resumeWorktree(p, instanceID, req.Branch, setupW)
+	} else {
+		worktreeDir, err = createWorktree(p, instanceID, req.Branch, setupW)
+	}
 	if err != nil {
 		setupErr = err
-		log.Printf("start failed: stage=container project=%s branch=%s instance=%s worktree=%s elapsed=%s err=%v",
-			req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), err)
-		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		log.Printf("start failed: stage=worktree project=%s branch=%s instance=%s main_dir=%s elapsed=%s err=%v",
+			req.Project, req.Branch, instanceID, p.MainDir(), time.Since(startedAt).Round(time.Millisecond), err)
+		respondStartFailure(conn, err.Error(), outputBuf.Bytes())
 		return
 	}
-	composeProject := ""
-	if p.Container.Compose != "" {
-		composeProject = "grove-" + instanceID
+	if req.Resume {
+		rollbacks = append(rollbacks, func() { removeWorktreeDir(p, instanceID) })
+	} else {
+		rollbacks = append(rollbacks, func() { removeWorktree(p, instanceID, req.Branch) })
 	}
-	rollbacks = append(rollbacks, func() { stopContainer(containerName, composeProject) })
-
-	// Copy host's ~/.claude.json into the container so Claude starts with
-	// existing preferences/auth. This is a copy, not a bind mount, to avoid
-	// file corruption from concurrent writes by host and container Claude.
-	if p.Agent.Command == "claude" || p.Agent.Command == "" {
-		seedClaudeConfig(containerName)
+	if p.Subdir != "" {
+		if info, err := os.Stat(filepath.Join(worktreeDir, filepath.FromSlash(p.Subdir))); err != nil || !info.IsDir() {
+			setupErr = fmt.Errorf("subdir %s not found", p.Subdir)
+			log.Printf("start failed: stage=worktree project=%s branch=%s instance=%s subdir=%s", req.Project, req.Branch, instanceID, p.Subdir)
+			respondStartFailure(conn, "subdir "+p.Subdir+" (project.yaml) does not exist on branch "+req.Branch, outputBuf.Bytes())
+			return
+		}
 	}
-
-	// Run start commands inside the container.
-	if err := runStart(p, containerName, setupW); err != nil {
+	if err := configureWorktreeGit(p, worktreeDir, setupW); err != nil {
 		setupErr = err
-		log.Printf("start failed: stage=start project=%s branch=%s instance=%s worktree=%s elapsed=%s err=%v",
-			req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), err)
-		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		log.Printf("start failed: stage=worktree project=%s branch=%s instance=%s main_dir=%s elapsed=%s err=%v",
+			req.Project, req.Branch, instanceID, p.MainDir(), time.Since(startedAt).Round(time.Millisecond), err)
+		respondStartFailure(conn, err.Error(), outputBuf.Bytes())
 		return
 	}
 
-	// Ensure the agent binary is available inside the container.
 	agentCmd := p.Agent.Command
 	if agentCmd == "" {
 		agentCmd = "sh"
 	}
-	if err := ensureAgentInstalled(agentCmd, containerName, setupW); err != nil {
+	containerName, composeProject, stage, err := setupContainer(p, instanceID, worktreeDir, agentCmd, setupW)
+	if err != nil {
 		setupErr = err
-		log.Printf("start failed: stage=agent-install project=%s branch=%s instance=%s worktree=%s elapsed=%s err=%v",
-			req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), err)
-		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		log.Printf("start failed: stage=%s project=%s branch=%s instance=%s worktree=%s elapsed=%s err=%v",
+			stage, req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), err)
+		respondStartFailure(conn, err.Error(), outputBuf.Bytes())
 		return
 	}
+	rollbacks = append(rollbacks, func() { stopContainer(containerName, composeProject) })
 
 	inst := &Instance{
 		ID:             instanceID,
+		Name:           req.Name,
 		Project:        req.Project,
 		Branch:         req.Branch,
 		WorktreeDir:    worktreeDir,
@@ -166,39 +198,228 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 		InstancesDir:   filepath.Join(d.rootDir, "instances"),
 		ContainerID:    containerName,
 		ComposeProject: composeProject,
+		// Seed the rolling buffer with setup output so `grove logs` shows
+		// the clone/container/install history, not just the agent's PTY.
+		logBuf:   append([]byte(nil), outputBuf.Bytes()...),
+		timeline: tl,
 	}
 
-	// Build the agent environment: env file is the base, request-level
-	// values (from the CLI prompt or host env) override.
-	agentEnv := envfile.Load(filepath.Join(d.rootDir, "env"))
-	for k, v := range req.AgentEnv {
-		agentEnv[k] = v
+	repoEnv, err := p.loadRepoEnv(worktreeDir)
+	if err != nil {
+		log.Printf("warning: instance %s: %v", instanceID, err)
+		fmt.Fprintf(setupW, "warning: %v; starting without it\n", err)
 	}
+	agentEnv := d.agentEnv(p, repoEnv, req.AgentEnv)
 	logAgentCredentials(instanceID, agentEnv)
 
-	if err := inst.startAgent(agentCmd, p.Agent.Args, agentEnv); err != nil {
+	agentArgs := p.agentArgs(agentCmd, req.AgentArgs)
+	inst.config = instanceConfig(p, agentCmd, agentArgs, agentEnv)
+	inst.config.Prompt = req.Prompt
+	if err := inst.startAgent(agentCmd, agentArgs, agentEnv, p.agentPipe()); err != nil {
 		setupErr = err
 		log.Printf("start failed: stage=agent-launch project=%s branch=%s instance=%s worktree=%s elapsed=%s err=%v",
 			req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), err)
-		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		respondStartFailure(conn, err.Error(), outputBuf.Bytes())
 		return
 	}
 
+	// A launched docker exec is not proof of a usable session: catch agents
+	// that fail straight away (bad args, missing credentials) so the user is
+	// not told the start succeeded and then attaches to a dead instance.
+	if window := p.agentStartupCheck(); window > 0 {
+		if exited, state, out := inst.exitedWithin(window, outputBuf.Len()); exited && startupFailed(state) {
+			setupErr = fmt.Errorf("agent exited during startup")
+			// ptyReader persisted the exit; the instance was never registered.
+			rollbacks = append(rollbacks, func() { os.Remove(filepath.Join(d.rootDir, "instances", instanceID+".json")) })
+			log.Printf("start failed: stage=agent-startup project=%s branch=%s instance=%s worktree=%s elapsed=%s state=%s",
+				req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), state)
+			respondStartFailure(conn, startupFailure(agentCmd, window, state, out), outputBuf.Bytes())
+			return
+		}
+	}
+
 	// All steps succeeded — register the instance and respond.
 	d.mu.Lock()
 	d.instances[instanceID] = inst
+	d.started++
 	d.mu.Unlock()
+	go d.superviseAgent(inst, p, agentCmd, agentArgs, agentEnv)
+	if req.Prompt != "" {
+		go inst.deliverPrompt(req.Prompt)
+	}
 
 	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
 
-	// Send the JSON ACK first, then stream any captured setup output.
-	respond(conn, proto.Response{OK: true, InstanceID: instanceID})
-	if outputBuf.Len() > 0 {
+	// Send the JSON ACK first, then stream any captured setup output.  The
+	// client may have stopped waiting (grove start --timeout); the instance
+	// is registered regardless and simply runs detached.
+	if err := respond(conn, proto.Response{OK: true, InstanceID: instanceID, Instances: []proto.InstanceInfo{inst.Info()}}); err != nil {
+		log.Printf("start: client gave up before instance %s was ready; it runs detached (%v)", instanceID, err)
+	} else if outputBuf.Len() > 0 {
 		conn.Write(outputBuf.Bytes())
 	}
 	log.Printf("start succeeded: project=%s branch=%s instance=%s worktree=%s elapsed=%s", req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond))
 }
 
+// setupContainer starts p's container for instance id with worktreeDir
+// bind-mounted inside it, at the image's own working directory unless
+// grove.yaml sets one, and readies it for agentCmd: Claude's config, the
+// start commands, the agent install.  Output goes to w.  On failure it
+// returns the stage that failed (container, start or agent-install) and
+// leaves no container behind.
+func setupContainer(p *Project, id, worktreeDir, agentCmd string, w io.Writer) (containerName, composeProject, stage string, err error) {
+	detectWorkdir(p, w)
+	containerName, err = startContainer(p, id, worktreeDir, w)
+	if err != nil {
+		return "", "", "container", err
+	}
+	if p.Container.Compose != "" {
+		composeProject = "grove-" + id
+	}
+
+	// Copy host's ~/.claude.json into the container so Claude starts with
+	// existing preferences/auth. This is a copy, not a bind mount, to avoid
+	// file corruption from concurrent writes by host and container Claude.
+	if p.Agent.Command == "claude" || p.Agent.Command == "" {
+		seedClaudeConfig(containerName)
+	}
+
+	if err := runStart(p, containerName, w); err != nil {
+		stopContainer(containerName, composeProject)
+		return "", "", "start", err
+	}
+	if err := ensureAgentInstalled(agentCmd, containerName, w); err != nil {
+		stopContainer(containerName, composeProject)
+		return "", "", "agent-install", err
+	}
+	return containerName, composeProject, "", nil
+}
+
+// errorResponse is the failed response for err, with the Code of the
+// failures clients tell apart.
+func errorResponse(err error) proto.Response {
+	resp := proto.Response{OK: false, Error: err.Error()}
+	var notFound *projectNotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		resp.Code = proto.CodeProjectNotFound
+	case dockerUnavailable(err.Error()):
+		resp.Code = proto.CodeNoEngine
+	}
+	return resp
+}
+
+// instanceNotFound is the response to a request naming an instance the
+// daemon does not have.
+func instanceNotFound(id string) proto.Response {
+	return proto.Response{OK: false, Code: proto.CodeInstanceNotFound, Error: proto.ErrInstanceNotFound + ": " + id}
+}
+
+// respondStartFailure sends a failed start response followed by the setup
+// output captured so far, the same way a successful start streams it, so the
+// client can show what went wrong without a trip to the daemon log.  A
+// failure that docker explains with there being no engine is marked as
+// such.
+func respondStartFailure(conn net.Conn, msg string, output []byte) {
+	resp := proto.Response{OK: false, Error: msg}
+	if dockerUnavailable(msg + "\n" + string(output)) {
+		resp.Code = proto.CodeNoEngine
+	}
+	respond(conn, resp)
+	if len(output) > 0 {
+		conn.Write(output)
+	}
+}
+
+// maxStartupOutput caps how much of a dead agent's output is echoed back in
+// the start error.
+const maxStartupOutput = 2048
+
+// startupFailure describes an agent that exited within the startup check
+// window, ending with the tail of whatever it printed.
+func startupFailure(agentCmd string, window time.Duration, state string, out []byte) string {
+	msg := fmt.Sprintf("agent %q exited within %s of launch (%s)", agentCmd, window, state)
+	if len(out) > maxStartupOutput {
+		out = out[len(out)-maxStartupOutput:]
+	}
+	if text := strings.TrimSpace(string(out)); text != "" {
+		msg += "; its output:\n" + text
+	}
+	return msg
+}
+
+// startupFailed reports whether an agent that exited during the startup
+// check, ending in state, fails the start.  A clean exit does not: that is
+// an agent that did its work quickly, not one that could not run.
+func startupFailed(state string) bool {
+	return state != proto.StateExited
+}
+
+// agentEnv builds the environment for the agent's docker exec session.
+// Lowest to highest precedence: repoEnv (grove.yaml's env_file, see
+// Project.loadRepoEnv), container.env_passthrough variables taken from the
+// daemon's own environment, the env file (global or the project's
+// credentials override), the env: block of grove.yaml, then request-level
+// values (from the CLI prompt, host env or --env-file).
+func (d *Daemon) agentEnv(p *Project, repoEnv, reqEnv map[string]string) map[string]string {
+	env := map[string]string{}
+	for k, v := range repoEnv {
+		env[k] = v
+	}
+	for _, name := range p.Container.EnvPassthrough {
+		if v, ok := os.LookupEnv(name); ok {
+			env[name] = v
+		}
+	}
+	for k, v := range envfile.Load(p.agentEnvFile(d.rootDir)) {
+		env[k] = v
+	}
+	for k, v := range p.Env {
+		env[k] = v
+	}
+	for k, v := range reqEnv {
+		env[k] = v
+	}
+	// The daemon's token may sit in the same env file; agents must not
+	// see it.
+	delete(env, proto.DaemonTokenVar)
+	return env
+}
+
+// instanceConfig records the configuration an instance is started with: the
+// container settings resolved the way startContainer applies them and the
+// agent command line.  Only the names of agentEnv are kept.
+func instanceConfig(p *Project, agentCmd string, agentArgs []string, agentEnv map[string]string) *proto.InstanceConfig {
+	c := proto.InstanceConfig{
+		Image:   p.Container.Image,
+		Workdir: p.containerWorkdir(),
+		Subdir:  p.Subdir,
+		Hidden:  hiddenPaths(p, io.Discard),
+	}
+	if p.Container.Compose != "" {
+		c.Image = ""
+		c.Compose = p.Container.Compose
+		c.Service = p.containerService()
+	}
+	for _, m := range buildMounts(p, io.Discard) {
+		c.Mounts = append(c.Mounts, m.volumeArg())
+	}
+	return withAgent(c, agentCmd, agentArgs, agentEnv, p.agentPipe())
+}
+
+// withAgent returns a copy of c describing the given agent command line.
+func withAgent(c proto.InstanceConfig, agentCmd string, agentArgs []string, agentEnv map[string]string, pipe bool) *proto.InstanceConfig {
+	c.AgentCommand = agentCmd
+	c.AgentArgs = append([]string(nil), agentArgs...)
+	c.AgentEnvKeys = nil
+	for k := range agentEnv {
+		c.AgentEnvKeys = append(c.AgentEnvKeys, k)
+	}
+	sort.Strings(c.AgentEnvKeys)
+	c.Pipe = pipe
+	return &c
+}
+
 func repoURLHintSuffix(repo string) string {
 	if strings.HasPrefix(repo, "github.com/") || strings.HasPrefix(repo, "gitlab.com/") || strings.HasPrefix(repo, "bitbucket.org/") {
 		return " hint=\"repo URL may be missing scheme; try https://host/org/repo.git or git@host:org/repo.git\""
@@ -206,6 +427,131 @@ func repoURLHintSuffix(repo string) string {
 	return ""
 }
 
+func (d *Daemon) handleCheckRepo(conn net.Conn, req proto.Request) {
+	p, err := loadProject(d.rootDir, req.Project)
+	if err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	if p.Repo == "" {
+		respond(conn, proto.Response{OK: false, Error: "project " + p.Name + " has no repo URL"})
+		return
+	}
+	if err := checkRepoAccess(p.Repo); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error() + repoURLHintSuffix(p.Repo)})
+		return
+	}
+	respond(conn, proto.Response{OK: true})
+}
+
+// handleReload pulls the project's main checkout so grove.yaml is the latest
+// committed version, then reports which sections changed and when each
+// change reaches the project's running instances.  Nothing is restarted.
+func (d *Daemon) handleReload(conn net.Conn, req proto.Request) {
+	before, err := loadProject(d.rootDir, req.Project)
+	if err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	if _, err := loadInRepoConfig(before); err != nil {
+		log.Printf("warning: could not read grove.yaml for %s: %v", req.Project, err)
+	}
+	respond(conn, proto.Response{OK: true})
+
+	if err := pullMain(before, conn); err != nil {
+		fmt.Fprintf(conn, "warning: %v; comparing the grove.yaml already checked out\n", err)
+	}
+	after, err := loadProject(d.rootDir, req.Project)
+	if err != nil {
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return
+	}
+	if found, err := loadInRepoConfig(after); err != nil {
+		fmt.Fprintf(conn, "error: %v\ncheck and finish will fail until it is fixed\n", err)
+		return
+	} else if !found {
+		fmt.Fprintf(conn, "error: no grove.yaml in %s\n", after.MainDir())
+		return
+	}
+
+	var active []string
+	d.mu.Lock()
+	for _, inst := range d.instances {
+		if inst.Project == req.Project && inst.Info().State != proto.StateFinished {
+			active = append(active, inst.ID)
+		}
+	}
+	d.mu.Unlock()
+	sort.Strings(active)
+	log.Printf("reload: project=%s instances=%v", req.Project, active)
+
+	changes := configChanges(before, after)
+	if len(changes) == 0 {
+		fmt.Fprintf(conn, "\ngrove.yaml unchanged\n")
+		return
+	}
+	fmt.Fprintf(conn, "\n")
+	for _, c := range changes {
+		fmt.Fprintf(conn, "%-14s changed, applies %s\n", c.section, c.applies)
+	}
+	if len(active) > 0 {
+		fmt.Fprintf(conn, "\ninstances of %s: %s\n", req.Project, strings.Join(active, ", "))
+	}
+}
+
+// handleWarm pulls the project's main checkout, then the image(s) its
+// grove.yaml names, streaming docker's progress, so that the next start does
+// not wait on the download.
+func (d *Daemon) handleWarm(conn net.Conn, req proto.Request) {
+	p, err := loadProject(d.rootDir, req.Project)
+	if err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	respond(conn, proto.Response{OK: true, Framed: req.Framed})
+
+	rw := newResilientWriter(conn, nil)
+	rw.framed = req.Framed
+	if err := pullMain(p, rw); err != nil {
+		fmt.Fprintf(rw, "warning: %v; using the grove.yaml already checked out\n", err)
+	}
+	if _, err := loadInRepoConfig(p); err != nil {
+		rw.writeResult(proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	if err := warmImages(p, rw); err != nil {
+		rw.writeResult(proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	log.Printf("warm: project=%s images pulled", req.Project)
+	rw.writeResult(proto.Response{OK: true})
+}
+
+// worktreeGone returns the error to report for an operation on inst whose
+// worktree was removed outside grove, or "" if the worktree is intact.  The
+// container's bind mount still points at the deleted directory, so neither
+// restarting the agent nor recreating the directory brings it back.
+func worktreeGone(inst *Instance) string {
+	err := checkWorktree(inst.WorktreeDir)
+	if err == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v (deleted outside grove?); drop this instance with: grove drop %s", err, inst.ID)
+}
+
+// containerGone returns the error to report for an operation that needs the
+// container of inst after grove stop --container removed it, or "" if the
+// container is still there.
+func containerGone(inst *Instance) string {
+	inst.mu.Lock()
+	stopped := inst.containerStopped
+	inst.mu.Unlock()
+	if !stopped {
+		return ""
+	}
+	return fmt.Sprintf("instance %s has no container (grove stop --container); bring it back with: grove restart --fresh %s", inst.ID, inst.ID)
+}
+
 func (d *Daemon) handleList(conn net.Conn) {
 	d.mu.Lock()
 	infos := make([]proto.InstanceInfo, 0, len(d.instances))
@@ -214,6 +560,10 @@ func (d *Daemon) handleList(conn net.Conn) {
 	}
 	d.mu.Unlock()
 
+	for i := range infos {
+		infos[i].WorktreeMissing = infos[i].WorktreeDir != "" && checkWorktree(infos[i].WorktreeDir) != nil
+	}
+
 	sort.Slice(infos, func(i, j int) bool {
 		return infos[i].CreatedAt < infos[j].CreatedAt
 	})
@@ -224,30 +574,61 @@ func (d *Daemon) handleList(conn net.Conn) {
 func (d *Daemon) handleAttach(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
-		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		respond(conn, instanceNotFound(req.InstanceID))
 		return
 	}
 
 	inst.mu.Lock()
 	state := inst.state
+	pipe := inst.pipe
 	inst.mu.Unlock()
 
 	if proto.IsTerminal(state) {
 		respond(conn, proto.Response{OK: false, Error: "instance has " + strings.ToLower(state)})
 		return
 	}
+	if pipe {
+		respond(conn, proto.Response{OK: false, Error: "instance " + req.InstanceID + " runs its agent without a terminal (agent.pty: false); follow it with: grove logs -f " + req.InstanceID})
+		return
+	}
+	if state == proto.StateAttached && !req.Takeover {
+		respond(conn, proto.Response{OK: false, Error: "instance " + req.InstanceID + " is already attached from another client; take it over with: grove attach --takeover " + req.InstanceID})
+		return
+	}
 
-	// Send the handshake ACK before entering streaming mode.
-	respond(conn, proto.Response{OK: true})
+	// Send the handshake ACK before entering streaming mode.  The instance
+	// info lets the client label the session (e.g. the terminal title).
+	respond(conn, proto.Response{OK: true, Instances: []proto.InstanceInfo{inst.Info()}})
 
 	// Attach blocks until the client detaches or the agent exits.
-	inst.Attach(conn)
+	inst.Attach(conn, AttachOptions{DetachOnIdle: req.Once, Takeover: req.Takeover})
 }
 
 func (d *Daemon) handleLogs(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
-		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+
+	if req.MergeSetup {
+		if inst.timeline.empty() {
+			respond(conn, proto.Response{OK: false, Error: "no timestamped output for instance " + req.InstanceID + " (it is recorded only while the daemon that started the agent is running)"})
+			return
+		}
+		respond(conn, proto.Response{OK: true, InstanceID: req.InstanceID})
+		inst.timeline.render(conn)
+		return
+	}
+
+	if req.AllRuns {
+		logs, err := os.ReadFile(inst.LogFile)
+		if err != nil {
+			respond(conn, proto.Response{OK: false, Error: "no log file for instance " + req.InstanceID + ": " + err.Error()})
+			return
+		}
+		respond(conn, proto.Response{OK: true, InstanceID: req.InstanceID})
+		conn.Write(tailBytes(logs, req.TailBytes))
 		return
 	}
 
@@ -257,13 +638,68 @@ func (d *Daemon) handleLogs(conn net.Conn, req proto.Request) {
 	inst.mu.Unlock()
 
 	respond(conn, proto.Response{OK: true, InstanceID: req.InstanceID})
-	conn.Write(logs)
+	conn.Write(tailBytes(logs, req.TailBytes))
+}
+
+// tailBytes returns about the last n bytes of b, or all of b when n <= 0 or
+// b is shorter.  The cut is moved forward, best-effort, so the result does
+// not begin partway through a UTF-8 character or a terminal escape sequence.
+func tailBytes(b []byte, n int) []byte {
+	if n <= 0 || n >= len(b) {
+		return b
+	}
+	start := len(b) - n
+	// An escape sequence is short; look back a little for an ESC whose
+	// sequence runs past the cut.
+	for i := start - 1; i >= 0 && i >= start-32; i-- {
+		if b[i] != 0x1b {
+			continue
+		}
+		if end := escapeEnd(b, i); end > start {
+			start = end
+		}
+		break
+	}
+	for start < len(b) && !utf8.RuneStart(b[start]) {
+		start++
+	}
+	return b[start:]
+}
+
+// escapeEnd returns the index just past the escape sequence that begins at
+// b[i] (an ESC).  CSI sequences (ESC [) end at a byte in 0x40–0x7e; OSC
+// sequences (ESC ]) at BEL or ESC \; anything else is two bytes long.  An
+// unterminated sequence ends at len(b).
+func escapeEnd(b []byte, i int) int {
+	if i+1 >= len(b) {
+		return len(b)
+	}
+	switch b[i+1] {
+	case '[':
+		for j := i + 2; j < len(b); j++ {
+			if b[j] >= 0x40 && b[j] <= 0x7e {
+				return j + 1
+			}
+		}
+		return len(b)
+	case ']':
+		for j := i + 2; j < len(b); j++ {
+			if b[j] == 0x07 {
+				return j + 1
+			}
+			if b[j] == 0x1b && j+1 < len(b) && b[j+1] == '\\' {
+				return j + 2
+			}
+		}
+		return len(b)
+	}
+	return i + 2
 }
 
 func (d *Daemon) handleLogsFollow(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
-		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		respond(conn, instanceNotFound(req.InstanceID))
 		return
 	}
 	respond(conn, proto.Response{OK: true})
@@ -274,6 +710,7 @@ func (d *Daemon) handleLogsFollow(conn net.Conn, req proto.Request) {
 	copy(initial, inst.logBuf)
 	offset := len(inst.logBuf)
 	inst.mu.Unlock()
+	initial = tailBytes(initial, req.TailBytes)
 
 	if len(initial) > 0 {
 		if _, err := conn.Write(initial); err != nil {
@@ -312,22 +749,149 @@ func (d *Daemon) handleLogsFollow(conn net.Conn, req proto.Request) {
 func (d *Daemon) handleStop(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
-		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+
+	inst.mu.Lock()
+	processDone := inst.processDone
+	finishing := inst.finishing
+	inst.mu.Unlock()
+	if req.Container && finishing {
+		respond(conn, proto.Response{OK: false, Error: "cannot stop the container: instance " + req.InstanceID + " is finishing"})
 		return
 	}
 
 	// Kill the agent process if it is running; ptyReader will transition
 	// the state to CRASHED and persist it.  For already-dead instances
-	// (EXITED/CRASHED/FINISHED) this is a no-op.
+	// (EXITED/CRASHED/FINISHED) this is a no-op.  An attached client is
+	// told why first rather than just losing its session.
+	inst.evictClient(stopNotice)
 	inst.destroy()
 
-	respond(conn, proto.Response{OK: true})
+	// With Wait, block until ptyReader has recorded the terminal state so
+	// the caller can rely on the agent being gone.  processDone is nil for
+	// instances reloaded from disk, which are already dead.
+	if (req.Wait || req.Snapshot || req.Container) && processDone != nil {
+		select {
+		case <-processDone:
+		case <-time.After(stopWaitTimeout):
+			respond(conn, proto.Response{OK: false, Error: fmt.Sprintf("timed out after %s waiting for instance %s to stop", stopWaitTimeout, req.InstanceID)})
+			return
+		}
+	}
+
+	if req.Container {
+		inst.mu.Lock()
+		stopped := inst.containerStopped
+		inst.containerStopped = true
+		inst.mu.Unlock()
+		if !stopped {
+			stopContainer(inst.ContainerID, inst.ComposeProject)
+			log.Printf("instance %s: container stopped and removed; worktree kept", inst.ID)
+		}
+		inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	}
+
+	resp := proto.Response{OK: true}
+	if req.Snapshot {
+		screen, err := inst.snapshot()
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		resp.Screen = screen
+	}
+	respond(conn, resp)
+}
+
+// handleSnapshot returns the agent's current terminal screen, or for an
+// agent that has ended, the screen it left behind.
+func (d *Daemon) handleSnapshot(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+	screen, err := inst.snapshot()
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	respond(conn, proto.Response{OK: true, Screen: screen})
+}
+
+// handleExec runs req.Command in the instance's container for grove exec,
+// streaming its output as frames.  Unlike a check it leaves the instance's
+// state alone and needs nothing in grove.yaml.
+func (d *Daemon) handleExec(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+	if strings.TrimSpace(req.Command) == "" {
+		respond(conn, proto.Response{OK: false, Error: "no command given"})
+		return
+	}
+	if msg := containerGone(inst); msg != "" {
+		respond(conn, proto.Response{OK: false, Error: msg})
+		return
+	}
+	inst.mu.Lock()
+	state := inst.state
+	inst.mu.Unlock()
+	if proto.IsTerminal(state) {
+		respond(conn, proto.Response{OK: false, Error: fmt.Sprintf("cannot exec: instance %s is %s; grove restart %s first", inst.ID, state, inst.ID)})
+		return
+	}
+
+	// The command sees grove.yaml's env: as start, check and finish
+	// commands do.
+	var env map[string]string
+	if p, err := loadProject(d.rootDir, inst.Project); err == nil {
+		if _, err := loadInRepoConfig(p); err != nil {
+			log.Printf("warning: could not read grove.yaml for %s: %v", inst.Project, err)
+		}
+		env = p.Env
+	}
+
+	respond(conn, proto.Response{OK: true, Framed: true})
+	// The client sends nothing more; a read returning means it has gone
+	// (grove exec interrupted), so end the docker exec rather than let it
+	// run unseen.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+	rw := newResilientWriter(conn, nil)
+	rw.framed = true
+	err := execInContainerSplit(ctx, inst.ContainerID, req.Command, env, rw, rw.stderr())
+	result := proto.Response{OK: err == nil}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		result.Error = fmt.Sprintf("command exited with status %d", result.ExitCode)
+	case err != nil:
+		result.Error = err.Error()
+	}
+	rw.writeResult(result)
 }
 
 func (d *Daemon) handleDrop(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
-		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+
+	inst.mu.Lock()
+	finishing := inst.finishing
+	inst.mu.Unlock()
+	if finishing {
+		respond(conn, proto.Response{OK: false, Error: "cannot drop: instance " + req.InstanceID + " is finishing"})
 		return
 	}
 
@@ -337,9 +901,26 @@ func (d *Daemon) handleDrop(conn net.Conn, req proto.Request) {
 	composeProject := inst.ComposeProject
 	projectName := inst.Project
 
-	// Kill the docker exec session (container keeps running until stopContainer).
+	// Detach any client cleanly, then kill the docker exec session
+	// (container keeps running until stopContainer).
+	inst.evictClient(dropNotice)
 	inst.destroy()
 
+	// Wait for ptyReader to record the exit: its final persistMeta must land
+	// before the record is removed below, or it would bring the instance
+	// back on the next daemon start.
+	inst.mu.Lock()
+	processDone := inst.processDone
+	inst.mu.Unlock()
+	if processDone != nil {
+		select {
+		case <-processDone:
+		case <-time.After(stopWaitTimeout):
+			respond(conn, proto.Response{OK: false, Error: fmt.Sprintf("timed out after %s waiting for instance %s to stop", stopWaitTimeout, req.InstanceID)})
+			return
+		}
+	}
+
 	// Stop and remove the container (or compose stack).
 	stopContainer(containerID, composeProject)
 
@@ -365,7 +946,15 @@ func (d *Daemon) handleDrop(conn net.Conn, req proto.Request) {
 func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
-		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+	if msg := worktreeGone(inst); msg != "" {
+		respond(conn, proto.Response{OK: false, Error: msg})
+		return
+	}
+	if msg := containerGone(inst); msg != "" {
+		respond(conn, proto.Response{OK: false, Error: msg})
 		return
 	}
 
@@ -373,7 +962,55 @@ func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
 	branch := inst.Branch
 	projectName := inst.Project
 
+	p, projectErr := loadProject(d.rootDir, projectName)
+	if projectErr == nil {
+		if _, err := loadInRepoConfig(p); err != nil {
+			log.Printf("warning: could not read grove.yaml for %s: %v", projectName, err)
+		}
+	}
+	defaultBranch := ""
+	if p != nil {
+		defaultBranch = p.DefaultBranch
+	}
+
+	// Finishing a branch the agent never committed to would only push an
+	// empty branch (and open an empty PR), so refuse while the agent can
+	// still be asked to do the work.
+	if !req.AllowEmpty && inst.Info().State != proto.StateFinished {
+		if n, base, err := commitsAhead(worktreeDir, defaultBranch); err != nil {
+			log.Printf("instance %s: cannot count commits before finish: %v", inst.ID, err)
+		} else if n == 0 {
+			msg := fmt.Sprintf("%s has no commits ahead of %s; nothing to finish", branch, base)
+			if worktreeDirty(worktreeDir) {
+				msg += " (the worktree has uncommitted changes)"
+			}
+			respond(conn, proto.Response{OK: false, Error: msg + "; use --allow-empty to finish anyway"})
+			return
+		}
+	}
+
+	var finishCmds []string
+	if p != nil {
+		finishCmds = p.Finish
+	}
+	target, err := finishTarget(worktreeDir, req.Target, defaultBranch, finishCmds)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
 	inst.mu.Lock()
+	if inst.finishing {
+		inst.mu.Unlock()
+		respond(conn, proto.Response{OK: false, Error: "instance " + req.InstanceID + " is already finishing"})
+		return
+	}
+	inst.finishing = true
+	defer func() {
+		inst.mu.Lock()
+		inst.finishing = false
+		inst.mu.Unlock()
+	}()
 	state := inst.state
 	switch state {
 	case proto.StateExited, proto.StateCrashed, proto.StateKilled:
@@ -403,14 +1040,10 @@ func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
 	// Send ACK — instance is now FINISHED regardless of what complete commands do.
 	respond(conn, proto.Response{OK: true, WorktreeDir: worktreeDir, Branch: branch})
 
-	p, err := loadProject(d.rootDir, projectName)
-	if err != nil {
-		fmt.Fprintf(conn, "warning: could not load project to run finish commands: %v\n", err)
+	if projectErr != nil {
+		fmt.Fprintf(conn, "warning: could not load project to run finish commands: %v\n", projectErr)
 		return
 	}
-	if _, err := loadInRepoConfig(p); err != nil {
-		log.Printf("warning: could not read grove.yaml for %s: %v", projectName, err)
-	}
 	if len(p.Finish) == 0 {
 		return
 	}
@@ -425,14 +1058,16 @@ func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
 	// w writes to both the connection and the log file.  If the client
 	// disconnects, writes to conn are silently dropped but the log keeps
 	// receiving output and commands run to completion.
-	w := newResilientWriter(conn, logFd)
+	w := io.MultiWriter(newResilientWriter(conn, logFd), inst.timeline.writer(sourceFinish))
 
 	containerID := inst.ContainerID
 
+	vars := strings.NewReplacer("{{branch}}", branch, "{{target}}", target)
+
 	for _, cmdStr := range p.Finish {
-		expanded := strings.ReplaceAll(cmdStr, "{{branch}}", branch)
+		expanded := vars.Replace(cmdStr)
 		fmt.Fprintf(w, "$ %s\n", expanded)
-		if err := execInContainer(containerID, expanded, w); err != nil {
+		if err := execInContainer(context.Background(), containerID, expanded, p.Env, w); err != nil {
 			fmt.Fprintf(w, "error: command failed: %v\n", err)
 			log.Printf("instance %s: finish command failed: %v", inst.ID, err)
 			return
@@ -440,10 +1075,47 @@ func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
 	}
 }
 
+// shellWord matches names that a shell takes literally as one word.
+var shellWord = regexp.MustCompile(`^[A-Za-z0-9._/+@,=-]+$`)
+
+// finishTarget returns the branch {{target}} expands to in a finish command:
+// target when given, else the default branch.  It is substituted into shell
+// commands unquoted, so it must be a valid branch name that is also a single
+// literal shell word.  When no default branch can be found it is "", which
+// is an error only if one of finishCmds uses {{target}}.
+func finishTarget(dir, target, defaultBranch string, finishCmds []string) (string, error) {
+	if target == "" {
+		target = defaultBranchName(dir, defaultBranch)
+	}
+	if target == "" {
+		for _, c := range finishCmds {
+			if strings.Contains(c, "{{target}}") {
+				return "", fmt.Errorf("cannot find the default branch for {{target}} in the finish commands; pass --target <branch> or set default_branch")
+			}
+		}
+		return "", nil
+	}
+	if err := gitref.CheckBranchName(dir, target); err != nil {
+		return "", err
+	}
+	if !shellWord.MatchString(target) {
+		return "", fmt.Errorf("invalid branch name %q: finish commands only take letters, digits and . _ / + @ , = -", target)
+	}
+	return target, nil
+}
+
 func (d *Daemon) handleCheck(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
-		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+	if msg := worktreeGone(inst); msg != "" {
+		respond(conn, proto.Response{OK: false, Error: msg})
+		return
+	}
+	if msg := containerGone(inst); msg != "" {
+		respond(conn, proto.Response{OK: false, Error: msg})
 		return
 	}
 
@@ -469,7 +1141,7 @@ func (d *Daemon) handleCheck(conn net.Conn, req proto.Request) {
 
 	p, err := loadProject(d.rootDir, projectName)
 	if err != nil {
-		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		respond(conn, errorResponse(err))
 		return
 	}
 	if _, err := loadInRepoConfig(p); err != nil {
@@ -479,37 +1151,101 @@ func (d *Daemon) handleCheck(conn net.Conn, req proto.Request) {
 		respond(conn, proto.Response{OK: false, Error: "no check commands defined in grove.yaml"})
 		return
 	}
+	groups, err := p.Check.selectGroups(req.CheckOnly, req.CheckSkip)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
 
-	respond(conn, proto.Response{OK: true})
+	respond(conn, proto.Response{OK: true, Framed: req.Framed})
 
 	logFd, _ := os.OpenFile(inst.LogFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
 	if logFd != nil {
 		defer logFd.Close()
 	}
 
-	w := newResilientWriter(conn, logFd)
+	rw := newResilientWriter(conn, logFd)
+	rw.framed = req.Framed
+	w := io.MultiWriter(rw, inst.timeline.writer(sourceCheck))
 
 	containerID := inst.ContainerID
+	timeout := p.checkTimeout()
 
+	// Unnamed groups (the list form of check:) hold a single command each and
+	// are printed unlabelled, as before groups existed.
+	failed := make([]bool, len(groups))
 	var wg sync.WaitGroup
-	for _, cmdStr := range p.Check {
+	for i, g := range groups {
 		wg.Add(1)
-		go func(cmd string) {
+		go func(i int, g CheckGroup) {
 			defer wg.Done()
-			fmt.Fprintf(w, "$ %s\n", cmd)
-			if err := execInContainer(containerID, cmd, w); err != nil {
-				fmt.Fprintf(w, "error: check command failed: %v\n", err)
-				log.Printf("instance %s: check command %q failed: %v", inst.ID, cmd, err)
+			var gw io.Writer = w
+			if g.Name != "" {
+				pw := &prefixWriter{w: w, prefix: "[" + g.Name + "] "}
+				defer pw.Flush()
+				gw = pw
+			}
+			for _, cmd := range g.Commands {
+				fmt.Fprintf(gw, "$ %s\n", cmd)
+				if err := runCheckCommand(containerID, cmd, p.Env, timeout, gw); err != nil {
+					if errors.Is(err, context.DeadlineExceeded) {
+						fmt.Fprintf(gw, "error: check command timed out after %s and was killed (check_timeout in grove.yaml)\n", timeout)
+					} else {
+						fmt.Fprintf(gw, "error: check command failed: %v\n", err)
+					}
+					log.Printf("instance %s: check command %q failed: %v", inst.ID, cmd, err)
+					failed[i] = true
+					return
+				}
 			}
-		}(cmdStr)
+		}(i, g)
 	}
 	wg.Wait()
+
+	if groups.named() {
+		fmt.Fprintln(w)
+		for i, g := range groups {
+			result := "passed"
+			if failed[i] {
+				result = "FAILED"
+			}
+			fmt.Fprintf(w, "%-12s %s\n", g.Name, result)
+		}
+	}
+
+	nFailed := 0
+	for _, f := range failed {
+		if f {
+			nFailed++
+		}
+	}
+	result := proto.Response{OK: nFailed == 0}
+	if nFailed > 0 {
+		result.Error = fmt.Sprintf("%d of %d checks failed", nFailed, len(groups))
+	}
+	rw.writeResult(result)
+}
+
+// runCheckCommand runs one check command in the container with env set,
+// killing it after timeout unless that is 0.
+func runCheckCommand(containerID, cmd string, env map[string]string, timeout time.Duration, w io.Writer) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return execInContainer(ctx, containerID, cmd, env, w)
 }
 
 func (d *Daemon) handleRestart(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
-		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+	if msg := worktreeGone(inst); msg != "" {
+		respond(conn, proto.Response{OK: false, Error: msg})
 		return
 	}
 
@@ -524,7 +1260,7 @@ func (d *Daemon) handleRestart(conn net.Conn, req proto.Request) {
 
 	p, err := loadProject(d.rootDir, inst.Project)
 	if err != nil {
-		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		respond(conn, errorResponse(err))
 		return
 	}
 
@@ -537,25 +1273,300 @@ func (d *Daemon) handleRestart(conn net.Conn, req proto.Request) {
 		agentCmd = "sh"
 	}
 
-	// Reset mutable state before restarting.
+	inst.mu.Lock()
+	prompt := ""
+	if inst.config != nil {
+		prompt = inst.config.Prompt
+	}
+	inst.mu.Unlock()
+	if req.ResendPrompt {
+		switch {
+		case prompt == "":
+			respond(conn, proto.Response{OK: false, Error: "cannot resend prompt: instance " + inst.ID + " was not started with --prompt"})
+			return
+		case p.agentPipe():
+			respond(conn, proto.Response{OK: false, Error: "cannot resend prompt: the agent runs without a terminal (agent.pty is false in grove.yaml)"})
+			return
+		}
+	}
+
+	if req.Fresh {
+		if err := d.recreateContainer(inst, p, agentCmd); err != nil {
+			respond(conn, errorResponse(err))
+			return
+		}
+	} else if msg := containerGone(inst); msg != "" {
+		respond(conn, proto.Response{OK: false, Error: msg})
+		return
+	}
+
+	// Reset mutable state before restarting.  What the user set on the
+	// instance — its branch, annotations and --prompt — is left alone;
+	// startAgent clears the last run's exit reason.
 	inst.mu.Lock()
 	inst.endedAt = time.Time{}
 	inst.finishRequest = false
 	inst.killed = false
+	inst.restarts = 0
+	inst.logBuf = inst.logBuf[:0] // clear stale output from prior runs
 	inst.mu.Unlock()
 
-	agentEnv := envfile.Load(filepath.Join(d.rootDir, "env"))
-	for k, v := range req.AgentEnv {
-		agentEnv[k] = v
+	repoEnv, err := p.loadRepoEnv(inst.WorktreeDir)
+	if err != nil {
+		log.Printf("warning: instance %s: %v", inst.ID, err)
+		inst.recordSetup(fmt.Sprintf("warning: %v; starting without it\r\n", err))
 	}
+	agentEnv := d.agentEnv(p, repoEnv, req.AgentEnv)
 	logAgentCredentials(inst.ID, agentEnv)
 
-	if err := inst.startAgent(agentCmd, p.Agent.Args, agentEnv); err != nil {
+	agentArgs := p.agentArgs(agentCmd, req.AgentArgs)
+	// Unless the container was just recreated it is unchanged; only the
+	// agent command line follows the reloaded grove.yaml.
+	inst.mu.Lock()
+	if req.Fresh {
+		inst.config = instanceConfig(p, agentCmd, agentArgs, agentEnv)
+		inst.config.Prompt = prompt
+	} else if inst.config != nil {
+		inst.config = withAgent(*inst.config, agentCmd, agentArgs, agentEnv, p.agentPipe())
+	}
+	inst.mu.Unlock()
+	if err := inst.startAgent(agentCmd, agentArgs, agentEnv, p.agentPipe()); err != nil {
 		respond(conn, proto.Response{OK: false, Error: err.Error()})
 		return
 	}
+	go d.superviseAgent(inst, p, agentCmd, agentArgs, agentEnv)
 
 	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
 
-	respond(conn, proto.Response{OK: true})
+	switch {
+	case req.ResendPrompt && req.WaitReady:
+		// The prompt is sent once the agent is ready, so the reply waits
+		// for that too.
+		if err := inst.sendPrompt(prompt); err != nil {
+			respond(conn, proto.Response{OK: false, Error: "resend prompt: " + err.Error()})
+			return
+		}
+	case req.ResendPrompt:
+		go inst.deliverPrompt(prompt)
+	case req.WaitReady:
+		// An agent still printing after maxReadyWait is taken to be ready.
+		if err := inst.waitReady(maxReadyWait); err != nil && !errors.Is(err, errStillBusy) {
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+	}
+
+	respond(conn, proto.Response{OK: true, Instances: []proto.InstanceInfo{inst.Info()}})
+}
+
+// recreateContainer replaces inst's container, or the one grove stop
+// --container removed, with a new one set up by setupContainer as for
+// handleStart, from the current grove.yaml.  Its output goes to the
+// instance log.  On failure the instance is left without a container.
+func (d *Daemon) recreateContainer(inst *Instance, p *Project, agentCmd string) error {
+	logFd := inst.openLog()
+	if logFd != nil {
+		defer logFd.Close()
+	}
+	w := inst.timeline.writer(sourceSetup)
+	if logFd != nil {
+		w = io.MultiWriter(w, logFd)
+	}
+
+	inst.mu.Lock()
+	stopped := inst.containerStopped
+	inst.containerStopped = true
+	inst.mu.Unlock()
+	if !stopped {
+		stopContainer(inst.ContainerID, inst.ComposeProject)
+	}
+
+	containerName, composeProject, stage, err := setupContainer(p, inst.ID, inst.WorktreeDir, agentCmd, w)
+	if err != nil {
+		inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+		log.Printf("instance %s: fresh restart failed: stage=%s err=%v", inst.ID, stage, err)
+		return fmt.Errorf("recreate container: %v (output: grove logs --all-runs %s)", err, inst.ID)
+	}
+
+	inst.mu.Lock()
+	inst.ContainerID = containerName
+	inst.ComposeProject = composeProject
+	inst.containerStopped = false
+	inst.mu.Unlock()
+	log.Printf("instance %s: container recreated", inst.ID)
+	return nil
+}
+
+// maxReadyWait bounds how long ReqRestart with WaitReady waits for an agent
+// to settle, and how long waitReady waits for one that prints nothing; one
+// that stays up that long is taken to be ready.
+const maxReadyWait = 10 * time.Second
+
+// superviseAgent waits for the agent session just started on inst to end and,
+// if it crashed and the project's agent.restart policy allows another
+// attempt, relaunches it the way handleRestart does after a backoff.  Clean
+// exits, kills (stop/drop) and finishes end in other states and are left
+// alone.  Past the limit the instance stays CRASHED until restarted by hand,
+// which resets the count.
+func (d *Daemon) superviseAgent(inst *Instance, p *Project, agentCmd string, agentArgs []string, agentEnv map[string]string) {
+	inst.mu.Lock()
+	done := inst.processDone
+	inst.mu.Unlock()
+	<-done
+
+	inst.mu.Lock()
+	crashed := inst.state == proto.StateCrashed
+	attempt := inst.restarts + 1
+	inst.mu.Unlock()
+	if !crashed || attempt > p.agentMaxRestarts() {
+		return
+	}
+
+	delay := restartBackoff(attempt)
+	log.Printf("instance %s: agent crashed; restart %d/%d in %s", inst.ID, attempt, p.agentMaxRestarts(), delay)
+	time.Sleep(delay)
+
+	// Bail if the instance was dropped or restarted by hand in the meantime.
+	if d.getInstance(inst.ID) != inst {
+		return
+	}
+	inst.mu.Lock()
+	if inst.state != proto.StateCrashed || inst.processDone != done {
+		inst.mu.Unlock()
+		return
+	}
+	inst.endedAt = time.Time{}
+	inst.restarts = attempt
+	inst.mu.Unlock()
+
+	if err := inst.startAgent(agentCmd, agentArgs, agentEnv, p.agentPipe()); err != nil {
+		log.Printf("instance %s: automatic restart failed: %v", inst.ID, err)
+		return
+	}
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	d.superviseAgent(inst, p, agentCmd, agentArgs, agentEnv)
+}
+
+// restartBackoff returns the delay before automatic restart number attempt
+// (1-based): 2s, 4s, 8s, … capped at 30s.
+func restartBackoff(attempt int) time.Duration {
+	delay := 2 * time.Second
+	for i := 1; i < attempt && delay < 30*time.Second; i++ {
+		delay *= 2
+	}
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	return delay
+}
+
+func (d *Daemon) handleAnnotate(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+
+	inst.annotate(req.Annotations)
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+
+	respond(conn, proto.Response{OK: true, Instances: []proto.InstanceInfo{inst.Info()}})
+}
+
+// handleInstanceConfig returns the configuration recorded when the instance
+// was started (and updated by restarts), which may differ from the project's
+// current grove.yaml.
+func (d *Daemon) handleInstanceConfig(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+	config := inst.recordedConfig()
+	if config == nil {
+		respond(conn, proto.Response{OK: false, Error: "no recorded config for instance " + inst.ID + " (started by an older groved)"})
+		return
+	}
+	respond(conn, proto.Response{OK: true, Instances: []proto.InstanceInfo{inst.Info()}, InstanceConfig: config})
+}
+
+// handleMove renames an instance's branch.  Worktrees are named after the
+// instance ID rather than the branch, so the worktree path, and with it the
+// container's bind mount, is unaffected.
+func (d *Daemon) handleMove(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, instanceNotFound(req.InstanceID))
+		return
+	}
+	if req.Branch == "" {
+		respond(conn, proto.Response{OK: false, Error: "new branch name is required"})
+		return
+	}
+
+	inst.mu.Lock()
+	finishing := inst.finishing
+	oldBranch := inst.Branch
+	inst.mu.Unlock()
+	if finishing {
+		respond(conn, proto.Response{OK: false, Error: "cannot rename: instance " + req.InstanceID + " is finishing"})
+		return
+	}
+	if req.Branch == oldBranch {
+		respond(conn, proto.Response{OK: false, Error: "instance " + req.InstanceID + " is already on " + oldBranch})
+		return
+	}
+	if owner := d.instanceOnBranch(inst.Project, req.Branch); owner != "" {
+		respond(conn, proto.Response{OK: false, Error: req.Branch + " is already used by instance " + owner})
+		return
+	}
+
+	if err := renameBranch(inst.WorktreeDir, oldBranch, req.Branch); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	d.mu.Lock()
+	inst.mu.Lock()
+	inst.Branch = req.Branch
+	inst.mu.Unlock()
+	d.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	log.Printf("instance %s: branch renamed %s -> %s", inst.ID, oldBranch, req.Branch)
+
+	respond(conn, proto.Response{OK: true, Instances: []proto.InstanceInfo{inst.Info()}})
+}
+
+func (d *Daemon) handleMetrics(conn net.Conn) {
+	m := d.metrics()
+	m.DockerReachable = validateDocker() == nil
+	respond(conn, proto.Response{OK: true, Metrics: &m})
+}
+
+// metrics aggregates everything in proto.Metrics except DockerReachable,
+// which needs a docker round trip.
+func (d *Daemon) metrics() proto.Metrics {
+	d.mu.Lock()
+	insts := make([]*Instance, 0, len(d.instances))
+	for _, inst := range d.instances {
+		insts = append(insts, inst)
+	}
+	m := proto.Metrics{
+		UptimeSeconds:    int64(time.Since(d.startedAt).Seconds()),
+		Instances:        len(insts),
+		InstancesStarted: d.started,
+		States:           make(map[string]int),
+	}
+	d.mu.Unlock()
+
+	for _, inst := range insts {
+		m.States[inst.Info().State]++
+		inst.mu.Lock()
+		m.LogBytes += int64(len(inst.logBuf))
+		if inst.attachedConn != nil {
+			m.AttachSessions++
+		}
+		inst.mu.Unlock()
+	}
+	return m
 }
@@ -24,8 +24,11 @@ package daemon
 //  └──────────────────────────────┘
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -33,11 +36,15 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/creack/pty"
+	"github.com/gandalfthegui/grove/internal/engine"
 	"github.com/gandalfthegui/grove/internal/proto"
 )
 
@@ -47,12 +54,18 @@ const (
 	// waitingIdleThreshold is how long an agent must produce no PTY output
 	// before its state is promoted from RUNNING to WAITING.
 	waitingIdleThreshold = 2 * time.Second
+
+	// readyQuietPeriod is how long an agent must stay silent after its first
+	// output before waitReady considers it booted.
+	readyQuietPeriod = 500 * time.Millisecond
 )
 
 // Instance represents one running (or stopped) agent session.
 type Instance struct {
-	// Immutable after creation.
+	// Immutable after creation, except Branch, which grove mv changes while
+	// holding both Daemon.mu and mu.
 	ID             string
+	Name           string // label from grove start --name; "" if none
 	Project        string
 	Branch         string
 	WorktreeDir    string
@@ -65,12 +78,13 @@ type Instance struct {
 	mu             sync.Mutex
 	state          string
 	pid            int
-	ptm            *os.File     // PTY master; nil after process exits
-	logBuf         []byte       // rolling in-memory copy of recent output
-	lastOutputTime time.Time    // last time the PTY produced output
-	endedAt        time.Time    // when the process exited; zero if still running
-	attachedConn   net.Conn     // non-nil while a client is attached
-	attachDone     chan struct{} // closed when the current attach session ends
+	ptm            *os.File          // PTY master; nil after process exits
+	logBuf         []byte            // rolling in-memory copy of recent output
+	lastOutputTime time.Time         // last time the PTY produced output
+	endedAt        time.Time         // when the process exited; zero if still running
+	attachedConn   net.Conn          // non-nil while a client is attached
+	attachDone     chan struct{}     // closed when the current attach session ends
+	annotations    map[string]string // user key/value notes; see ReqAnnotate
 
 	// InstancesDir is set so ptyReader can persist state changes on exit.
 	InstancesDir string
@@ -82,6 +96,42 @@ type Instance struct {
 	killed bool
 	// processDone is closed by ptyReader when the agent process fully exits.
 	processDone chan struct{}
+	// pipe is true when the agent runs without a PTY (agent.pty: false);
+	// such an instance cannot be attached.
+	pipe bool
+	// timeline records timestamped output from every source for
+	// grove logs --merge-setup.  Set at creation; has its own lock.
+	timeline *timeline
+	// finishing is true while handleFinish is running finish commands, so a
+	// concurrent finish or drop cannot run push/PR side effects twice.
+	finishing bool
+	// restarts counts automatic crash restarts since the last manual start;
+	// see superviseAgent.
+	restarts int
+	// runs counts launches of the agent; see writeRunMarker.
+	runs int
+	// containerStopped is true after grove stop --container removed the
+	// container, until a fresh restart creates a new one.
+	containerStopped bool
+	// exitReason is why the last run crashed, when grove could tell; see
+	// diagnoseCrash.
+	exitReason string
+	// authWatch is true while a claude agent's startup output is scanned for
+	// signs that its token has expired; authTail holds the end of the output
+	// seen so far, escape sequences removed, so a message split across reads
+	// still matches.  Detection stops after the first hit of a session, or
+	// once the agent first goes idle: past startup, those phrases are more
+	// likely to be part of the agent's work than a rejected token.
+	authWatch bool
+	authTail  []byte
+	// screen follows the agent's PTY output to know what its terminal shows,
+	// for grove snapshot.  Replaced on each PTY start; nil for pipe agents
+	// and for instances reloaded from disk, whose last screen is in
+	// screenFile.
+	screen *screen
+	// config is what the instance was started with, for ReqInstanceConfig.
+	// It is replaced, never modified in place, so Info can share it.
+	config *proto.InstanceConfig
 }
 
 // Info returns a serialisable snapshot of this instance's metadata.
@@ -102,45 +152,146 @@ func (inst *Instance) Info() proto.InstanceInfo {
 	if !inst.endedAt.IsZero() {
 		endedAt = inst.endedAt.Unix()
 	}
+	var annotations map[string]string
+	if len(inst.annotations) > 0 {
+		annotations = make(map[string]string, len(inst.annotations))
+		for k, v := range inst.annotations {
+			annotations[k] = v
+		}
+	}
 	return proto.InstanceInfo{
-		ID:             inst.ID,
-		Project:        inst.Project,
-		State:          state,
-		Branch:         inst.Branch,
-		WorktreeDir:    inst.WorktreeDir,
-		CreatedAt:      inst.CreatedAt.Unix(),
-		EndedAt:        endedAt,
-		PID:            inst.pid,
-		ContainerID:    inst.ContainerID,
-		ComposeProject: inst.ComposeProject,
+		ID:               inst.ID,
+		Name:             inst.Name,
+		Project:          inst.Project,
+		State:            state,
+		Branch:           inst.Branch,
+		WorktreeDir:      inst.WorktreeDir,
+		CreatedAt:        inst.CreatedAt.Unix(),
+		EndedAt:          endedAt,
+		PID:              inst.pid,
+		ContainerID:      inst.ContainerID,
+		ComposeProject:   inst.ComposeProject,
+		Annotations:      annotations,
+		Restarts:         inst.restarts,
+		Runs:             inst.runs,
+		ContainerStopped: inst.containerStopped,
+		ExitReason:       inst.exitReason,
+		Pipe:             inst.pipe,
+	}
+}
+
+// annotate merges kv into the instance's annotations; empty values delete.
+func (inst *Instance) annotate(kv map[string]string) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	for k, v := range kv {
+		if v == "" {
+			delete(inst.annotations, k)
+			continue
+		}
+		if inst.annotations == nil {
+			inst.annotations = make(map[string]string)
+		}
+		inst.annotations[k] = v
 	}
 }
 
+// recordedConfig returns the configuration the instance was started with, or
+// nil for one started by a daemon that predates it.
+func (inst *Instance) recordedConfig() *proto.InstanceConfig {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return inst.config
+}
+
+// instanceRecord is the JSON persistMeta writes.  The configuration is kept
+// with the metadata but left out of InstanceInfo, so list and watch do not
+// carry it; ReqInstanceConfig serves it.
+type instanceRecord struct {
+	proto.InstanceInfo
+	Config *proto.InstanceConfig `json:"config,omitempty"`
+}
+
 // persistMeta writes the instance metadata to ~/.grove/instances/<id>.json.
 func (inst *Instance) persistMeta(instancesDir string) {
-	info := inst.Info()
-	data, _ := json.MarshalIndent(info, "", "  ")
+	record := instanceRecord{InstanceInfo: inst.Info(), Config: inst.recordedConfig()}
+	data, _ := json.MarshalIndent(record, "", "  ")
 	path := filepath.Join(instancesDir, inst.ID+".json")
-	_ = os.WriteFile(path, data, 0o644)
+	if err := writeFileAtomic(path, data); err != nil {
+		log.Printf("instance %s: could not save its record: %v", inst.ID, err)
+	}
+}
+
+// writeFileAtomic replaces path with data by way of a temporary file in the
+// same directory, so a crash mid-write leaves the old contents rather than a
+// truncated file.  The data is synced before the rename and the directory
+// after it, so a power loss cannot leave an empty file or lose the rename.
+// Leftover temporaries end in ".tmp".
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes dir's entries, such as a file just renamed into it, to
+// disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 // startAgent allocates a PTY, starts the agent inside the instance's container
 // via "docker exec -it", and launches the background goroutine that drains PTY
 // output into logBuf.
 //
+// With pipe set (agent.pty: false) there is no PTY: the agent runs under a
+// plain "docker exec" with stdout and stderr read separately, stderr lines
+// are tagged in the log, and the instance cannot be attached.
+//
 // destroy() kills the docker exec process; the container keeps running so that
 // restart works by starting a new docker exec in the same container.
-func (inst *Instance) startAgent(agentCmd string, agentArgs []string, extraEnv map[string]string) error {
+func (inst *Instance) startAgent(agentCmd string, agentArgs []string, extraEnv map[string]string, pipe bool) error {
 	// bash in sh mode resets PS1 during initialisation; PROMPT_COMMAND fires
 	// before every prompt and is not reset, so it reliably overrides PS1 for
 	// shell sessions.  Agents like claude/aider ignore both variables.
 	ps1 := fmt.Sprintf("\033[2m%s/%s\033[0m $ ", inst.Project, inst.Branch)
 	promptCmd := `PS1="` + ps1 + `"; unset PROMPT_COMMAND`
 
-	dockerArgs := []string{"exec", "-it",
-		"-e", "TERM=xterm-256color",
-		"-e", "PROMPT_COMMAND=" + promptCmd,
+	dockerArgs := []string{"exec"}
+	if !pipe {
+		dockerArgs = append(dockerArgs, "-it")
 	}
+	dockerArgs = append(dockerArgs,
+		"-e", "TERM=xterm-256color",
+		"-e", "PROMPT_COMMAND="+promptCmd,
+	)
 	// Run agent as root with HOME=/root so it sees config mounted at
 	// /root/.claude and /root/.claude.json (many images use a non-root default user).
 	// Include /root/.local/bin in PATH so claude can find itself at its native
@@ -157,26 +308,47 @@ func (inst *Instance) startAgent(agentCmd string, agentArgs []string, extraEnv m
 	if agentCmd == "claude" {
 		dockerArgs = append(dockerArgs, "-e", "IS_DEMO=true")
 	}
+	// claude refuses --dangerously-skip-permissions as root unless told it
+	// is sandboxed, which the container is.
+	if agentCmd == "claude" && slices.Contains(agentArgs, "--dangerously-skip-permissions") {
+		dockerArgs = append(dockerArgs, "-e", "IS_SANDBOX=1")
+	}
 	for k, v := range extraEnv {
 		dockerArgs = append(dockerArgs, "-e", k+"="+v)
 	}
 	dockerArgs = append(dockerArgs, inst.ContainerID, agentCmd)
 	dockerArgs = append(dockerArgs, agentArgs...)
-	cmd := exec.Command("docker", dockerArgs...)
+	cmd := engine.Command(dockerArgs...)
 	// No cmd.Dir or cmd.Env — handled by the container.
 
+	inst.mu.Lock()
+	inst.authWatch = agentCmd == "claude"
+	inst.authTail = nil
+	inst.mu.Unlock()
+
+	if pipe {
+		return inst.startPiped(cmd)
+	}
+
 	// Start the command attached to a new PTY.
 	ptm, err := pty.Start(cmd)
 	if err != nil {
 		return fmt.Errorf("pty.Start: %w", err)
 	}
 
+	if f := inst.screenFile(); f != "" {
+		os.Remove(f)
+	}
+
 	inst.mu.Lock()
 	inst.ptm = ptm
+	inst.pipe = false
+	inst.screen = newScreen(defaultScreenCols, defaultScreenRows)
+	inst.runs++
+	inst.exitReason = ""
 	inst.pid = cmd.Process.Pid
 	inst.state = proto.StateRunning
 	inst.processDone = make(chan struct{})
-	inst.logBuf = inst.logBuf[:0]     // clear stale output from prior runs
 	inst.lastOutputTime = time.Time{} // reset idle timer
 	inst.mu.Unlock()
 
@@ -186,6 +358,192 @@ func (inst *Instance) startAgent(agentCmd string, agentArgs []string, extraEnv m
 	return nil
 }
 
+// stderrTag marks agent stderr lines in the log when running without a PTY.
+const stderrTag = "[stderr] "
+
+// startPiped starts cmd with separate stdout and stderr pipes and launches
+// pipeReader to drain them.
+func (inst *Instance) startPiped(cmd *exec.Cmd) error {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	// Own process group, so destroy() can kill it the same way as a PTY
+	// session leader.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start agent: %w", err)
+	}
+
+	inst.mu.Lock()
+	inst.ptm = nil
+	inst.pipe = true
+	inst.screen = nil
+	inst.runs++
+	inst.exitReason = ""
+	inst.pid = cmd.Process.Pid
+	inst.state = proto.StateRunning
+	inst.processDone = make(chan struct{})
+	inst.lastOutputTime = time.Time{}
+	inst.mu.Unlock()
+
+	go inst.pipeReader(cmd, stdout, stderr)
+	return nil
+}
+
+// pipeReader records the agent's stdout and stderr line by line, stderr
+// lines prefixed with stderrTag, then handles the exit like ptyReader.
+func (inst *Instance) pipeReader(cmd *exec.Cmd, stdout, stderr io.Reader) {
+	logFd := inst.openLog()
+	inst.writeRunMarker(logFd)
+	defer func() {
+		if logFd != nil {
+			logFd.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	readLines := func(r io.Reader, tag string) {
+		defer wg.Done()
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				inst.recordOutput(append([]byte(tag), line...), logFd)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go readLines(stdout, "")
+	go readLines(stderr, stderrTag)
+	wg.Wait()
+
+	inst.agentExited(cmd.Wait())
+}
+
+// exitedWithin waits up to window for the agent to exit.  If it does, it
+// returns true along with the final state and the output the agent produced
+// after the first skip bytes of logBuf (the seeded setup output).
+func (inst *Instance) exitedWithin(window time.Duration, skip int) (bool, string, []byte) {
+	inst.mu.Lock()
+	done := inst.processDone
+	inst.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(window):
+		return false, "", nil
+	}
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	var out []byte
+	if skip < len(inst.logBuf) {
+		out = append(out, inst.logBuf[skip:]...)
+	}
+	return true, inst.state, out
+}
+
+// errStillBusy is returned by waitReady when the agent is still printing at
+// the deadline.
+var errStillBusy = errors.New("agent still busy")
+
+// waitReady blocks until the agent just started has finished booting: it has
+// produced output and then been quiet for readyQuietPeriod, or it has stayed
+// up for maxReadyWait (or max, if shorter) without printing anything.  It
+// returns errStillBusy if the agent is still printing after max, and another
+// error if the agent exits first.
+func (inst *Instance) waitReady(max time.Duration) error {
+	inst.mu.Lock()
+	done := inst.processDone
+	inst.mu.Unlock()
+
+	start := time.Now()
+	deadline := time.After(max)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			inst.mu.Lock()
+			state := inst.state
+			inst.mu.Unlock()
+			return fmt.Errorf("agent exited before it was ready (%s)", state)
+		case <-deadline:
+			inst.mu.Lock()
+			lastOutput := inst.lastOutputTime
+			inst.mu.Unlock()
+			if lastOutput.IsZero() {
+				return nil
+			}
+			return fmt.Errorf("%w after %s", errStillBusy, max)
+		case <-ticker.C:
+			inst.mu.Lock()
+			lastOutput := inst.lastOutputTime
+			inst.mu.Unlock()
+			if lastOutput.IsZero() && time.Since(start) >= maxReadyWait {
+				return nil
+			}
+			if !lastOutput.IsZero() && time.Since(lastOutput) >= readyQuietPeriod {
+				return nil
+			}
+		}
+	}
+}
+
+// maxPromptWait bounds how long sendPrompt waits for a busy agent to settle
+// before giving up; typed into an agent still booting, the prompt could be
+// lost.
+const maxPromptWait = 30 * time.Second
+
+// sendPrompt waits for the agent to be ready (see waitReady) and then types
+// prompt into its terminal followed by Enter.  A multi-line prompt is sent
+// as a bracketed paste so its newlines do not submit it early.
+func (inst *Instance) sendPrompt(prompt string) error {
+	if err := inst.waitReady(maxPromptWait); err != nil {
+		return err
+	}
+	inst.mu.Lock()
+	ptm := inst.ptm
+	inst.mu.Unlock()
+	if ptm == nil {
+		return fmt.Errorf("agent has no terminal")
+	}
+	_, err := ptm.Write(promptInput(prompt))
+	return err
+}
+
+// deliverPrompt is sendPrompt for callers that do not wait for it; the
+// outcome goes to the daemon log, and a failure to the instance output too,
+// where grove attach and grove logs show it.
+func (inst *Instance) deliverPrompt(prompt string) {
+	if err := inst.sendPrompt(prompt); err != nil {
+		log.Printf("instance %s: prompt not sent: %v", inst.ID, err)
+		if logFd := inst.openLog(); logFd != nil {
+			inst.recordOutput([]byte("\r\n[grove] prompt not sent: "+err.Error()+"; type it in with grove attach\r\n"), logFd)
+			logFd.Close()
+		}
+		return
+	}
+	log.Printf("instance %s: prompt sent (%d bytes)", inst.ID, len(prompt))
+}
+
+// promptInput returns the keystrokes that enter prompt at an agent's input.
+func promptInput(prompt string) []byte {
+	prompt = strings.TrimRight(prompt, "\r\n")
+	if strings.ContainsAny(prompt, "\r\n") {
+		prompt = "\x1b[200~" + prompt + "\x1b[201~"
+	}
+	return []byte(prompt + "\r")
+}
+
 // ptyReader reads all output from the PTY master in a tight loop.
 // It:
 //   - appends output to the rolling in-memory log buffer
@@ -194,10 +552,8 @@ func (inst *Instance) startAgent(agentCmd string, agentArgs []string, extraEnv m
 //
 // It transitions the instance to EXITED or CRASHED when the process ends.
 func (inst *Instance) ptyReader(cmd *exec.Cmd) {
-	logFd, err := os.OpenFile(inst.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-	if err != nil {
-		log.Printf("instance %s: cannot open log file: %v", inst.ID, err)
-	}
+	logFd := inst.openLog()
+	inst.writeRunMarker(logFd)
 	defer func() {
 		if logFd != nil {
 			logFd.Close()
@@ -208,27 +564,7 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 	for {
 		n, err := inst.ptm.Read(buf)
 		if n > 0 {
-			chunk := buf[:n]
-
-			// Write to on-disk log.
-			if logFd != nil {
-				logFd.Write(chunk)
-			}
-
-			inst.mu.Lock()
-			// Append to rolling in-memory buffer, trimming if too large.
-			inst.logBuf = append(inst.logBuf, chunk...)
-			if len(inst.logBuf) > maxLogBytes {
-				inst.logBuf = inst.logBuf[len(inst.logBuf)-maxLogBytes:]
-			}
-			inst.lastOutputTime = time.Now()
-			conn := inst.attachedConn
-			inst.mu.Unlock()
-
-			// Forward to attached client (ignore errors; client may have gone away).
-			if conn != nil {
-				conn.Write(chunk)
-			}
+			inst.recordOutput(buf[:n], logFd)
 		}
 		if err != nil {
 			// PTY read error means the slave side closed (process exited).
@@ -237,11 +573,139 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 	}
 
 	// Wait for the process to fully exit and determine the exit code.
-	waitErr := cmd.Wait()
+	inst.agentExited(cmd.Wait())
+}
+
+// openLog opens the instance log file for appending, or returns nil (after
+// logging why) if it cannot be opened.
+func (inst *Instance) openLog() *os.File {
+	logFd, err := os.OpenFile(inst.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("instance %s: cannot open log file: %v", inst.ID, err)
+		return nil
+	}
+	return logFd
+}
 
+// writeRunMarker writes a line to the on-disk log announcing the start of
+// the agent's current run, so grove logs --all-runs can show where one run
+// ends and the next begins.  The marker is not added to logBuf, which only
+// ever holds the latest runs' output anyway.
+func (inst *Instance) writeRunMarker(logFd *os.File) {
+	if logFd == nil {
+		return
+	}
 	inst.mu.Lock()
-	inst.ptm.Close()
-	inst.ptm = nil
+	run := inst.runs
+	inst.mu.Unlock()
+	fmt.Fprintf(logFd, "\r\n%s%d started %s ───\r\n", runMarkerPrefix, run, time.Now().Format("2006-01-02 15:04:05"))
+}
+
+// runMarkerPrefix begins every run marker line in an instance log.
+const runMarkerPrefix = "─── grove: run "
+
+// recordSetup adds a line of setup output for the run about to start to the
+// on-disk log, the timeline and the rolling in-memory buffer, where grove
+// logs shows it ahead of the agent's output.
+func (inst *Instance) recordSetup(line string) {
+	if logFd := inst.openLog(); logFd != nil {
+		logFd.WriteString(line)
+		logFd.Close()
+	}
+	inst.timeline.add(sourceSetup, []byte(line))
+	inst.mu.Lock()
+	inst.logBuf = append(inst.logBuf, line...)
+	inst.mu.Unlock()
+}
+
+// recordOutput appends a chunk of agent output to the on-disk log, the
+// timeline and the rolling in-memory buffer, and forwards it to the attached
+// client, if any.
+func (inst *Instance) recordOutput(chunk []byte, logFd *os.File) {
+	// Write to on-disk log.
+	if logFd != nil {
+		logFd.Write(chunk)
+	}
+	inst.timeline.add(sourceAgent, chunk)
+
+	inst.mu.Lock()
+	// Append to rolling in-memory buffer, trimming if too large.
+	inst.logBuf = append(inst.logBuf, chunk...)
+	if len(inst.logBuf) > maxLogBytes {
+		inst.logBuf = inst.logBuf[len(inst.logBuf)-maxLogBytes:]
+	}
+	now := time.Now()
+	if inst.authWatch && !inst.lastOutputTime.IsZero() &&
+		now.Sub(inst.lastOutputTime) > waitingIdleThreshold {
+		// The agent reached WAITING since its last output: startup is over.
+		inst.authWatch = false
+		inst.authTail = nil
+	}
+	inst.lastOutputTime = now
+	if inst.screen != nil {
+		inst.screen.Write(chunk)
+	}
+	conn := inst.attachedConn
+	expired := inst.authWatch && inst.scanAuthFailure(chunk)
+	inst.mu.Unlock()
+
+	// Forward to attached client (ignore errors; client may have gone away).
+	if conn != nil {
+		conn.Write(chunk)
+	}
+	if expired {
+		log.Printf("instance %s: claude token appears expired or invalid (agent asked to log in); run claude setup-token, then grove token", inst.ID)
+		markTokenExpired(filepath.Dir(inst.InstancesDir), inst.Project)
+	}
+}
+
+// authFailureMarkers are lower-cased fragments of what claude prints when
+// its token is expired, revoked or otherwise rejected.
+var authFailureMarkers = []string{
+	"oauth token has expired",
+	"invalid api key",
+	"please run /login",
+	"api error: 401",
+}
+
+// maxAuthTail bounds authTail; it only needs to hold the longest marker.
+const maxAuthTail = 256
+
+// scanAuthFailure adds chunk to authTail and reports whether the output now
+// shows an authentication failure, ending the watch if so.  Must be called
+// with mu held.
+func (inst *Instance) scanAuthFailure(chunk []byte) bool {
+	for i := 0; i < len(chunk); {
+		if chunk[i] == 0x1b {
+			i = escapeEnd(chunk, i)
+			continue
+		}
+		inst.authTail = append(inst.authTail, chunk[i])
+		i++
+	}
+	if len(inst.authTail) > maxAuthTail {
+		inst.authTail = inst.authTail[len(inst.authTail)-maxAuthTail:]
+	}
+	text := bytes.ToLower(inst.authTail)
+	for _, m := range authFailureMarkers {
+		if bytes.Contains(text, []byte(m)) {
+			inst.authWatch = false
+			inst.authTail = nil
+			return true
+		}
+	}
+	return false
+}
+
+// agentExited records the end of the agent process: it sets the terminal
+// state from waitErr, closes any attached client, persists the final state
+// and closes processDone.
+func (inst *Instance) agentExited(waitErr error) {
+	inst.mu.Lock()
+	if inst.ptm != nil {
+		inst.ptm.Close()
+		inst.ptm = nil
+	}
 	inst.endedAt = time.Now()
 	if waitErr == nil {
 		inst.state = proto.StateExited
@@ -252,8 +716,19 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 	}
 	conn := inst.attachedConn
 	inst.attachedConn = nil
+	var final string
+	if inst.screen != nil {
+		final = inst.screen.render()
+	}
 	inst.mu.Unlock()
 
+	// Keep the last screen across daemon restarts; see snapshot.
+	if f := inst.screenFile(); f != "" && final != "" {
+		if err := os.WriteFile(f, []byte(final), 0o644); err != nil {
+			log.Printf("instance %s: could not save final screen: %v", inst.ID, err)
+		}
+	}
+
 	// Close the client connection to unblock the Attach goroutine's frame
 	// reader.  The Attach goroutine's defer is the sole owner of close(done);
 	// closing it here too would double-close the channel and panic the daemon.
@@ -262,6 +737,7 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 	}
 
 	log.Printf("instance %s: agent exited (%v)", inst.ID, waitErr)
+	inst.diagnoseCrash(waitErr)
 
 	// If finish was requested, override state to FINISHED.
 	inst.mu.Lock()
@@ -283,6 +759,115 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 	}
 }
 
+// diagnoseCrash records why the agent crashed when the cause can be found
+// out, and notes it at the end of the log.  So far that is the kernel's OOM
+// killer: the agent died of SIGKILL in a container docker saw run out of
+// memory.
+func (inst *Instance) diagnoseCrash(waitErr error) {
+	inst.mu.Lock()
+	crashed := inst.state == proto.StateCrashed
+	containerID := inst.ContainerID
+	inst.mu.Unlock()
+
+	var exitErr *exec.ExitError
+	if !crashed || containerID == "" || !errors.As(waitErr, &exitErr) || exitErr.ExitCode() != oomExitCode {
+		return
+	}
+	if !oomKilled(containerID) {
+		return
+	}
+
+	inst.mu.Lock()
+	inst.exitReason = exitReasonOOM
+	inst.mu.Unlock()
+	log.Printf("instance %s: agent was killed for running out of memory", inst.ID)
+	if logFd := inst.openLog(); logFd != nil {
+		inst.recordOutput([]byte("\r\n[grove] agent killed: out of memory; raise container.memory in grove.yaml\r\n"), logFd)
+		logFd.Close()
+	}
+}
+
+// exitReasonOOM is the exit reason of an agent killed by the OOM killer.
+const exitReasonOOM = "out of memory"
+
+// screenFile is where the agent's final screen is saved when it exits,
+// next to the log file.  Empty if the instance has no log file.
+func (inst *Instance) screenFile() string {
+	if inst.LogFile == "" {
+		return ""
+	}
+	return strings.TrimSuffix(inst.LogFile, ".log") + ".screen"
+}
+
+// snapshot returns what the agent's terminal shows, or showed when it
+// exited: from the live screen if there is one, else from screenFile.
+func (inst *Instance) snapshot() (string, error) {
+	inst.mu.Lock()
+	pipe := inst.pipe
+	var text string
+	live := inst.screen != nil
+	if live {
+		text = inst.screen.render()
+	}
+	inst.mu.Unlock()
+
+	if pipe {
+		return "", fmt.Errorf("instance %s runs without a PTY (agent.pty: false), so it has no screen; use grove logs", inst.ID)
+	}
+	if live {
+		return text, nil
+	}
+	noScreen := fmt.Errorf("no screen recorded for instance %s", inst.ID)
+	f := inst.screenFile()
+	if f == "" {
+		return "", noScreen
+	}
+	data, err := os.ReadFile(f)
+	if os.IsNotExist(err) {
+		return "", noScreen
+	}
+	return string(data), err
+}
+
+// AttachOptions tunes a single attach session.
+type AttachOptions struct {
+	// DetachOnIdle ends the session the first time the agent goes idle
+	// (no output for waitingIdleThreshold) after producing output, ignoring
+	// the redraw in the first attachRedrawGrace of the session and anything
+	// before the client last submitted input containing a newline.
+	DetachOnIdle bool
+
+	// Takeover evicts a client that is already attached rather than
+	// refusing the new one.
+	Takeover bool
+}
+
+// takeoverNotice is the last thing an evicted client is sent before its
+// connection is closed.
+const takeoverNotice = "\r\n[grove] another client took over this session\r\n"
+
+// stopNotice and dropNotice are the last thing an attached client is sent
+// when its instance is stopped or dropped out from under it.
+const (
+	stopNotice = "\r\n[grove] this instance was stopped\r\n"
+	dropNotice = "\r\n[grove] this instance was dropped\r\n"
+)
+
+// evictClient sends notice to the attached client, if any, closes its
+// connection and waits for its attach session to finish cleaning up.
+func (inst *Instance) evictClient(notice string) {
+	inst.mu.Lock()
+	old, oldDone := inst.attachedConn, inst.attachDone
+	inst.mu.Unlock()
+	if old == nil {
+		return
+	}
+	old.SetWriteDeadline(time.Now().Add(time.Second))
+	io.WriteString(old, notice)
+	old.Close()
+	<-oldDone
+}
+
 // Attach connects a client network connection to this instance's PTY.
 //
 // It:
@@ -291,13 +876,21 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 //  3. Starts a goroutine reading framed messages from the client (stdin data,
 //     resize events, detach signal).
 //  4. Blocks until the session ends (client detaches, client disconnects,
-//     or the agent exits).
-func (inst *Instance) Attach(conn net.Conn) {
+//     the agent exits, or — with DetachOnIdle — the agent goes idle).
+func (inst *Instance) Attach(conn net.Conn, opts AttachOptions) {
 	inst.mu.Lock()
-	if inst.state == proto.StateAttached {
+	for inst.state == proto.StateAttached {
+		if !opts.Takeover {
+			inst.mu.Unlock()
+			fmt.Fprintf(conn, `{"ok":false,"error":"already attached"}`+"\n")
+			return
+		}
+		// Tell the current client why its session ended, close it, and
+		// wait for its frame reader to finish the usual detach cleanup.
 		inst.mu.Unlock()
-		fmt.Fprintf(conn, `{"ok":false,"error":"already attached"}`+"\n")
-		return
+		log.Printf("instance %s: attach takeover, evicting previous client", inst.ID)
+		inst.evictClient(takeoverNotice)
+		inst.mu.Lock()
 	}
 
 	// Grab a copy of the log buffer to replay.
@@ -316,12 +909,25 @@ func (inst *Instance) Attach(conn net.Conn) {
 		conn.Write(replay)
 	}
 
-	// If the agent is already gone there's nothing to do.
+	// If the agent is already gone there's nothing to do.  done is still
+	// closed so that evictClient never waits on this session.
 	if ptm == nil {
 		conn.Close()
+		close(done)
 		return
 	}
 
+	// armedAt is the unix-nano time after which agent output counts towards
+	// DetachOnIdle: attachRedrawGrace after attaching, so the redraw the
+	// resize triggers is ignored, then the time the client last sent a
+	// newline.  An agent that finishes a task on its own still ends the
+	// session.
+	var armedAt atomic.Int64
+	if opts.DetachOnIdle {
+		armedAt.Store(time.Now().Add(attachRedrawGrace).UnixNano())
+		go inst.detachWhenIdle(conn, done, &armedAt)
+	}
+
 	// Read framed messages from the client and act on them.
 	go func() {
 		defer func() {
@@ -357,6 +963,9 @@ func (inst *Instance) Attach(conn net.Conn) {
 				if p != nil {
 					p.Write(payload)
 				}
+				if opts.DetachOnIdle && bytes.ContainsAny(payload, "\r\n") {
+					armedAt.Store(time.Now().UnixNano())
+				}
 
 			case proto.AttachFrameResize:
 				// payload: 2-byte cols + 2-byte rows (big-endian uint16)
@@ -365,6 +974,9 @@ func (inst *Instance) Attach(conn net.Conn) {
 					rows := binary.BigEndian.Uint16(payload[2:4])
 					inst.mu.Lock()
 					p := inst.ptm
+					if p != nil && inst.screen != nil {
+						inst.screen.resize(int(cols), int(rows))
+					}
 					inst.mu.Unlock()
 					if p != nil {
 						pty.Setsize(p, &pty.Winsize{
@@ -385,6 +997,35 @@ func (inst *Instance) Attach(conn net.Conn) {
 	<-done
 }
 
+// attachRedrawGrace is how long after an --once attach output is taken to
+// be the agent redrawing for the new terminal rather than work.
+const attachRedrawGrace = time.Second
+
+// detachWhenIdle closes conn once the agent has produced output after
+// armedAt and then stayed silent for waitingIdleThreshold: the first
+// RUNNING→WAITING transition that follows.  Closing conn unblocks the frame
+// reader, which runs the normal detach cleanup.  Returns when the session
+// ends for any reason.
+func (inst *Instance) detachWhenIdle(conn net.Conn, done <-chan struct{}, armedAt *atomic.Int64) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			inst.mu.Lock()
+			lastOutput := inst.lastOutputTime
+			inst.mu.Unlock()
+			if lastOutput.UnixNano() > armedAt.Load() && time.Since(lastOutput) > waitingIdleThreshold {
+				log.Printf("instance %s: agent idle, ending --once attach", inst.ID)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
 // destroy kills the agent process and its process group, then closes the PTY.
 func (inst *Instance) destroy() {
 	inst.mu.Lock()
@@ -416,4 +1057,3 @@ func (inst *Instance) destroy() {
 		conn.Close()
 	}
 }
-
@@ -1,11 +1,20 @@
 package daemon
 
 import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/creack/pty"
 	"github.com/gandalfthegui/grove/internal/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 
@@ -49,3 +58,306 @@ func TestInfoNonRunningStateUnchanged(t *testing.T) {
 		assert.Equal(t, state, inst.Info().State, "state %s should not be promoted", state)
 	}
 }
+
+func TestAnnotateMergesAndDeletes(t *testing.T) {
+	inst := &Instance{ID: "1", state: proto.StateRunning}
+
+	inst.annotate(map[string]string{"ticket": "JIRA-1", "reviewer": "alice"})
+	inst.annotate(map[string]string{"ticket": "JIRA-2", "reviewer": ""})
+
+	assert.Equal(t, map[string]string{"ticket": "JIRA-2"}, inst.Info().Annotations)
+}
+
+func TestExitedWithin(t *testing.T) {
+	running := &Instance{processDone: make(chan struct{})}
+	exited, _, _ := running.exitedWithin(10*time.Millisecond, 0)
+	assert.False(t, exited)
+
+	dead := &Instance{
+		state:       proto.StateCrashed,
+		logBuf:      []byte("setup output\nError: unknown flag --bogus\n"),
+		processDone: make(chan struct{}),
+	}
+	close(dead.processDone)
+	exited, state, out := dead.exitedWithin(time.Second, len("setup output\n"))
+	assert.True(t, exited)
+	assert.Equal(t, proto.StateCrashed, state)
+	assert.Equal(t, "Error: unknown flag --bogus\n", string(out), "seeded setup output is skipped")
+	assert.True(t, startupFailed(state))
+
+	done := &Instance{ID: "1", LogFile: filepath.Join(t.TempDir(), "1.log")}
+	require.NoError(t, done.startPiped(exec.Command("sh", "-c", "echo all done")))
+	exited, state, _ = done.exitedWithin(5*time.Second, 0)
+	assert.True(t, exited)
+	assert.Equal(t, proto.StateExited, state)
+	assert.False(t, startupFailed(state), "a clean quick exit is not a failed start")
+}
+
+func TestStartPipedTagsStderr(t *testing.T) {
+	inst := &Instance{ID: "1", LogFile: filepath.Join(t.TempDir(), "1.log")}
+	cmd := exec.Command("sh", "-c", "echo out; echo oops >&2; exit 3")
+	require.NoError(t, inst.startPiped(cmd))
+
+	exited, state, out := inst.exitedWithin(5*time.Second, 0)
+	require.True(t, exited)
+	assert.Equal(t, proto.StateCrashed, state)
+	assert.Contains(t, string(out), "out\n")
+	assert.Contains(t, string(out), stderrTag+"oops\n")
+	assert.True(t, inst.Info().Pipe)
+
+	logged, err := os.ReadFile(inst.LogFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(logged), stderrTag+"oops\n")
+}
+
+func TestWaitReady(t *testing.T) {
+	inst := &Instance{processDone: make(chan struct{})}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		inst.mu.Lock()
+		inst.lastOutputTime = time.Now()
+		inst.mu.Unlock()
+	}()
+	start := time.Now()
+	require.NoError(t, inst.waitReady(5*time.Second))
+	assert.GreaterOrEqual(t, time.Since(start), readyQuietPeriod, "waits for output to settle")
+
+	silent := &Instance{processDone: make(chan struct{})}
+	assert.NoError(t, silent.waitReady(200*time.Millisecond), "a silent agent that stays up is ready")
+
+	busy := &Instance{processDone: make(chan struct{}), lastOutputTime: time.Now().Add(time.Hour)}
+	assert.ErrorIs(t, busy.waitReady(200*time.Millisecond), errStillBusy)
+
+	dead := &Instance{state: proto.StateCrashed, processDone: make(chan struct{})}
+	close(dead.processDone)
+	assert.ErrorContains(t, dead.waitReady(time.Second), "CRASHED")
+}
+
+func TestRecordOutputDetectsExpiredToken(t *testing.T) {
+	root := t.TempDir()
+	inst := &Instance{ID: "1", Project: "app", InstancesDir: filepath.Join(root, "instances"), authWatch: true}
+
+	inst.recordOutput([]byte("Welcome to Claude\r\n"), nil)
+	assert.NoFileExists(t, filepath.Join(root, tokenExpiredFile))
+
+	// The message is split across reads and interleaved with colour codes.
+	inst.recordOutput([]byte("\x1b[31mOAuth token has \x1b[1m"), nil)
+	inst.recordOutput([]byte("expired\x1b[0m · Please run /login\r\n"), nil)
+	marker, err := os.ReadFile(filepath.Join(root, tokenExpiredFile))
+	require.NoError(t, err)
+	assert.Equal(t, "app\n", string(marker))
+	assert.False(t, inst.authWatch, "one report per session")
+}
+
+func TestAttachTakeover(t *testing.T) {
+	ptm, tty, err := pty.Open()
+	require.NoError(t, err)
+	defer ptm.Close()
+	defer tty.Close()
+	inst := &Instance{ID: "1", state: proto.StateRunning, ptm: ptm}
+	attached := func() bool { return inst.Info().State == proto.StateAttached }
+
+	first, firstClient := net.Pipe()
+	firstOut := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(firstClient)
+		firstOut <- string(data)
+	}()
+	go inst.Attach(first, AttachOptions{})
+	require.Eventually(t, attached, time.Second, 10*time.Millisecond)
+
+	second, secondClient := net.Pipe()
+	go inst.Attach(second, AttachOptions{})
+	refused := make([]byte, 256)
+	n, _ := secondClient.Read(refused)
+	assert.Contains(t, string(refused[:n]), "already attached")
+
+	third, thirdClient := net.Pipe()
+	defer thirdClient.Close()
+	go inst.Attach(third, AttachOptions{Takeover: true})
+	assert.Equal(t, takeoverNotice, <-firstOut, "the evicted client is told why, then disconnected")
+	require.Eventually(t, func() bool {
+		inst.mu.Lock()
+		defer inst.mu.Unlock()
+		return inst.attachedConn == third && inst.state == proto.StateAttached
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFinalScreenSavedOnExit(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "1.log")
+	inst := &Instance{ID: "1", LogFile: logFile}
+	cmd := exec.Command("sh", "-c", `printf 'boot\r\n\033[?1049h\033[H\033[2J> last prompt'`)
+	ptm, err := pty.Start(cmd)
+	require.NoError(t, err)
+	inst.ptm = ptm
+	inst.screen = newScreen(defaultScreenCols, defaultScreenRows)
+	inst.processDone = make(chan struct{})
+	go inst.ptyReader(cmd)
+
+	select {
+	case <-inst.processDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not exit")
+	}
+	live, err := inst.snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, "> last prompt\n", live)
+
+	// After a daemon restart there is no live screen; the saved one is used.
+	reloaded := &Instance{ID: "1", LogFile: logFile}
+	saved, err := reloaded.snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, live, saved)
+
+	_, err = (&Instance{ID: "2", LogFile: filepath.Join(t.TempDir(), "2.log")}).snapshot()
+	assert.ErrorContains(t, err, "no screen recorded")
+	_, err = (&Instance{ID: "3", pipe: true}).snapshot()
+	assert.ErrorContains(t, err, "without a PTY")
+}
+
+func TestRunMarkersAcrossRestarts(t *testing.T) {
+	inst := &Instance{ID: "1", LogFile: filepath.Join(t.TempDir(), "1.log")}
+	for _, word := range []string{"first", "second"} {
+		require.NoError(t, inst.startPiped(exec.Command("echo", word)))
+		exited, _, _ := inst.exitedWithin(5*time.Second, 0)
+		require.True(t, exited)
+	}
+	assert.Equal(t, 2, inst.Info().Runs)
+
+	logged, err := os.ReadFile(inst.LogFile)
+	require.NoError(t, err)
+	text := string(logged)
+	run1 := strings.Index(text, runMarkerPrefix+"1 started")
+	run2 := strings.Index(text, runMarkerPrefix+"2 started")
+	require.True(t, run1 >= 0 && run2 > run1, "markers in order:\n%s", text)
+	assert.Contains(t, text[run1:run2], "first\n")
+	assert.Contains(t, text[run2:], "second\n")
+}
+
+func TestCrashDiagnosedAsOutOfMemory(t *testing.T) {
+	// A fake docker whose inspect reports an OOM kill in the container.
+	fakeDocker(t, "echo true\n")
+
+	run := func(script string) *Instance {
+		inst := &Instance{ID: "1", ContainerID: "grove-1", LogFile: filepath.Join(t.TempDir(), "1.log"), timeline: &timeline{}}
+		require.NoError(t, inst.startPiped(exec.Command("sh", "-c", script)))
+		exited, _, _ := inst.exitedWithin(5*time.Second, 0)
+		require.True(t, exited)
+		return inst
+	}
+
+	inst := run("exit 137")
+	assert.Equal(t, exitReasonOOM, inst.Info().ExitReason)
+	logged, err := os.ReadFile(inst.LogFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(logged), "out of memory; raise container.memory")
+
+	// Other crashes are not blamed on an earlier OOM kill.
+	assert.Empty(t, run("exit 1").Info().ExitReason)
+}
+
+func TestSendPrompt(t *testing.T) {
+	inst := &Instance{ID: "1", LogFile: filepath.Join(t.TempDir(), "1.log")}
+	cmd := exec.Command("sh", "-c", `printf 'ready> '; read line; echo "got:$line"`)
+	ptm, err := pty.Start(cmd)
+	require.NoError(t, err)
+	inst.ptm = ptm
+	inst.processDone = make(chan struct{})
+	go inst.ptyReader(cmd)
+
+	require.NoError(t, inst.sendPrompt("fix the flaky test\n"))
+	select {
+	case <-inst.processDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not exit")
+	}
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	assert.Contains(t, string(inst.logBuf), "got:fix the flaky test")
+
+	// An agent that dies first gets a note in its output instead.
+	dead := &Instance{ID: "2", LogFile: filepath.Join(t.TempDir(), "2.log"), state: proto.StateCrashed, processDone: make(chan struct{})}
+	close(dead.processDone)
+	dead.deliverPrompt("fix the flaky test")
+	logged, err := os.ReadFile(dead.LogFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(logged), "[grove] prompt not sent: agent exited before it was ready (CRASHED)")
+
+	// Embedded newlines are pasted rather than submitting the prompt early.
+	assert.Equal(t, "\x1b[200~step 1\nstep 2\x1b[201~\r", string(promptInput("step 1\nstep 2")))
+}
+
+func TestRecordOutputIgnoresAuthPhrasesAfterStartup(t *testing.T) {
+	root := t.TempDir()
+	inst := &Instance{ID: "1", Project: "app", InstancesDir: filepath.Join(root, "instances"), authWatch: true}
+
+	inst.recordOutput([]byte("Welcome to Claude\r\n> "), nil)
+	// The agent went idle at its prompt; the user then asked it about auth.
+	inst.lastOutputTime = time.Now().Add(-waitingIdleThreshold - time.Second)
+	inst.recordOutput([]byte("The handler returns API Error: 401 when the key is missing\r\n"), nil)
+	assert.NoFileExists(t, filepath.Join(root, tokenExpiredFile))
+	assert.False(t, inst.authWatch, "the watch ends with startup")
+}
+
+func TestDetachWhenIdleWithoutInput(t *testing.T) {
+	inst := &Instance{ID: "1", state: proto.StateAttached}
+	conn, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	defer close(done)
+
+	// Attached a moment ago without typing; the agent was busy and has
+	// since gone quiet.
+	var armedAt atomic.Int64
+	armedAt.Store(time.Now().Add(-3 * time.Second).UnixNano())
+	inst.lastOutputTime = time.Now().Add(-waitingIdleThreshold - time.Second)
+	go inst.detachWhenIdle(conn, done, &armedAt)
+
+	closed := make(chan error, 1)
+	go func() {
+		_, err := client.Read(make([]byte, 1))
+		closed <- err
+	}()
+	select {
+	case err := <-closed:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("session not detached after the agent went idle")
+	}
+}
+
+func TestDetachWhenIdleIgnoresOutputBeforeArming(t *testing.T) {
+	inst := &Instance{ID: "1", state: proto.StateAttached}
+	conn, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	defer close(done)
+
+	var armedAt atomic.Int64
+	armedAt.Store(time.Now().UnixNano())
+	inst.lastOutputTime = time.Now().Add(-waitingIdleThreshold - time.Second)
+	go inst.detachWhenIdle(conn, done, &armedAt)
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := client.Read(make([]byte, 1))
+	var ne net.Error
+	require.ErrorAs(t, err, &ne)
+	assert.True(t, ne.Timeout(), "an idle agent keeps the session open")
+}
+
+func TestStartAgentSandboxedForSkipPermissions(t *testing.T) {
+	argsFile := fakeDocker(t, "printf '%s\\n' \"$@\" >> \"$CALLS\"\n")
+
+	run := func(agentArgs ...string) string {
+		os.Remove(argsFile)
+		inst := &Instance{ID: "1", ContainerID: "grove-1", LogFile: filepath.Join(t.TempDir(), "1.log"), timeline: &timeline{}}
+		require.NoError(t, inst.startAgent("claude", agentArgs, nil, true))
+		exited, _, _ := inst.exitedWithin(5*time.Second, 0)
+		require.True(t, exited)
+		data, err := os.ReadFile(argsFile)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Contains(t, run("--dangerously-skip-permissions"), "IS_SANDBOX=1\n", "claude runs as root")
+	assert.NotContains(t, run(), "IS_SANDBOX")
+}
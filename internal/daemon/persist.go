@@ -1,7 +1,10 @@
 package daemon
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -25,17 +28,34 @@ func (d *Daemon) loadPersistedInstances() error {
 	}
 
 	for _, e := range entries {
-		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+		if e.IsDir() {
 			continue
 		}
-		data, err := os.ReadFile(filepath.Join(instancesDir, e.Name()))
+		path := filepath.Join(instancesDir, e.Name())
+		switch filepath.Ext(e.Name()) {
+		case ".json":
+		case ".tmp":
+			// Left behind by a persistMeta interrupted before its rename;
+			// the record it was replacing is intact.
+			os.Remove(path)
+			continue
+		default:
+			continue
+		}
+		data, err := os.ReadFile(path)
 		if err != nil {
+			log.Printf("warning: could not read instance record %s: %v", path, err)
 			continue
 		}
-		var info proto.InstanceInfo
-		if err := json.Unmarshal(data, &info); err != nil {
+		var record instanceRecord
+		if err := json.Unmarshal(data, &record); err != nil || record.ID == "" {
+			if err == nil {
+				err = fmt.Errorf("no instance ID")
+			}
+			setAsideCorrupt(path, err)
 			continue
 		}
+		info := record.InstanceInfo
 
 		// Determine the correct state on reload.
 		state := info.State
@@ -51,17 +71,25 @@ func (d *Daemon) loadPersistedInstances() error {
 		}
 
 		inst := &Instance{
-			ID:             info.ID,
-			Project:        info.Project,
-			Branch:         info.Branch,
-			WorktreeDir:    info.WorktreeDir,
-			CreatedAt:      time.Unix(info.CreatedAt, 0),
-			LogFile:        filepath.Join(d.rootDir, "logs", info.ID+".log"),
-			state:          state,
-			endedAt:        endedAt,
-			InstancesDir:   instancesDir,
-			ContainerID:    info.ContainerID,
-			ComposeProject: info.ComposeProject,
+			ID:               info.ID,
+			Name:             info.Name,
+			Project:          info.Project,
+			Branch:           info.Branch,
+			WorktreeDir:      info.WorktreeDir,
+			CreatedAt:        time.Unix(info.CreatedAt, 0),
+			LogFile:          filepath.Join(d.rootDir, "logs", info.ID+".log"),
+			state:            state,
+			endedAt:          endedAt,
+			InstancesDir:     instancesDir,
+			ContainerID:      info.ContainerID,
+			ComposeProject:   info.ComposeProject,
+			annotations:      info.Annotations,
+			restarts:         info.Restarts,
+			runs:             info.Runs,
+			containerStopped: info.ContainerStopped,
+			exitReason:       info.ExitReason,
+			timeline:         &timeline{},
+			config:           record.Config,
 		}
 		d.instances[info.ID] = inst
 
@@ -74,6 +102,17 @@ func (d *Daemon) loadPersistedInstances() error {
 	return nil
 }
 
+// setAsideCorrupt renames an instance record that cannot be loaded to
+// <id>.json.corrupt, so it is kept for inspection but no longer read, and
+// logs why.
+func setAsideCorrupt(path string, err error) {
+	if rerr := os.Rename(path, path+".corrupt"); rerr != nil {
+		log.Printf("warning: instance record %s is unreadable (%v) and could not be moved aside: %v", path, err, rerr)
+		return
+	}
+	log.Printf("warning: instance record %s is unreadable (%v); moved it to %s.corrupt and skipped the instance", path, err, path)
+}
+
 // logAgentCredentials logs which credential keys are present in agentEnv so
 // auth problems can be diagnosed from the daemon log without exposing values.
 func logAgentCredentials(instanceID string, agentEnv map[string]string) {
@@ -90,17 +129,34 @@ func logAgentCredentials(instanceID string, agentEnv map[string]string) {
 	}
 }
 
+// tokenExpiredFile, under the data root, records the project whose claude
+// agent last showed an authentication failure.  grove start checks it
+// against the env file's modification time to prompt for a new token before
+// starting another agent with the old one.
+const tokenExpiredFile = "token-expired"
+
+// markTokenExpired writes tokenExpiredFile for project.  Errors are logged.
+func markTokenExpired(dataRoot, project string) {
+	if err := os.WriteFile(filepath.Join(dataRoot, tokenExpiredFile), []byte(project+"\n"), 0o644); err != nil {
+		log.Printf("warning: could not record expired token: %v", err)
+	}
+}
+
 // ─── resilientWriter ──────────────────────────────────────────────────────────
 
 // resilientWriter fans output to a log file (always) and a network connection
 // (best-effort).  If the connection breaks, writes continue to the log and the
 // caller (exec.Command) never sees an error, so the child process keeps running
 // even if the client disconnects.
+//
+// With framed set, output goes to the connection as proto.StreamFrameOutput
+// frames, for clients that asked for Request.Framed; see also stderr.
 type resilientWriter struct {
 	mu     sync.Mutex
 	conn   net.Conn
 	log    *os.File
 	connOK bool
+	framed bool
 }
 
 func newResilientWriter(conn net.Conn, log *os.File) *resilientWriter {
@@ -108,10 +164,32 @@ func newResilientWriter(conn net.Conn, log *os.File) *resilientWriter {
 }
 
 func (rw *resilientWriter) Write(p []byte) (int, error) {
+	return rw.write(proto.StreamFrameOutput, p)
+}
+
+// stderr returns a writer for a command's stderr that shares rw but sends
+// proto.StreamFrameStderr frames when framed.
+func (rw *resilientWriter) stderr() io.Writer {
+	return stderrWriter{rw}
+}
+
+type stderrWriter struct{ rw *resilientWriter }
+
+func (w stderrWriter) Write(p []byte) (int, error) {
+	return w.rw.write(proto.StreamFrameStderr, p)
+}
+
+func (rw *resilientWriter) write(frameType byte, p []byte) (int, error) {
 	rw.mu.Lock()
 	defer rw.mu.Unlock()
 	if rw.connOK {
-		if _, err := rw.conn.Write(p); err != nil {
+		var err error
+		if rw.framed {
+			err = proto.WriteFrame(rw.conn, frameType, p)
+		} else {
+			_, err = rw.conn.Write(p)
+		}
+		if err != nil {
 			rw.connOK = false
 		}
 	}
@@ -120,3 +198,51 @@ func (rw *resilientWriter) Write(p []byte) (int, error) {
 	}
 	return len(p), nil // always succeed so child processes never get SIGPIPE
 }
+
+// writeResult ends a framed stream with resp as a proto.StreamFrameResult
+// frame.  It does nothing for unframed streams or a broken connection.
+func (rw *resilientWriter) writeResult(resp proto.Response) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if !rw.framed || !rw.connOK {
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	proto.WriteFrame(rw.conn, proto.StreamFrameResult, data)
+}
+
+// ─── prefixWriter ─────────────────────────────────────────────────────────────
+
+// prefixWriter labels each line written through it with prefix before passing
+// it to w, so output from concurrent check groups stays attributable.  Partial
+// lines are held until their newline arrives or Flush is called.  It is not
+// safe for concurrent use; exec.Cmd serialises writes when Stdout and Stderr
+// are the same writer.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		pw.w.Write(append([]byte(pw.prefix), pw.buf[:i+1]...))
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes any buffered partial line, terminated with a newline.
+func (pw *prefixWriter) Flush() {
+	if len(pw.buf) > 0 {
+		pw.Write([]byte{'\n'})
+	}
+}
@@ -1,13 +1,23 @@
 package daemon
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gandalfthegui/grove/internal/envfile"
+	"github.com/gandalfthegui/grove/internal/gitref"
+	"github.com/gandalfthegui/grove/internal/registration"
 	"gopkg.in/yaml.v3"
 )
 
@@ -16,8 +26,44 @@ type ContainerConfig struct {
 	Image   string   `yaml:"image"`   // single container image (e.g. "ruby:3.3")
 	Compose string   `yaml:"compose"` // path to docker-compose.yml (relative to repo root)
 	Service string   `yaml:"service"` // compose service to exec into; default "app"
-	Workdir string   `yaml:"workdir"` // working directory inside container; default "/app"
+	Workdir string   `yaml:"workdir"` // working directory inside container; default the image's, else "/app"
 	Mounts  []string `yaml:"mounts"`  // extra host paths to bind-mount; ~/foo maps to /root/foo
+	Hide    []string `yaml:"hide"`    // worktree subpaths shadowed by container-local volumes
+
+	// EnvPassthrough names variables copied from the daemon's environment
+	// into the agent's (e.g. HTTPS_PROXY).  Unset variables are skipped.
+	EnvPassthrough []string `yaml:"env_passthrough"`
+
+	// Gitconfig controls the automatic read-only mount of the host's
+	// ~/.gitconfig; nil means enabled.
+	Gitconfig *bool `yaml:"gitconfig"`
+
+	// ComposeOverride is a compose-file fragment merged into the override
+	// grove generates (e.g. depends_on or environment for the app service).
+	ComposeOverride map[string]any `yaml:"compose_override"`
+
+	// Memory caps the container's memory (docker's --memory, e.g. "4g");
+	// MemorySwap caps memory plus swap (--memory-swap, "-1" for unlimited)
+	// and needs Memory.  Empty leaves docker's defaults.
+	Memory     string `yaml:"memory"`
+	MemorySwap string `yaml:"memory_swap"`
+}
+
+// CredentialsConfig overrides where an agent's credentials come from for one
+// project.  It lives in the per-machine registration (project.yaml), not in
+// grove.yaml, because the paths are specific to the host.
+type CredentialsConfig struct {
+	Dir     string `yaml:"dir"`      // mounted instead of the agent's default (e.g. ~/.claude)
+	EnvFile string `yaml:"env_file"` // used instead of ~/.grove/env
+}
+
+// GitConfig is the git: section of grove.yaml: git settings applied to each
+// new worktree.
+type GitConfig struct {
+	// HooksPath is set as core.hooksPath in each new worktree (e.g.
+	// ".githooks"), so commits made there run the team's hooks.  A relative
+	// path is resolved by git against the worktree root.
+	HooksPath string `yaml:"hooks_path"`
 }
 
 // Project holds the parsed contents of a project.yaml file.
@@ -25,31 +71,310 @@ type Project struct {
 	Name string `yaml:"name"`
 	Repo string `yaml:"repo"`
 
+	// DefaultBranch is the integration branch new worktrees start from and
+	// the main checkout follows.  Empty means the remote's HEAD.  Set in
+	// project.yaml or grove.yaml; grove.yaml wins.
+	DefaultBranch string `yaml:"default_branch"`
+
+	// Subdir, set in project.yaml, makes the project one directory of a
+	// monorepo: worktrees still hold the whole repo, but grove.yaml is read
+	// from this directory (falling back to the repo root) and the agent and
+	// start/check/finish commands run in it.  Each such project keeps its
+	// own clone under DataDir; projects on one repo do not share a clone.
+	Subdir string `yaml:"subdir"`
+
+	Credentials CredentialsConfig `yaml:"credentials"`
+
 	Container ContainerConfig `yaml:"container"`
 
-	Start  []string `yaml:"start"`
-	Finish []string `yaml:"finish"`
-	Check  []string `yaml:"check"`
+	Git GitConfig `yaml:"git"`
+
+	Start  []string    `yaml:"start"`
+	Finish []string    `yaml:"finish"`
+	Check  CheckConfig `yaml:"check"`
+
+	// CheckTimeout is how long one check command may run before it is
+	// killed and counted as failed (a Go duration, default 30m; "0" means
+	// no limit).
+	CheckTimeout string `yaml:"check_timeout"`
+
+	// Env is set for the agent and for the start, check and finish
+	// commands.  For the agent it overrides the env file and is overridden
+	// by values sent with the request (see Daemon.agentEnv).
+	Env map[string]string `yaml:"env"`
+
+	// EnvFile is a dotenv file committed to the repo, relative to grove.yaml,
+	// with safe defaults for the agent.  It ranks below every other source
+	// of agent env (see Daemon.agentEnv).
+	EnvFile string `yaml:"env_file"`
 
 	Agent struct {
 		Command string   `yaml:"command"`
 		Args    []string `yaml:"args"`
+
+		// Restart is the crash policy: "on-failure" relaunches an agent
+		// that exits non-zero, up to MaxRestarts times (default 3).
+		Restart     string `yaml:"restart"`
+		MaxRestarts int    `yaml:"max_restarts"`
+
+		// PTY runs the agent on a terminal; nil means true.  false runs it
+		// with plain pipes so stderr is kept apart from stdout in the log,
+		// for automated agents that need no terminal.  Such instances
+		// cannot be attached.
+		PTY *bool `yaml:"pty"`
+
+		// StartupCheck is how long the agent must stay up, or take to exit
+		// cleanly, after launch for grove start to report success (a Go
+		// duration, default 1s; "0" skips the check).
+		StartupCheck string `yaml:"startup_check"`
+
+		// SkipPermissions adds the agent's flag for running without asking
+		// before each edit or command (claude --dangerously-skip-permissions,
+		// aider --yes-always).  The container is the sandbox.  nil means
+		// false; a pointer so that grove.yaml can turn it off again.
+		SkipPermissions *bool `yaml:"skip_permissions"`
+
+		// NoDefaultArgs turns off grove's per-agent default args; see
+		// agentDefaultArgs.  nil means false, as for SkipPermissions.
+		NoDefaultArgs *bool `yaml:"no_default_args"`
 	} `yaml:"agent"`
 
+	// imageWorkdir is the image's own working directory, found by
+	// detectWorkdir when container.workdir is not set.
+	imageWorkdir string
+
 	// DataDir is where all project data lives: registration (project.yaml),
 	// canonical clone (main/), and worktrees (worktrees/).
 	// Always set to <daemonRoot>/projects/<name>.
 	DataDir string `yaml:"-"`
 }
 
-// containerWorkdir returns the working directory to use inside the container.
+// CheckGroup is a named list of check commands.  Commands in a group run one
+// after another; groups run concurrently.
+type CheckGroup struct {
+	Name     string
+	Commands []string
+}
+
+// CheckConfig is the check: section of grove.yaml.  It is either a list of
+// commands, each of which becomes its own unnamed group (so they all run
+// concurrently, as before groups existed), or a mapping of group name to a
+// command or list of commands.  Groups keep the order they are written in.
+type CheckConfig []CheckGroup
+
+// UnmarshalYAML accepts both the list and the mapping form of check:.
+func (c *CheckConfig) UnmarshalYAML(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.SequenceNode:
+		var cmds []string
+		if err := n.Decode(&cmds); err != nil {
+			return err
+		}
+		groups := make(CheckConfig, 0, len(cmds))
+		for _, cmd := range cmds {
+			groups = append(groups, CheckGroup{Commands: []string{cmd}})
+		}
+		*c = groups
+		return nil
+	case yaml.MappingNode:
+		groups := make(CheckConfig, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			name, val := n.Content[i].Value, n.Content[i+1]
+			var cmds []string
+			if val.Kind == yaml.ScalarNode {
+				cmds = []string{val.Value}
+			} else if err := val.Decode(&cmds); err != nil {
+				return fmt.Errorf("check group %q: %w", name, err)
+			}
+			groups = append(groups, CheckGroup{Name: name, Commands: cmds})
+		}
+		*c = groups
+		return nil
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			*c = nil
+			return nil
+		}
+	}
+	return fmt.Errorf("line %d: check must be a list of commands or a map of group name to commands", n.Line)
+}
+
+// named reports whether the groups come from the mapping form.
+func (c CheckConfig) named() bool {
+	return len(c) > 0 && c[0].Name != ""
+}
+
+// selectGroups returns the groups named in only (all groups if only is
+// empty), minus any named in skip.  Unknown names are an error so a typo
+// does not silently run nothing.
+func (c CheckConfig) selectGroups(only, skip []string) (CheckConfig, error) {
+	if len(only) == 0 && len(skip) == 0 {
+		return c, nil
+	}
+	if !c.named() {
+		return nil, fmt.Errorf("--only and --skip need named check groups in grove.yaml (check: is a plain list)")
+	}
+	known := make(map[string]bool, len(c))
+	var names []string
+	for _, g := range c {
+		known[g.Name] = true
+		names = append(names, g.Name)
+	}
+	for _, name := range append(append([]string{}, only...), skip...) {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown check group %q (have: %s)", name, strings.Join(names, ", "))
+		}
+	}
+	var selected CheckConfig
+	for _, g := range c {
+		if len(only) > 0 && !slices.Contains(only, g.Name) {
+			continue
+		}
+		if slices.Contains(skip, g.Name) {
+			continue
+		}
+		selected = append(selected, g)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no check groups selected")
+	}
+	return selected, nil
+}
+
+// defaultMaxRestarts is the restart limit for agent.restart: on-failure when
+// max_restarts is not set.
+const defaultMaxRestarts = 3
+
+// agentMaxRestarts returns how many times a crashed agent may be relaunched
+// automatically; 0 unless agent.restart is "on-failure".
+func (p *Project) agentMaxRestarts() int {
+	if p.Agent.Restart != "on-failure" {
+		return 0
+	}
+	if p.Agent.MaxRestarts > 0 {
+		return p.Agent.MaxRestarts
+	}
+	return defaultMaxRestarts
+}
+
+// defaultStartupCheck is the agent.startup_check window when none is set.
+const defaultStartupCheck = time.Second
+
+// agentStartupCheck returns how long handleStart waits after launching the
+// agent to confirm it did not exit straight away.  An unparseable value falls
+// back to the default.
+func (p *Project) agentStartupCheck() time.Duration {
+	if p.Agent.StartupCheck == "" {
+		return defaultStartupCheck
+	}
+	if p.Agent.StartupCheck == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(p.Agent.StartupCheck)
+	if err != nil || d < 0 {
+		return defaultStartupCheck
+	}
+	return d
+}
+
+// defaultCheckTimeout is the check_timeout when none is set.
+const defaultCheckTimeout = 30 * time.Minute
+
+// checkTimeout returns how long handleCheck lets each check command run, or
+// 0 for no limit.  An unparseable value falls back to the default.
+func (p *Project) checkTimeout() time.Duration {
+	if p.CheckTimeout == "" {
+		return defaultCheckTimeout
+	}
+	if p.CheckTimeout == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(p.CheckTimeout)
+	if err != nil || d < 0 {
+		return defaultCheckTimeout
+	}
+	return d
+}
+
+// agentPipe reports whether the agent runs without a PTY (agent.pty: false).
+func (p *Project) agentPipe() bool {
+	return p.Agent.PTY != nil && !*p.Agent.PTY
+}
+
+// agentDefaultArgs returns the args grove passes to known agents unless
+// agent.no_default_args is set, plus the agent's flag for
+// agent.skip_permissions.  Unknown agents get none.
+func (p *Project) agentDefaultArgs(agentCmd string) []string {
+	skipPermissions := p.Agent.SkipPermissions != nil && *p.Agent.SkipPermissions
+	var args []string
+	switch agentCmd {
+	case "aider":
+		// Containers are rebuilt often; the update check only adds noise.
+		if p.Agent.NoDefaultArgs == nil || !*p.Agent.NoDefaultArgs {
+			args = append(args, "--no-check-update")
+		}
+		if skipPermissions {
+			args = append(args, "--yes-always")
+		}
+	case "claude":
+		if skipPermissions {
+			args = append(args, "--dangerously-skip-permissions")
+		}
+	}
+	return args
+}
+
+// agentArgs returns the full argument list for agentCmd: the defaults from
+// agentDefaultArgs, minus any flag that agent.args or extra (per-run
+// --agent-arg values) set themselves, then agent.args, then extra.  A flag
+// counts as set if it appears with or without a value or as its --no- form,
+// so --check-update in agent.args drops the default --no-check-update.
+func (p *Project) agentArgs(agentCmd string, extra []string) []string {
+	user := append(append([]string(nil), p.Agent.Args...), extra...)
+	var args []string
+	for _, d := range p.agentDefaultArgs(agentCmd) {
+		if !hasFlag(user, d) {
+			args = append(args, d)
+		}
+	}
+	return append(args, user...)
+}
+
+// hasFlag reports whether args contain flag, ignoring any "=value" and a
+// "no-" prefix on either side.
+func hasFlag(args []string, flag string) bool {
+	name := func(a string) string {
+		a, _, _ = strings.Cut(a, "=")
+		a = strings.TrimLeft(a, "-")
+		return strings.TrimPrefix(a, "no-")
+	}
+	want := name(flag)
+	for _, a := range args {
+		if strings.HasPrefix(a, "-") && name(a) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// containerWorkdir returns the working directory to use inside the container:
+// container.workdir, else the image's (see detectWorkdir), else /app.
 func (p *Project) containerWorkdir() string {
 	if p.Container.Workdir != "" {
 		return p.Container.Workdir
 	}
+	if p.imageWorkdir != "" {
+		return p.imageWorkdir
+	}
 	return "/app"
 }
 
+// mountGitconfig reports whether the host's ~/.gitconfig should be mounted
+// into the container.  Defaults to true.
+func (p *Project) mountGitconfig() bool {
+	return p.Container.Gitconfig == nil || *p.Container.Gitconfig
+}
+
 // containerService returns the compose service name to exec into.
 func (p *Project) containerService() string {
 	if p.Container.Service != "" {
@@ -58,6 +383,18 @@ func (p *Project) containerService() string {
 	return "app"
 }
 
+// execDir is the in-container directory the agent and the start, check and
+// finish commands run in: the workdir, or the project's subdir within it.
+func (p *Project) execDir() string {
+	return path.Join(p.containerWorkdir(), p.Subdir)
+}
+
+// inRepoConfigPath returns the grove.yaml loadInRepoConfig reads: the one in
+// the project's subdir if there is one, else the one at the repo root.
+func (p *Project) inRepoConfigPath() string {
+	return registration.InRepoConfigPath(p.MainDir(), p.Subdir)
+}
+
 // MainDir returns the path of the canonical checkout for this project.
 func (p *Project) MainDir() string {
 	return filepath.Join(p.DataDir, "main")
@@ -73,6 +410,53 @@ func (p *Project) WorktreeDir(instanceID string) string {
 	return filepath.Join(p.WorktreesDir(), instanceID)
 }
 
+// agentEnvFile returns the env file whose values are injected into the agent:
+// the project's credentials.env_file if set, otherwise <dataRoot>/env.
+func (p *Project) agentEnvFile(dataRoot string) string {
+	return registration.AgentEnvFile(dataRoot, p.Credentials.EnvFile)
+}
+
+// loadRepoEnv reads env_file from worktreeDir, relative to the directory of
+// the grove.yaml that names it: the project's subdir, if it has one.  It
+// returns nil if none is set, and an error if the file is missing or
+// env_file points outside the worktree, directly or through a symlink; a
+// start carries on without it.
+func (p *Project) loadRepoEnv(worktreeDir string) (map[string]string, error) {
+	if p.EnvFile == "" {
+		return nil, nil
+	}
+	inRepo := filepath.Join(filepath.FromSlash(p.Subdir), p.EnvFile)
+	if filepath.IsAbs(p.EnvFile) || !filepath.IsLocal(inRepo) {
+		return nil, fmt.Errorf("env_file %s is not inside the repo; give a path relative to grove.yaml", p.EnvFile)
+	}
+	path := filepath.Join(worktreeDir, inRepo)
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("env_file %s not found in the worktree", p.EnvFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("env_file %s: %w", p.EnvFile, err)
+	}
+	root, err := filepath.EvalSymlinks(worktreeDir)
+	if err != nil {
+		return nil, fmt.Errorf("env_file %s: %w", p.EnvFile, err)
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("env_file %s links outside the worktree", p.EnvFile)
+	}
+	return envfile.Load(resolved), nil
+}
+
+// projectNotFoundError is loadProject's error for a project that is not
+// registered.
+type projectNotFoundError struct {
+	name, yamlPath string
+}
+
+func (e *projectNotFoundError) Error() string {
+	return fmt.Sprintf("project %q not found (expected %s)", e.name, e.yamlPath)
+}
+
 // loadProject reads the project registration from <dataRoot>/projects/<name>/project.yaml.
 // The registration only carries name and repo — all other config (container, agent,
 // start, finish, check) comes exclusively from grove.yaml in the project repo.
@@ -82,23 +466,35 @@ func loadProject(dataRoot, name string) (*Project, error) {
 	data, err := os.ReadFile(yamlPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("project %q not found (expected %s)", name, yamlPath)
+			return nil, &projectNotFoundError{name: name, yamlPath: yamlPath}
 		}
 		return nil, fmt.Errorf("read project.yaml: %w", err)
 	}
 
 	var reg struct {
-		Name string `yaml:"name"`
-		Repo string `yaml:"repo"`
+		Name          string            `yaml:"name"`
+		Repo          string            `yaml:"repo"`
+		DefaultBranch string            `yaml:"default_branch"`
+		Subdir        string            `yaml:"subdir"`
+		Credentials   CredentialsConfig `yaml:"credentials"`
+		Env           map[string]string `yaml:"env"`
 	}
 	if err := yaml.Unmarshal(data, &reg); err != nil {
 		return nil, fmt.Errorf("parse project.yaml: %w", err)
 	}
+	subdir, err := registration.CleanSubdir(reg.Subdir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", yamlPath, err)
+	}
 
 	p := &Project{
-		Name:    reg.Name,
-		Repo:    reg.Repo,
-		DataDir: projectDir,
+		Name:          reg.Name,
+		Repo:          reg.Repo,
+		DefaultBranch: reg.DefaultBranch,
+		Subdir:        subdir,
+		Credentials:   reg.Credentials,
+		Env:           reg.Env,
+		DataDir:       projectDir,
 	}
 	if p.Name == "" {
 		p.Name = name
@@ -142,21 +538,117 @@ func ensureMainCheckout(p *Project, w io.Writer) error {
 	return nil
 }
 
+// repoCheckTimeout bounds checkRepoAccess so an unreachable host fails fast.
+const repoCheckTimeout = 20 * time.Second
+
+// checkRepoAccess runs "git ls-remote" against repo, without cloning, to
+// confirm the URL resolves and the daemon's credentials can read it.  The
+// error names the likely cause (authentication, not found, host lookup)
+// followed by git's own message.
+func checkRepoAccess(repo string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), repoCheckTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", repo, "HEAD")
+	// Never block on a username/password prompt; report it as an auth failure.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s reaching %s", repoCheckTimeout, repo)
+	}
+	if err == nil {
+		return nil
+	}
+	detail := strings.TrimSpace(string(out))
+	return fmt.Errorf("%s: %s", classifyGitRemoteError(detail), detail)
+}
+
+// classifyGitRemoteError maps git's remote error output to a short cause.
+func classifyGitRemoteError(out string) string {
+	lower := strings.ToLower(out)
+	switch {
+	case strings.Contains(lower, "permission denied"),
+		strings.Contains(lower, "authentication failed"),
+		strings.Contains(lower, "could not read username"),
+		strings.Contains(lower, "terminal prompts disabled"),
+		strings.Contains(lower, "host key verification failed"):
+		return "authentication failed"
+	case strings.Contains(lower, "repository not found"),
+		strings.Contains(lower, "not found"),
+		strings.Contains(lower, "does not appear to be a git repository"),
+		strings.Contains(lower, "does not exist"):
+		return "repository not found (or no access)"
+	case strings.Contains(lower, "could not resolve host"):
+		return "cannot resolve host"
+	}
+	return "git ls-remote failed"
+}
+
 // pullMain runs "git pull" in the main checkout to bring it up-to-date with
 // the remote before branching.  Errors are non-fatal — the caller logs and
 // continues so that offline use still works.  Output is written to w.
+//
+// With a default branch configured the main checkout is switched to it and
+// fast-forwarded to origin/<branch> instead.  Since grove.yaml may be what
+// names the branch, the copy already checked out is consulted; p's own
+// DefaultBranch applies when it does not set one.
 func pullMain(p *Project, w io.Writer) error {
-	cmd := exec.Command("git", "-C", p.MainDir(), "pull")
+	mainDir := p.MainDir()
+	branch := p.DefaultBranch
+	if b := inRepoDefaultBranch(filepath.Dir(p.inRepoConfigPath())); b != "" {
+		branch = b
+	}
+	if branch == "" {
+		cmd := exec.Command("git", "-C", mainDir, "pull")
+		cmd.Stdout = w
+		cmd.Stderr = w
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git pull: %w", err)
+		}
+		return nil
+	}
+
+	cmd := exec.Command("git", "-C", mainDir, "fetch", "origin")
 	cmd.Stdout = w
 	cmd.Stderr = w
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("git pull: %w", err)
+		return fmt.Errorf("git fetch: %w", err)
+	}
+	if !gitRefExists(mainDir, "origin/"+branch) {
+		return fmt.Errorf("default_branch %q does not exist on origin", branch)
+	}
+	for _, args := range [][]string{
+		{"checkout", "--quiet", branch},
+		{"merge", "--ff-only", "--quiet", "origin/" + branch},
+	} {
+		cmd := exec.Command("git", append([]string{"-C", mainDir}, args...)...)
+		cmd.Stdout = w
+		cmd.Stderr = w
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s: %w", args[0], err)
+		}
 	}
 	return nil
 }
 
+// inRepoDefaultBranch returns default_branch from the grove.yaml in dir, or
+// "" if the file is missing, unreadable, or does not set it.
+func inRepoDefaultBranch(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "grove.yaml"))
+	if err != nil {
+		return ""
+	}
+	var cfg struct {
+		DefaultBranch string `yaml:"default_branch"`
+	}
+	if yaml.Unmarshal(data, &cfg) != nil {
+		return ""
+	}
+	return cfg.DefaultBranch
+}
+
 // createWorktree creates a new git worktree at worktreeDir on branch branchName,
-// branching off from the current HEAD of the main checkout.
+// branching off from the project's default branch if one is configured, or
+// else the current HEAD of the main checkout.
 func createWorktree(p *Project, instanceID, branchName string, w io.Writer) (string, error) {
 	mainDir := p.MainDir()
 	worktreeDir := p.WorktreeDir(instanceID)
@@ -165,8 +657,23 @@ func createWorktree(p *Project, instanceID, branchName string, w io.Writer) (str
 		return "", err
 	}
 
+	// The local branch is preferred since pullMain keeps it current; a
+	// remote-only one is branched from without tracking so a later plain
+	// "git push" cannot land on the integration branch.
+	addArgs := []string{"-C", mainDir, "worktree", "add", "-b", branchName, worktreeDir}
+	if base := p.DefaultBranch; base != "" {
+		switch {
+		case gitRefExists(mainDir, "refs/heads/"+base):
+			addArgs = append(addArgs, base)
+		case gitRefExists(mainDir, "refs/remotes/origin/"+base):
+			addArgs = append(addArgs, "--no-track", "origin/"+base)
+		default:
+			return "", fmt.Errorf("default_branch %q does not exist on origin or locally in %s", base, mainDir)
+		}
+	}
+
 	// Try creating a new branch; if it already exists, check it out directly.
-	cmd := exec.Command("git", "-C", mainDir, "worktree", "add", "-b", branchName, worktreeDir)
+	cmd := exec.Command("git", addArgs...)
 	cmd.Stdout = w
 	cmd.Stderr = w
 	if err := cmd.Run(); err != nil {
@@ -181,27 +688,148 @@ func createWorktree(p *Project, instanceID, branchName string, w io.Writer) (str
 	return worktreeDir, nil
 }
 
-// removeWorktree removes the git worktree for the given instance and deletes
-// the associated branch.  Errors are best-effort (logged but not fatal).
-func removeWorktree(p *Project, instanceID, branchName string) {
+// resumeWorktree creates a worktree for instanceID that checks out an existing
+// branch, preserving its commits.  A local branch is preferred; otherwise a
+// local branch is created from origin/<branch>.  It is an error if the branch
+// exists in neither place.
+//
+// Stale worktree registrations (directories that no longer exist) are pruned
+// first.  If the branch is still checked out in a worktree that does exist,
+// git refuses and its message is returned in the error.
+func resumeWorktree(p *Project, instanceID, branchName string, w io.Writer) (string, error) {
 	mainDir := p.MainDir()
 	worktreeDir := p.WorktreeDir(instanceID)
 
-	// git worktree remove --force <path>
-	exec.Command("git", "-C", mainDir, "worktree", "remove", "--force", worktreeDir).Run()
+	if err := os.MkdirAll(p.WorktreesDir(), 0o755); err != nil {
+		return "", err
+	}
+	exec.Command("git", "-C", mainDir, "worktree", "prune").Run()
+
+	var args []string
+	switch {
+	case gitRefExists(mainDir, "refs/heads/"+branchName):
+		args = []string{"worktree", "add", worktreeDir, branchName}
+	case gitRefExists(mainDir, "refs/remotes/origin/"+branchName):
+		args = []string{"worktree", "add", "--track", "-b", branchName, worktreeDir, "origin/" + branchName}
+	default:
+		return "", fmt.Errorf("cannot resume: branch %q not found locally or on origin", branchName)
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", mainDir}, args...)...)
+	cmd.Stdout = io.MultiWriter(w, &out)
+	cmd.Stderr = io.MultiWriter(w, &out)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git worktree add: %w: %s", err, strings.TrimSpace(out.String()))
+	}
+	return worktreeDir, nil
+}
+
+// configureWorktreeGit applies the git: section of grove.yaml to the new
+// worktree at dir.  Settings go in the worktree's own config file
+// (git config --worktree, enabling extensions.worktreeConfig in the main
+// checkout if needed) so the main checkout and other worktrees keep theirs.
+func configureWorktreeGit(p *Project, dir string, w io.Writer) error {
+	if p.Git.HooksPath == "" {
+		return nil
+	}
+	if out, err := exec.Command("git", "-C", p.MainDir(), "config", "extensions.worktreeConfig", "true").CombinedOutput(); err != nil {
+		return fmt.Errorf("enable per-worktree git config: %s", strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.Command("git", "-C", dir, "config", "--worktree", "core.hooksPath", p.Git.HooksPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("set core.hooksPath: %s", strings.TrimSpace(string(out)))
+	}
+	fmt.Fprintf(w, "Git hooks: core.hooksPath = %s\n", p.Git.HooksPath)
+	return nil
+}
+
+// gitRefExists reports whether ref resolves in the repository at dir.
+func gitRefExists(dir, ref string) bool {
+	return exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", ref).Run() == nil
+}
+
+// removeWorktree removes the git worktree for the given instance and deletes
+// the associated branch.  Errors are best-effort (logged but not fatal).
+func removeWorktree(p *Project, instanceID, branchName string) {
+	removeWorktreeDir(p, instanceID)
 
 	// git branch -D <branch>
-	exec.Command("git", "-C", mainDir, "branch", "-D", branchName).Run()
+	exec.Command("git", "-C", p.MainDir(), "branch", "-D", branchName).Run()
 }
 
-// loadInRepoConfig reads grove.yaml from the root of the project's main clone
-// and overlays its fields onto p.  In-repo config takes precedence over the
-// registration so teams can commit authoritative settings alongside their code.
+// renameBranch renames branch from to to in the worktree at dir.  git moves
+// the worktree's HEAD along with it, so a running agent stays on the branch.
+func renameBranch(dir, from, to string) error {
+	if err := gitref.CheckBranchName(dir, to); err != nil {
+		return err
+	}
+	if out, err := exec.Command("git", "-C", dir, "branch", "-m", from, to).CombinedOutput(); err != nil {
+		return fmt.Errorf("git branch -m: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// commitsAhead returns how many commits HEAD in dir has that the
+// repository's default branch (see gitref.DefaultBranch) does not, and the
+// ref it compared against.
+func commitsAhead(dir, defaultBranch string) (int, string, error) {
+	name, base := gitref.DefaultBranch(dir, defaultBranch)
+	if base == "" {
+		if name != "" {
+			return 0, "", fmt.Errorf("default_branch %q does not exist on origin or locally in %s", name, dir)
+		}
+		return 0, "", fmt.Errorf("cannot find the default branch in %s", dir)
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-list", "--count", base+"..HEAD").Output()
+	if err != nil {
+		return 0, base, fmt.Errorf("git rev-list %s..HEAD: %w", base, err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	return n, base, err
+}
+
+// defaultBranchName returns the name of the repository's default branch as
+// seen from dir (see gitref.DefaultBranch), or "" if none can be found.
+func defaultBranchName(dir, defaultBranch string) string {
+	name, _ := gitref.DefaultBranch(dir, defaultBranch)
+	return name
+}
+
+// checkWorktree returns an error if dir no longer exists or is no longer a
+// git worktree.  Either form of .git counts: the file a linked worktree has
+// and the directory of a main checkout.
+func checkWorktree(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("worktree %s is missing", dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return fmt.Errorf("worktree %s is no longer a git worktree", dir)
+	}
+	return nil
+}
+
+// worktreeDirty reports whether the worktree at dir has uncommitted changes.
+func worktreeDirty(dir string) bool {
+	out, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	return err == nil && len(bytes.TrimSpace(out)) > 0
+}
+
+// removeWorktreeDir removes the git worktree for the given instance but keeps
+// its branch.
+func removeWorktreeDir(p *Project, instanceID string) {
+	// git worktree remove --force <path>
+	exec.Command("git", "-C", p.MainDir(), "worktree", "remove", "--force", p.WorktreeDir(instanceID)).Run()
+}
+
+// loadInRepoConfig reads grove.yaml from the project's main clone (see
+// inRepoConfigPath) and overlays its fields onto p.  In-repo config takes
+// precedence over the registration so teams can commit authoritative
+// settings alongside their code.
 //
 // Returns (true, nil) if the file was found and applied, (false, nil) if it
 // does not exist, or (false, err) on a parse error.
 func loadInRepoConfig(p *Project) (bool, error) {
-	inRepoPath := filepath.Join(p.MainDir(), "grove.yaml")
+	inRepoPath := p.inRepoConfigPath()
 	data, err := os.ReadFile(inRepoPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -232,11 +860,65 @@ func loadInRepoConfig(p *Project) (bool, error) {
 	if len(overlay.Container.Mounts) > 0 {
 		p.Container.Mounts = overlay.Container.Mounts
 	}
+	if len(overlay.Container.Hide) > 0 {
+		p.Container.Hide = overlay.Container.Hide
+	}
+	if len(overlay.Container.EnvPassthrough) > 0 {
+		p.Container.EnvPassthrough = overlay.Container.EnvPassthrough
+	}
+	if overlay.Container.Gitconfig != nil {
+		p.Container.Gitconfig = overlay.Container.Gitconfig
+	}
+	if len(overlay.Container.ComposeOverride) > 0 {
+		p.Container.ComposeOverride = overlay.Container.ComposeOverride
+	}
+	if overlay.Container.Memory != "" {
+		p.Container.Memory = overlay.Container.Memory
+	}
+	if overlay.Container.MemorySwap != "" {
+		p.Container.MemorySwap = overlay.Container.MemorySwap
+	}
+	if overlay.DefaultBranch != "" {
+		p.DefaultBranch = overlay.DefaultBranch
+	}
+	if overlay.Git.HooksPath != "" {
+		p.Git.HooksPath = overlay.Git.HooksPath
+	}
 	if len(overlay.Start) > 0 {
 		p.Start = overlay.Start
 	}
+	if overlay.CheckTimeout != "" {
+		p.CheckTimeout = overlay.CheckTimeout
+	}
+	if overlay.EnvFile != "" {
+		p.EnvFile = overlay.EnvFile
+	}
+	// env: merges key by key, grove.yaml winning over project.yaml.
+	for k, v := range overlay.Env {
+		if p.Env == nil {
+			p.Env = map[string]string{}
+		}
+		p.Env[k] = v
+	}
 	if overlay.Agent.Command != "" {
 		p.Agent = overlay.Agent
+	} else {
+		if overlay.Agent.Restart != "" {
+			p.Agent.Restart = overlay.Agent.Restart
+			p.Agent.MaxRestarts = overlay.Agent.MaxRestarts
+		}
+		if overlay.Agent.StartupCheck != "" {
+			p.Agent.StartupCheck = overlay.Agent.StartupCheck
+		}
+		if overlay.Agent.PTY != nil {
+			p.Agent.PTY = overlay.Agent.PTY
+		}
+		if overlay.Agent.SkipPermissions != nil {
+			p.Agent.SkipPermissions = overlay.Agent.SkipPermissions
+		}
+		if overlay.Agent.NoDefaultArgs != nil {
+			p.Agent.NoDefaultArgs = overlay.Agent.NoDefaultArgs
+		}
 	}
 	if len(overlay.Finish) > 0 {
 		p.Finish = overlay.Finish
@@ -248,12 +930,56 @@ func loadInRepoConfig(p *Project) (bool, error) {
 	return true, nil
 }
 
+// configChange is one grove.yaml section that differs between two loads of
+// a project, and when the new value takes effect for running instances.
+type configChange struct {
+	section string
+	applies string
+}
+
+// configChanges compares two loaded configs section by section.  check and
+// finish are read afresh on every grove check / grove finish, and the agent
+// settings on every grove restart; container and start only shape new
+// instances, since a running instance keeps the container it was created
+// with.
+func configChanges(before, after *Project) []configChange {
+	var changes []configChange
+	if !reflect.DeepEqual(before.Check, after.Check) || before.CheckTimeout != after.CheckTimeout {
+		changes = append(changes, configChange{"check", "from the next grove check"})
+	}
+	if !reflect.DeepEqual(before.Finish, after.Finish) {
+		changes = append(changes, configChange{"finish", "from the next grove finish"})
+	}
+	if !reflect.DeepEqual(before.Env, after.Env) {
+		changes = append(changes, configChange{"env", "from the next grove check or finish, and for the agent when it is next started with grove restart"})
+	}
+	if before.EnvFile != after.EnvFile {
+		changes = append(changes, configChange{"env_file", "for the agent when it is next started with grove restart"})
+	}
+	if !reflect.DeepEqual(before.Agent, after.Agent) {
+		changes = append(changes, configChange{"agent", "when an agent is next started with grove restart (on-failure relaunches keep the old settings)"})
+	}
+	if !reflect.DeepEqual(before.Container, after.Container) {
+		changes = append(changes, configChange{"container", "for new instances only; drop and start again to use it"})
+	}
+	if before.DefaultBranch != after.DefaultBranch {
+		changes = append(changes, configChange{"default_branch", "for new instances only; existing branches keep their base"})
+	}
+	if before.Git != after.Git {
+		changes = append(changes, configChange{"git", "for new instances only; existing worktrees keep their git settings"})
+	}
+	if !reflect.DeepEqual(before.Start, after.Start) {
+		changes = append(changes, configChange{"start", "for new instances only; start commands run once per container"})
+	}
+	return changes
+}
+
 // runStart executes the project start commands sequentially inside the container.
 // All output is written to w.
 func runStart(p *Project, containerName string, w io.Writer) error {
 	for _, cmdStr := range p.Start {
 		fmt.Fprintf(w, "Start: %s\n", cmdStr)
-		if err := execInContainer(containerName, cmdStr, w); err != nil {
+		if err := execInContainer(context.Background(), containerName, cmdStr, p.Env, w); err != nil {
 			return fmt.Errorf("start %q: %w", cmdStr, err)
 		}
 	}
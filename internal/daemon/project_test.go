@@ -1,12 +1,19 @@
 package daemon
 
 import (
+	"bytes"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gandalfthegui/grove/internal/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestProjectDirHelpers(t *testing.T) {
@@ -71,6 +78,65 @@ func TestLoadInRepoConfig(t *testing.T) {
 	assert.Equal(t, []string{"git push"}, p.Finish)
 }
 
+func TestLoadInRepoConfigEnv(t *testing.T) {
+	root := t.TempDir()
+	dataDir := filepath.Join(root, "projects", "app")
+	mainDir := filepath.Join(dataDir, "main")
+	require.NoError(t, os.MkdirAll(mainDir, 0o755))
+	reg := "name: app\nenv:\n  PORT: \"8080\"\n  REGION: eu\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "project.yaml"), []byte(reg), 0o644))
+	yaml := "env:\n  RAILS_ENV: development\n  PORT: \"3000\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(mainDir, "grove.yaml"), []byte(yaml), 0o644))
+
+	p, err := loadProject(root, "app")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"PORT": "8080", "REGION": "eu"}, p.Env)
+	_, err = loadInRepoConfig(p)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"RAILS_ENV": "development", "PORT": "3000", "REGION": "eu"}, p.Env,
+		"grove.yaml merges over project.yaml key by key")
+}
+
+func TestLoadRepoEnv(t *testing.T) {
+	worktree := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(worktree, "config"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, "config", "grove.env"), []byte("FEATURE_X=on\n"), 0o644))
+	outside := filepath.Join(t.TempDir(), "secrets.env")
+	require.NoError(t, os.WriteFile(outside, []byte("TOKEN=host\n"), 0o600))
+	require.NoError(t, os.Symlink(outside, filepath.Join(worktree, "linked.env")))
+
+	p := &Project{}
+	env, err := p.loadRepoEnv(worktree)
+	assert.NoError(t, err)
+	assert.Nil(t, env, "no env_file set")
+
+	p.EnvFile = "config/grove.env"
+	env, err = p.loadRepoEnv(worktree)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"FEATURE_X": "on"}, env)
+
+	// With a subdir it is relative to that grove.yaml.
+	p.Subdir = "config"
+	p.EnvFile = "grove.env"
+	env, err = p.loadRepoEnv(worktree)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"FEATURE_X": "on"}, env)
+	p.EnvFile = "../linked.env"
+	_, err = p.loadRepoEnv(worktree)
+	assert.EqualError(t, err, "env_file ../linked.env links outside the worktree")
+	p.Subdir = ""
+
+	p.EnvFile = "missing.env"
+	_, err = p.loadRepoEnv(worktree)
+	assert.EqualError(t, err, "env_file missing.env not found in the worktree")
+
+	for _, escape := range []string{"../secrets.env", outside, "linked.env"} {
+		p.EnvFile = escape
+		_, err = p.loadRepoEnv(worktree)
+		assert.Error(t, err, escape)
+	}
+}
+
 func TestLoadInRepoConfigMissing(t *testing.T) {
 	p := &Project{DataDir: t.TempDir()}
 	found, err := loadInRepoConfig(p)
@@ -97,3 +163,450 @@ func TestLoadInRepoConfigPartialDoesNotWipeOtherFields(t *testing.T) {
 	assert.Empty(t, p.Agent.Command, "agent should remain empty when absent from in-repo config")
 	assert.Empty(t, p.Finish, "finish should remain empty when absent from in-repo config")
 }
+
+func TestConfigChangesAfterGroveYAMLRewrite(t *testing.T) {
+	// A fake docker that records the command line of each check it runs.
+	calls := fakeDocker(t, "for a; do last=$a; done\necho \"$last\" >> \"$CALLS\"\n")
+
+	root := t.TempDir()
+	dataDir := filepath.Join(root, "projects", "app")
+	mainDir := filepath.Join(dataDir, "main")
+	require.NoError(t, os.MkdirAll(mainDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "project.yaml"), []byte("name: app\n"), 0o644))
+	groveYAML := filepath.Join(mainDir, "grove.yaml")
+	worktree := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: x\n"), 0o644))
+
+	d := &Daemon{rootDir: root, instances: map[string]*Instance{"1": {ID: "1", Project: "app",
+		WorktreeDir: worktree, ContainerID: "grove-1", state: proto.StateWaiting}}}
+	check := func() {
+		resp := callHandler(t, d.handleCheck, proto.Request{InstanceID: "1"})
+		require.True(t, resp.OK, resp.Error)
+	}
+
+	require.NoError(t, os.WriteFile(groveYAML, []byte("agent:\n  command: claude\ncheck:\n  - make test\nfinish:\n  - git push\n"), 0o644))
+	before := &Project{DataDir: dataDir}
+	_, err := loadInRepoConfig(before)
+	require.NoError(t, err)
+	check()
+
+	// grove check loads grove.yaml on every call, so the running instance
+	// must pick up the rewrite without any daemon state being refreshed.
+	require.NoError(t, os.WriteFile(groveYAML, []byte("agent:\n  command: claude\ncheck:\n  - make lint\nfinish:\n  - git push\n"), 0o644))
+	check()
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "make test\nmake lint\n", string(data))
+
+	after := &Project{DataDir: dataDir}
+	_, err = loadInRepoConfig(after)
+	require.NoError(t, err)
+	changes := configChanges(before, after)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "check", changes[0].section)
+
+	assert.Empty(t, configChanges(after, after))
+}
+
+func TestLoadProjectCredentialsOverride(t *testing.T) {
+	dataRoot := t.TempDir()
+	t.Setenv("HOME", "/home/u")
+
+	projectDir := filepath.Join(dataRoot, "projects", "work")
+	require.NoError(t, os.MkdirAll(projectDir, 0o755))
+	yaml := "name: work\nrepo: git@github.com:org/work.git\ncredentials:\n  dir: ~/.claude-work\n  env_file: ~/.grove/work.env\n"
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "project.yaml"), []byte(yaml), 0o644))
+
+	p, err := loadProject(dataRoot, "work")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/u/.grove/work.env", p.agentEnvFile(dataRoot))
+	assert.Equal(t, [][2]string{{"/home/u/.claude-work", "/root/.claude"}},
+		agentCredentialMounts("claude", "/home/u", p.Credentials.Dir))
+}
+
+func TestAgentEnvFileDefault(t *testing.T) {
+	p := &Project{}
+	assert.Equal(t, "/data/env", p.agentEnvFile("/data"))
+	assert.Equal(t, [][2]string{{"/home/u/.claude", "/root/.claude"}},
+		agentCredentialMounts("claude", "/home/u", ""))
+}
+
+func TestResumeWorktreeChecksOutExistingBranch(t *testing.T) {
+	p := &Project{DataDir: t.TempDir()}
+	main := p.MainDir()
+	require.NoError(t, os.MkdirAll(main, 0o755))
+	git := func(args ...string) {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", main}, args...)...).CombinedOutput()
+		require.NoError(t, err, "git %v: %s", args, out)
+	}
+	git("init", "-q")
+	git("-c", "user.email=t@t", "-c", "user.name=t", "commit", "-q", "--allow-empty", "-m", "init")
+	git("checkout", "-q", "-b", "feat/kept")
+	require.NoError(t, os.WriteFile(filepath.Join(main, "kept.txt"), []byte("x"), 0o644))
+	git("add", "kept.txt")
+	git("-c", "user.email=t@t", "-c", "user.name=t", "commit", "-q", "-m", "work")
+	git("checkout", "-q", "-")
+
+	dir, err := resumeWorktree(p, "1", "feat/kept", io.Discard)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "kept.txt"))
+
+	_, err = resumeWorktree(p, "2", "feat/missing", io.Discard)
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestDefaultBranchPullAndWorktreeBase(t *testing.T) {
+	origin := t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.email=t@t", "-c", "user.name=t"}, args...)...).CombinedOutput()
+		require.NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	git(origin, "init", "-q", "-b", "main")
+	git(origin, "commit", "-q", "--allow-empty", "-m", "init")
+	git(origin, "checkout", "-q", "-b", "develop")
+	require.NoError(t, os.WriteFile(filepath.Join(origin, "grove.yaml"), []byte("default_branch: develop\n"), 0o644))
+	git(origin, "add", "grove.yaml")
+	git(origin, "commit", "-q", "-m", "develop work")
+	git(origin, "checkout", "-q", "main")
+
+	p := &Project{DataDir: t.TempDir()}
+	require.NoError(t, exec.Command("git", "clone", "-q", origin, p.MainDir()).Run())
+
+	// The clone is on main, whose grove.yaml says nothing, so the
+	// registration's default_branch decides what to follow.
+	p.DefaultBranch = "develop"
+	require.NoError(t, pullMain(p, io.Discard))
+	assert.Equal(t, "develop", git(p.MainDir(), "rev-parse", "--abbrev-ref", "HEAD"))
+
+	dir, err := createWorktree(p, "1", "feat/x", io.Discard)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "grove.yaml"), "worktree should branch from develop")
+
+	// The checked-out grove.yaml now names develop, and wins over the
+	// registration.
+	p.DefaultBranch = ""
+	assert.NoError(t, pullMain(p, io.Discard))
+
+	p.DefaultBranch = "release"
+	require.NoError(t, os.Remove(filepath.Join(p.MainDir(), "grove.yaml")))
+	assert.ErrorContains(t, pullMain(p, io.Discard), `default_branch "release" does not exist on origin`)
+	_, err = createWorktree(p, "2", "feat/y", io.Discard)
+	assert.ErrorContains(t, err, `default_branch "release" does not exist`)
+}
+
+func TestConfigureWorktreeGitHooksPath(t *testing.T) {
+	p := &Project{DataDir: t.TempDir()}
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.email=t@t", "-c", "user.name=t"}, args...)...).CombinedOutput()
+		require.NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	require.NoError(t, os.MkdirAll(p.MainDir(), 0o755))
+	git(p.MainDir(), "init", "-q", "-b", "main")
+	require.NoError(t, os.MkdirAll(filepath.Join(p.MainDir(), ".githooks"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(p.MainDir(), ".githooks", "pre-commit"), []byte("#!/bin/sh\necho hook ran >&2\nexit 1\n"), 0o755))
+	git(p.MainDir(), "add", ".githooks")
+	git(p.MainDir(), "commit", "-q", "-m", "hooks")
+
+	dir, err := createWorktree(p, "1", "feat/x", io.Discard)
+	require.NoError(t, err)
+	require.NoError(t, configureWorktreeGit(p, dir, io.Discard), "no git: section is a no-op")
+
+	p.Git.HooksPath = ".githooks"
+	var out bytes.Buffer
+	require.NoError(t, configureWorktreeGit(p, dir, &out))
+	assert.Contains(t, out.String(), "core.hooksPath = .githooks")
+	assert.Equal(t, ".githooks", git(dir, "config", "core.hooksPath"))
+
+	cmd := exec.Command("git", "-C", dir, "-c", "user.email=t@t", "-c", "user.name=t", "commit", "-q", "--allow-empty", "-m", "blocked")
+	msg, err := cmd.CombinedOutput()
+	assert.Error(t, err, "the worktree's commits run the hook")
+	assert.Contains(t, string(msg), "hook ran")
+
+	_, err = exec.Command("git", "-C", p.MainDir(), "config", "core.hooksPath").Output()
+	assert.Error(t, err, "the main checkout is left alone")
+}
+
+func TestCheckWorktree(t *testing.T) {
+	p := &Project{DataDir: t.TempDir()}
+	main := p.MainDir()
+	require.NoError(t, os.MkdirAll(main, 0o755))
+	git := func(args ...string) {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", main, "-c", "user.email=t@t", "-c", "user.name=t"}, args...)...).CombinedOutput()
+		require.NoError(t, err, "git %v: %s", args, out)
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+
+	dir, err := createWorktree(p, "1", "feat/x", io.Discard)
+	require.NoError(t, err)
+	assert.NoError(t, checkWorktree(dir))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, ".git")))
+	assert.ErrorContains(t, checkWorktree(dir), "no longer a git worktree")
+
+	require.NoError(t, os.RemoveAll(dir))
+	assert.ErrorContains(t, checkWorktree(dir), "is missing")
+	assert.Contains(t, worktreeGone(&Instance{ID: "1", WorktreeDir: dir}), "grove drop 1")
+}
+
+func TestAgentMaxRestarts(t *testing.T) {
+	p := &Project{}
+	assert.Equal(t, 0, p.agentMaxRestarts(), "no policy means no automatic restarts")
+
+	p.Agent.Restart = "on-failure"
+	assert.Equal(t, defaultMaxRestarts, p.agentMaxRestarts())
+
+	p.Agent.MaxRestarts = 5
+	assert.Equal(t, 5, p.agentMaxRestarts())
+}
+
+func TestAgentArgsDefaults(t *testing.T) {
+	p := &Project{}
+	assert.Empty(t, p.agentArgs("sh", nil), "unknown agents get no defaults")
+	assert.Empty(t, p.agentArgs("claude", nil))
+	assert.Equal(t, []string{"--no-check-update", "--model", "sonnet"}, p.agentArgs("aider", []string{"--model", "sonnet"}))
+
+	p.Agent.Args = []string{"--check-update"}
+	assert.Equal(t, []string{"--check-update"}, p.agentArgs("aider", nil), "negated form overrides the default")
+
+	p.Agent.Args = nil
+	yes := true
+	p.Agent.SkipPermissions = &yes
+	assert.Equal(t, []string{"--dangerously-skip-permissions"}, p.agentArgs("claude", nil))
+	assert.Equal(t, []string{"--no-check-update", "--yes-always"}, p.agentArgs("aider", nil))
+	assert.Equal(t, []string{"--no-check-update", "--yes-always=false"}, p.agentArgs("aider", []string{"--yes-always=false"}), "a per-run --agent-arg overrides too")
+
+	p.Agent.NoDefaultArgs = &yes
+	assert.Equal(t, []string{"--yes-always"}, p.agentArgs("aider", nil), "skip_permissions is independent of no_default_args")
+}
+
+func TestLoadInRepoConfigAgentRestartOnly(t *testing.T) {
+	dir := t.TempDir()
+	p := &Project{DataDir: dir}
+	p.Agent.Command = "claude"
+	require.NoError(t, os.MkdirAll(p.MainDir(), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(p.MainDir(), "grove.yaml"), []byte("agent:\n  restart: on-failure\n  max_restarts: 2\n"), 0o644))
+
+	_, err := loadInRepoConfig(p)
+	require.NoError(t, err)
+	assert.Equal(t, "claude", p.Agent.Command, "restart-only overlay keeps the agent command")
+	assert.Equal(t, 2, p.agentMaxRestarts())
+}
+
+func TestLoadInRepoConfigAgentFlagsOff(t *testing.T) {
+	dir := t.TempDir()
+	p := &Project{DataDir: dir}
+	yes := true
+	p.Agent.SkipPermissions = &yes
+	p.Agent.NoDefaultArgs = &yes
+	require.NoError(t, os.MkdirAll(p.MainDir(), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(p.MainDir(), "grove.yaml"), []byte("agent:\n  skip_permissions: false\n"), 0o644))
+
+	_, err := loadInRepoConfig(p)
+	require.NoError(t, err)
+	assert.False(t, *p.Agent.SkipPermissions, "grove.yaml turns skip_permissions off")
+	assert.True(t, *p.Agent.NoDefaultArgs, "and leaves what it does not mention")
+	assert.Empty(t, p.agentArgs("aider", nil))
+}
+
+func TestClassifyGitRemoteError(t *testing.T) {
+	cases := map[string]string{
+		"git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.": "authentication failed",
+		"fatal: could not read Username for 'https://github.com': terminal prompts disabled":            "authentication failed",
+		"remote: Repository not found.\nfatal: repository 'https://github.com/org/x.git/' not found":    "repository not found (or no access)",
+		"fatal: 'github.com/org/x' does not appear to be a git repository":                              "repository not found (or no access)",
+		"fatal: unable to access 'https://nope.invalid/x.git/': Could not resolve host: nope.invalid":   "cannot resolve host",
+		"fatal: something else": "git ls-remote failed",
+	}
+	for out, want := range cases {
+		assert.Equal(t, want, classifyGitRemoteError(out), out)
+	}
+}
+
+func TestCheckRepoAccessLocalRepo(t *testing.T) {
+	dir := t.TempDir()
+	out, err := exec.Command("git", "init", "-q", dir).CombinedOutput()
+	require.NoError(t, err, "%s", out)
+
+	assert.NoError(t, checkRepoAccess(dir))
+	assert.ErrorContains(t, checkRepoAccess(filepath.Join(dir, "missing")), "not found")
+}
+
+func TestCheckConfigListAndGroups(t *testing.T) {
+	var list Project
+	require.NoError(t, yaml.Unmarshal([]byte("check:\n  - make lint\n  - make test\n"), &list))
+	assert.Equal(t, CheckConfig{{Commands: []string{"make lint"}}, {Commands: []string{"make test"}}}, list.Check)
+	_, err := list.Check.selectGroups([]string{"test"}, nil)
+	assert.Error(t, err, "--only needs named groups")
+
+	var named Project
+	require.NoError(t, yaml.Unmarshal([]byte("check:\n  test:\n    - go vet ./...\n    - go test ./...\n  lint: golangci-lint run\n"), &named))
+	assert.Equal(t, CheckConfig{
+		{Name: "test", Commands: []string{"go vet ./...", "go test ./..."}},
+		{Name: "lint", Commands: []string{"golangci-lint run"}},
+	}, named.Check, "group order follows the file")
+
+	sel, err := named.Check.selectGroups([]string{"lint"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "lint", sel[0].Name)
+	sel, err = named.Check.selectGroups(nil, []string{"lint"})
+	require.NoError(t, err)
+	assert.Len(t, sel, 1)
+	assert.Equal(t, "test", sel[0].Name)
+	_, err = named.Check.selectGroups([]string{"typo"}, nil)
+	assert.ErrorContains(t, err, `unknown check group "typo"`)
+}
+
+func TestAgentStartupCheck(t *testing.T) {
+	p := &Project{}
+	assert.Equal(t, time.Second, p.agentStartupCheck())
+	p.Agent.StartupCheck = "3s"
+	assert.Equal(t, 3*time.Second, p.agentStartupCheck())
+	p.Agent.StartupCheck = "0"
+	assert.Zero(t, p.agentStartupCheck())
+	p.Agent.StartupCheck = "soon"
+	assert.Equal(t, time.Second, p.agentStartupCheck(), "bad values fall back to the default")
+}
+
+func TestCheckTimeout(t *testing.T) {
+	p := &Project{}
+	assert.Equal(t, 30*time.Minute, p.checkTimeout())
+	p.CheckTimeout = "90s"
+	assert.Equal(t, 90*time.Second, p.checkTimeout())
+	p.CheckTimeout = "0"
+	assert.Zero(t, p.checkTimeout())
+	p.CheckTimeout = "later"
+	assert.Equal(t, 30*time.Minute, p.checkTimeout(), "bad values fall back to the default")
+}
+
+func TestRenameBranch(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"-c", "user.email=t@t", "-c", "user.name=t", "commit", "-q", "--allow-empty", "-m", "init"},
+		{"checkout", "-q", "-b", "feat/old"},
+	} {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	require.NoError(t, renameBranch(dir, "feat/old", "feat/new"))
+	head, err := exec.Command("git", "-C", dir, "rev-parse", "--abbrev-ref", "HEAD").Output()
+	require.NoError(t, err)
+	assert.Equal(t, "feat/new", strings.TrimSpace(string(head)))
+
+	assert.ErrorContains(t, renameBranch(dir, "feat/new", "bad..name"), "invalid branch name")
+}
+
+func TestCommitsAhead(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.email=t@t", "-c", "user.name=t"}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	git("checkout", "-q", "-b", "feat/x")
+
+	n, base, err := commitsAhead(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, "main", base)
+	assert.False(t, worktreeDirty(dir))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644))
+	assert.True(t, worktreeDirty(dir))
+	git("add", "a.txt")
+	git("commit", "-q", "-m", "add a")
+	n, _, err = commitsAhead(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestDefaultBranchName(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.email=t@t", "-c", "user.name=t"}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	git("init", "-q", "-b", "master")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	git("checkout", "-q", "-b", "feat/x")
+
+	assert.Equal(t, "master", defaultBranchName(dir, ""))
+	assert.Equal(t, "release", defaultBranchName(dir, "release"), "configured default_branch wins")
+
+	git("update-ref", "refs/remotes/origin/develop", "HEAD")
+	git("symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/develop")
+	assert.Equal(t, "develop", defaultBranchName(dir, ""), "origin/HEAD is preferred over local guesses")
+}
+
+func TestProjectSubdir(t *testing.T) {
+	dataRoot := t.TempDir()
+	projectDir := filepath.Join(dataRoot, "projects", "api")
+	require.NoError(t, os.MkdirAll(projectDir, 0o755))
+	writeReg := func(subdir string) {
+		reg := "name: api\nrepo: git@github.com:org/mono.git\nsubdir: " + subdir + "\n"
+		require.NoError(t, os.WriteFile(filepath.Join(projectDir, "project.yaml"), []byte(reg), 0o644))
+	}
+
+	writeReg("services/api/")
+	p, err := loadProject(dataRoot, "api")
+	require.NoError(t, err)
+	assert.Equal(t, "services/api", p.Subdir)
+	assert.Equal(t, "/app/services/api", p.execDir())
+	p.Container.Hide = []string{"node_modules"}
+	assert.Equal(t, []string{"/app/services/api/node_modules"}, hiddenPaths(p, io.Discard))
+
+	// grove.yaml comes from the subdir, falling back to the repo root.
+	rootYAML := filepath.Join(p.MainDir(), "grove.yaml")
+	subYAML := filepath.Join(p.MainDir(), "services", "api", "grove.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(subYAML), 0o755))
+	require.NoError(t, os.WriteFile(rootYAML, []byte("check:\n  - make test\n"), 0o644))
+	assert.Equal(t, rootYAML, p.inRepoConfigPath())
+	require.NoError(t, os.WriteFile(subYAML, []byte("check:\n  - go test ./...\n"), 0o644))
+	found, err := loadInRepoConfig(p)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, CheckConfig{{Commands: []string{"go test ./..."}}}, p.Check)
+
+	out, err := composeOverride(p, "/wt/1", io.Discard)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "working_dir: /app/services/api")
+
+	writeReg("../elsewhere")
+	_, err = loadProject(dataRoot, "api")
+	assert.ErrorContains(t, err, "must be a path inside the repository")
+}
+
+func TestFinishTarget(t *testing.T) {
+	dir := t.TempDir()
+	out, err := exec.Command("git", "-C", dir, "-c", "user.email=t@t", "-c", "user.name=t", "init", "-q", "-b", "trunk").CombinedOutput()
+	require.NoError(t, err, string(out))
+	push := []string{"git push origin {{branch}}:{{target}}"}
+
+	target, err := finishTarget(dir, "release/2", "", push)
+	require.NoError(t, err)
+	assert.Equal(t, "release/2", target)
+
+	target, err = finishTarget(dir, "", "develop", push)
+	require.NoError(t, err)
+	assert.Equal(t, "develop", target)
+
+	_, err = finishTarget(dir, "main;rm -rf /", "", push)
+	assert.ErrorContains(t, err, "invalid branch name")
+	_, err = finishTarget(dir, "main$(id)", "", push)
+	assert.ErrorContains(t, err, "invalid branch name", "valid for git but not a literal shell word")
+
+	_, err = finishTarget(dir, "", "", push)
+	assert.ErrorContains(t, err, "cannot find the default branch")
+	target, err = finishTarget(dir, "", "", []string{"git push origin {{branch}}"})
+	require.NoError(t, err, "no default branch is fine when nothing uses {{target}}")
+	assert.Empty(t, target)
+}
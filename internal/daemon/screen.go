@@ -0,0 +1,464 @@
+package daemon
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Default screen size, used until an attached client reports its own.
+// Agents started on a PTY nobody has resized see the same fallback.
+const (
+	defaultScreenCols = 80
+	defaultScreenRows = 24
+)
+
+// Escape-sequence parser states for screen.
+const (
+	stGround = iota
+	stEscape
+	stEscapeInter // ESC followed by an intermediate byte, e.g. ESC ( B
+	stCSI
+	stString    // OSC, DCS, APC, PM or SOS: skipped up to BEL or ST
+	stStringEsc // ESC seen inside a string; ESC \ ends it
+)
+
+// maxCSIParams bounds the parameter bytes kept for one control sequence.
+const maxCSIParams = 64
+
+// screen is a deliberately small terminal emulator: it follows cursor
+// movement, erasing, scrolling and the alternate screen well enough to know
+// what a full-screen agent TUI is showing, so grove snapshot can print it
+// after the agent is gone.  It ignores attributes and colors, treats every
+// character as one column wide, and silently drops sequences it does not
+// know.  It is not safe for concurrent use; Instance guards it with mu.
+type screen struct {
+	cols, rows int
+	main, alt  [][]rune
+	cells      [][]rune // main or alt, whichever is active
+	altActive  bool
+
+	x, y           int
+	savedX, savedY int
+	wrapPending    bool // the last column was written; the next rune wraps
+	top, bottom    int  // scroll region, inclusive
+
+	state  int
+	params []byte
+	utf8   []byte // bytes of a rune split across writes
+}
+
+func newScreen(cols, rows int) *screen {
+	s := &screen{}
+	s.resize(cols, rows)
+	return s
+}
+
+// Write feeds terminal output to the screen.  It never fails.
+func (s *screen) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.feed(b)
+	}
+	return len(p), nil
+}
+
+func (s *screen) feed(b byte) {
+	switch s.state {
+	case stEscape:
+		s.escape(b)
+		return
+	case stEscapeInter:
+		s.state = stGround
+		return
+	case stCSI:
+		switch {
+		case b >= 0x40 && b <= 0x7e:
+			s.state = stGround
+			s.csi(b, string(s.params))
+		case b >= 0x20 && b <= 0x3f:
+			if len(s.params) < maxCSIParams {
+				s.params = append(s.params, b)
+			}
+		case b == 0x1b:
+			s.state = stEscape
+		default:
+			s.control(b)
+		}
+		return
+	case stString:
+		switch b {
+		case 0x07:
+			s.state = stGround
+		case 0x1b:
+			s.state = stStringEsc
+		}
+		return
+	case stStringEsc:
+		if b == '\\' {
+			s.state = stGround
+		} else {
+			s.state = stString
+		}
+		return
+	}
+
+	if len(s.utf8) > 0 || b >= 0x80 {
+		s.utf8 = append(s.utf8, b)
+		if !utf8.FullRune(s.utf8) {
+			return
+		}
+		r, _ := utf8.DecodeRune(s.utf8)
+		s.utf8 = s.utf8[:0]
+		s.put(r)
+		return
+	}
+	if b == 0x1b {
+		s.state = stEscape
+		return
+	}
+	if b < 0x20 || b == 0x7f {
+		s.control(b)
+		return
+	}
+	s.put(rune(b))
+}
+
+// control handles a C0 control character.
+func (s *screen) control(b byte) {
+	switch b {
+	case '\r':
+		s.x = 0
+		s.wrapPending = false
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapPending = false
+	case '\t':
+		s.x = min((s.x/8+1)*8, s.cols-1)
+	}
+}
+
+func (s *screen) escape(b byte) {
+	s.state = stGround
+	switch b {
+	case '[':
+		s.state = stCSI
+		s.params = s.params[:0]
+	case ']', 'P', '_', '^', 'X':
+		s.state = stString
+	case '(', ')', '*', '+', '#', '%', ' ':
+		s.state = stEscapeInter
+	case '7':
+		s.savedX, s.savedY = s.x, s.y
+	case '8':
+		// The screen may have shrunk since ESC 7.
+		s.x, s.y = s.savedX, s.savedY
+		s.clamp()
+		s.wrapPending = false
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.x = 0
+		s.lineFeed()
+	case 'M':
+		s.reverseIndex()
+	case 'c':
+		s.setAlt(false)
+		s.resize(s.cols, s.rows)
+		s.eraseRect(0, 0, s.rows-1, s.cols-1)
+		s.x, s.y = 0, 0
+	}
+}
+
+// csi applies the control sequence ending in final with parameter bytes
+// params, e.g. final 'H' and params "3;1".
+func (s *screen) csi(final byte, params string) {
+	private := strings.HasPrefix(params, "?")
+	if private {
+		params = params[1:]
+	}
+	var nums []int
+	for _, f := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(f)
+		nums = append(nums, n)
+	}
+	arg := func(i, def int) int {
+		if i < len(nums) && nums[i] > 0 {
+			return nums[i]
+		}
+		return def
+	}
+
+	if private {
+		if final == 'h' || final == 'l' {
+			for _, n := range nums {
+				s.privateMode(n, final == 'h')
+			}
+		}
+		return
+	}
+
+	s.wrapPending = false
+	switch final {
+	case 'A':
+		s.y -= arg(0, 1)
+	case 'B', 'e':
+		s.y += arg(0, 1)
+	case 'C', 'a':
+		s.x += arg(0, 1)
+	case 'D':
+		s.x -= arg(0, 1)
+	case 'E':
+		s.y += arg(0, 1)
+		s.x = 0
+	case 'F':
+		s.y -= arg(0, 1)
+		s.x = 0
+	case 'G', '`':
+		s.x = arg(0, 1) - 1
+	case 'd':
+		s.y = arg(0, 1) - 1
+	case 'H', 'f':
+		s.y, s.x = arg(0, 1)-1, arg(1, 1)-1
+	case 'J':
+		s.clamp()
+		switch arg(0, 0) {
+		case 0:
+			s.eraseRect(s.y, s.x, s.y, s.cols-1)
+			s.eraseRect(s.y+1, 0, s.rows-1, s.cols-1)
+		case 1:
+			s.eraseRect(0, 0, s.y-1, s.cols-1)
+			s.eraseRect(s.y, 0, s.y, s.x)
+		default:
+			s.eraseRect(0, 0, s.rows-1, s.cols-1)
+		}
+	case 'K':
+		s.clamp()
+		switch arg(0, 0) {
+		case 0:
+			s.eraseRect(s.y, s.x, s.y, s.cols-1)
+		case 1:
+			s.eraseRect(s.y, 0, s.y, s.x)
+		default:
+			s.eraseRect(s.y, 0, s.y, s.cols-1)
+		}
+	case 'X':
+		s.clamp()
+		s.eraseRect(s.y, s.x, s.y, min(s.x+arg(0, 1), s.cols)-1)
+	case '@':
+		s.clamp()
+		s.shiftLine(arg(0, 1))
+	case 'P':
+		s.clamp()
+		s.shiftLine(-arg(0, 1))
+	case 'L':
+		s.clamp()
+		if s.y >= s.top && s.y <= s.bottom {
+			s.scrollDown(s.y, s.bottom, arg(0, 1))
+		}
+	case 'M':
+		s.clamp()
+		if s.y >= s.top && s.y <= s.bottom {
+			s.scrollUp(s.y, s.bottom, arg(0, 1))
+		}
+	case 'S':
+		s.scrollUp(s.top, s.bottom, arg(0, 1))
+	case 'T':
+		s.scrollDown(s.top, s.bottom, arg(0, 1))
+	case 'r':
+		top, bottom := arg(0, 1)-1, arg(1, s.rows)-1
+		if top < bottom && bottom < s.rows {
+			s.top, s.bottom = top, bottom
+		}
+		s.x, s.y = 0, 0
+	case 's':
+		s.savedX, s.savedY = s.x, s.y
+	case 'u':
+		s.x, s.y = s.savedX, s.savedY
+	}
+	s.clamp()
+}
+
+// privateMode handles DEC private mode n being set or reset; only the
+// alternate-screen modes matter here.
+func (s *screen) privateMode(n int, set bool) {
+	switch n {
+	case 1049:
+		if set {
+			s.savedX, s.savedY = s.x, s.y
+			s.setAlt(true)
+		} else {
+			s.setAlt(false)
+			s.x, s.y = s.savedX, s.savedY
+			s.clamp()
+		}
+	case 47, 1047:
+		s.setAlt(set)
+	}
+}
+
+// setAlt switches between the main and a freshly cleared alternate screen.
+func (s *screen) setAlt(on bool) {
+	if on == s.altActive {
+		return
+	}
+	s.altActive = on
+	if on {
+		s.alt = blankGrid(s.cols, s.rows)
+		s.cells = s.alt
+	} else {
+		s.alt = nil
+		s.cells = s.main
+	}
+	s.wrapPending = false
+}
+
+// put writes r at the cursor and advances it, wrapping at the right margin.
+func (s *screen) put(r rune) {
+	if s.wrapPending {
+		s.x = 0
+		s.lineFeed()
+	}
+	s.cells[s.y][s.x] = r
+	if s.x == s.cols-1 {
+		s.wrapPending = true
+	} else {
+		s.x++
+	}
+}
+
+// lineFeed moves the cursor down a line, scrolling the region when it is on
+// the bottom margin.
+func (s *screen) lineFeed() {
+	s.wrapPending = false
+	if s.y == s.bottom {
+		s.scrollUp(s.top, s.bottom, 1)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+// reverseIndex moves the cursor up a line, scrolling the region down when it
+// is on the top margin.
+func (s *screen) reverseIndex() {
+	s.wrapPending = false
+	if s.y == s.top {
+		s.scrollDown(s.top, s.bottom, 1)
+	} else if s.y > 0 {
+		s.y--
+	}
+}
+
+// scrollUp moves rows top..bottom up by n, blanking the rows exposed at the
+// bottom.
+func (s *screen) scrollUp(top, bottom, n int) {
+	n = min(n, bottom-top+1)
+	copy(s.cells[top:bottom+1], s.cells[top+n:bottom+1])
+	for i := bottom - n + 1; i <= bottom; i++ {
+		s.cells[i] = blankRow(s.cols)
+	}
+}
+
+// scrollDown moves rows top..bottom down by n, blanking the rows exposed at
+// the top.
+func (s *screen) scrollDown(top, bottom, n int) {
+	n = min(n, bottom-top+1)
+	copy(s.cells[top+n:bottom+1], s.cells[top:bottom+1-n])
+	for i := top; i < top+n; i++ {
+		s.cells[i] = blankRow(s.cols)
+	}
+}
+
+// shiftLine moves the rest of the cursor's line right by n (inserting
+// blanks) or, for negative n, left (deleting characters).
+func (s *screen) shiftLine(n int) {
+	row := s.cells[s.y]
+	if n > 0 {
+		n = min(n, s.cols-s.x)
+		copy(row[s.x+n:], row[s.x:])
+		s.eraseRect(s.y, s.x, s.y, s.x+n-1)
+	} else {
+		n = min(-n, s.cols-s.x)
+		copy(row[s.x:], row[s.x+n:])
+		s.eraseRect(s.y, s.cols-n, s.y, s.cols-1)
+	}
+}
+
+// eraseRect blanks rows r0..r1, columns c0..c1 (inclusive, clipped).
+func (s *screen) eraseRect(r0, c0, r1, c1 int) {
+	for r := max(r0, 0); r <= min(r1, s.rows-1); r++ {
+		for c := max(c0, 0); c <= min(c1, s.cols-1); c++ {
+			s.cells[r][c] = ' '
+		}
+	}
+}
+
+func (s *screen) clamp() {
+	s.x = max(0, min(s.x, s.cols-1))
+	s.y = max(0, min(s.y, s.rows-1))
+}
+
+// resize changes the screen size, keeping the top-left of what is shown.
+// Sizes below 1x1 are ignored.
+func (s *screen) resize(cols, rows int) {
+	if cols < 1 || rows < 1 {
+		return
+	}
+	s.main = resizeGrid(s.main, cols, rows)
+	if s.alt != nil {
+		s.alt = resizeGrid(s.alt, cols, rows)
+	}
+	s.cells = s.main
+	if s.altActive {
+		s.cells = s.alt
+	}
+	s.cols, s.rows = cols, rows
+	s.top, s.bottom = 0, rows-1
+	s.wrapPending = false
+	s.clamp()
+	s.savedX = max(0, min(s.savedX, cols-1))
+	s.savedY = max(0, min(s.savedY, rows-1))
+}
+
+// render returns the visible screen as text: one line per row with trailing
+// blanks removed, and trailing empty rows dropped.
+func (s *screen) render() string {
+	lines := make([]string, len(s.cells))
+	for i, row := range s.cells {
+		lines[i] = strings.TrimRight(string(row), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func blankRow(cols int) []rune {
+	row := make([]rune, cols)
+	for i := range row {
+		row[i] = ' '
+	}
+	return row
+}
+
+func blankGrid(cols, rows int) [][]rune {
+	g := make([][]rune, rows)
+	for i := range g {
+		g[i] = blankRow(cols)
+	}
+	return g
+}
+
+func resizeGrid(g [][]rune, cols, rows int) [][]rune {
+	out := blankGrid(cols, rows)
+	for i := 0; i < rows && i < len(g); i++ {
+		copy(out[i], g[i])
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScreenPlainText(t *testing.T) {
+	s := newScreen(10, 3)
+	s.Write([]byte("hello\r\nwörld\r\n"))
+	assert.Equal(t, "hello\nwörld\n", s.render())
+
+	// Splitting a multi-byte rune across writes still decodes it.
+	s = newScreen(10, 3)
+	s.Write([]byte("w\xc3"))
+	s.Write([]byte("\xb6rld"))
+	assert.Equal(t, "wörld\n", s.render())
+}
+
+func TestScreenWrapsAndScrolls(t *testing.T) {
+	s := newScreen(4, 2)
+	s.Write([]byte("abcdefgh\r\nij"))
+	assert.Equal(t, "efgh\nij\n", s.render(), "wrapped at the margin, then scrolled")
+}
+
+func TestScreenCursorAndErase(t *testing.T) {
+	s := newScreen(10, 3)
+	s.Write([]byte("xxxxxxxxxx\r\nyyyyyyyyyy\r\nzzzzzzzzzz"))
+	s.Write([]byte("\x1b[2;3H\x1b[K"))     // row 2, col 3: erase to end of line
+	s.Write([]byte("\x1b[1;1H\x1b[31mAB")) // colours are ignored
+	s.Write([]byte("\x1b[3;5H\x1b[1K"))    // erase row 3 up to col 5
+	assert.Equal(t, "ABxxxxxxxx\nyy\n     zzzzz\n", s.render())
+
+	s.Write([]byte("\x1b[2J"))
+	assert.Equal(t, "", s.render())
+}
+
+func TestScreenAlternateScreen(t *testing.T) {
+	s := newScreen(20, 3)
+	s.Write([]byte("$ claude\r\n"))
+	s.Write([]byte("\x1b[?1049h\x1b[H\x1b[2J> Working…\x1b]0;title\x07"))
+	assert.Equal(t, "> Working…\n", s.render(), "TUI on the alternate screen")
+
+	s.Write([]byte("\x1b[?1049l"))
+	assert.Equal(t, "$ claude\n", s.render(), "main screen restored on exit")
+}
+
+func TestScreenScrollRegionAndInsertDelete(t *testing.T) {
+	s := newScreen(5, 4)
+	s.Write([]byte("head\r\n1\r\n2\r\nfoot"))
+	s.Write([]byte("\x1b[2;3r\x1b[3;1H\n")) // region rows 2-3; LF on its bottom row
+	assert.Equal(t, "head\n2\n\nfoot\n", s.render(), "only the region scrolls")
+
+	s.Write([]byte("\x1b[1;1H\x1b[2P")) // delete two characters
+	s.Write([]byte("\x1b[4;1H\x1b[1@")) // insert one blank
+	assert.Equal(t, "ad\n2\n\n foot\n", s.render())
+}
+
+func TestScreenResize(t *testing.T) {
+	s := newScreen(10, 3)
+	s.Write([]byte("0123456789\r\nabc"))
+	s.resize(4, 5)
+	assert.Equal(t, "0123\nabc\n", s.render())
+	s.Write([]byte("\x1b[5;4HZ"))
+	assert.Equal(t, "0123\nabc\n\n\n   Z\n", s.render())
+}
+
+func TestScreenRestoreCursorAfterShrink(t *testing.T) {
+	s := newScreen(80, 24)
+	s.Write([]byte("\x1b[20;70H\x1b7")) // save the cursor near the bottom right
+	s.resize(40, 10)
+	s.Write([]byte("\x1b8X"))
+	assert.Equal(t, strings.Repeat("\n", 9)+strings.Repeat(" ", 39)+"X\n", s.render())
+
+	// The saved cursor is clamped too, so growing back first makes no
+	// difference.
+	s = newScreen(80, 24)
+	s.Write([]byte("\x1b[20;70H\x1b7"))
+	s.resize(40, 10)
+	s.resize(80, 24)
+	s.Write([]byte("\x1b8Y"))
+	assert.Equal(t, strings.Repeat("\n", 9)+strings.Repeat(" ", 39)+"Y\n", s.render())
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Timeline sources: which part of an instance's life a chunk of output came
+// from.
+const (
+	sourceSetup  = "setup"
+	sourceAgent  = "agent"
+	sourceCheck  = "check"
+	sourceFinish = "finish"
+)
+
+// logSegment is one chunk of instance output and when it was written.
+type logSegment struct {
+	at     time.Time
+	source string
+	data   []byte
+}
+
+// timeline keeps timestamped output from every source an instance writes to
+// its log — setup, the agent PTY, check and finish — so grove logs
+// --merge-setup can show them in the order they happened.  Like logBuf it is
+// capped at maxLogBytes, dropping the oldest segments first.  It has its own
+// lock so writers do not need Instance.mu.
+type timeline struct {
+	mu   sync.Mutex
+	segs []logSegment
+	size int
+}
+
+// add records p under source.  A nil timeline records nothing.
+func (t *timeline) add(source string, p []byte) {
+	if t == nil || len(p) == 0 {
+		return
+	}
+	seg := logSegment{at: time.Now(), source: source, data: append([]byte(nil), p...)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.segs = append(t.segs, seg)
+	t.size += len(seg.data)
+	for t.size > maxLogBytes && len(t.segs) > 1 {
+		t.size -= len(t.segs[0].data)
+		t.segs = t.segs[1:]
+	}
+}
+
+// writer returns an io.Writer that records everything written to it under
+// source.  It never fails.
+func (t *timeline) writer(source string) io.Writer {
+	return timelineWriter{t: t, source: source}
+}
+
+type timelineWriter struct {
+	t      *timeline
+	source string
+}
+
+func (w timelineWriter) Write(p []byte) (int, error) {
+	w.t.add(w.source, p)
+	return len(p), nil
+}
+
+// render writes the timeline to w with every line prefixed by its time and
+// source.  A line split across segments from the same source is printed
+// once, under the time its first part arrived.
+func (t *timeline) render(w io.Writer) {
+	t.mu.Lock()
+	segs := append([]logSegment(nil), t.segs...)
+	t.mu.Unlock()
+
+	lastSource := ""
+	midLine := false
+	for _, seg := range segs {
+		if midLine && seg.source != lastSource {
+			io.WriteString(w, "\n")
+			midLine = false
+		}
+		data := seg.data
+		for len(data) > 0 {
+			if !midLine {
+				fmt.Fprintf(w, "%s %-6s | ", seg.at.Format("15:04:05.000"), seg.source)
+			}
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				w.Write(data)
+				midLine = true
+				break
+			}
+			w.Write(data[:i+1])
+			data = data[i+1:]
+			midLine = false
+		}
+		lastSource = seg.source
+	}
+	if midLine {
+		io.WriteString(w, "\n")
+	}
+}
+
+// empty reports whether nothing has been recorded.
+func (t *timeline) empty() bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.segs) == 0
+}
@@ -0,0 +1,38 @@
+package daemon
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimelineRenderLabelsLines(t *testing.T) {
+	tl := &timeline{}
+	tl.add(sourceSetup, []byte("Cloning...\nStart: bundle"))
+	tl.add(sourceSetup, []byte(" install\n"))
+	tl.add(sourceAgent, []byte("> ready"))
+	tl.add(sourceCheck, []byte("$ make test\nok\n"))
+
+	var buf bytes.Buffer
+	tl.render(&buf)
+	stamp := regexp.MustCompile(`(?m)^\d\d:\d\d:\d\d\.\d{3} `)
+	assert.Equal(t,
+		"setup  | Cloning...\n"+
+			"setup  | Start: bundle install\n"+
+			"agent  | > ready\n"+
+			"check  | $ make test\n"+
+			"check  | ok\n",
+		stamp.ReplaceAllString(buf.String(), ""))
+}
+
+func TestTimelineDropsOldestOverCap(t *testing.T) {
+	tl := &timeline{}
+	tl.add(sourceSetup, make([]byte, maxLogBytes))
+	tl.add(sourceAgent, []byte("latest\n"))
+	assert.Len(t, tl.segs, 1)
+	assert.Equal(t, sourceAgent, tl.segs[0].source)
+	assert.False(t, tl.empty())
+	assert.True(t, (*timeline)(nil).empty())
+}
@@ -0,0 +1,71 @@
+// Package diskspace reports the free space on the filesystem holding the
+// data root, for grove doctor (cmd/grove) and the daemon's startup warning
+// (internal/daemon).  Worktrees and logs fill it up over time, and running
+// out fails clones and container starts with errors that do not say why.
+package diskspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Below these, Free reports the filesystem as low.
+const (
+	MinBytes  = 2 << 30 // 2 GiB
+	MinInodes = 50000
+)
+
+// Free is what is left on one filesystem.
+type Free struct {
+	Bytes  uint64 // available to unprivileged users
+	Inodes uint64
+	// HasInodes is false on filesystems that allocate inodes on demand and
+	// report no total; Inodes means nothing there.
+	HasInodes bool
+}
+
+// Check returns what is free on the filesystem holding path.  A path that
+// does not exist yet is checked through its nearest existing parent.
+func Check(path string) (Free, error) {
+	path = existingParent(path)
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return Free{}, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	return Free{
+		Bytes:     uint64(st.Bavail) * uint64(st.Bsize),
+		Inodes:    uint64(st.Ffree),
+		HasInodes: st.Files > 0,
+	}, nil
+}
+
+// LowBytes reports whether less than MinBytes is free.
+func (f Free) LowBytes() bool { return f.Bytes < MinBytes }
+
+// LowInodes reports whether fewer than MinInodes inodes are free.
+func (f Free) LowInodes() bool { return f.HasInodes && f.Inodes < MinInodes }
+
+// String describes f, e.g. "12.5 GiB and 80312 inodes free".
+func (f Free) String() string {
+	gib := float64(f.Bytes) / (1 << 30)
+	if !f.HasInodes {
+		return fmt.Sprintf("%.1f GiB free", gib)
+	}
+	return fmt.Sprintf("%.1f GiB and %d inodes free", gib, f.Inodes)
+}
+
+// existingParent returns path, or the nearest of its parents that exists.
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,30 @@
+package diskspace
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	free, err := Check(dir)
+	require.NoError(t, err)
+	assert.NotZero(t, free.Bytes)
+
+	missing, err := Check(filepath.Join(dir, "not", "created", "yet"))
+	require.NoError(t, err, "a data root that does not exist yet is checked through its parent")
+	assert.NotZero(t, missing.Bytes)
+}
+
+func TestLow(t *testing.T) {
+	assert.True(t, Free{Bytes: MinBytes - 1}.LowBytes())
+	assert.False(t, Free{Bytes: MinBytes}.LowBytes())
+	assert.True(t, Free{Inodes: 10, HasInodes: true}.LowInodes())
+	assert.False(t, Free{Inodes: 0}.LowInodes(), "no inode count to go by")
+
+	assert.Equal(t, "1.5 GiB and 42 inodes free", Free{Bytes: 3 << 29, Inodes: 42, HasInodes: true}.String())
+	assert.Equal(t, "1.5 GiB free", Free{Bytes: 3 << 29}.String())
+}
@@ -0,0 +1,35 @@
+// Package engine runs the container engine's CLI for the daemon
+// (internal/daemon) and the CLI (cmd/grove): docker, or another binary that
+// takes the same arguments, such as podman, named by GROVE_CONTAINER_RUNTIME.
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Default is the engine used when GROVE_CONTAINER_RUNTIME is unset.
+const Default = "docker"
+
+// Name returns the engine binary: GROVE_CONTAINER_RUNTIME if set, else
+// Default.
+func Name() string {
+	if name := strings.TrimSpace(os.Getenv("GROVE_CONTAINER_RUNTIME")); name != "" {
+		return name
+	}
+	return Default
+}
+
+// Command returns an exec.Cmd that runs the engine with args, e.g.
+// Command("exec", name, "sh", "-c", cmd).  Compose files go through the
+// engine's compose subcommand ("docker compose", "podman compose").
+func Command(args ...string) *exec.Cmd {
+	return exec.Command(Name(), args...)
+}
+
+// CommandContext is Command with a context that kills the engine process.
+func CommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, Name(), args...)
+}
@@ -0,0 +1,22 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommand(t *testing.T) {
+	args := []string{"compose", "-p", "grove-1", "-f", "docker-compose.yml", "up", "-d"}
+
+	t.Setenv("GROVE_CONTAINER_RUNTIME", "")
+	assert.Equal(t, "docker", Name())
+	assert.Equal(t, append([]string{"docker"}, args...), Command(args...).Args)
+
+	t.Setenv("GROVE_CONTAINER_RUNTIME", " podman ")
+	assert.Equal(t, "podman", Name())
+	assert.Equal(t, append([]string{"podman"}, args...), Command(args...).Args)
+	assert.Equal(t, []string{"podman", "exec", "box", "sh", "-c", "make"},
+		CommandContext(context.Background(), "exec", "box", "sh", "-c", "make").Args)
+}
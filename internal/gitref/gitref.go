@@ -0,0 +1,53 @@
+// Package gitref finds a repository's default branch for the CLI (cmd/grove)
+// and the daemon (internal/daemon), which must agree on it: grove export,
+// the empty-branch check before grove finish and finish's {{target}} all
+// measure a branch against it.
+package gitref
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DefaultBranch returns the repository's default branch as seen from dir:
+// its name, and the ref to compare against (origin/<name> when that exists,
+// else the local branch).  A configured default_branch always names the
+// branch; ref is "" if it exists neither on origin nor locally.  Otherwise
+// origin/HEAD is used if set, else the first of main and master found on
+// origin or locally.  Both are "" if nothing is found.
+func DefaultBranch(dir, configured string) (name, ref string) {
+	if configured != "" {
+		return configured, existingRef(dir, configured)
+	}
+	if out, err := exec.Command("git", "-C", dir, "symbolic-ref", "--short", "refs/remotes/origin/HEAD").Output(); err == nil {
+		head := strings.TrimSpace(string(out))
+		return strings.TrimPrefix(head, "origin/"), head
+	}
+	for _, name := range []string{"main", "master"} {
+		if ref := existingRef(dir, name); ref != "" {
+			return name, ref
+		}
+	}
+	return "", ""
+}
+
+// existingRef returns origin/<branch> if it exists in dir, else branch if
+// that exists, else "".
+func existingRef(dir, branch string) string {
+	for _, ref := range []string{"refs/remotes/origin/" + branch, "refs/heads/" + branch} {
+		if exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", ref).Run() == nil {
+			return strings.TrimPrefix(strings.TrimPrefix(ref, "refs/remotes/"), "refs/heads/")
+		}
+	}
+	return ""
+}
+
+// CheckBranchName returns an error unless name is a valid branch name, as
+// git check-ref-format --branch decides.
+func CheckBranchName(dir, name string) error {
+	if out, err := exec.Command("git", "-C", dir, "check-ref-format", "--branch", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("invalid branch name %q: %s", name, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package gitref
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultBranch(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.email=t@t", "-c", "user.name=t"}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	git("init", "-q", "-b", "master")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	git("checkout", "-q", "-b", "feat/x")
+
+	name, ref := DefaultBranch(dir, "")
+	assert.Equal(t, "master", name)
+	assert.Equal(t, "master", ref)
+
+	name, ref = DefaultBranch(dir, "release")
+	assert.Equal(t, "release", name, "configured default_branch wins")
+	assert.Empty(t, ref, "even when it does not exist")
+
+	git("update-ref", "refs/remotes/origin/master", "HEAD")
+	_, ref = DefaultBranch(dir, "master")
+	assert.Equal(t, "origin/master", ref, "origin is preferred over the local branch")
+
+	git("update-ref", "refs/remotes/origin/develop", "HEAD")
+	git("symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/develop")
+	name, ref = DefaultBranch(dir, "")
+	assert.Equal(t, "develop", name, "origin/HEAD is preferred over local guesses")
+	assert.Equal(t, "origin/develop", ref)
+}
+
+func TestCheckBranchName(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, CheckBranchName(dir, "release/1.2"))
+	for _, bad := range []string{"bad..name", "has space", "-leading", "a~b"} {
+		assert.ErrorContains(t, CheckBranchName(dir, bad), "invalid branch name", bad)
+	}
+}
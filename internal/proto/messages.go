@@ -1,8 +1,9 @@
 // Package proto defines the IPC message types and attach-stream framing
-// used between grove (client) and groved (daemon) over a Unix domain socket.
+// used between grove (client) and groved (daemon) over a Unix domain socket
+// or, when groved is started with --listen, TCP.
 //
-// Normal commands use newline-delimited JSON: client sends one Request,
-// daemon sends one Response, then the connection closes.
+// Normal commands use length-prefixed JSON messages (see WriteMessage): client
+// sends one Request, daemon sends one Response, then the connection closes.
 //
 // The attach command is special: after the JSON handshake the connection
 // enters a streaming mode where the server sends raw PTY output and the
@@ -11,25 +12,55 @@ package proto
 
 import (
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 )
 
 // Request type constants.
 const (
-	ReqPing    = "ping"
-	ReqStart   = "start"
-	ReqList    = "list"
-	ReqAttach  = "attach"
+	ReqPing       = "ping"
+	ReqStart      = "start"
+	ReqList       = "list"
+	ReqAttach     = "attach"
 	ReqLogs       = "logs"
 	ReqLogsFollow = "logs_follow"
-	ReqStop = "stop"
+	ReqStop       = "stop"
 	ReqDrop       = "drop"
 	ReqFinish     = "finish"
 	ReqRestart    = "restart"
 	ReqCheck      = "check"
+	ReqAnnotate   = "annotate"
+	ReqMove       = "move"
+	ReqMetrics    = "metrics"
+	ReqCheckRepo  = "check_repo"
+	ReqReload     = "reload"
+	ReqWarm       = "warm"
+
+	ReqInstanceConfig = "instance_config"
+	ReqSnapshot       = "snapshot"
+	ReqExec           = "exec"
+)
+
+// ErrInstanceNotFound starts Response.Error for a request naming an
+// instance the daemon does not have; the ID follows after ": ".  Its Code is
+// CodeInstanceNotFound.
+const ErrInstanceNotFound = "instance not found"
+
+// Codes for Response.Code, which classify the failures clients tell apart
+// without reading Response.Error.
+const (
+	CodeInstanceNotFound = "instance_not_found" // no instance with the requested ID
+	CodeProjectNotFound  = "project_not_found"  // no project registered under the name
+	CodeNoEngine         = "no_engine"          // docker is not running or not installed
 )
 
+// DaemonTokenVar names the shared secret a client must send in
+// Request.Token to a daemon listening on TCP.  It is read from the
+// environment or <root>/env, and never passed on to agents.
+const DaemonTokenVar = "GROVE_DAEMON_TOKEN"
+
 // Instance state constants.
 const (
 	StateRunning  = "RUNNING"
@@ -59,15 +90,115 @@ type Request struct {
 	Branch     string `json:"branch,omitempty"`
 	InstanceID string `json:"instance_id,omitempty"`
 
+	// Token authenticates a request sent over TCP (see DaemonTokenVar).
+	// Requests on the Unix socket do not need it.
+	Token string `json:"token,omitempty"`
+
 	// AgentEnv carries environment variables that the client extracted on the
 	// host (e.g. OAuth tokens from the macOS Keychain) and that must be
 	// injected into the agent's docker exec session.
 	AgentEnv map[string]string `json:"agent_env,omitempty"`
+
+	// AgentArgs, on ReqStart and ReqRestart, are appended to the agent
+	// command's configured args for this run only.
+	AgentArgs []string `json:"agent_args,omitempty"`
+
+	// Resume, on ReqStart, requires the branch to already exist (locally or
+	// on origin) and checks it out instead of branching from HEAD.
+	Resume bool `json:"resume,omitempty"`
+
+	// WaitReady, on ReqRestart, makes the daemon respond only once the new
+	// agent has printed its first output and settled, so a follow-up command
+	// does not land in an agent that is still booting.
+	WaitReady bool `json:"wait_ready,omitempty"`
+
+	// Wait, on ReqStop, makes the daemon respond only once the agent process
+	// has fully exited (or a timeout elapses).
+	Wait bool `json:"wait,omitempty"`
+
+	// Snapshot, on ReqStop, waits like Wait and returns the agent's final
+	// screen in Response.Screen.
+	Snapshot bool `json:"snapshot,omitempty"`
+
+	// Container, on ReqStop, also stops and removes the instance's container
+	// once the agent has exited.  The worktree and record are kept; restart
+	// with Fresh brings the container back.
+	Container bool `json:"container,omitempty"`
+
+	// Fresh, on ReqRestart, replaces the instance's container with a new one
+	// built from the current grove.yaml (running start commands again)
+	// before launching the agent.
+	Fresh bool `json:"fresh,omitempty"`
+
+	// Prompt, on ReqStart, is typed into the agent once it is ready, as if
+	// the user had attached and entered it.  It is kept in the instance's
+	// config so a restart can send it again (see ResendPrompt).
+	Prompt string `json:"prompt,omitempty"`
+
+	// Name, on ReqStart, labels the new instance so commands can take it in
+	// place of the ID.  It must not be another instance's ID or name.
+	Name string `json:"name,omitempty"`
+
+	// ResendPrompt, on ReqRestart, types the prompt the instance was
+	// started with into the restarted agent once it is ready.
+	ResendPrompt bool `json:"resend_prompt,omitempty"`
+
+	// Once, on ReqAttach, makes the daemon end the session the first time
+	// the agent goes idle after the client has submitted a line of input.
+	Once bool `json:"once,omitempty"`
+
+	// Takeover, on ReqAttach, detaches any client already attached to the
+	// instance instead of refusing, and attaches this one in its place.
+	Takeover bool `json:"takeover,omitempty"`
+
+	// CheckOnly and CheckSkip, on ReqCheck, select named check groups from
+	// grove.yaml: only the groups in CheckOnly (all if empty), minus those
+	// in CheckSkip.
+	CheckOnly []string `json:"check_only,omitempty"`
+	CheckSkip []string `json:"check_skip,omitempty"`
+
+	// Framed, on ReqCheck and ReqWarm, asks for the streamed output as
+	// StreamFrameOutput frames followed by one StreamFrameResult frame, so
+	// the client learns whether every check passed (or the pull worked).
+	// Without it the output is sent raw.
+	Framed bool `json:"framed,omitempty"`
+
+	// Command, on ReqExec, is a shell command line to run in the instance's
+	// container.  Its output always comes back framed, stderr in
+	// StreamFrameStderr frames, and the result frame carries its exit status
+	// in Response.ExitCode.
+	Command string `json:"command,omitempty"`
+
+	// MergeSetup, on ReqLogs, asks for setup, agent, check and finish output
+	// interleaved in time order with each line labelled by time and source.
+	MergeSetup bool `json:"merge_setup,omitempty"`
+
+	// TailBytes, on ReqLogs and ReqLogsFollow, limits the buffered output
+	// sent to roughly its last TailBytes bytes.  Zero sends all of it.
+	TailBytes int `json:"tail_bytes,omitempty"`
+
+	// AllRuns, on ReqLogs, sends the instance's on-disk log instead of the
+	// in-memory buffer: the output of every run of the agent, each preceded
+	// by a run marker, along with setup, check and finish output.
+	AllRuns bool `json:"all_runs,omitempty"`
+
+	// AllowEmpty, on ReqFinish, finishes even when the branch has no commits
+	// ahead of the default branch.
+	AllowEmpty bool `json:"allow_empty,omitempty"`
+
+	// Target, on ReqFinish, is the branch substituted for {{target}} in
+	// finish commands.  Empty means the project's default branch.
+	Target string `json:"target,omitempty"`
+
+	// Annotations, on ReqAnnotate, are merged into the instance's
+	// annotations.  An empty value removes the key.
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // InstanceInfo is a point-in-time snapshot of an instance's metadata.
 type InstanceInfo struct {
 	ID             string `json:"id"`
+	Name           string `json:"name,omitempty"` // from grove start --name; accepted wherever ID is
 	Project        string `json:"project"`
 	State          string `json:"state"`
 	Branch         string `json:"branch"`
@@ -77,12 +208,63 @@ type InstanceInfo struct {
 	PID            int    `json:"pid"`
 	ContainerID    string `json:"container_id,omitempty"`
 	ComposeProject string `json:"compose_project,omitempty"`
+
+	// Annotations are arbitrary user-assigned key/value notes
+	// (e.g. ticket=JIRA-123) set via ReqAnnotate.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Restarts counts automatic restarts after crashes since the agent was
+	// last started by hand (see agent.restart in grove.yaml).
+	Restarts int `json:"restarts,omitempty"`
+
+	// Runs counts every launch of the agent, manual or automatic, over the
+	// instance's life; each is marked in the log (see ReqLogs AllRuns).
+	Runs int `json:"runs,omitempty"`
+
+	// ContainerStopped is true once the container was removed by a stop
+	// with Container; the instance needs a fresh restart to run again.
+	ContainerStopped bool `json:"container_stopped,omitempty"`
+
+	// ExitReason explains a crash grove could diagnose, e.g. "out of
+	// memory".  Cleared when the agent is started again.
+	ExitReason string `json:"exit_reason,omitempty"`
+
+	// Pipe is true when the agent runs without a PTY (agent.pty: false in
+	// grove.yaml).  Its output is available through logs; it cannot be
+	// attached.
+	Pipe bool `json:"pipe,omitempty"`
+
+	// WorktreeMissing is true when the instance's worktree directory has
+	// been deleted or is no longer a git worktree, e.g. after an rm -rf or
+	// git worktree remove outside grove.  Set by ReqList.
+	WorktreeMissing bool `json:"worktree_missing,omitempty"`
+}
+
+// InstanceConfig is the resolved configuration an instance runs with, as the
+// daemon recorded it at start.  The container fields are fixed for the life
+// of the instance; the agent fields are updated by ReqRestart, which
+// re-reads grove.yaml.  Environment values are never recorded, only names.
+type InstanceConfig struct {
+	Image   string   `json:"image,omitempty"`
+	Compose string   `json:"compose,omitempty"`
+	Service string   `json:"service,omitempty"`
+	Workdir string   `json:"workdir"`
+	Subdir  string   `json:"subdir,omitempty"` // monorepo directory commands run in, relative to Workdir
+	Mounts  []string `json:"mounts,omitempty"` // "source:target[:ro]", as passed to docker run -v
+	Hidden  []string `json:"hidden,omitempty"` // in-container paths shadowed by container.hide
+
+	AgentCommand string   `json:"agent_command"`
+	AgentArgs    []string `json:"agent_args,omitempty"`
+	AgentEnvKeys []string `json:"agent_env_keys,omitempty"` // sorted; values redacted
+	Pipe         bool     `json:"pipe,omitempty"`
+	Prompt       string   `json:"prompt,omitempty"` // from grove start --prompt
 }
 
 // Response is the JSON payload returned by the daemon for all non-attach commands.
 type Response struct {
 	OK         bool           `json:"ok"`
 	Error      string         `json:"error,omitempty"`
+	Code       string         `json:"code,omitempty"` // see the Code constants; "" for any other failure
 	InstanceID string         `json:"instance_id,omitempty"`
 	Instances  []InstanceInfo `json:"instances,omitempty"`
 
@@ -94,6 +276,137 @@ type Response struct {
 	// project has no grove.yaml in its repository.  The client should prompt
 	// the user and write a boilerplate file here.
 	InitPath string `json:"init_path,omitempty"`
+
+	// Screen is the agent's terminal screen as plain text, set on a
+	// ReqSnapshot response and on ReqStop with Snapshot.
+	Screen string `json:"screen,omitempty"`
+
+	// InstanceConfig is set on a ReqInstanceConfig response.
+	InstanceConfig *InstanceConfig `json:"instance_config,omitempty"`
+
+	// Metrics is set on a ReqMetrics response.
+	Metrics *Metrics `json:"metrics,omitempty"`
+
+	// Framed confirms that the output following this response is framed as
+	// the request asked.  Daemons that predate Request.Framed leave it unset
+	// and stream raw output.
+	Framed bool `json:"framed,omitempty"`
+
+	// ExitCode is the exit status of a ReqExec command that ran and failed,
+	// in the result frame.  0 with OK false means it could not be run.
+	ExitCode int `json:"exit_code,omitempty"`
+}
+
+// Metrics is an aggregate snapshot of the daemon, returned by ReqMetrics.
+type Metrics struct {
+	UptimeSeconds    int64          `json:"uptime_seconds"`
+	Instances        int            `json:"instances"`         // instances currently known
+	InstancesStarted int            `json:"instances_started"` // successful starts since the daemon started
+	States           map[string]int `json:"states"`            // instance count by state
+	LogBytes         int64          `json:"log_bytes"`         // bytes held in in-memory log buffers
+	AttachSessions   int            `json:"attach_sessions"`   // clients currently attached
+	DockerReachable  bool           `json:"docker_reachable"`
+}
+
+// ─── Message framing ──────────────────────────────────────────────────────────
+//
+// Requests and responses are sent as
+//
+//     [4 bytes big-endian length][JSON]
+//
+// so a message of any size up to MaxMessageSize can be read without a line
+// length limit, and without reading past its end into the raw stream that
+// follows on attach and logs connections.
+//
+// Older grove and groved builds sent newline-terminated JSON instead.  A
+// legacy message starts with '{', which a length prefix never does (its first
+// byte is zero for any size under 16 MiB), so ReadMessage accepts both.
+
+// MaxMessageSize caps a single message so a corrupt length prefix cannot
+// cause a huge allocation.
+const MaxMessageSize = 64 << 20
+
+// ErrMessageTooLarge is returned by ReadMessage for messages over MaxMessageSize.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// WriteMessage writes v to w as one length-prefixed JSON message.
+func WriteMessage(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(data))
+	}
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadMessage reads one message from r into v.  legacy reports that the
+// peer sent newline-terminated JSON, in which case any reply should use the
+// same format (see WriteLegacyMessage).  ReadMessage never reads beyond the
+// end of the message.
+func ReadMessage(r io.Reader, v any) (legacy bool, err error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:1]); err != nil {
+		return false, err
+	}
+	if hdr[0] == '{' {
+		line, err := readLine(r, hdr[0])
+		if err != nil {
+			return true, err
+		}
+		return true, json.Unmarshal(line, v)
+	}
+
+	if _, err := io.ReadFull(r, hdr[1:]); err != nil {
+		return false, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > MaxMessageSize {
+		return false, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return false, err
+	}
+	return false, json.Unmarshal(data, v)
+}
+
+// WriteLegacyMessage writes v as newline-terminated JSON, for replying to a
+// peer whose message ReadMessage reported as legacy.
+func WriteLegacyMessage(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// readLine reads up to and excluding the next '\n' one byte at a time, so
+// nothing after the newline is consumed.  first has already been read.
+func readLine(r io.Reader, first byte) ([]byte, error) {
+	line := []byte{first}
+	var b [1]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return line, nil
+			}
+			return nil, err
+		}
+		if b[0] == '\n' {
+			return line, nil
+		}
+		if len(line) >= MaxMessageSize {
+			return nil, fmt.Errorf("%w: over %d bytes", ErrMessageTooLarge, MaxMessageSize)
+		}
+		line = append(line, b[0])
+	}
 }
 
 // ─── Attach stream framing ────────────────────────────────────────────────────
@@ -115,6 +428,20 @@ const (
 	AttachFrameDetach byte = 0x02
 )
 
+// Framed command output (Request.Framed) uses the same frame layout from
+// server to client:
+//
+//	0x10  output – bytes of command output
+//	0x11  result – payload: a JSON Response; OK reports whether the command
+//	               succeeded and Error says why not.  Always the last frame.
+//	0x12  stderr – bytes the command wrote to stderr; only ReqExec keeps
+//	               them apart, everything else sends all output as 0x10.
+const (
+	StreamFrameOutput byte = 0x10
+	StreamFrameResult byte = 0x11
+	StreamFrameStderr byte = 0x12
+)
+
 // WriteFrame writes a single framed message to w.
 func WriteFrame(w io.Writer, frameType byte, payload []byte) error {
 	hdr := make([]byte, 5)
@@ -2,6 +2,7 @@ package proto_test
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/gandalfthegui/grove/internal/proto"
@@ -53,3 +54,39 @@ func TestReadFrameMultiple(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, []byte("second"), p2)
 }
+
+func TestWriteReadMessageRoundTrip(t *testing.T) {
+	// Larger than bufio.Scanner's default 64 KiB token limit.
+	big := strings.Repeat("x", 256<<10)
+	var buf bytes.Buffer
+	require.NoError(t, proto.WriteMessage(&buf, proto.Response{OK: true, Error: big}))
+	buf.WriteString("raw stream bytes")
+
+	var resp proto.Response
+	legacy, err := proto.ReadMessage(&buf, &resp)
+	require.NoError(t, err)
+	assert.False(t, legacy)
+	assert.True(t, resp.OK)
+	assert.Equal(t, big, resp.Error)
+	// Nothing after the message was consumed.
+	assert.Equal(t, "raw stream bytes", buf.String())
+}
+
+func TestReadMessageLegacyNewline(t *testing.T) {
+	buf := bytes.NewBufferString("{\"type\":\"ping\"}\nrest")
+
+	var req proto.Request
+	legacy, err := proto.ReadMessage(buf, &req)
+	require.NoError(t, err)
+	assert.True(t, legacy)
+	assert.Equal(t, proto.ReqPing, req.Type)
+	assert.Equal(t, "rest", buf.String())
+}
+
+func TestReadMessageTooLarge(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{0x7f, 0xff, 0xff, 0xff})
+
+	var resp proto.Response
+	_, err := proto.ReadMessage(buf, &resp)
+	assert.ErrorIs(t, err, proto.ErrMessageTooLarge)
+}
@@ -0,0 +1,106 @@
+// Package registration holds the rules for a project's registration,
+// ~/.grove/projects/<name>/project.yaml, that the CLI (cmd/grove) and the
+// daemon (internal/daemon) must agree on.
+package registration
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CleanSubdir normalises a project.yaml subdir: a relative path inside the
+// repo, with "/" separators.  "" and "." mean the repo root.
+func CleanSubdir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	clean := path.Clean(filepath.ToSlash(dir))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("subdir %q must be a path inside the repository", dir)
+	}
+	if clean == "." {
+		return "", nil
+	}
+	return clean, nil
+}
+
+// ExpandHome replaces a leading "~" or "~/" in path with home.
+func ExpandHome(path, home string) string {
+	if path == "~" {
+		return home
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
+
+// AgentEnvFile returns the env file whose values are injected into a
+// project's agent, given its credentials.env_file: that path with ~
+// expanded, or <dataRoot>/env if it is empty.
+func AgentEnvFile(dataRoot, envFile string) string {
+	if envFile == "" {
+		return filepath.Join(dataRoot, "env")
+	}
+	home, _ := os.UserHomeDir()
+	return ExpandHome(envFile, home)
+}
+
+// InRepoConfigPath returns the grove.yaml a project reads from its checkout
+// at repoDir: the one in subdir (as CleanSubdir returns it) if there is one,
+// else the one at the repo root.
+func InRepoConfigPath(repoDir, subdir string) string {
+	if subdir != "" {
+		inSubdir := filepath.Join(repoDir, filepath.FromSlash(subdir), "grove.yaml")
+		if _, err := os.Stat(inSubdir); err == nil {
+			return inSubdir
+		}
+	}
+	return filepath.Join(repoDir, "grove.yaml")
+}
+
+// ReadDefaultBranch returns the default branch of the project registered as
+// name under dataRoot: default_branch from the grove.yaml in its main
+// checkout (see InRepoConfigPath), else from its project.yaml, else "".
+func ReadDefaultBranch(dataRoot, name string) string {
+	projectDir := filepath.Join(dataRoot, "projects", name)
+	var reg struct {
+		DefaultBranch string `yaml:"default_branch"`
+		Subdir        string `yaml:"subdir"`
+	}
+	if data, err := os.ReadFile(filepath.Join(projectDir, "project.yaml")); err == nil {
+		yaml.Unmarshal(data, &reg)
+	}
+	var inRepo struct {
+		DefaultBranch string `yaml:"default_branch"`
+	}
+	if subdir, err := CleanSubdir(reg.Subdir); err == nil {
+		if data, err := os.ReadFile(InRepoConfigPath(filepath.Join(projectDir, "main"), subdir)); err == nil {
+			yaml.Unmarshal(data, &inRepo)
+		}
+	}
+	if inRepo.DefaultBranch != "" {
+		return inRepo.DefaultBranch
+	}
+	return reg.DefaultBranch
+}
+
+// ReadAgentEnvFile is AgentEnvFile for the project registered as name under
+// dataRoot, read from its project.yaml.  A missing or unreadable
+// registration gets the default.
+func ReadAgentEnvFile(dataRoot, name string) string {
+	var reg struct {
+		Credentials struct {
+			EnvFile string `yaml:"env_file"`
+		} `yaml:"credentials"`
+	}
+	if data, err := os.ReadFile(filepath.Join(dataRoot, "projects", name, "project.yaml")); err == nil {
+		yaml.Unmarshal(data, &reg)
+	}
+	return AgentEnvFile(dataRoot, reg.Credentials.EnvFile)
+}
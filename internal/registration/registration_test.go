@@ -0,0 +1,75 @@
+package registration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanSubdir(t *testing.T) {
+	for in, want := range map[string]string{
+		"":              "",
+		".":             "",
+		"services/api/": "services/api",
+		"./web//app":    "web/app",
+		"a/../services": "services",
+	} {
+		got, err := CleanSubdir(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, bad := range []string{"/abs", "..", "../sibling", "a/../../b"} {
+		_, err := CleanSubdir(bad)
+		assert.ErrorContains(t, err, "must be a path inside the repository", bad)
+	}
+}
+
+func TestReadAgentEnvFile(t *testing.T) {
+	root := t.TempDir()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	assert.Equal(t, filepath.Join(root, "env"), ReadAgentEnvFile(root, "app"), "no registration")
+
+	write := func(envFile string) {
+		dir := filepath.Join(root, "projects", "app")
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		reg := "name: app\ncredentials:\n  env_file: " + envFile + "\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "project.yaml"), []byte(reg), 0o644))
+	}
+	write("~/.grove/work.env")
+	assert.Equal(t, filepath.Join(home, ".grove", "work.env"), ReadAgentEnvFile(root, "app"))
+	write("/etc/grove.env")
+	assert.Equal(t, "/etc/grove.env", ReadAgentEnvFile(root, "app"))
+
+	assert.Equal(t, home, ExpandHome("~", home))
+	assert.Equal(t, "~user/x", ExpandHome("~user/x", home))
+}
+
+func TestReadDefaultBranch(t *testing.T) {
+	root := t.TempDir()
+	assert.Empty(t, ReadDefaultBranch(root, "app"), "no registration")
+
+	dir := filepath.Join(root, "projects", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "project.yaml"), []byte("name: app\ndefault_branch: develop\nsubdir: web\n"), 0o644))
+	assert.Equal(t, "develop", ReadDefaultBranch(root, "app"))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "main", "web"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main", "web", "grove.yaml"), []byte("default_branch: trunk\n"), 0o644))
+	assert.Equal(t, "trunk", ReadDefaultBranch(root, "app"), "grove.yaml wins")
+}
+
+func TestInRepoConfigPath(t *testing.T) {
+	repo := t.TempDir()
+	root := filepath.Join(repo, "grove.yaml")
+	assert.Equal(t, root, InRepoConfigPath(repo, ""))
+	assert.Equal(t, root, InRepoConfigPath(repo, "services/api"), "falls back to the repo root")
+
+	sub := filepath.Join(repo, "services", "api", "grove.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(sub), 0o755))
+	require.NoError(t, os.WriteFile(sub, nil, 0o644))
+	assert.Equal(t, sub, InRepoConfigPath(repo, "services/api"))
+}
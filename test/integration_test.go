@@ -218,8 +218,9 @@ func makeGitRepo(t *testing.T) string {
 	run("git", "config", "user.name", "Grove Integration Test")
 
 	// grove.yaml: use `sh` as the agent (always present in containers).
-	// start is empty so we don't need real commands to succeed.
-	groveYAML := "container:\n  image: alpine\nstart: []\nagent:\n  command: sh\n  args: []\n"
+	// start is empty so we don't need real commands to succeed.  The mock
+	// docker exec returns at once, so skip the agent startup check.
+	groveYAML := "container:\n  image: alpine\nstart: []\nagent:\n  command: sh\n  args: []\n  startup_check: \"0\"\n"
 	require.NoError(t, os.WriteFile(filepath.Join(dir, "grove.yaml"), []byte(groveYAML), 0o644))
 
 	run("git", "add", ".")